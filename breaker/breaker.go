@@ -0,0 +1,84 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Package breaker implements a circuit breaker, suspending the calls
+// to a backend after consecutive failures.
+package breaker
+
+import (
+	"sync"
+	"time"
+)
+
+// Breaker is a circuit breaker. After threshold consecutive failures
+// it rejects the requests for the given timeout, then lets a single
+// request through to probe if the backend is back.
+type Breaker struct {
+	threshold int
+	timeout   time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+// New returns a circuit breaker. A threshold of 0 disables it.
+func New(threshold int, timeout time.Duration) *Breaker {
+	return &Breaker{
+		threshold: threshold,
+		timeout:   timeout,
+	}
+}
+
+// Allow returns true if a request can be made.
+func (b *Breaker) Allow() bool {
+	if b.threshold <= 0 {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return true
+	}
+	now := time.Now()
+	if now.Before(b.openUntil) {
+		return false
+	}
+	// half open: let this request through and keep
+	// rejecting the others until it completes
+	b.openUntil = now.Add(b.timeout)
+	return true
+}
+
+// Success records a successful request.
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+}
+
+// Failure records a failed request.
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.timeout)
+	}
+}
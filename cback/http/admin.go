@@ -0,0 +1,110 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package cback
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/cs3org/reva/pkg/appctx"
+)
+
+// requireAdmin allows the request only to the members of the admin group.
+func (s *svc) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := appctx.ContextGetUser(r.Context())
+		if !ok {
+			writeError(w, r, http.StatusUnauthorized, "user not authenticated")
+			return
+		}
+		if s.config.AdminGroup == "" || !slices.Contains(user.Groups, s.config.AdminGroup) {
+			writeError(w, r, http.StatusForbidden, "user is not an administrator")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type adminRestoreOut struct {
+	*restoreOut
+	Username string `json:"username"`
+	BackupID int    `json:"backup_id"`
+}
+
+func (s *svc) getAllRestores(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, _ := appctx.ContextGetUser(ctx)
+
+	filter, err := parseRestoreFilter(r.URL.Query())
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	list, err := s.client.ListAllRestores(ctx, user.Username, filter)
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	res := make([]*adminRestoreOut, 0, len(list))
+	for _, r := range list {
+		res = append(res, &adminRestoreOut{
+			restoreOut: s.convertToRestoureOut(r),
+			Username:   r.Username,
+			BackupID:   r.BackupID,
+		})
+	}
+
+	s.writeJSON(w, res)
+}
+
+type backupOut struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	Group    string `json:"group,omitempty"`
+	Path     string `json:"path"`
+}
+
+func (s *svc) getAllBackups(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, _ := appctx.ContextGetUser(ctx)
+
+	list, err := s.client.ListAllBackups(ctx, user.Username)
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	res := make([]*backupOut, 0, len(list))
+	for _, b := range list {
+		path, err := getPath(b.Source, s.tplStorage)
+		if err != nil {
+			continue
+		}
+		res = append(res, &backupOut{
+			ID:       b.ID,
+			Username: b.Username,
+			Group:    b.Group.Name,
+			Path:     path,
+		})
+	}
+
+	s.writeJSON(w, res)
+}
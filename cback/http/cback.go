@@ -23,7 +23,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"mime"
 	"net/http"
+	"net/url"
+	"path/filepath"
 	"strconv"
 	"text/template"
 	"time"
@@ -31,6 +34,9 @@ import (
 	"github.com/Masterminds/sprig"
 	cbackfs "github.com/cernbox/reva-plugins/cback/storage"
 	cback "github.com/cernbox/reva-plugins/cback/utils"
+	"github.com/cernbox/reva-plugins/events"
+	"github.com/cernbox/reva-plugins/ratelimit"
+	"github.com/cernbox/reva-plugins/runner"
 	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
 	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
 	storage "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
@@ -39,8 +45,9 @@ import (
 	"github.com/cs3org/reva/pkg/rgrpc/todo/pool"
 	"github.com/cs3org/reva/pkg/rhttp/global"
 	"github.com/cs3org/reva/pkg/sharedconf"
+	"github.com/cs3org/reva/pkg/utils/cfg"
 	"github.com/go-chi/chi/v5"
-	"github.com/mitchellh/mapstructure"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/pkg/errors"
 )
 
@@ -52,14 +59,39 @@ const webdavPrefix = "/remote.php/dav/files/"
 
 type config struct {
 	Prefix            string `mapstructure:"prefix"`
-	Token             string `mapstructure:"token"`
-	URL               string `mapstructure:"url"`
+	Token             string `mapstructure:"token"      validate:"required"`
+	URL               string `mapstructure:"url"        validate:"required,url"`
 	Insecure          bool   `mapstructure:"insecure"`
 	Timeout           int    `mapstructure:"timeout"`
 	GatewaySvc        string `mapstructure:"gatewaysvc"`
-	StorageID         string `mapstructure:"storage_id"`
+	StorageID         string `mapstructure:"storage_id" validate:"required"`
 	TemplateToStorage string `mapstructure:"template_to_storage"`
 	TemplateToCback   string `mapstructure:"template_to_cback"`
+	// MaxIdleConns, MaxIdleConnsPerHost and IdleConnTimeout tune
+	// the pool of connections to cback, see utils.Config.
+	MaxIdleConns        int `mapstructure:"max_idle_conns"`
+	MaxIdleConnsPerHost int `mapstructure:"max_idle_conns_per_host"`
+	IdleConnTimeout     int `mapstructure:"idle_conn_timeout"`
+	// Retries, RetryBackoff, BreakerThreshold and BreakerTimeout
+	// configure the retries and the circuit breaker of the requests
+	// to cback, see utils.Config.
+	Retries          int `mapstructure:"retries"           validate:"min=0"`
+	RetryBackoff     int `mapstructure:"retry_backoff"`
+	BreakerThreshold int `mapstructure:"breaker_threshold" validate:"min=0"`
+	BreakerTimeout   int `mapstructure:"breaker_timeout"`
+	// Events configures the publisher of the restore events.
+	Events map[string]interface{} `mapstructure:"events"`
+	// AdminGroup is the group whose members can access the admin
+	// endpoints. If empty, the admin endpoints are disabled.
+	AdminGroup string `mapstructure:"admin_group"`
+	// MaxActiveRestores is the maximum number of restores each user
+	// can have queued or running at the same time. 0 means no limit.
+	MaxActiveRestores int `mapstructure:"max_active_restores" validate:"min=0"`
+	// PollInterval is the interval in seconds at which the status of the
+	// restores in progress is checked, to emit an event when they end.
+	PollInterval int `mapstructure:"poll_interval"`
+	// RateLimit configures the rate limits of the endpoints.
+	RateLimit map[string]interface{} `mapstructure:"ratelimit"`
 }
 
 type svc struct {
@@ -69,6 +101,9 @@ type svc struct {
 	gw         gateway.GatewayAPIClient
 	tplStorage *template.Template
 	tplCback   *template.Template
+	events     events.Publisher
+	poller     *restorePoller
+	runner     *runner.Runner
 }
 
 func (svc) RevaPlugin() reva.PluginInfo {
@@ -83,12 +118,10 @@ var _ global.NewService = New
 // New returns a new cback http service.
 func New(ctx context.Context, m map[string]interface{}) (global.Service, error) {
 	c := &config{}
-	if err := mapstructure.Decode(m, c); err != nil {
-		return nil, errors.Wrap(err, "cback: error decodinf config")
+	if err := cfg.Decode(m, c); err != nil {
+		return nil, errors.Wrap(err, "cback: invalid config")
 	}
 
-	c.init()
-
 	gw, err := pool.GetGatewayServiceClient(pool.Endpoint(c.GatewaySvc))
 	if err != nil {
 		return nil, errors.Wrap(err, "cback: error getting gateway client")
@@ -96,39 +129,64 @@ func New(ctx context.Context, m map[string]interface{}) (global.Service, error)
 
 	tplStorage, err := template.New("tpl_storage").Funcs(sprig.TxtFuncMap()).Parse(c.TemplateToStorage)
 	if err != nil {
-		return nil, errors.Wrap(err, "cback: error creating template")
+		return nil, errors.Wrap(err, "cback: error creating template_to_storage")
 	}
 
 	tplCback, err := template.New("tpl_cback").Funcs(sprig.TxtFuncMap()).Parse(c.TemplateToCback)
 	if err != nil {
-		return nil, errors.Wrap(err, "cback: error creating template")
+		return nil, errors.Wrap(err, "cback: error creating template_to_cback")
+	}
+
+	publisher, err := events.New(c.Events)
+	if err != nil {
+		return nil, err
+	}
+
+	rateLimit, err := ratelimit.Middleware(c.RateLimit)
+	if err != nil {
+		return nil, err
 	}
 
 	r := chi.NewRouter()
+	r.Use(middleware.RequestID, rateLimit)
 	s := &svc{
 		config: c,
 		gw:     gw,
 		router: r,
 		client: cback.New(&cback.Config{
-			URL:     c.URL,
-			Token:   c.Token,
-			Timeout: c.Timeout,
+			URL:                 c.URL,
+			Token:               c.Token,
+			Timeout:             c.Timeout,
+			Insecure:            c.Insecure,
+			MaxIdleConns:        c.MaxIdleConns,
+			MaxIdleConnsPerHost: c.MaxIdleConnsPerHost,
+			IdleConnTimeout:     c.IdleConnTimeout,
+			Retries:             c.Retries,
+			RetryBackoff:        c.RetryBackoff,
+			BreakerThreshold:    c.BreakerThreshold,
+			BreakerTimeout:      c.BreakerTimeout,
 		}),
 		tplStorage: tplStorage,
 		tplCback:   tplCback,
+		events:     publisher,
 	}
 
 	s.initRouter()
 
+	s.poller = newRestorePoller(s)
+	s.runner = runner.New(context.Background())
+	s.runner.Every("cback: poll restores", time.Duration(c.PollInterval)*time.Second, false, s.poller.poll)
+
 	return s, nil
 }
 
 // Close cleanup the cback http service.
 func (s *svc) Close() error {
-	return nil
+	_ = s.runner.Close()
+	return s.events.Close()
 }
 
-func (c *config) init() {
+func (c *config) ApplyDefaults() {
 	if c.Prefix == "" {
 		c.Prefix = "cback"
 	}
@@ -138,6 +196,9 @@ func (c *config) init() {
 	if c.TemplateToCback == "" {
 		c.TemplateToCback = "{{.}}"
 	}
+	if c.PollInterval == 0 {
+		c.PollInterval = 60
+	}
 	c.GatewaySvc = sharedconf.GetGatewaySVC(c.GatewaySvc)
 }
 
@@ -153,8 +214,15 @@ func (s *svc) initRouter() {
 	s.router.Get("/restores", s.getRestores)
 	s.router.Get("/restores/{id}", s.getRestoreByID)
 	s.router.Post("/restores", s.createRestore)
+	s.router.Delete("/restores/{id}", s.cancelRestore)
 
 	s.router.Get("/backups", s.getBackups)
+
+	s.router.Route("/admin", func(r chi.Router) {
+		r.Use(s.requireAdmin)
+		r.Get("/restores", s.getAllRestores)
+		r.Get("/backups", s.getAllBackups)
+	})
 }
 
 type restoreOut struct {
@@ -181,63 +249,204 @@ func (s *svc) createRestore(w http.ResponseWriter, r *http.Request) {
 
 	user, ok := appctx.ContextGetUser(ctx)
 	if !ok {
-		http.Error(w, "user not authenticated", http.StatusUnauthorized)
+		writeError(w, r, http.StatusUnauthorized, "user not authenticated")
 		return
 	}
 
-	path := r.URL.Query().Get("path")
-	if path == "" {
-		http.Error(w, "missing path", http.StatusBadRequest)
+	req, err := parseRestoreRequest(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
 
 	stat, err := s.gw.Stat(ctx, &storage.StatRequest{
 		Ref: &storage.Reference{
-			Path: path,
+			Path: req.Path,
 		},
 	})
 
 	switch {
 	case err != nil:
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeClientError(w, r, err)
 		return
 	case stat.Status.Code == rpc.Code_CODE_NOT_FOUND:
-		http.Error(w, stat.Status.Message, http.StatusNotFound)
+		writeError(w, r, http.StatusNotFound, stat.Status.Message)
 		return
 	case stat.Status.Code != rpc.Code_CODE_OK:
-		http.Error(w, stat.Status.Message, http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, stat.Status.Message)
 		return
 	}
 
 	if stat.Info.Id == nil || stat.Info.Id.StorageId != s.config.StorageID {
-		http.Error(w, fmt.Sprintf("path not belonging to %s storage driver", s.config.StorageID), http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, fmt.Sprintf("path not belonging to %s storage driver", s.config.StorageID))
 		return
 	}
 
 	path, snapshotID, backupID, ok := cbackfs.GetBackupInfo(stat.Info.Id)
 	if !ok {
-		http.Error(w, "cannot restore the given path", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "cannot restore the given path")
+		return
+	}
+	if req.Snapshot != "" {
+		snapshotID = req.Snapshot
+	}
+	if snapshotID == "" {
+		writeError(w, r, http.StatusBadRequest, "missing snapshot")
 		return
 	}
 
-	restore, err := s.client.NewRestore(ctx, user.Username, backupID, s.cbackPath(path), snapshotID, true)
+	cbackPath, err := getPath(path, s.tplCback)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeClientError(w, r, err)
 		return
 	}
+	if req.Pattern != "" {
+		cbackPath = filepath.Join(cbackPath, req.Pattern)
+	}
 
-	s.writeJSON(w, s.convertToRestoureOut(restore))
+	if s.config.MaxActiveRestores > 0 {
+		active, err := s.client.ListRestores(ctx, user.Username, &cback.RestoreFilter{
+			Statuses: []int{cback.RestoreQueued, cback.RestoreRunning},
+		})
+		if err != nil {
+			writeClientError(w, r, err)
+			return
+		}
+		if len(active) >= s.config.MaxActiveRestores {
+			writeAPIError(w, r, http.StatusTooManyRequests, &apiError{
+				Code:    "too_many_restores",
+				Message: fmt.Sprintf("at most %d restores can be in progress at the same time", s.config.MaxActiveRestores),
+				Details: map[string]any{
+					"limit":  s.config.MaxActiveRestores,
+					"active": len(active),
+				},
+			})
+			return
+		}
+	}
+
+	var cbackDestination string
+	if destination := req.Destination; destination != "" {
+		if code, err := s.checkDestination(ctx, destination); err != nil {
+			writeError(w, r, code, err.Error())
+			return
+		}
+		if cbackDestination, err = getPath(destination, s.tplCback); err != nil {
+			writeClientError(w, r, err)
+			return
+		}
+	}
+
+	restore, err := s.client.NewRestoreTo(ctx, user.Username, backupID, cbackPath, snapshotID, cbackDestination, true)
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	out := s.convertToRestoureOut(restore)
+	s.publishRestoreEvent(ctx, events.RestoreCreated, user.Username, out)
+	s.poller.follow(user.Username, restore.ID)
+
+	s.writeJSON(w, out)
 }
 
-func must[T any](v T, err error) T {
+// restoreRequest is the request to create a restore. It is read from
+// the json body if the request has one, from the query parameters otherwise.
+type restoreRequest struct {
+	// Path of the resource to restore, in a snapshot or in the
+	// folder of a backup if the snapshot is given.
+	Path string `json:"path"`
+	// Snapshot overrides the snapshot in the path.
+	Snapshot string `json:"snapshot"`
+	// Destination of the restore. Empty to restore in the original location.
+	Destination string `json:"destination"`
+	// Pattern restricts the restore to the files matching it,
+	// relative to the restored path.
+	Pattern string `json:"pattern"`
+}
+
+func parseRestoreRequest(r *http.Request) (*restoreRequest, error) {
+	req := &restoreRequest{}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+		d := json.NewDecoder(r.Body)
+		d.DisallowUnknownFields()
+		if err := d.Decode(req); err != nil {
+			return nil, errors.Wrap(err, "invalid json body")
+		}
+	} else {
+		q := r.URL.Query()
+		req.Path = q.Get("path")
+		req.Snapshot = q.Get("snapshot")
+		req.Destination = q.Get("destination")
+		req.Pattern = q.Get("pattern")
+	}
+
+	if req.Path == "" {
+		return nil, errors.New("missing path")
+	}
+	if req.Destination != "" && !filepath.IsAbs(req.Destination) {
+		return nil, errors.New("destination must be an absolute path")
+	}
+	if req.Pattern != "" {
+		if !filepath.IsLocal(req.Pattern) {
+			return nil, errors.New("pattern must be relative to the restored path")
+		}
+	}
+	return req, nil
+}
+
+// checkDestination checks that the user can write in the destination
+// of a restore, creating it if it does not exist. In case of error,
+// the http status code to return is also returned.
+func (s *svc) checkDestination(ctx context.Context, destination string) (int, error) {
+	ref := &storage.Reference{Path: destination}
+	stat, err := s.gw.Stat(ctx, &storage.StatRequest{Ref: ref})
 	if err != nil {
-		panic(err)
+		return http.StatusInternalServerError, err
+	}
+
+	switch stat.Status.Code {
+	case rpc.Code_CODE_OK:
+		if stat.Info.Type != storage.ResourceType_RESOURCE_TYPE_CONTAINER {
+			return http.StatusBadRequest, errors.New("destination is not a folder")
+		}
+		if perms := stat.Info.PermissionSet; perms == nil || !perms.InitiateFileUpload || !perms.CreateContainer {
+			return http.StatusForbidden, errors.New("no permissions to write in the destination")
+		}
+		return 0, nil
+	case rpc.Code_CODE_NOT_FOUND:
+		res, err := s.gw.CreateContainer(ctx, &storage.CreateContainerRequest{Ref: ref})
+		switch {
+		case err != nil:
+			return http.StatusInternalServerError, err
+		case res.Status.Code == rpc.Code_CODE_PERMISSION_DENIED:
+			return http.StatusForbidden, errors.New("no permissions to create the destination")
+		case res.Status.Code != rpc.Code_CODE_OK:
+			return http.StatusInternalServerError, errors.New(res.Status.Message)
+		}
+		return 0, nil
+	case rpc.Code_CODE_PERMISSION_DENIED:
+		return http.StatusForbidden, errors.New("no permissions to access the destination")
+	default:
+		return http.StatusInternalServerError, errors.New(stat.Status.Message)
 	}
-	return v
 }
 
-func (s *svc) cbackPath(p string) string {
-	return must(getPath(p, s.tplCback))
+func (s *svc) publishRestoreEvent(ctx context.Context, eventType, username string, r *restoreOut) {
+	err := s.events.Publish(ctx, &events.Event{
+		Type:       eventType,
+		Actor:      username,
+		Recipients: []string{username},
+		Data: map[string]string{
+			"restore_id":  strconv.Itoa(r.ID),
+			"path":        r.Path,
+			"destination": r.Destination,
+			"status":      strconv.Itoa(r.Status),
+		},
+	})
+	if err != nil {
+		appctx.GetLogger(ctx).Error().Err(err).Int("restore_id", r.ID).Msg("cback: error publishing restore event")
+	}
 }
 
 func (s *svc) getRestores(w http.ResponseWriter, r *http.Request) {
@@ -245,13 +454,19 @@ func (s *svc) getRestores(w http.ResponseWriter, r *http.Request) {
 
 	user, ok := appctx.ContextGetUser(ctx)
 	if !ok {
-		http.Error(w, "user not authenticated", http.StatusUnauthorized)
+		writeError(w, r, http.StatusUnauthorized, "user not authenticated")
 		return
 	}
 
-	list, err := s.client.ListRestores(ctx, user.Username)
+	filter, err := parseRestoreFilter(r.URL.Query())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	list, err := s.client.ListRestores(ctx, user.Username, filter)
+	if err != nil {
+		writeClientError(w, r, err)
 		return
 	}
 
@@ -263,9 +478,46 @@ func (s *svc) getRestores(w http.ResponseWriter, r *http.Request) {
 	s.writeJSON(w, res)
 }
 
+// parseRestoreFilter parses the query parameters of the restores list:
+// limit and offset for the pagination, status (repeatable) and from/to,
+// in RFC3339 format, for the creation time.
+func parseRestoreFilter(q url.Values) (*cback.RestoreFilter, error) {
+	f := &cback.RestoreFilter{}
+
+	var err error
+	if l := q.Get("limit"); l != "" {
+		if f.Limit, err = strconv.Atoi(l); err != nil || f.Limit < 0 {
+			return nil, errors.New("limit must be a non-negative integer")
+		}
+	}
+	if o := q.Get("offset"); o != "" {
+		if f.Offset, err = strconv.Atoi(o); err != nil || f.Offset < 0 {
+			return nil, errors.New("offset must be a non-negative integer")
+		}
+	}
+	for _, st := range q["status"] {
+		status, err := strconv.Atoi(st)
+		if err != nil {
+			return nil, errors.Errorf("invalid status %s", st)
+		}
+		f.Statuses = append(f.Statuses, status)
+	}
+	if from := q.Get("from"); from != "" {
+		if f.CreatedAfter, err = time.Parse(time.RFC3339, from); err != nil {
+			return nil, errors.Wrap(err, "invalid from date")
+		}
+	}
+	if to := q.Get("to"); to != "" {
+		if f.CreatedBefore, err = time.Parse(time.RFC3339, to); err != nil {
+			return nil, errors.Wrap(err, "invalid to date")
+		}
+	}
+	return f, nil
+}
+
 func (s *svc) writeJSON(w http.ResponseWriter, r any) {
-	w.WriteHeader(http.StatusOK)
 	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 	_ = json.NewEncoder(w).Encode(r)
 }
 
@@ -274,20 +526,45 @@ func (s *svc) getRestoreByID(w http.ResponseWriter, r *http.Request) {
 
 	user, ok := appctx.ContextGetUser(ctx)
 	if !ok {
-		http.Error(w, "user not authenticated", http.StatusUnauthorized)
+		writeError(w, r, http.StatusUnauthorized, "user not authenticated")
 		return
 	}
 
 	id := chi.URLParam(r, "id")
 	restoreID, err := strconv.ParseInt(id, 10, 32)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
 
 	restore, err := s.client.GetRestore(ctx, user.Username, int(restoreID))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeClientError(w, r, err)
+		return
+	}
+
+	s.writeJSON(w, s.convertToRestoureOut(restore))
+}
+
+func (s *svc) cancelRestore(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, ok := appctx.ContextGetUser(ctx)
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, "user not authenticated")
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	restoreID, err := strconv.ParseInt(id, 10, 32)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	restore, err := s.client.CancelRestore(ctx, user.Username, int(restoreID))
+	if err != nil {
+		writeClientError(w, r, err)
 		return
 	}
 
@@ -307,13 +584,13 @@ func (s *svc) getBackups(w http.ResponseWriter, r *http.Request) {
 
 	user, ok := appctx.ContextGetUser(ctx)
 	if !ok {
-		http.Error(w, "user not authenticated", http.StatusUnauthorized)
+		writeError(w, r, http.StatusUnauthorized, "user not authenticated")
 		return
 	}
 
 	list, err := s.client.ListBackups(ctx, user.Username)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeClientError(w, r, err)
 		return
 	}
 
@@ -0,0 +1,84 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package cback
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/cs3org/reva/pkg/errtypes"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/pkg/errors"
+)
+
+// apiError is the body of the responses of the failed requests.
+type apiError struct {
+	Code      string         `json:"code"`
+	Message   string         `json:"message"`
+	RequestID string         `json:"request_id,omitempty"`
+	Details   map[string]any `json:"details,omitempty"`
+}
+
+var errorCodes = map[int]string{
+	http.StatusBadRequest:          "bad_request",
+	http.StatusUnauthorized:        "unauthorized",
+	http.StatusForbidden:           "forbidden",
+	http.StatusNotFound:            "not_found",
+	http.StatusConflict:            "conflict",
+	http.StatusTooManyRequests:     "too_many_requests",
+	http.StatusInternalServerError: "internal_error",
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	code, ok := errorCodes[status]
+	if !ok {
+		code = "error"
+	}
+	writeAPIError(w, r, status, &apiError{Code: code, Message: msg})
+}
+
+func writeAPIError(w http.ResponseWriter, r *http.Request, status int, e *apiError) {
+	e.RequestID = middleware.GetReqID(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(e)
+}
+
+// writeClientError writes the error returned by the cback client
+// or by the gateway, with the corresponding http status.
+func writeClientError(w http.ResponseWriter, r *http.Request, err error) {
+	writeError(w, r, statusFromError(err), err.Error())
+}
+
+func statusFromError(err error) int {
+	switch errors.Cause(err).(type) {
+	case errtypes.NotFound:
+		return http.StatusNotFound
+	case errtypes.PermissionDenied:
+		return http.StatusForbidden
+	case errtypes.BadRequest:
+		return http.StatusBadRequest
+	case errtypes.AlreadyExists:
+		return http.StatusConflict
+	case errtypes.TooEarly:
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package cback
+
+import (
+	"context"
+	"sync"
+
+	cback "github.com/cernbox/reva-plugins/cback/utils"
+	"github.com/cernbox/reva-plugins/events"
+	"github.com/rs/zerolog/log"
+)
+
+// restorePoller follows the restores created through the service, and
+// emits an event when they complete or fail.
+// The followed restores are kept in memory, so the ones in progress
+// when the service is restarted are not notified.
+type restorePoller struct {
+	svc *svc
+
+	mu       sync.Mutex
+	restores map[int]string // restore id -> username
+}
+
+func newRestorePoller(s *svc) *restorePoller {
+	return &restorePoller{
+		svc:      s,
+		restores: make(map[int]string),
+	}
+}
+
+func (p *restorePoller) follow(username string, restoreID int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.restores[restoreID] = username
+}
+
+func (p *restorePoller) unfollow(restoreID int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.restores, restoreID)
+}
+
+func (p *restorePoller) followed() map[int]string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	res := make(map[int]string, len(p.restores))
+	for id, u := range p.restores {
+		res[id] = u
+	}
+	return res
+}
+
+// poll checks the status of the followed restores.
+func (p *restorePoller) poll(ctx context.Context) error {
+	for id, username := range p.followed() {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		restore, err := p.svc.client.GetRestore(ctx, username, id)
+		if err != nil {
+			log.Error().Err(err).Int("restore_id", id).Msg("cback: error getting restore status")
+			continue
+		}
+
+		if !restore.IsDone() {
+			continue
+		}
+		p.unfollow(id)
+
+		switch restore.Status {
+		case cback.RestoreCompleted:
+			p.svc.publishRestoreEvent(ctx, events.RestoreFinished, username, p.svc.convertToRestoureOut(restore))
+		case cback.RestoreFailed:
+			p.svc.publishRestoreEvent(ctx, events.RestoreFailed, username, p.svc.convertToRestoureOut(restore))
+		}
+	}
+	return nil
+}
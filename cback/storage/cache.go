@@ -21,68 +21,205 @@ package cbackfs
 import (
 	"context"
 	"fmt"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/cernbox/reva-plugins/cback/utils"
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	"github.com/cs3org/reva/pkg/appctx"
+	"github.com/cs3org/reva/pkg/errtypes"
+	"golang.org/x/sync/errgroup"
 )
 
-func (f *fs) listBackups(ctx context.Context, username string) ([]*utils.Backup, error) {
-	key := "backups:" + username
-	if d, err := f.cache.Get(key); err == nil {
-		return d.([]*utils.Backup), nil
+// allow checks that the user, and all the users together, did not
+// exceed the configured rate of requests to cback.
+func (f *fs) allow(ctx context.Context, username string) error {
+	if f.limiter == nil {
+		return nil
 	}
-	backups, err := f.client.ListBackups(ctx, username)
+
+	check := func(key string, rate float64, burst int) error {
+		if rate <= 0 {
+			return nil
+		}
+		ok, wait, err := f.limiter.Allow(ctx, key, rate, burst)
+		if err != nil {
+			// do not block the users if the limiter is not working
+			appctx.GetLogger(ctx).Error().Err(err).Msg("cback: error checking rate limit")
+			return nil
+		}
+		if !ok {
+			return errtypes.TooEarly(fmt.Sprintf("cback: too many requests, retry in %s", wait.Round(time.Second)))
+		}
+		return nil
+	}
+
+	if err := check("user:"+username, f.conf.UserRate, f.conf.UserBurst); err != nil {
+		return err
+	}
+	return check("global", f.conf.GlobalRate, f.conf.GlobalBurst)
+}
+
+// setCache stores a value in the cache. Failing to cache a value is
+// not an error for the caller, as it will be fetched again from cback.
+func (f *fs) setCache(ctx context.Context, key string, v interface{}) {
+	if err := f.cache.set(key, v, time.Duration(f.conf.Expiration)*time.Second); err != nil {
+		appctx.GetLogger(ctx).Error().Err(err).Str("key", key).Msg("cback: error storing value in cache")
+	}
+}
+
+// listBackups lists the backups of the user, merged with the ones
+// of the groups the user is member of if group backups are enabled.
+func (f *fs) listBackups(ctx context.Context, u *userpb.User) ([]*utils.Backup, error) {
+	backups, err := f.listBackupsFromEndpoint(ctx, "backups:"+u.Username, u.Username, f.client.ListBackups)
+	if err != nil || !f.conf.GroupBackups {
+		return backups, err
+	}
+
+	groupBackups, err := f.listBackupsFromEndpoint(ctx, "group-backups:"+u.Username, u.Username, f.client.ListGroupBackups)
+	if err != nil {
+		// the user can still browse its own backups
+		appctx.GetLogger(ctx).Error().Err(err).Str("user", u.Username).Msg("cback: error listing group backups")
+		return backups, nil
+	}
+
+	res := make([]*utils.Backup, 0, len(backups)+len(groupBackups))
+	res = append(res, backups...)
+	for _, b := range groupBackups {
+		if slices.Contains(u.Groups, b.Group.Name) {
+			res = append(res, b)
+		}
+	}
+	return res, nil
+}
+
+func (f *fs) listBackupsFromEndpoint(ctx context.Context, key, username string, list func(context.Context, string) ([]*utils.Backup, error)) ([]*utils.Backup, error) {
+	var backups []*utils.Backup
+	if f.cache.get(key, &backups) {
+		return backups, nil
+	}
+	if err := f.allow(ctx, username); err != nil {
+		return nil, err
+	}
+	backups, err := list(ctx, username)
 	if err != nil {
 		return nil, err
 	}
 	for _, b := range backups {
-		b.Source = convertTemplate(b.Source, f.tplStorage)
+		if b.Source, err = convertTemplate(b.Source, f.tplStorage); err != nil {
+			return nil, err
+		}
 	}
-	_ = f.cache.SetWithExpire(key, backups, time.Duration(f.conf.Expiration)*time.Second)
+	f.setCache(ctx, key, backups)
 	return backups, nil
 }
 
 func (f *fs) stat(ctx context.Context, username string, id int, snapshot, path string) (*utils.Resource, error) {
 	key := fmt.Sprintf("stat:%s:%d:%s:%s", username, id, snapshot, path)
-	if s, err := f.cache.Get(key); err == nil {
-		return s.(*utils.Resource), nil
+	var s *utils.Resource
+	if f.cache.get(key, &s) {
+		return s, nil
+	}
+	if err := f.allow(ctx, username); err != nil {
+		return nil, err
 	}
 	s, err := f.client.Stat(ctx, username, id, snapshot, path, true)
 	if err != nil {
 		return nil, err
 	}
-	_ = f.cache.SetWithExpire(key, s, time.Duration(f.conf.Expiration)*time.Second)
+	f.setCache(ctx, key, s)
 	return s, nil
 }
 
 func (f *fs) listFolder(ctx context.Context, username string, id int, snapshot, path string) ([]*utils.Resource, error) {
 	key := fmt.Sprintf("list:%s:%d:%s:%s", username, id, snapshot, path)
-	if l, err := f.cache.Get(key); err == nil {
-		return l.([]*utils.Resource), nil
+	var l []*utils.Resource
+	if f.cache.get(key, &l) {
+		return l, nil
+	}
+	path, err := convertTemplate(path, f.tplCback)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.allow(ctx, username); err != nil {
+		return nil, err
 	}
-	path = convertTemplate(path, f.tplCback)
-	l, err := f.client.ListFolder(ctx, username, id, snapshot, path, true)
+	l, err = f.client.ListFolder(ctx, username, id, snapshot, path, true)
 	if err != nil {
 		return nil, err
 	}
-	_ = f.cache.SetWithExpire(key, l, time.Duration(f.conf.Expiration)*time.Second)
+	f.setCache(ctx, key, l)
 	return l, nil
 }
 
 func (f *fs) listSnapshots(ctx context.Context, username string, id int) ([]*utils.Snapshot, error) {
 	key := fmt.Sprintf("snapshots:%s:%d", username, id)
-	if l, err := f.cache.Get(key); err == nil {
-		return l.([]*utils.Snapshot), nil
+	var l []*utils.Snapshot
+	if f.cache.get(key, &l) {
+		return l, nil
+	}
+	if err := f.allow(ctx, username); err != nil {
+		return nil, err
 	}
 	l, err := f.client.ListSnapshots(ctx, username, id)
 	if err != nil {
 		return nil, err
 	}
+	f.truncateSnapshotsTime(l)
+	f.setCache(ctx, key, l)
+	return l, nil
+}
+
+func (f *fs) listForgottenSnapshots(ctx context.Context, username string, id int) ([]*utils.Snapshot, error) {
+	key := fmt.Sprintf("forgotten:%s:%d", username, id)
+	var l []*utils.Snapshot
+	if f.cache.get(key, &l) {
+		return l, nil
+	}
+	if err := f.allow(ctx, username); err != nil {
+		return nil, err
+	}
+	l, err := f.client.ListForgottenSnapshots(ctx, username, id)
+	if err != nil {
+		return nil, err
+	}
+	f.truncateSnapshotsTime(l)
+	f.setCache(ctx, key, l)
+	return l, nil
+}
+
+// listSnapshotsOfBackups lists concurrently the snapshots of the given backups.
+// The snapshots of the backups that could be listed are returned, together
+// with the first error encountered.
+func (f *fs) listSnapshotsOfBackups(ctx context.Context, username string, ids []int) (map[int][]*utils.Snapshot, error) {
+	var mu sync.Mutex
+	res := make(map[int][]*utils.Snapshot, len(ids))
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(f.conf.ListConcurrency)
+	for _, id := range ids {
+		id := id
+		g.Go(func() error {
+			l, err := f.listSnapshots(ctx, username, id)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			res[id] = l
+			mu.Unlock()
+			return nil
+		})
+	}
+	err := g.Wait()
+	return res, err
+}
+
+// truncateSnapshotsTime truncates the time of the snapshots according to the
+// configured format, as the formatted time is used as snapshot id.
+func (f *fs) truncateSnapshotsTime(l []*utils.Snapshot) {
 	for _, snap := range l {
-		// truncate the time according to the given format
 		t, _ := time.Parse(f.conf.TimestampFormat, snap.Time.Format(f.conf.TimestampFormat))
 		snap.Time = utils.CBackTime{Time: t}
 	}
-	_ = f.cache.SetWithExpire(key, l, time.Duration(f.conf.Expiration)*time.Second)
-	return l, nil
 }
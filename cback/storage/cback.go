@@ -32,9 +32,9 @@ import (
 	"time"
 
 	"github.com/Masterminds/sprig"
-	"github.com/bluele/gcache"
 	"github.com/cernbox/reva-plugins/cback/utils"
 	cback "github.com/cernbox/reva-plugins/cback/utils"
+	"github.com/cernbox/reva-plugins/ratelimit"
 	user "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
 	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
 	types "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
@@ -44,16 +44,17 @@ import (
 	"github.com/cs3org/reva/pkg/mime"
 	"github.com/cs3org/reva/pkg/storage"
 	"github.com/cs3org/reva/pkg/storage/fs/registry"
-	"github.com/mitchellh/mapstructure"
+	"github.com/cs3org/reva/pkg/utils/cfg"
 	"github.com/pkg/errors"
 )
 
 type fs struct {
 	conf       *Config
 	client     *utils.Client
-	cache      gcache.Cache
+	cache      cacheStore
 	tplStorage *template.Template
 	tplCback   *template.Template
+	limiter    ratelimit.Limiter
 }
 
 func init() {
@@ -66,35 +67,56 @@ var _ registry.NewFunc = New
 // the snapshots stored in cback.
 func New(_ context.Context, m map[string]interface{}) (storage.FS, error) {
 	c := &Config{}
-	if err := mapstructure.Decode(m, c); err != nil {
-		return nil, errors.Wrap(err, "cback: error decoding config")
+	if err := cfg.Decode(m, c); err != nil {
+		return nil, errors.Wrap(err, "cback: invalid config")
 	}
-	c.init()
 
 	tplStorage, err := template.New("tpl_storage").Funcs(sprig.TxtFuncMap()).Parse(c.TemplateToStorage)
 	if err != nil {
-		return nil, errors.Wrap(err, "cback: error creating template")
+		return nil, errors.Wrap(err, "cback: error creating template_to_storage")
 	}
 
 	tplCback, err := template.New("tpl_cback").Funcs(sprig.TxtFuncMap()).Parse(c.TemplateToCback)
 	if err != nil {
-		return nil, errors.Wrap(err, "cback: error creating template")
+		return nil, errors.Wrap(err, "cback: error creating template_to_cback")
 	}
 
 	client := utils.New(
 		&utils.Config{
-			URL:     c.APIURL,
-			Token:   c.Token,
-			Timeout: c.Timeout,
+			URL:                 c.APIURL,
+			Token:               c.Token,
+			Timeout:             c.Timeout,
+			Checksum:            c.Checksum,
+			Insecure:            c.Insecure,
+			MaxIdleConns:        c.MaxIdleConns,
+			MaxIdleConnsPerHost: c.MaxIdleConnsPerHost,
+			IdleConnTimeout:     c.IdleConnTimeout,
+			Retries:             c.Retries,
+			RetryBackoff:        c.RetryBackoff,
+			BreakerThreshold:    c.BreakerThreshold,
+			BreakerTimeout:      c.BreakerTimeout,
 		},
 	)
 
+	cache, err := newCacheStore(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var limiter ratelimit.Limiter
+	if c.UserRate > 0 || c.GlobalRate > 0 {
+		if limiter, err = ratelimit.NewLimiterFromMap(c.RateLimitStore); err != nil {
+			return nil, err
+		}
+	}
+
 	return &fs{
 		conf:       c,
 		client:     client,
-		cache:      gcache.New(c.Size).LRU().Build(),
+		cache:      cache,
 		tplStorage: tplStorage,
 		tplCback:   tplCback,
+		limiter:    limiter,
 	}, nil
 }
 
@@ -138,11 +160,9 @@ func (f *fs) convertToResourceInfo(r *utils.Resource, path string, resID, parent
 	}
 
 	return &provider.ResourceInfo{
-		Type: rtype,
-		Id:   resID,
-		Checksum: &provider.ResourceChecksum{
-			Type: provider.ResourceChecksumType_RESOURCE_CHECKSUM_TYPE_UNSET,
-		},
+		Type:     rtype,
+		Id:       resID,
+		Checksum: f.checksum(r),
 		Etag:     strconv.FormatUint(uint64(r.CTime), 10),
 		MimeType: mime.Detect(r.IsDir(), path),
 		Mtime: &types.Timestamp{
@@ -156,6 +176,24 @@ func (f *fs) convertToResourceInfo(r *utils.Resource, path string, resID, parent
 	}
 }
 
+var checksumTypes = map[string]provider.ResourceChecksumType{
+	"adler32": provider.ResourceChecksumType_RESOURCE_CHECKSUM_TYPE_ADLER32,
+	"md5":     provider.ResourceChecksumType_RESOURCE_CHECKSUM_TYPE_MD5,
+	"sha1":    provider.ResourceChecksumType_RESOURCE_CHECKSUM_TYPE_SHA1,
+}
+
+func (f *fs) checksum(r *utils.Resource) *provider.ResourceChecksum {
+	if f.conf.Checksum == "" || r.Checksum == "" {
+		return &provider.ResourceChecksum{
+			Type: provider.ResourceChecksumType_RESOURCE_CHECKSUM_TYPE_UNSET,
+		}
+	}
+	return &provider.ResourceChecksum{
+		Type: checksumTypes[f.conf.Checksum],
+		Sum:  r.Checksum,
+	}
+}
+
 func encodeBackupInResourceID(backupID int, snapshotID, source, path string) *provider.ResourceId {
 	id := fmt.Sprintf("%d#%s#%s#%s", backupID, snapshotID, source, path)
 	opaque := base64.StdEncoding.EncodeToString([]byte(id))
@@ -191,6 +229,37 @@ func GetBackupInfo(r *provider.ResourceId) (string, string, int, bool) {
 	return filepath.Join(source, path), snap, id, ok
 }
 
+// Keys of the arbitrary metadata describing the provenance
+// of the backups and of the snapshots.
+const (
+	MetadataBackupID   = "cback:backup-id"
+	MetadataRepository = "cback:repository"
+	MetadataSnapshotID = "cback:snapshot-id"
+)
+
+func findBackup(backups []*utils.Backup, id int) *utils.Backup {
+	for _, b := range backups {
+		if b.ID == id {
+			return b
+		}
+	}
+	return nil
+}
+
+// backupMetadata returns the arbitrary metadata of a backup, and of
+// one of its snapshots if snap is not nil.
+func backupMetadata(b *utils.Backup, snap *utils.Snapshot) *provider.ArbitraryMetadata {
+	md := map[string]string{}
+	if b != nil {
+		md[MetadataBackupID] = strconv.Itoa(b.ID)
+		md[MetadataRepository] = b.Repository
+	}
+	if snap != nil {
+		md[MetadataSnapshotID] = snap.ID
+	}
+	return &provider.ArbitraryMetadata{Metadata: md}
+}
+
 func (f *fs) placeholderResourceInfo(path string, owner *user.UserId, mtime *types.Timestamp, resID *provider.ResourceId) *provider.ResourceInfo {
 	if mtime == nil {
 		mtime = &types.Timestamp{
@@ -228,18 +297,48 @@ func hasPrefix(lst, prefix []string) bool {
 	return true
 }
 
-func (f *fs) isParentOfBackup(path string, backups []*utils.Backup) bool {
+// backupsUnder returns the backups whose source is inside path.
+func backupsUnder(path string, backups []*utils.Backup) []*utils.Backup {
 	pathSplit := []string{""}
 	if path != "/" {
 		pathSplit = strings.Split(path, "/")
 	}
+	var res []*utils.Backup
 	for _, b := range backups {
 		backupSplit := strings.Split(b.Source, "/")
 		if hasPrefix(backupSplit, pathSplit) {
-			return true
+			res = append(res, b)
+		}
+	}
+	return res
+}
+
+// latestSnapshotTime returns the time of the most recent snapshot
+// among the given backups, or nil if there is none.
+// The snapshots of the backups are listed concurrently.
+func (f *fs) latestSnapshotTime(ctx context.Context, username string, backups []*utils.Backup) *types.Timestamp {
+	ids := make([]int, 0, len(backups))
+	for _, b := range backups {
+		ids = append(ids, b.ID)
+	}
+	snapshots, err := f.listSnapshotsOfBackups(ctx, username, ids)
+	if err != nil {
+		// the mtime is only informative, use what was listed
+		appctx.GetLogger(ctx).Error().Err(err).Ints("backups", ids).Msg("cback: error listing snapshots")
+	}
+
+	var latest time.Time
+	for _, l := range snapshots {
+		for _, s := range l {
+			if s.Time.After(latest) {
+				latest = s.Time.Time
+			}
 		}
 	}
-	return false
+	if latest.IsZero() {
+		return nil
+	}
+	return timeToTimestamp(latest)
 }
 
 func (f *fs) GetMD(ctx context.Context, ref *provider.Reference, mdKeys []string) (*provider.ResourceInfo, error) {
@@ -253,7 +352,7 @@ func (f *fs) GetMD(ctx context.Context, ref *provider.Reference, mdKeys []string
 		id                     int
 	)
 
-	backups, err := f.listBackups(ctx, user.Username)
+	backups, err := f.listBackups(ctx, user)
 	if err != nil {
 		return nil, errors.Wrapf(err, "cback: error listing backups")
 	}
@@ -265,10 +364,21 @@ func (f *fs) GetMD(ctx context.Context, ref *provider.Reference, mdKeys []string
 		}
 	} else {
 		source, snapshot, path, id, ok = split(ref.Path, backups)
-		source = convertTemplate(source, f.tplCback)
+		source, err = convertTemplate(source, f.tplCback)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	if ok {
+		// the name of the snapshot in the path is kept even if it is
+		// the alias of the latest snapshot, while the resource ids
+		// always refer to the actual snapshot
+		snapName := snapshot
+		if snapshot, err = f.resolveSnapshot(ctx, user.Username, id, snapshot); err != nil {
+			return nil, err
+		}
+
 		if snapshot != "" && path != "" {
 			// the path from the user is something like /eos/home-g/gdelmont/<snapshot_id>/rest/of/path
 			// in this case the method has to return the stat of the file /eos/home-g/gdelmont/rest/of/path
@@ -279,7 +389,7 @@ func (f *fs) GetMD(ctx context.Context, ref *provider.Reference, mdKeys []string
 			}
 			return f.convertToResourceInfo(
 				res,
-				filepath.Join(source, snapshot, path),
+				filepath.Join(source, snapName, path),
 				encodeBackupInResourceID(id, snapshot, source, path),
 				encodeBackupInResourceID(id, snapshot, source, filepath.Dir(path)),
 				user.Id,
@@ -290,17 +400,23 @@ func (f *fs) GetMD(ctx context.Context, ref *provider.Reference, mdKeys []string
 			if err != nil {
 				return nil, errors.Wrap(err, "cback: error getting snapshot")
 			}
-			return f.placeholderResourceInfo(filepath.Join(source, snapshot), user.Id, timeToTimestamp(snap.Time.Time), encodeBackupInResourceID(id, snapshot, source, "")), nil
+			info := f.placeholderResourceInfo(filepath.Join(source, snapName), user.Id, timeToTimestamp(snap.Time.Time), encodeBackupInResourceID(id, snapshot, source, ""))
+			info.ArbitraryMetadata = backupMetadata(findBackup(backups, id), snap)
+			return info, nil
 		}
 		// the path from the user is something like /eos/home-g/gdelmont
-		return f.placeholderResourceInfo(source, user.Id, nil, nil), nil
+		mtime := f.latestSnapshotTime(ctx, user.Username, []*utils.Backup{{ID: id}})
+		info := f.placeholderResourceInfo(source, user.Id, mtime, nil)
+		info.ArbitraryMetadata = backupMetadata(findBackup(backups, id), nil)
+		return info, nil
 	}
 
 	// the path is not one of the backup. There is a situation in which
 	// the user's path is a parent folder of some of the backups
 
-	if f.isParentOfBackup(source, backups) {
-		return f.placeholderResourceInfo(source, user.Id, nil, nil), nil
+	if under := backupsUnder(source, backups); len(under) != 0 {
+		mtime := f.latestSnapshotTime(ctx, user.Username, under)
+		return f.placeholderResourceInfo(source, user.Id, mtime, nil), nil
 	}
 
 	return nil, errtypes.NotFound(fmt.Sprintf("path %s does not exist", source))
@@ -329,19 +445,51 @@ func (f *fs) getSnapshot(ctx context.Context, username string, backupID int, tim
 	return nil, errtypes.NotFound(fmt.Sprintf("snapshot %s from backup %d not found", timestamp, backupID))
 }
 
+func latestSnapshot(snapshots []*utils.Snapshot) *utils.Snapshot {
+	var latest *utils.Snapshot
+	for _, s := range snapshots {
+		if latest == nil || s.Time.After(latest.Time.Time) {
+			latest = s
+		}
+	}
+	return latest
+}
+
+// resolveSnapshot returns the id of the most recent snapshot of the backup
+// if snapshot is the configured alias, otherwise the snapshot itself.
+func (f *fs) resolveSnapshot(ctx context.Context, username string, backupID int, snapshot string) (string, error) {
+	if f.conf.LatestAlias == "" || snapshot != f.conf.LatestAlias {
+		return snapshot, nil
+	}
+	snapshots, err := f.listSnapshots(ctx, username, backupID)
+	if err != nil {
+		return "", errors.Wrap(err, "cback: error listing snapshots")
+	}
+	latest := latestSnapshot(snapshots)
+	if latest == nil {
+		return "", errtypes.NotFound(fmt.Sprintf("backup %d has no snapshots", backupID))
+	}
+	return latest.Time.Format(f.conf.TimestampFormat), nil
+}
+
 func (f *fs) ListFolder(ctx context.Context, ref *provider.Reference, mdKeys []string) ([]*provider.ResourceInfo, error) {
 	user, ok := appctx.ContextGetUser(ctx)
 	if !ok {
 		return nil, errtypes.UserRequired("cback: user not found in context")
 	}
 
-	backups, err := f.listBackups(ctx, user.Username)
+	backups, err := f.listBackups(ctx, user)
 	if err != nil {
 		return nil, errors.Wrapf(err, "cback: error listing backups")
 	}
 
 	source, snapshot, path, id, ok := split(ref.Path, backups)
 	if ok {
+		snapName := snapshot
+		if snapshot, err = f.resolveSnapshot(ctx, user.Username, id, snapshot); err != nil {
+			return nil, err
+		}
+
 		if snapshot != "" {
 			// the path from the user is something like /eos/home-g/gdelmont/<snapshot_id>/(rest/of/path)
 			// in this case the method has to return the content of the folder /eos/home-g/gdelmont/(rest/of/path)
@@ -356,7 +504,7 @@ func (f *fs) ListFolder(ctx context.Context, ref *provider.Reference, mdKeys []s
 				base := filepath.Base(info.Name)
 				res = append(res, f.convertToResourceInfo(
 					info,
-					filepath.Join(source, snapshot, path, base),
+					filepath.Join(source, snapName, path, base),
 					encodeBackupInResourceID(id, snapshot, source, filepath.Join(path, base)),
 					parentID,
 					user.Id,
@@ -370,41 +518,72 @@ func (f *fs) ListFolder(ctx context.Context, ref *provider.Reference, mdKeys []s
 		if err != nil {
 			return nil, err
 		}
-		res := make([]*provider.ResourceInfo, 0, len(snapshots))
+		b := findBackup(backups, id)
+		res := make([]*provider.ResourceInfo, 0, len(snapshots)+1)
 		for _, s := range snapshots {
 			snapTime := s.Time.Format(f.conf.TimestampFormat)
-			res = append(res, f.placeholderResourceInfo(filepath.Join(source, snapTime), user.Id, timeToTimestamp(s.Time.Time), encodeBackupInResourceID(id, snapTime, source, "")))
+			info := f.placeholderResourceInfo(filepath.Join(source, snapTime), user.Id, timeToTimestamp(s.Time.Time), encodeBackupInResourceID(id, snapTime, source, ""))
+			info.ArbitraryMetadata = backupMetadata(b, s)
+			res = append(res, info)
+		}
+		if latest := latestSnapshot(snapshots); f.conf.LatestAlias != "" && latest != nil {
+			snapTime := latest.Time.Format(f.conf.TimestampFormat)
+			info := f.placeholderResourceInfo(filepath.Join(source, f.conf.LatestAlias), user.Id, timeToTimestamp(latest.Time.Time), encodeBackupInResourceID(id, snapTime, source, ""))
+			info.ArbitraryMetadata = backupMetadata(b, latest)
+			res = append(res, info)
 		}
 		return res, nil
 	}
 
 	// the path is not one of the backup. Can happen that the
 	// user's path is a parent folder of some of the backups
-	resSet := make(map[string]struct{}) // used to discard duplicates
-	var resources []*provider.ResourceInfo
+	under := backupsUnder(ref.Path, backups)
+	if len(under) == 0 {
+		return nil, errtypes.NotFound(fmt.Sprintf("path %s does not exist", ref.Path))
+	}
+
+	// the snapshots of all the backups are fetched at once, to compute the
+	// mtime of the folders as the time of the most recent snapshot they contain
+	ids := make([]int, 0, len(under))
+	for _, b := range under {
+		ids = append(ids, b.ID)
+	}
+	snapshots, err := f.listSnapshotsOfBackups(ctx, user.Username, ids)
+	if err != nil {
+		appctx.GetLogger(ctx).Error().Err(err).Ints("backups", ids).Msg("cback: error listing snapshots")
+	}
 
 	sourceSplit := []string{""}
 	if ref.Path != "/" {
 		sourceSplit = strings.Split(ref.Path, "/")
 	}
-	for _, b := range backups {
+	var paths []string
+	latest := make(map[string]time.Time)
+	for _, b := range under {
 		backupSplit := strings.Split(b.Source, "/")
-		if hasPrefix(backupSplit, sourceSplit) {
-			base := backupSplit[len(sourceSplit)]
-			path := filepath.Join(ref.Path, base)
+		path := filepath.Join(ref.Path, backupSplit[len(sourceSplit)])
 
-			if _, ok := resSet[path]; !ok {
-				resources = append(resources, f.placeholderResourceInfo(path, user.Id, nil, nil))
-				resSet[path] = struct{}{}
+		t, ok := latest[path]
+		if !ok {
+			paths = append(paths, path)
+		}
+		for _, s := range snapshots[b.ID] {
+			if s.Time.After(t) {
+				t = s.Time.Time
 			}
 		}
+		latest[path] = t
 	}
 
-	if len(resources) != 0 {
-		return resources, nil
+	resources := make([]*provider.ResourceInfo, 0, len(paths))
+	for _, path := range paths {
+		var mtime *types.Timestamp
+		if t := latest[path]; !t.IsZero() {
+			mtime = timeToTimestamp(t)
+		}
+		resources = append(resources, f.placeholderResourceInfo(path, user.Id, mtime, nil))
 	}
-
-	return nil, errtypes.NotFound(fmt.Sprintf("path %s does not exist", ref.Path))
+	return resources, nil
 }
 
 func (f *fs) Download(ctx context.Context, ref *provider.Reference) (io.ReadCloser, error) {
@@ -418,24 +597,31 @@ func (f *fs) Download(ctx context.Context, ref *provider.Reference) (io.ReadClos
 		return nil, errors.Wrap(err, "cback: error statting resource")
 	}
 
-	if stat.Type != provider.ResourceType_RESOURCE_TYPE_FILE {
-		return nil, errtypes.BadRequest("cback: can only download files")
+	source, snapshot, path, id, ok := decodeResourceID(stat.Id)
+	if !ok || snapshot == "" {
+		return nil, errtypes.BadRequest("cback: can only download resources in a snapshot")
+	}
+	source, err = convertTemplate(source, f.tplCback)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.allow(ctx, user.Username); err != nil {
+		return nil, err
 	}
 
-	source, snapshot, path, id, ok := decodeResourceID(stat.Id)
-	if !ok {
-		return nil, errtypes.BadRequest("cback: can only download files")
+	if stat.Type == provider.ResourceType_RESOURCE_TYPE_CONTAINER {
+		// folders are downloaded as an archive
+		return f.client.DownloadArchive(ctx, user.Username, id, snapshot, filepath.Join(source, path), true, utils.ArchiveFormat(f.conf.ArchiveFormat))
 	}
-	source = convertTemplate(source, f.tplCback)
 	return f.client.Download(ctx, user.Username, id, snapshot, filepath.Join(source, path), true)
 }
 
-func convertTemplate(s string, t *template.Template) string {
+func convertTemplate(s string, t *template.Template) (string, error) {
 	var b bytes.Buffer
 	if err := t.Execute(&b, s); err != nil {
-		panic(errors.Wrap(err, "error executing template"))
+		return "", errors.Wrapf(err, "cback: error executing template %s", t.Name())
 	}
-	return b.String()
+	return b.String(), nil
 }
 
 func (f *fs) GetHome(ctx context.Context) (string, error) {
@@ -470,8 +656,47 @@ func (f *fs) DownloadRevision(ctx context.Context, ref *provider.Reference, key
 	return nil, errtypes.NotSupported("Operation Not Permitted")
 }
 
+// RestoreRevision creates a cback restore job of the referenced resource back
+// to its original location. The key, if given, is the snapshot to restore from;
+// otherwise the snapshot of the reference is used.
 func (f *fs) RestoreRevision(ctx context.Context, ref *provider.Reference, key string) error {
-	return errtypes.NotSupported("Operation Not Permitted")
+	user, ok := appctx.ContextGetUser(ctx)
+	if !ok {
+		return errtypes.UserRequired("cback: user not found in context")
+	}
+
+	stat, err := f.GetMD(ctx, ref, nil)
+	if err != nil {
+		return errors.Wrap(err, "cback: error statting resource")
+	}
+
+	source, snapshot, path, id, ok := decodeResourceID(stat.Id)
+	if !ok {
+		return errtypes.BadRequest("cback: can only restore resources in a snapshot")
+	}
+
+	if key != "" {
+		if key, err = f.resolveSnapshot(ctx, user.Username, id, key); err != nil {
+			return err
+		}
+		if _, err := f.getSnapshot(ctx, user.Username, id, key); err != nil {
+			return err
+		}
+		snapshot = key
+	}
+	if snapshot == "" {
+		return errtypes.BadRequest("cback: missing snapshot to restore from")
+	}
+
+	pattern, err := convertTemplate(filepath.Join(source, path), f.tplCback)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.client.NewRestore(ctx, user.Username, id, pattern, snapshot, true); err != nil {
+		return errors.Wrap(err, "cback: error creating restore job")
+	}
+	return nil
 }
 
 func (f *fs) GetPathByID(ctx context.Context, id *provider.ResourceId) (string, error) {
@@ -507,7 +732,7 @@ func (f *fs) CreateReference(ctx context.Context, path string, targetURI *url.UR
 }
 
 func (f *fs) Shutdown(ctx context.Context) error {
-	return errtypes.NotSupported("Operation Not Permitted")
+	return f.cache.close()
 }
 
 func (f *fs) SetArbitraryMetadata(ctx context.Context, ref *provider.Reference, md *provider.ArbitraryMetadata) error {
@@ -526,14 +751,6 @@ func (f *fs) CreateStorageSpace(ctx context.Context, req *provider.CreateStorage
 	return nil, errtypes.NotSupported("Operation Not Permitted")
 }
 
-func (f *fs) ListRecycle(ctx context.Context, basePath, key, relativePath string, from, to *types.Timestamp) ([]*provider.RecycleItem, error) {
-	return nil, errtypes.NotSupported("Operation Not Permitted")
-}
-
-func (f *fs) RestoreRecycleItem(ctx context.Context, basePath, key, relativePath string, restoreRef *provider.Reference) error {
-	return errtypes.NotSupported("Operation Not Permitted")
-}
-
 func (f *fs) PurgeRecycleItem(ctx context.Context, basePath, key, relativePath string) error {
 	return errtypes.NotSupported("Operation Not Permitted")
 }
@@ -18,12 +18,16 @@
 
 package cbackfs
 
-import provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+import (
+	"math"
+
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+)
 
 // Config for the cback driver.
 type Config struct {
-	Token             string `mapstructure:"token"`
-	APIURL            string `mapstructure:"api_url"`
+	Token             string `mapstructure:"token"   validate:"required"`
+	APIURL            string `mapstructure:"api_url" validate:"required,url"`
 	Insecure          bool   `mapstructure:"insecure"`
 	Timeout           int    `mapstructure:"timeout"`
 	Size              int    `mapstructure:"size"`
@@ -31,9 +35,56 @@ type Config struct {
 	TemplateToStorage string `mapstructure:"template_to_storage"`
 	TemplateToCback   string `mapstructure:"template_to_cback"`
 	TimestampFormat   string `mapstructure:"timestamp_format"`
+	// ListConcurrency is the maximum number of concurrent requests
+	// made to cback when listing the snapshots of multiple backups.
+	ListConcurrency int `mapstructure:"list_concurrency"`
+	// GroupBackups enables browsing the backups owned by the
+	// groups the user is member of, next to the user's own backups.
+	GroupBackups bool `mapstructure:"group_backups"`
+	// Checksum is the type of checksum exposed for the files in the
+	// snapshots: adler32, md5 or sha1. Empty to not compute the checksums.
+	Checksum string `mapstructure:"checksum" validate:"omitempty,oneof=adler32 md5 sha1"`
+	// UserRate and GlobalRate are the number of requests per second
+	// each user and all the users together can make to cback.
+	// A request exceeding the rate fails with a retryable error.
+	// A rate of 0 (default) disables the limit.
+	UserRate    float64 `mapstructure:"user_rate"    validate:"min=0"`
+	UserBurst   int     `mapstructure:"user_burst"`
+	GlobalRate  float64 `mapstructure:"global_rate"  validate:"min=0"`
+	GlobalBurst int     `mapstructure:"global_burst"`
+	// RateLimitStore is the configuration of the store of the rate
+	// limiter, see ratelimit.StoreConfig.
+	RateLimitStore map[string]interface{} `mapstructure:"ratelimit_store"`
+	// MaxIdleConns, MaxIdleConnsPerHost and IdleConnTimeout tune
+	// the pool of connections to cback, see utils.Config.
+	MaxIdleConns        int `mapstructure:"max_idle_conns"`
+	MaxIdleConnsPerHost int `mapstructure:"max_idle_conns_per_host"`
+	IdleConnTimeout     int `mapstructure:"idle_conn_timeout"`
+	// Retries, RetryBackoff, BreakerThreshold and BreakerTimeout
+	// configure the retries and the circuit breaker of the requests
+	// to cback, see utils.Config.
+	Retries          int `mapstructure:"retries"           validate:"min=0"`
+	RetryBackoff     int `mapstructure:"retry_backoff"`
+	BreakerThreshold int `mapstructure:"breaker_threshold" validate:"min=0"`
+	BreakerTimeout   int `mapstructure:"breaker_timeout"`
+	// LatestAlias is the name of a folder under each backup that always
+	// resolves to the most recent snapshot, e.g. "latest". Empty to disable.
+	LatestAlias string `mapstructure:"latest_alias"`
+	// CacheDriver is where the responses of cback are cached: "memory"
+	// (default) for a per-process cache, or "redis" for a cache shared
+	// among multiple reva instances.
+	CacheDriver   string `mapstructure:"cache_driver"   validate:"oneof=memory redis"`
+	RedisAddress  string `mapstructure:"redis_address"`
+	RedisUsername string `mapstructure:"redis_username"`
+	RedisPassword string `mapstructure:"redis_password"`
+	RedisPrefix   string `mapstructure:"redis_prefix"`
+	// ArchiveFormat is the format of the archives in which the
+	// folders are downloaded: "tar" (default) or "zip".
+	ArchiveFormat string `mapstructure:"archive_format" validate:"oneof=tar zip"`
 }
 
-func (c *Config) init() {
+// ApplyDefaults sets the defaults for the cback driver config.
+func (c *Config) ApplyDefaults() {
 	if c.Size == 0 {
 		c.Size = 1_000_000
 	}
@@ -53,6 +104,34 @@ func (c *Config) init() {
 	if c.TimestampFormat == "" {
 		c.TimestampFormat = "2006-01-02T15:04:05Z07:00"
 	}
+
+	if c.ListConcurrency == 0 {
+		c.ListConcurrency = 4
+	}
+
+	if c.UserBurst == 0 {
+		c.UserBurst = max(1, int(math.Ceil(c.UserRate)))
+	}
+
+	if c.GlobalBurst == 0 {
+		c.GlobalBurst = max(1, int(math.Ceil(c.GlobalRate)))
+	}
+
+	if c.CacheDriver == "" {
+		c.CacheDriver = "memory"
+	}
+
+	if c.RedisAddress == "" {
+		c.RedisAddress = ":6379"
+	}
+
+	if c.RedisPrefix == "" {
+		c.RedisPrefix = "cback:"
+	}
+
+	if c.ArchiveFormat == "" {
+		c.ArchiveFormat = "tar"
+	}
 }
 
 var permDir = &provider.ResourcePermissions{
@@ -66,12 +145,12 @@ var permDir = &provider.ResourcePermissions{
 	ListGrants:           true,
 	ListContainer:        true,
 	ListFileVersions:     true,
-	ListRecycle:          false,
+	ListRecycle:          true,
 	Move:                 false,
 	RemoveGrant:          false,
 	PurgeRecycle:         false,
-	RestoreFileVersion:   false,
-	RestoreRecycleItem:   false,
+	RestoreFileVersion:   true,
+	RestoreRecycleItem:   true,
 	Stat:                 true,
 	UpdateGrant:          false,
 	DenyGrant:            false,
@@ -92,7 +171,7 @@ var permFile = &provider.ResourcePermissions{
 	Move:                 false,
 	RemoveGrant:          false,
 	PurgeRecycle:         false,
-	RestoreFileVersion:   false,
+	RestoreFileVersion:   true,
 	RestoreRecycleItem:   false,
 	Stat:                 true,
 	UpdateGrant:          false,
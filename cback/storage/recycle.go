@@ -0,0 +1,184 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package cbackfs
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	user "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	types "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
+	"github.com/cs3org/reva/pkg/appctx"
+	"github.com/cs3org/reva/pkg/errtypes"
+	"github.com/pkg/errors"
+)
+
+// The recycle bin of a backup exposes the snapshots forgotten by the
+// retention policy but not yet pruned from the restic repository.
+// The basePath is the source of the backup, the key is the snapshot
+// id (the formatted snapshot time) and the relativePath is the path
+// of a resource inside the snapshot. The key of the items nested in a
+// snapshot is <snapshot id>/<path>, and is split back when restored.
+
+func (f *fs) getRecycleBackup(ctx context.Context, u *user.User, basePath string) (string, int, error) {
+	backups, err := f.listBackups(ctx, u)
+	if err != nil {
+		return "", 0, errors.Wrap(err, "cback: error listing backups")
+	}
+	source, snapshot, _, id, ok := split(basePath, backups)
+	if !ok || snapshot != "" {
+		return "", 0, errtypes.NotFound(fmt.Sprintf("path %s is not a backup", basePath))
+	}
+	return source, id, nil
+}
+
+// getForgottenSnapshot finds the forgotten snapshot the key refers to.
+// It returns the snapshot id and the path of the item in the snapshot
+// for the keys of nested items.
+func (f *fs) getForgottenSnapshot(ctx context.Context, username string, backupID int, key string) (string, string, error) {
+	snapshots, err := f.listForgottenSnapshots(ctx, username, backupID)
+	if err != nil {
+		return "", "", err
+	}
+	for _, s := range snapshots {
+		// the snapshot id is matched as a whole, as a custom
+		// timestamp format may contain slashes
+		id := s.Time.Format(f.conf.TimestampFormat)
+		if key == id {
+			return id, "", nil
+		}
+		if rel, ok := strings.CutPrefix(key, id+"/"); ok {
+			return id, rel, nil
+		}
+	}
+	return "", "", errtypes.NotFound(fmt.Sprintf("snapshot %s from backup %d not found in the recycle", key, backupID))
+}
+
+func inTimeRange(t *types.Timestamp, from, to *types.Timestamp) bool {
+	if from != nil && t.Seconds < from.Seconds {
+		return false
+	}
+	if to != nil && t.Seconds > to.Seconds {
+		return false
+	}
+	return true
+}
+
+func (f *fs) ListRecycle(ctx context.Context, basePath, key, relativePath string, from, to *types.Timestamp) ([]*provider.RecycleItem, error) {
+	user, ok := appctx.ContextGetUser(ctx)
+	if !ok {
+		return nil, errtypes.UserRequired("cback: user not found in context")
+	}
+
+	source, id, err := f.getRecycleBackup(ctx, user, basePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if key == "" {
+		// list the forgotten snapshots
+		snapshots, err := f.listForgottenSnapshots(ctx, user.Username, id)
+		if err != nil {
+			return nil, errors.Wrap(err, "cback: error listing forgotten snapshots")
+		}
+		items := make([]*provider.RecycleItem, 0, len(snapshots))
+		for _, s := range snapshots {
+			snapTime := s.Time.Format(f.conf.TimestampFormat)
+			ts := timeToTimestamp(s.Time.Time)
+			if !inTimeRange(ts, from, to) {
+				continue
+			}
+			items = append(items, &provider.RecycleItem{
+				Type:         provider.ResourceType_RESOURCE_TYPE_CONTAINER,
+				Key:          snapTime,
+				Ref:          &provider.Reference{Path: filepath.Join(source, snapTime)},
+				DeletionTime: ts,
+			})
+		}
+		return items, nil
+	}
+
+	// list the content of a folder in a forgotten snapshot
+	snapshot, rel, err := f.getForgottenSnapshot(ctx, user.Username, id, key)
+	if err != nil {
+		return nil, err
+	}
+	relativePath = filepath.Join(rel, relativePath)
+
+	content, err := f.listFolder(ctx, user.Username, id, snapshot, filepath.Join(source, relativePath))
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*provider.RecycleItem, 0, len(content))
+	for _, r := range content {
+		rtype := provider.ResourceType_RESOURCE_TYPE_FILE
+		if r.IsDir() {
+			rtype = provider.ResourceType_RESOURCE_TYPE_CONTAINER
+		}
+		base := filepath.Base(r.Name)
+		items = append(items, &provider.RecycleItem{
+			Type:         rtype,
+			Key:          filepath.Join(snapshot, relativePath, base),
+			Ref:          &provider.Reference{Path: filepath.Join(source, snapshot, relativePath, base)},
+			Size:         r.Size,
+			DeletionTime: &types.Timestamp{Seconds: uint64(r.CTime)},
+		})
+	}
+	return items, nil
+}
+
+func (f *fs) RestoreRecycleItem(ctx context.Context, basePath, key, relativePath string, restoreRef *provider.Reference) error {
+	user, ok := appctx.ContextGetUser(ctx)
+	if !ok {
+		return errtypes.UserRequired("cback: user not found in context")
+	}
+
+	source, id, err := f.getRecycleBackup(ctx, user, basePath)
+	if err != nil {
+		return err
+	}
+
+	if key == "" {
+		return errtypes.BadRequest("cback: missing snapshot to restore")
+	}
+
+	snapshot, rel, err := f.getForgottenSnapshot(ctx, user.Username, id, key)
+	if err != nil {
+		return err
+	}
+	relativePath = filepath.Join(rel, relativePath)
+
+	if restoreRef != nil && restoreRef.Path != "" && restoreRef.Path != filepath.Join(source, relativePath) {
+		return errtypes.NotSupported("cback: restoring to a different location is not supported")
+	}
+
+	pattern, err := convertTemplate(filepath.Join(source, relativePath), f.tplCback)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.client.NewRestore(ctx, user.Username, id, pattern, snapshot, true); err != nil {
+		return errors.Wrap(err, "cback: error creating restore job")
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package cbackfs
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	"github.com/cs3org/reva/pkg/appctx"
+)
+
+func TestRestoreNestedRecycleItem(t *testing.T) {
+	var mu sync.Mutex
+	var restores []map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/backups/":
+			_, _ = w.Write([]byte(`[{"id": 1, "source": "/eos/user/a/alice"}]`))
+		case "/backups/1/snapshots":
+			_, _ = w.Write([]byte(`[{"id": "abc", "time": "2024-01-02T03:04:05"}]`))
+		case "/restores/":
+			var req map[string]any
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			mu.Lock()
+			restores = append(restores, req)
+			mu.Unlock()
+			_, _ = w.Write([]byte(`{"id": 1}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	fs, err := New(context.Background(), map[string]interface{}{"api_url": srv.URL, "token": "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := appctx.ContextSetUser(context.Background(), &userpb.User{Username: "alice"})
+	const snapshot = "2024-01-02T03:04:05Z"
+	const base = "/eos/user/a/alice"
+
+	// the key of an item nested in a folder of the snapshot
+	key := snapshot + "/docs/report.pdf"
+	if err := fs.RestoreRecycleItem(ctx, base, key, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	// the same item, with the path given separately
+	if err := fs.RestoreRecycleItem(ctx, base, snapshot, "docs/report.pdf", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(restores) != 2 {
+		t.Fatalf("expected 2 restores, got %d", len(restores))
+	}
+	for _, r := range restores {
+		if r["date"] != snapshot {
+			t.Fatalf("expected the restore of snapshot %s, got %v", snapshot, r["date"])
+		}
+		if r["pattern"] != base+"/docs/report.pdf" {
+			t.Fatalf("expected the restore of %s/docs/report.pdf, got %v", base, r["pattern"])
+		}
+	}
+
+	if err := fs.RestoreRecycleItem(ctx, base, "2023-01-01T00:00:00Z/docs", "", nil); err == nil {
+		t.Fatal("expected an error restoring from an unknown snapshot")
+	}
+}
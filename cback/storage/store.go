@@ -0,0 +1,115 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package cbackfs
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/bluele/gcache"
+	"github.com/cernbox/reva-plugins/redispool"
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+// cacheStore stores the responses of the cback APIs.
+type cacheStore interface {
+	// get decodes in v the value stored with the given key,
+	// returning false if the key is not in the cache.
+	get(key string, v interface{}) bool
+	set(key string, v interface{}, expiration time.Duration) error
+	close() error
+}
+
+func newCacheStore(c *Config) (cacheStore, error) {
+	switch c.CacheDriver {
+	case "memory":
+		return &memoryStore{cache: gcache.New(c.Size).LRU().Build()}, nil
+	case "redis":
+		return newRedisStore(c.RedisAddress, c.RedisUsername, c.RedisPassword, c.RedisPrefix), nil
+	default:
+		return nil, errors.Errorf("cback: unknown cache driver %s", c.CacheDriver)
+	}
+}
+
+// memoryStore keeps the values in a per-process LRU cache.
+type memoryStore struct {
+	cache gcache.Cache
+}
+
+func (s *memoryStore) get(key string, v interface{}) bool {
+	d, err := s.cache.Get(key)
+	if err != nil {
+		return false
+	}
+	reflect.ValueOf(v).Elem().Set(reflect.ValueOf(d))
+	return true
+}
+
+func (s *memoryStore) set(key string, v interface{}, expiration time.Duration) error {
+	return s.cache.SetWithExpire(key, v, expiration)
+}
+
+func (s *memoryStore) close() error {
+	s.cache.Purge()
+	return nil
+}
+
+// redisStore keeps the values json encoded in redis, so that
+// the cache is shared among multiple reva instances.
+type redisStore struct {
+	pool   *redis.Pool
+	prefix string
+}
+
+func newRedisStore(address, username, password, prefix string) *redisStore {
+	return &redisStore{
+		pool:   redispool.New(&redispool.Config{Address: address, Username: username, Password: password}),
+		prefix: prefix,
+	}
+}
+
+func (s *redisStore) get(key string, v interface{}) bool {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	data, err := redis.Bytes(conn.Do("GET", s.prefix+key))
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(data, v) == nil
+}
+
+func (s *redisStore) set(key string, v interface{}, expiration time.Duration) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	_, err = conn.Do("SET", s.prefix+key, data, "PX", expiration.Milliseconds())
+	return err
+}
+
+func (s *redisStore) close() error {
+	return s.pool.Close()
+}
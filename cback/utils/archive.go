@@ -0,0 +1,172 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package utils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ArchiveFormat is the format of the archives of the folders.
+type ArchiveFormat string
+
+// The supported archive formats.
+const (
+	ArchiveTar ArchiveFormat = "tar"
+	ArchiveZip ArchiveFormat = "zip"
+)
+
+// archiveWriter adds the entries to an archive of a given format.
+type archiveWriter interface {
+	writeDir(name string, mode fs.FileMode, mtime time.Time) error
+	writeFile(name string, size int64, mode fs.FileMode, mtime time.Time) (io.Writer, error)
+	Close() error
+}
+
+func newArchiveWriter(w io.Writer, format ArchiveFormat) (archiveWriter, error) {
+	switch format {
+	case ArchiveTar, "":
+		return &tarWriter{tar.NewWriter(w)}, nil
+	case ArchiveZip:
+		return &zipWriter{zip.NewWriter(w)}, nil
+	default:
+		return nil, errors.Errorf("cback: unsupported archive format %q", format)
+	}
+}
+
+type tarWriter struct{ *tar.Writer }
+
+func (t *tarWriter) writeDir(name string, mode fs.FileMode, mtime time.Time) error {
+	return t.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: name + "/", Mode: int64(mode), ModTime: mtime})
+}
+
+func (t *tarWriter) writeFile(name string, size int64, mode fs.FileMode, mtime time.Time) (io.Writer, error) {
+	err := t.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Size: size, Mode: int64(mode), ModTime: mtime})
+	return t.Writer, err
+}
+
+type zipWriter struct{ *zip.Writer }
+
+func (z *zipWriter) writeDir(name string, mode fs.FileMode, mtime time.Time) error {
+	hdr := &zip.FileHeader{Name: name + "/", Modified: mtime}
+	hdr.SetMode(mode | fs.ModeDir)
+	_, err := z.CreateHeader(hdr)
+	return err
+}
+
+func (z *zipWriter) writeFile(name string, size int64, mode fs.FileMode, mtime time.Time) (io.Writer, error) {
+	// the files in the backups are mostly already compressed
+	// or too large to be worth it, so they are only stored
+	hdr := &zip.FileHeader{Name: name, Method: zip.Store, Modified: mtime, UncompressedSize64: uint64(size)}
+	hdr.SetMode(mode)
+	return z.CreateHeader(hdr)
+}
+
+// DownloadArchive streams an archive in the given format with the content
+// of a folder stored in cback. The archive is assembled while it is read,
+// listing the folder recursively and downloading each file.
+func (c *Client) DownloadArchive(ctx context.Context, username string, backupID int, snapshotID, path string, isTimestamp bool, format ArchiveFormat) (io.ReadCloser, error) {
+	// fail early if the folder does not exist
+	root, err := c.Stat(ctx, username, backupID, snapshotID, path, isTimestamp)
+	if err != nil {
+		return nil, err
+	}
+	if !root.IsDir() {
+		return nil, errors.Errorf("cback: %s is not a folder", path)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	pr, pw := io.Pipe()
+	aw, err := newArchiveWriter(pw, format)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	go func() {
+		defer cancel()
+		base := filepath.Dir(path)
+		err := c.writeArchive(ctx, aw, username, backupID, snapshotID, base, root, path, isTimestamp)
+		if err == nil {
+			err = aw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	return &archiveReader{PipeReader: pr, cancel: cancel}, nil
+}
+
+// archiveReader stops the assembling of the archive when closed.
+type archiveReader struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+}
+
+func (r *archiveReader) Close() error {
+	r.cancel()
+	return r.PipeReader.Close()
+}
+
+func (c *Client) writeArchive(ctx context.Context, aw archiveWriter, username string, backupID int, snapshotID, base string, r *Resource, path string, isTimestamp bool) error {
+	name, err := filepath.Rel(base, path)
+	if err != nil {
+		return err
+	}
+	mode := fs.FileMode(r.Mode & 0o777)
+	mtime := time.Unix(int64(r.MTime), 0)
+
+	if !r.IsDir() {
+		w, err := aw.writeFile(name, int64(r.Size), mode, mtime)
+		if err != nil {
+			return err
+		}
+		body, err := c.Download(ctx, username, backupID, snapshotID, path, isTimestamp)
+		if err != nil {
+			return errors.Wrapf(err, "cback: error downloading %s", path)
+		}
+		defer body.Close()
+		_, err = io.Copy(w, body)
+		return err
+	}
+
+	if err := aw.writeDir(name, mode, mtime); err != nil {
+		return err
+	}
+	content, err := c.ListFolder(ctx, username, backupID, snapshotID, path, isTimestamp)
+	if err != nil {
+		return err
+	}
+	for _, child := range content {
+		if !child.IsDir() && !child.IsFile() {
+			// links and special files are not archived
+			continue
+		}
+		childPath := filepath.Join(path, filepath.Base(child.Name))
+		if err := c.writeArchive(ctx, aw, username, backupID, snapshotID, base, child, childPath, isTimestamp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
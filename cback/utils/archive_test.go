@@ -0,0 +1,166 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package utils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newArchiveServer serves a snapshot with the folder docs,
+// containing docs/a.txt and docs/sub/b.txt.
+func newArchiveServer() *httptest.Server {
+	files := map[string]string{
+		"docs/a.txt":     "hello",
+		"docs/sub/b.txt": "world",
+	}
+	folders := map[string][]*Resource{
+		"docs":     {{Name: "a.txt", Type: "file", Size: 5}, {Name: "sub", Type: "dir"}},
+		"docs/sub": {{Name: "b.txt", Type: "file", Size: 5}},
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, ok := strings.CutPrefix(r.URL.Path, "/backups/1/snapshots/snap/")
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		switch {
+		case r.Method == http.MethodGet:
+			content, ok := files[path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			_, _ = io.WriteString(w, content)
+		case r.URL.Query().Get("content") == "true":
+			_ = json.NewEncoder(w).Encode(folders[path])
+		default:
+			res := &Resource{Name: path, Type: "file", Size: 5}
+			if _, ok := folders[path]; ok {
+				res.Type = "dir"
+			}
+			_ = json.NewEncoder(w).Encode(res)
+		}
+	}))
+}
+
+func TestDownloadArchive(t *testing.T) {
+	srv := newArchiveServer()
+	defer srv.Close()
+	c := New(&Config{URL: srv.URL, Token: "token"})
+
+	expected := map[string]string{
+		"docs/":          "",
+		"docs/a.txt":     "hello",
+		"docs/sub/":      "",
+		"docs/sub/b.txt": "world",
+	}
+
+	t.Run("tar", func(t *testing.T) {
+		r, err := c.DownloadArchive(context.Background(), "alice", 1, "snap", "docs", false, ArchiveTar)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+
+		// the archive is read while it is assembled
+		got := map[string]string{}
+		tr := tar.NewReader(r)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, _ := io.ReadAll(tr)
+			got[hdr.Name] = string(b)
+		}
+		checkArchive(t, expected, got)
+	})
+
+	t.Run("zip", func(t *testing.T) {
+		r, err := c.DownloadArchive(context.Background(), "alice", 1, "snap", "docs", false, ArchiveZip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := map[string]string{}
+		for _, f := range zr.File {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, _ := io.ReadAll(rc)
+			rc.Close()
+			got[f.Name] = string(b)
+		}
+		checkArchive(t, expected, got)
+	})
+}
+
+func TestDownloadArchiveClosedEarly(t *testing.T) {
+	srv := newArchiveServer()
+	defer srv.Close()
+	c := New(&Config{URL: srv.URL, Token: "token"})
+
+	r, err := c.DownloadArchive(context.Background(), "alice", 1, "snap", "docs", false, ArchiveTar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// closing the reader stops the goroutine writing the archive,
+	// which would otherwise block on the pipe
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected an error reading a closed archive")
+	}
+}
+
+func checkArchive(t *testing.T, expected, got map[string]string) {
+	t.Helper()
+	if len(got) != len(expected) {
+		t.Fatalf("expected entries %v, got %v", expected, got)
+	}
+	for name, content := range expected {
+		if c, ok := got[name]; !ok || c != content {
+			t.Fatalf("expected %s with content %q, got %q", name, content, c)
+		}
+	}
+}
@@ -21,12 +21,20 @@ package utils
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/cernbox/reva-plugins/breaker"
+	"github.com/cernbox/reva-plugins/metrics"
 	"github.com/cs3org/reva/pkg/errtypes"
 	"github.com/cs3org/reva/pkg/httpclient"
 	"github.com/pkg/errors"
@@ -34,32 +42,171 @@ import (
 
 // Config is the config used by the cback client.
 type Config struct {
-	URL     string
-	Token   string
+	URL   string
+	Token string
+	// Timeout of the requests in seconds. 0 means no timeout.
 	Timeout int
+	// Insecure skips the verification of the cback server certificate.
+	Insecure bool
+	// MaxIdleConns and MaxIdleConnsPerHost are the maximum number
+	// of idle connections kept open to be reused, in total and per host.
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout is for how long in seconds an idle connection is kept open.
+	IdleConnTimeout int
+	// Checksum is the type of checksum (adler32, md5 or sha1) requested
+	// for the files when stat-ing or listing a folder. Empty to disable.
+	Checksum string
+	// Retries is the number of times a request that failed because of
+	// a transport error or a server error is retried. Only the requests
+	// that do not create resources are retried.
+	Retries int
+	// RetryBackoff is the wait in milliseconds before the first retry,
+	// doubled at each following one.
+	RetryBackoff int
+	// BreakerThreshold is the number of consecutive failures after which
+	// the requests to cback are suspended. 0 disables the circuit breaker.
+	BreakerThreshold int
+	// BreakerTimeout is for how long in seconds the requests are suspended.
+	BreakerTimeout int
 }
 
 // Client is the client to connect to cback.
 type Client struct {
-	c      *Config
-	client *httpclient.Client
+	c       *Config
+	client  *httpclient.Client
+	breaker *breaker.Breaker
 }
 
 // New creates a new cback client.
 func New(c *Config) *Client {
+	if c.RetryBackoff == 0 {
+		c.RetryBackoff = 100
+	}
+	if c.BreakerTimeout == 0 {
+		c.BreakerTimeout = 30
+	}
+	if c.MaxIdleConns == 0 {
+		c.MaxIdleConns = 100
+	}
+	if c.MaxIdleConnsPerHost == 0 {
+		c.MaxIdleConnsPerHost = 32
+	}
+	if c.IdleConnTimeout == 0 {
+		c.IdleConnTimeout = 90
+	}
+
+	tr := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		MaxIdleConns:        c.MaxIdleConns,
+		MaxIdleConnsPerHost: c.MaxIdleConnsPerHost,
+		IdleConnTimeout:     time.Duration(c.IdleConnTimeout) * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+		TLSClientConfig:     &tls.Config{InsecureSkipVerify: c.Insecure},
+	}
+
 	return &Client{
 		c: c,
 		client: httpclient.New(
-			httpclient.Timeout(time.Duration(c.Timeout)),
+			httpclient.RoundTripper(tr),
+			httpclient.Timeout(time.Duration(c.Timeout)*time.Second),
 		),
+		breaker: breaker.New(c.BreakerThreshold, time.Duration(c.BreakerTimeout)*time.Second),
+	}
+}
+
+// isIdempotent tells whether a request can be retried. DELETE is not,
+// as cancelling a restore that was already cancelled by a request whose
+// response was lost fails.
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	default:
+		return false
 	}
 }
 
 func (c *Client) doHTTPRequest(ctx context.Context, username, reqType, endpoint string, body io.Reader) (io.ReadCloser, error) {
+	// the body is kept in memory to be sent again in case of retries
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = io.ReadAll(body); err != nil {
+			return nil, errors.Wrap(err, "cback: error reading request body")
+		}
+	}
+
+	retries := 0
+	if isIdempotent(reqType) {
+		retries = c.c.Retries
+	}
+	backoff := time.Duration(c.c.RetryBackoff) * time.Millisecond
+
+	for attempt := 0; ; attempt++ {
+		if !c.breaker.Allow() {
+			return nil, errtypes.InternalError("cback: too many failures, requests to cback are suspended")
+		}
+
+		res, retry, err := c.do(ctx, username, reqType, endpoint, data)
+		if err == nil {
+			c.breaker.Success()
+			return res, nil
+		}
+		if !retry {
+			return nil, err
+		}
+		c.breaker.Failure()
+
+		if attempt >= retries {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+var (
+	requestsTotal    = metrics.NewCounterVec("cback_requests_total", "endpoint", "method", "status")
+	requestsDuration = metrics.NewHistogramVec("cback_request_duration_seconds", metrics.DefaultBuckets, "endpoint", "method")
+)
+
+// endpointLabel returns the endpoint without the ids and the paths,
+// e.g. backups/snapshots/resource for /backups/1/snapshots/<id>/<path>.
+func endpointLabel(endpoint string) string {
+	endpoint, _, _ = strings.Cut(endpoint, "?")
+	var label []string
+	for _, s := range strings.Split(strings.Trim(endpoint, "/"), "/") {
+		if _, err := strconv.Atoi(s); err == nil {
+			continue
+		}
+		if len(label) != 0 && label[len(label)-1] == "snapshots" {
+			label = append(label, "resource")
+			break
+		}
+		label = append(label, s)
+	}
+	return strings.Join(label, "/")
+}
+
+// do makes a single request to cback. It returns whether
+// the request failed because of cback and can be retried.
+func (c *Client) do(ctx context.Context, username, reqType, endpoint string, data []byte) (io.ReadCloser, bool, error) {
+	label := endpointLabel(endpoint)
+	defer requestsDuration.ObserveSince(time.Now(), label, reqType)
+
 	url := c.c.URL + endpoint
+	var body io.Reader
+	if data != nil {
+		body = bytes.NewReader(data)
+	}
 	req, err := http.NewRequestWithContext(ctx, reqType, url, body)
 	if err != nil {
-		return nil, errors.Wrapf(err, "error creationg http %s request to %s", reqType, url)
+		return nil, false, errors.Wrapf(err, "error creationg http %s request to %s", reqType, url)
 	}
 
 	req.SetBasicAuth(username, c.c.Token)
@@ -72,28 +219,53 @@ func (c *Client) doHTTPRequest(ctx context.Context, username, reqType, endpoint
 
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return nil, err
+		requestsTotal.Inc(label, reqType, "error")
+		return nil, ctx.Err() == nil, err
 	}
+	requestsTotal.Inc(label, reqType, strconv.Itoa(resp.StatusCode))
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		resp.Body.Close()
+		if resp.StatusCode >= 500 {
+			return nil, true, errtypes.InternalError("cback: internal server error: " + resp.Status)
+		}
 		switch resp.StatusCode {
 		case http.StatusNotFound:
-			return nil, errtypes.NotFound("cback: resource not found")
+			return nil, false, errtypes.NotFound("cback: resource not found")
 		case http.StatusForbidden:
-			return nil, errtypes.PermissionDenied("cback: user has no permissions to get the backup")
+			return nil, false, errtypes.PermissionDenied("cback: user has no permissions to get the backup")
 		case http.StatusBadRequest:
-			return nil, errtypes.BadRequest("")
+			return nil, false, errtypes.BadRequest("")
+		case http.StatusConflict:
+			return nil, false, errtypes.AlreadyExists("cback: conflict with the current state of the resource")
+		case http.StatusTooManyRequests:
+			return nil, false, errtypes.TooEarly("cback: too many requests")
 		default:
-			return nil, errtypes.InternalError("cback: internal server error: " + resp.Status)
+			return nil, false, errtypes.InternalError("cback: unexpected response: " + resp.Status)
 		}
 	}
 
-	return resp.Body, nil
+	return resp.Body, false, nil
 }
 
 // ListBackups gets all the backups of a user.
 func (c *Client) ListBackups(ctx context.Context, username string) ([]*Backup, error) {
-	body, err := c.doHTTPRequest(ctx, username, http.MethodGet, "/backups/", nil)
+	return c.listBackups(ctx, username, "/backups/")
+}
+
+// ListGroupBackups gets the backups owned by the groups the user is member of.
+func (c *Client) ListGroupBackups(ctx context.Context, username string) ([]*Backup, error) {
+	return c.listBackups(ctx, username, "/backups/?group=true")
+}
+
+// ListAllBackups gets the backups of all the users. The request is made
+// on behalf of the given user, that must be a cback administrator.
+func (c *Client) ListAllBackups(ctx context.Context, username string) ([]*Backup, error) {
+	return c.listBackups(ctx, username, "/admin/backups/")
+}
+
+func (c *Client) listBackups(ctx context.Context, username, endpoint string) ([]*Backup, error) {
+	body, err := c.doHTTPRequest(ctx, username, http.MethodGet, endpoint, nil)
 	if err != nil {
 		return nil, errors.Wrap(err, "cback: error listing backups for user "+username)
 	}
@@ -110,7 +282,16 @@ func (c *Client) ListBackups(ctx context.Context, username string) ([]*Backup, e
 
 // ListSnapshots gets all the snapshots of a backup.
 func (c *Client) ListSnapshots(ctx context.Context, username string, backupID int) ([]*Snapshot, error) {
-	endpoint := fmt.Sprintf("/backups/%d/snapshots", backupID)
+	return c.listSnapshots(ctx, username, fmt.Sprintf("/backups/%d/snapshots", backupID), backupID)
+}
+
+// ListForgottenSnapshots gets the snapshots of a backup that were forgotten
+// by the retention policy, but whose data was not yet pruned from the repository.
+func (c *Client) ListForgottenSnapshots(ctx context.Context, username string, backupID int) ([]*Snapshot, error) {
+	return c.listSnapshots(ctx, username, fmt.Sprintf("/backups/%d/snapshots?forgotten=true", backupID), backupID)
+}
+
+func (c *Client) listSnapshots(ctx context.Context, username, endpoint string, backupID int) ([]*Snapshot, error) {
 	body, err := c.doHTTPRequest(ctx, username, http.MethodGet, endpoint, nil)
 	if err != nil {
 		return nil, errors.Wrapf(err, "cback: error listing snapshots for backup %d", backupID)
@@ -126,11 +307,22 @@ func (c *Client) ListSnapshots(ctx context.Context, username string, backupID in
 	return snapshots, nil
 }
 
+func (c *Client) resourceQuery(isTimestamp bool) url.Values {
+	q := url.Values{}
+	if isTimestamp {
+		q.Set("timestamp", "true")
+	}
+	if c.c.Checksum != "" {
+		q.Set("checksum", c.c.Checksum)
+	}
+	return q
+}
+
 // Stat gets the info of a resource stored in cback.
 func (c *Client) Stat(ctx context.Context, username string, backupID int, snapshotID, path string, isTimestamp bool) (*Resource, error) {
 	endpoint := fmt.Sprintf("/backups/%d/snapshots/%s/%s", backupID, snapshotID, path)
-	if isTimestamp {
-		endpoint += "?timestamp=true"
+	if q := c.resourceQuery(isTimestamp); len(q) != 0 {
+		endpoint += "?" + q.Encode()
 	}
 	body, err := c.doHTTPRequest(ctx, username, http.MethodOptions, endpoint, nil)
 	if err != nil {
@@ -149,10 +341,9 @@ func (c *Client) Stat(ctx context.Context, username string, backupID int, snapsh
 
 // ListFolder gets the content of a folder stored in cback.
 func (c *Client) ListFolder(ctx context.Context, username string, backupID int, snapshotID, path string, isTimestamp bool) ([]*Resource, error) {
-	endpoint := fmt.Sprintf("/backups/%d/snapshots/%s/%s?content=true", backupID, snapshotID, path)
-	if isTimestamp {
-		endpoint += "&timestamp=true"
-	}
+	q := c.resourceQuery(isTimestamp)
+	q.Set("content", "true")
+	endpoint := fmt.Sprintf("/backups/%d/snapshots/%s/%s?%s", backupID, snapshotID, path, q.Encode())
 	body, err := c.doHTTPRequest(ctx, username, http.MethodOptions, endpoint, nil)
 	if err != nil {
 		return nil, errors.Wrapf(err, "cback: error statting %s in snapshot %s in backup %d", path, snapshotID, backupID)
@@ -177,9 +368,93 @@ func (c *Client) Download(ctx context.Context, username string, backupID int, sn
 	return c.doHTTPRequest(ctx, username, http.MethodGet, endpoint, nil)
 }
 
-// ListRestores gets the list of restore jobs created by the user.
-func (c *Client) ListRestores(ctx context.Context, username string) ([]*Restore, error) {
-	body, err := c.doHTTPRequest(ctx, username, http.MethodGet, "/restores/", nil)
+// RestoreFilter selects a page of the restore jobs. The zero value
+// of each field means no filter on it.
+type RestoreFilter struct {
+	// Statuses are the accepted statuses of the restores.
+	Statuses      []int
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	Offset        int
+	Limit         int
+}
+
+func (f *RestoreFilter) match(r *Restore) bool {
+	if len(f.Statuses) != 0 && !slices.Contains(f.Statuses, r.Status) {
+		return false
+	}
+	if !f.CreatedAfter.IsZero() && r.Created.Before(f.CreatedAfter) {
+		return false
+	}
+	if !f.CreatedBefore.IsZero() && r.Created.After(f.CreatedBefore) {
+		return false
+	}
+	return true
+}
+
+// query returns the parameters passing the filter to the cback API,
+// so that only the requested page is transferred.
+func (f *RestoreFilter) query() url.Values {
+	q := url.Values{}
+	for _, st := range f.Statuses {
+		q.Add("status", strconv.Itoa(st))
+	}
+	if !f.CreatedAfter.IsZero() {
+		q.Set("created_after", f.CreatedAfter.UTC().Format(time.RFC3339))
+	}
+	if !f.CreatedBefore.IsZero() {
+		q.Set("created_before", f.CreatedBefore.UTC().Format(time.RFC3339))
+	}
+	if f.Offset > 0 {
+		q.Set("offset", strconv.Itoa(f.Offset))
+	}
+	if f.Limit > 0 {
+		q.Set("limit", strconv.Itoa(f.Limit))
+	}
+	// the most recent restores first, so that the pages are stable
+	// when new restores are created
+	q.Set("ordering", "-created")
+	return q
+}
+
+// apply checks the page returned by cback against the filter. The
+// offset is applied by cback, the other criteria are applied again
+// as they do not change an already filtered page.
+func (f *RestoreFilter) apply(l []*Restore) []*Restore {
+	res := make([]*Restore, 0, len(l))
+	for _, r := range l {
+		if f.match(r) {
+			res = append(res, r)
+		}
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Created.After(res[j].Created.Time)
+	})
+
+	if f.Limit > 0 && f.Limit < len(res) {
+		res = res[:f.Limit]
+	}
+	return res
+}
+
+// ListRestores gets the list of restore jobs created by the user,
+// filtered by the given filter if not nil.
+func (c *Client) ListRestores(ctx context.Context, username string, filter *RestoreFilter) ([]*Restore, error) {
+	return c.listRestores(ctx, username, "/restores/", filter)
+}
+
+// ListAllRestores gets the restore jobs of all the users. The request is
+// made on behalf of the given user, that must be a cback administrator.
+func (c *Client) ListAllRestores(ctx context.Context, username string, filter *RestoreFilter) ([]*Restore, error) {
+	return c.listRestores(ctx, username, "/admin/restores/", filter)
+}
+
+func (c *Client) listRestores(ctx context.Context, username, endpoint string, filter *RestoreFilter) ([]*Restore, error) {
+	if filter != nil {
+		endpoint += "?" + filter.query().Encode()
+	}
+	body, err := c.doHTTPRequest(ctx, username, http.MethodGet, endpoint, nil)
 	if err != nil {
 		return nil, errors.Wrap(err, "cback: error getting restores")
 	}
@@ -191,6 +466,9 @@ func (c *Client) ListRestores(ctx context.Context, username string) ([]*Restore,
 		return nil, errors.Wrap(err, "cback: error decoding response body")
 	}
 
+	if filter != nil {
+		res = filter.apply(res)
+	}
 	return res, nil
 }
 
@@ -212,18 +490,45 @@ func (c *Client) GetRestore(ctx context.Context, username string, restoreID int)
 	return res, nil
 }
 
+// CancelRestore cancels a restore job that is not yet completed.
+func (c *Client) CancelRestore(ctx context.Context, username string, restoreID int) (*Restore, error) {
+	endpoint := fmt.Sprintf("/restores/%d", restoreID)
+	body, err := c.doHTTPRequest(ctx, username, http.MethodDelete, endpoint, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cback: error cancelling restore %d", restoreID)
+	}
+	defer body.Close()
+
+	var res *Restore
+
+	if err := json.NewDecoder(body).Decode(&res); err != nil {
+		return nil, errors.Wrap(err, "cback: error decoding response body")
+	}
+
+	return res, nil
+}
+
 type newRestoreRequest struct {
-	BackupID int    `json:"backup_id"`
-	Pattern  string `json:"pattern,omitempty"`
-	Date     string `json:"date,omitempty"`
-	Snapshot string `json:"snapshot"`
+	BackupID    int    `json:"backup_id"`
+	Pattern     string `json:"pattern,omitempty"`
+	Date        string `json:"date,omitempty"`
+	Snapshot    string `json:"snapshot"`
+	Destination string `json:"destination,omitempty"`
 }
 
-// NewRestore creates a new restore job in cback.
+// NewRestore creates a new restore job in cback, restoring
+// the resources in their original location.
 func (c *Client) NewRestore(ctx context.Context, username string, backupID int, pattern, snapshotID string, timestamp bool) (*Restore, error) {
+	return c.NewRestoreTo(ctx, username, backupID, pattern, snapshotID, "", timestamp)
+}
+
+// NewRestoreTo creates a new restore job in cback, restoring the resources
+// in the given destination. An empty destination means the original location.
+func (c *Client) NewRestoreTo(ctx context.Context, username string, backupID int, pattern, snapshotID, destination string, timestamp bool) (*Restore, error) {
 	r := newRestoreRequest{
-		BackupID: backupID,
-		Pattern:  pattern,
+		BackupID:    backupID,
+		Pattern:     pattern,
+		Destination: destination,
 	}
 	if timestamp {
 		r.Date = snapshotID
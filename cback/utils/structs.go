@@ -57,8 +57,19 @@ type Resource struct {
 	CTime float64 `json:"ctime"`
 	Inode uint64  `json:"inode"`
 	Size  uint64  `json:"size"`
+	// Checksum is set only if requested and only for files.
+	Checksum string `json:"checksum,omitempty"`
 }
 
+// Status of a restore job.
+const (
+	RestoreQueued = iota
+	RestoreRunning
+	RestoreCompleted
+	RestoreFailed
+	RestoreCancelled
+)
+
 // Restore represents the metadata information of a restore job.
 type Restore struct {
 	ID           int       `json:"id"`
@@ -68,6 +79,9 @@ type Restore struct {
 	Pattern      string    `json:"pattern"`
 	Status       int       `json:"status"`
 	Created      CBackTime `json:"created"`
+	// Username is the owner of the restore, returned only
+	// when listing the restores of all the users.
+	Username string `json:"username,omitempty"`
 }
 
 type CBackTime struct{ time.Time }
@@ -90,6 +104,11 @@ func (c *CBackTime) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
+// IsDone returns true if the restore job is not running anymore.
+func (r *Restore) IsDone() bool {
+	return r.Status == RestoreCompleted || r.Status == RestoreFailed || r.Status == RestoreCancelled
+}
+
 // IsDir returns true if the resoure is a directory.
 func (r *Resource) IsDir() bool {
 	return r.Type == "dir"
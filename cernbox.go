@@ -7,7 +7,10 @@ import (
 	_ "github.com/cernbox/reva-plugins/cernboxspaces"
 	_ "github.com/cernbox/reva-plugins/eosprojects"
 	_ "github.com/cernbox/reva-plugins/group"
+	_ "github.com/cernbox/reva-plugins/metrics"
 	_ "github.com/cernbox/reva-plugins/otg"
+	_ "github.com/cernbox/reva-plugins/share/api"
+	_ "github.com/cernbox/reva-plugins/share/batch"
 	_ "github.com/cernbox/reva-plugins/share/sql"
 	_ "github.com/cernbox/reva-plugins/storage/eoshomewrapper"
 	_ "github.com/cernbox/reva-plugins/storage/eoswrapper"
@@ -8,11 +8,14 @@ import (
 	"net/http"
 	"regexp"
 
+	"github.com/bluele/gcache"
+	"github.com/cernbox/reva-plugins/ratelimit"
 	group "github.com/cs3org/go-cs3apis/cs3/identity/group/v1beta1"
 	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
 	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
 	"github.com/cs3org/reva"
 	"github.com/cs3org/reva/pkg/appctx"
+	"github.com/cs3org/reva/pkg/eosclient"
 	"github.com/cs3org/reva/pkg/errtypes"
 	"github.com/cs3org/reva/pkg/rgrpc/todo/pool"
 	"github.com/cs3org/reva/pkg/rhttp/global"
@@ -42,6 +45,12 @@ type cboxProj struct {
 	c      *config
 	db     *sql.DB
 	router *chi.Mux
+	// details caches the details of the spaces by path
+	details  gcache.Cache
+	snapshot *spacesSnapshot
+	stmts    *statements
+	// quota sets the quota of the provisioned spaces
+	quota eosclient.EOSClient
 }
 
 func (cboxProj) RevaPlugin() reva.PluginInfo {
@@ -52,21 +61,37 @@ func (cboxProj) RevaPlugin() reva.PluginInfo {
 }
 
 type config struct {
-	Username              string `mapstructure:"username"`
+	Username              string `mapstructure:"username" validate:"required"`
 	Password              string `mapstructure:"password"`
-	Host                  string `mapstructure:"host"`
-	Port                  int    `mapstructure:"port"`
-	Name                  string `mapstructure:"name"`
-	Table                 string `mapstructure:"table"`
+	Host                  string `mapstructure:"host"     validate:"required"`
+	Port                  int    `mapstructure:"port"     validate:"required,min=1,max=65535"`
+	Name                  string `mapstructure:"name"     validate:"required"`
+	Table                 string `mapstructure:"table"    validate:"required"`
 	Prefix                string `mapstructure:"prefix"`
 	GatewaySvc            string `mapstructure:"gatewaysvc"`
 	SkipUserGroupsInToken bool   `mapstructure:"skip_user_groups_in_token"`
+	// The members of this group can create new spaces
+	SpaceCreatorsGroup string `mapstructure:"space_creators_group"`
+	// The number of spaces whose details are fetched concurrently
+	DetailsWorkers int `mapstructure:"details_workers"`
+	// The time in seconds for which the details of the spaces are cached
+	DetailsCacheTTL int `mapstructure:"details_cache_ttl"`
+	// The time in seconds for which the table of the spaces is cached
+	SpacesCacheTTL int `mapstructure:"spaces_cache_ttl"`
+	// The storage provider of the EOS spaces, where the spaces are provisioned
+	StorageProviderSvc string `mapstructure:"storage_provider_svc"`
+	// The quota set on EOS for the provisioned spaces
+	Quota quotaConfig `mapstructure:"quota"`
+
+	RateLimit map[string]interface{} `mapstructure:"ratelimit"`
 }
 
 type project struct {
 	Name        string `json:"name,omitempty"`
 	Path        string `json:"path,omitempty"`
 	Permissions string `json:"permissions,omitempty"`
+	// Details are included only if requested with ?details=true
+	Details *spaceDetails `json:"details,omitempty"`
 }
 
 var projectRegex = regexp.MustCompile(`^cernbox-project-(?P<Name>.+)-(?P<Permissions>admins|writers|readers)\z`)
@@ -76,6 +101,18 @@ func (c *config) ApplyDefaults() {
 		c.Prefix = "cernboxspaces"
 	}
 
+	if c.DetailsWorkers == 0 {
+		c.DetailsWorkers = 10
+	}
+	if c.DetailsCacheTTL == 0 {
+		c.DetailsCacheTTL = 300
+	}
+	if c.SpacesCacheTTL == 0 {
+		c.SpacesCacheTTL = 60
+	}
+
+	c.Quota.ApplyDefaults()
+
 	c.GatewaySvc = sharedconf.GetGatewaySVC(c.GatewaySvc)
 
 	c.SkipUserGroupsInToken = c.SkipUserGroupsInToken || sharedconf.SkipUserGroupsInToken()
@@ -92,14 +129,29 @@ func New(ctx context.Context, m map[string]interface{}) (global.Service, error)
 		return nil, errors.Wrap(err, "error creating sql connection")
 	}
 
+	rateLimit, err := ratelimit.Middleware(c.RateLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	quota, err := newQuotaClient(&c.Quota)
+	if err != nil {
+		return nil, err
+	}
+
 	r := chi.NewRouter()
+	r.Use(rateLimit)
 
 	log := appctx.GetLogger(ctx)
 	p := &cboxProj{
-		log:    log,
-		c:      &c,
-		db:     db,
-		router: r,
+		log:      log,
+		c:        &c,
+		db:       db,
+		router:   r,
+		details:  newDetailsCache(c.DetailsCacheTTL),
+		snapshot: &spacesSnapshot{},
+		stmts:    newStatements(db),
+		quota:    quota,
 	}
 
 	p.initRouter()
@@ -109,7 +161,10 @@ func New(ctx context.Context, m map[string]interface{}) (global.Service, error)
 
 func (p *cboxProj) initRouter() {
 	p.router.Get("/{project}/admins", p.GetProjectAdmins)
+	p.router.Get("/{project}/members", p.GetProjectMembers)
 	p.router.Get("/", p.GetProjectsHandler)
+	p.router.Post("/", p.CreateSpaceHandler)
+	p.router.Post("/invalidate", p.InvalidateSpacesHandler)
 }
 
 func (p *cboxProj) Handler() http.Handler {
@@ -130,9 +185,22 @@ func (p *cboxProj) Prefix() string {
 }
 
 func (p *cboxProj) Close() error {
+	p.stmts.close()
 	return p.db.Close()
 }
 
+func (p *cboxProj) selectSpacesQuery() string {
+	return fmt.Sprintf("SELECT project_name, eos_relative_path, storage FROM %s", p.c.Table)
+}
+
+func (p *cboxProj) insertSpaceQuery() string {
+	return fmt.Sprintf("INSERT INTO %s (project_name, eos_relative_path, storage) VALUES (?, ?, ?)", p.c.Table)
+}
+
+func (p *cboxProj) deleteSpaceQuery() string {
+	return fmt.Sprintf("DELETE FROM %s WHERE project_name=?", p.c.Table)
+}
+
 func (p *cboxProj) Unprotected() []string {
 	return nil
 }
@@ -160,6 +228,10 @@ func (p *cboxProj) GetProjectsHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 	}
 
+	if r.URL.Query().Get("details") == "true" {
+		p.addSpacesDetails(ctx, spaces)
+	}
+
 	data, err := encodeProjectsInJSON(spaces)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -275,13 +347,9 @@ func (p *cboxProj) getSpaces(ctx context.Context, sType SpaceType) ([]*project,
 		return nil, errtypes.UserRequired("")
 	}
 
-	groups := user.Groups
-	if p.c.SkipUserGroupsInToken {
-		var err error
-		groups, err = p.getUserGroups(ctx, user)
-		if err != nil {
-			return nil, errors.Wrap(err, "error getting user groups")
-		}
+	groups, err := p.userGroups(ctx, user)
+	if err != nil {
+		return nil, err
 	}
 
 	userProjects := make(map[string]string)
@@ -305,32 +373,28 @@ func (p *cboxProj) getSpaces(ctx context.Context, sType SpaceType) ([]*project,
 	var dbProjects []string
 	dbProjectsPaths := make(map[string]string)
 	dbProjectsStorages := make(map[string]string)
-	query := fmt.Sprintf("SELECT project_name, eos_relative_path, storage FROM %s", p.c.Table)
+	var storageFilter string
 	switch {
 	case sType == SpaceType_EOSPROJECT:
-		query = query + " WHERE storage = 'eos'"
+		storageFilter = "eos"
 	case sType == SpaceType_WINSPACE:
-		query = query + " WHERE storage = 'cephfs'"
+		storageFilter = "cephfs"
 	case sType == SpaceType_ALL:
 	default:
 		return nil, errtypes.BadRequest("Invalid space type")
 	}
-	results, err := p.db.Query(query)
+	rows, err := p.loadSpaces(ctx)
 	if err != nil {
-		return nil, errors.Wrap(err, "error getting projects from db")
+		return nil, err
 	}
 
-	for results.Next() {
-		var name string
-		var path string
-		var storage string
-		err = results.Scan(&name, &path, &storage)
-		if err != nil {
-			return nil, errors.Wrap(err, "error scanning rows from db")
+	for _, r := range rows {
+		if storageFilter != "" && r.storage != storageFilter {
+			continue
 		}
-		dbProjects = append(dbProjects, name)
-		dbProjectsPaths[name] = path
-		dbProjectsStorages[name] = storage
+		dbProjects = append(dbProjects, r.name)
+		dbProjectsPaths[r.name] = r.path
+		dbProjectsStorages[r.name] = r.storage
 	}
 
 	validProjects := intersect.Simple(dbProjects, userProjectsKeys)
@@ -340,16 +404,10 @@ func (p *cboxProj) getSpaces(ctx context.Context, sType SpaceType) ([]*project,
 		name := p.(string)
 		permissions := userProjects[name]
 		switch storage := dbProjectsStorages[name]; storage {
-		case "eos":
+		case "eos", "cephfs":
 			projects = append(projects, &project{
 				Name:        name,
-				Path:        fmt.Sprintf("/eos/project/%s", dbProjectsPaths[name]),
-				Permissions: permissions[:len(permissions)-1],
-			})
-		case "cephfs":
-			projects = append(projects, &project{
-				Name:        name,
-				Path:        fmt.Sprintf("/winspaces/%s", dbProjectsPaths[name]),
+				Path:        spacePath(storage, dbProjectsPaths[name]),
 				Permissions: permissions[:len(permissions)-1],
 			})
 		default:
@@ -360,6 +418,19 @@ func (p *cboxProj) getSpaces(ctx context.Context, sType SpaceType) ([]*project,
 	return projects, nil
 }
 
+// userGroups returns the groups of the user, from the token
+// unless they are skipped in it.
+func (p *cboxProj) userGroups(ctx context.Context, user *userpb.User) ([]string, error) {
+	if !p.c.SkipUserGroupsInToken {
+		return user.Groups, nil
+	}
+	groups, err := p.getUserGroups(ctx, user)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting user groups")
+	}
+	return groups, nil
+}
+
 func (p *cboxProj) getUserGroups(ctx context.Context, user *userpb.User) ([]string, error) {
 	client, err := pool.GetGatewayServiceClient(pool.Endpoint(p.c.GatewaySvc))
 	if err != nil {
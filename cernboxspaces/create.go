@@ -0,0 +1,279 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package cernboxspaces
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	group "github.com/cs3org/go-cs3apis/cs3/identity/group/v1beta1"
+	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/cs3org/reva/pkg/appctx"
+	conversions "github.com/cs3org/reva/pkg/cbox/utils"
+	"github.com/cs3org/reva/pkg/errtypes"
+	"github.com/cs3org/reva/pkg/rgrpc/todo/pool"
+	"github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
+)
+
+var projectNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]*$`)
+
+// Permissions granted on the provisioned spaces to the groups of the project.
+var projectGroupsPermissions = map[string]int{
+	"admins":  15,
+	"writers": 15,
+	"readers": 1,
+}
+
+// newSpace is the body of the requests creating a space.
+type newSpace struct {
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	Storage string `json:"storage"`
+	// Provision creates the directory of the space on eos,
+	// granting access to the groups of the project and setting its quota.
+	Provision bool `json:"provision"`
+	// QuotaBytes and QuotaFiles are the quota of a provisioned space,
+	// the configured defaults if 0, up to the configured limits.
+	QuotaBytes uint64 `json:"quota_bytes"`
+	QuotaFiles uint64 `json:"quota_files"`
+}
+
+func (s *newSpace) validate(quota *quotaConfig) error {
+	if !projectNameRegex.MatchString(s.Name) {
+		return errtypes.BadRequest("invalid space name " + s.Name)
+	}
+	if s.Path == "" || strings.HasPrefix(s.Path, "/") || path.Clean(s.Path) != s.Path || strings.HasPrefix(s.Path, "..") {
+		return errtypes.BadRequest("invalid space path " + s.Path)
+	}
+	switch s.Storage {
+	case "eos":
+	case "cephfs":
+		if s.Provision {
+			return errtypes.BadRequest("provisioning is supported only on eos")
+		}
+	default:
+		return errtypes.BadRequest("invalid storage " + s.Storage)
+	}
+	if s.QuotaBytes > quota.LimitBytes {
+		return errtypes.BadRequest(fmt.Sprintf("quota_bytes exceeds the limit of %d", quota.LimitBytes))
+	}
+	if s.QuotaFiles > quota.LimitFiles {
+		return errtypes.BadRequest(fmt.Sprintf("quota_files exceeds the limit of %d", quota.LimitFiles))
+	}
+	return nil
+}
+
+func (p *cboxProj) CreateSpaceHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := appctx.ContextGetUser(ctx)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	groups, err := p.userGroups(ctx, user)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if p.c.SpaceCreatorsGroup == "" || !contains(groups, p.c.SpaceCreatorsGroup) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	var s newSpace
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+	if err := s.validate(&p.c.Quota); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log := appctx.GetLogger(ctx)
+	project, err := p.createSpace(ctx, &s)
+	if err != nil {
+		log.Error().Err(err).Str("space", s.Name).Msg("error creating space")
+		switch err.(type) {
+		case errtypes.BadRequest, errtypes.NotFound:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errtypes.AlreadyExists:
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errtypes.NotSupported:
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	log.Info().Str("space", s.Name).Str("path", project.Path).Str("creator", user.Username).Msg("space created")
+
+	data, err := json.Marshal(project)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	w.Write(data)
+}
+
+func (p *cboxProj) createSpace(ctx context.Context, s *newSpace) (*project, error) {
+	client, err := pool.GetGatewayServiceClient(pool.Endpoint(p.c.GatewaySvc))
+	if err != nil {
+		return nil, err
+	}
+
+	if s.Provision && (p.quota == nil || p.c.StorageProviderSvc == "") {
+		return nil, errtypes.NotSupported("provisioning requires the storage provider and the quota of the spaces to be configured")
+	}
+
+	for role := range projectGroupsPermissions {
+		if err := checkGroupExists(ctx, client, projectGroup(s.Name, role)); err != nil {
+			return nil, err
+		}
+	}
+
+	// the row is inserted first to reserve the name of the space,
+	// and is deleted if the provisioning fails
+	stmt, err := p.stmts.get(ctx, p.insertSpaceQuery())
+	if err != nil {
+		return nil, errors.Wrap(err, "error preparing query")
+	}
+	if _, err := stmt.ExecContext(ctx, s.Name, s.Path, s.Storage); err != nil {
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 {
+			return nil, errtypes.AlreadyExists("space " + s.Name)
+		}
+		return nil, errors.Wrap(err, "error inserting space in db")
+	}
+
+	prj := &project{Name: s.Name, Path: spacePath(s.Storage, s.Path)}
+	if s.Provision {
+		if err := p.provisionSpace(ctx, s, prj.Path); err != nil {
+			if derr := p.deleteSpace(ctx, s.Name); derr != nil {
+				appctx.GetLogger(ctx).Error().Err(derr).Str("space", s.Name).Msg("error deleting space not provisioned")
+			}
+			return nil, errors.Wrap(err, "error provisioning space")
+		}
+	}
+	p.invalidateSpaces()
+	return prj, nil
+}
+
+// deleteSpace removes the row of a space.
+func (p *cboxProj) deleteSpace(ctx context.Context, name string) error {
+	stmt, err := p.stmts.get(ctx, p.deleteSpaceQuery())
+	if err != nil {
+		return errors.Wrap(err, "error preparing query")
+	}
+	if _, err := stmt.ExecContext(ctx, name); err != nil {
+		return errors.Wrap(err, "error deleting space from db")
+	}
+	return nil
+}
+
+// provisionSpace creates the directory of the space, grants
+// the groups of the project access to it and sets its quota.
+func (p *cboxProj) provisionSpace(ctx context.Context, s *newSpace, spacePath string) error {
+	// the grants are not exposed by the gateway
+	client, err := pool.GetStorageProviderServiceClient(pool.Endpoint(p.c.StorageProviderSvc))
+	if err != nil {
+		return err
+	}
+
+	ref := &provider.Reference{Path: spacePath}
+	res, err := client.CreateContainer(ctx, &provider.CreateContainerRequest{Ref: ref})
+	switch {
+	case err != nil:
+		return err
+	case res.Status.Code != rpc.Code_CODE_OK && res.Status.Code != rpc.Code_CODE_ALREADY_EXISTS:
+		return errtypes.InternalError(res.Status.Message)
+	}
+
+	for role, perm := range projectGroupsPermissions {
+		res, err := client.AddGrant(ctx, &provider.AddGrantRequest{
+			Ref: ref,
+			Grant: &provider.Grant{
+				Grantee: &provider.Grantee{
+					Type: provider.GranteeType_GRANTEE_TYPE_GROUP,
+					Id:   &provider.Grantee_GroupId{GroupId: &group.GroupId{OpaqueId: projectGroup(s.Name, role)}},
+				},
+				Permissions: conversions.IntTosharePerm(perm, "folder"),
+			},
+		})
+		switch {
+		case err != nil:
+			return err
+		case res.Status.Code != rpc.Code_CODE_OK:
+			return errtypes.InternalError(res.Status.Message)
+		}
+	}
+
+	if err := p.setSpaceQuota(ctx, spacePath, s.QuotaBytes, s.QuotaFiles); err != nil {
+		return errors.Wrap(err, "error setting quota")
+	}
+	return nil
+}
+
+func checkGroupExists(ctx context.Context, client gateway.GatewayAPIClient, g string) error {
+	res, err := client.GetGroup(ctx, &group.GetGroupRequest{
+		GroupId:             &group.GroupId{OpaqueId: g},
+		SkipFetchingMembers: true,
+	})
+	switch {
+	case err != nil:
+		return err
+	case res.Status.Code == rpc.Code_CODE_NOT_FOUND:
+		return errtypes.NotFound(fmt.Sprintf("group %s not found", g))
+	case res.Status.Code != rpc.Code_CODE_OK:
+		return errtypes.InternalError(res.Status.Message)
+	}
+	return nil
+}
+
+func projectGroup(project, role string) string {
+	return fmt.Sprintf("cernbox-project-%s-%s", project, role)
+}
+
+func spacePath(storage, relativePath string) string {
+	switch storage {
+	case "cephfs":
+		return fmt.Sprintf("/winspaces/%s", relativePath)
+	default:
+		return fmt.Sprintf("/eos/project/%s", relativePath)
+	}
+}
+
+func contains(l []string, s string) bool {
+	for _, e := range l {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package cernboxspaces
+
+import (
+	"context"
+	"time"
+
+	"github.com/bluele/gcache"
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/cs3org/reva/pkg/appctx"
+	"github.com/cs3org/reva/pkg/errtypes"
+	"github.com/cs3org/reva/pkg/rgrpc/todo/pool"
+	"golang.org/x/sync/errgroup"
+)
+
+const detailsCacheSize = 10000
+
+// spaceDetails are the quota usage and the last activity of a space.
+type spaceDetails struct {
+	QuotaTotal   uint64 `json:"quota_total,omitempty"`
+	QuotaUsed    uint64 `json:"quota_used,omitempty"`
+	LastModified int64  `json:"last_modified,omitempty"`
+}
+
+func newDetailsCache(ttl int) gcache.Cache {
+	return gcache.New(detailsCacheSize).LRU().Expiration(time.Duration(ttl) * time.Second).Build()
+}
+
+// addSpacesDetails fetches concurrently the details of the spaces.
+// The spaces whose details could not be fetched are left without.
+func (p *cboxProj) addSpacesDetails(ctx context.Context, spaces []*project) {
+	log := appctx.GetLogger(ctx)
+
+	var g errgroup.Group
+	g.SetLimit(p.c.DetailsWorkers)
+	for _, s := range spaces {
+		s := s
+		g.Go(func() error {
+			d, err := p.getSpaceDetails(ctx, s.Path)
+			if err != nil {
+				log.Error().Err(err).Str("space", s.Name).Msg("error getting space details")
+				return nil
+			}
+			s.Details = d
+			return nil
+		})
+	}
+	_ = g.Wait()
+}
+
+func (p *cboxProj) getSpaceDetails(ctx context.Context, path string) (*spaceDetails, error) {
+	if v, err := p.details.Get(path); err == nil {
+		return v.(*spaceDetails), nil
+	}
+
+	client, err := pool.GetGatewayServiceClient(pool.Endpoint(p.c.GatewaySvc))
+	if err != nil {
+		return nil, err
+	}
+	ref := &provider.Reference{Path: path}
+
+	quota, err := client.GetQuota(ctx, &gateway.GetQuotaRequest{Ref: ref})
+	switch {
+	case err != nil:
+		return nil, err
+	case quota.Status.Code != rpc.Code_CODE_OK:
+		return nil, errtypes.InternalError(quota.Status.Message)
+	}
+
+	stat, err := client.Stat(ctx, &provider.StatRequest{Ref: ref})
+	switch {
+	case err != nil:
+		return nil, err
+	case stat.Status.Code != rpc.Code_CODE_OK:
+		return nil, errtypes.InternalError(stat.Status.Message)
+	}
+
+	d := &spaceDetails{
+		QuotaTotal:   quota.TotalBytes,
+		QuotaUsed:    quota.UsedBytes,
+		LastModified: int64(stat.Info.GetMtime().GetSeconds()),
+	}
+	_ = p.details.Set(path, d)
+	return d, nil
+}
@@ -0,0 +1,190 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package cernboxspaces
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	group "github.com/cs3org/go-cs3apis/cs3/identity/group/v1beta1"
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	"github.com/cs3org/reva/pkg/appctx"
+	"github.com/cs3org/reva/pkg/errtypes"
+	"github.com/cs3org/reva/pkg/rgrpc/todo/pool"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	defaultMembersLimit = 100
+	maxMembersLimit     = 1000
+)
+
+type member struct {
+	user
+	Role string `json:"role"`
+}
+
+type membersPage struct {
+	Members []member `json:"members"`
+	Total   int      `json:"total"`
+}
+
+func (p *cboxProj) GetProjectMembers(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := appctx.ContextGetUser(ctx)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	project := chi.URLParam(r, "project")
+	if !p.userHasAccessToProject(ctx, user, project) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	roles := []string{"admins", "writers", "readers"}
+	if role := r.URL.Query().Get("role"); role != "" {
+		if _, ok := permissionsLevel[role]; !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		roles = []string{role}
+	}
+
+	offset, limit, err := parsePagination(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	page, err := p.getProjectMembers(ctx, project, roles, offset, limit)
+	if err != nil {
+		appctx.GetLogger(ctx).Error().Err(err).Str("project", project).Msg("error getting project members")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	d, err := json.Marshal(page)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(d)
+}
+
+func parsePagination(r *http.Request) (int, int, error) {
+	offset, limit := 0, defaultMembersLimit
+	var err error
+	if v := r.URL.Query().Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			return 0, 0, errtypes.BadRequest("invalid offset")
+		}
+	}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			return 0, 0, errtypes.BadRequest("invalid limit")
+		}
+	}
+	return offset, min(limit, maxMembersLimit), nil
+}
+
+// getProjectMembers merges the members of the groups of the project with
+// the given roles, each member getting its highest role. Only the users
+// of the requested page are resolved.
+func (p *cboxProj) getProjectMembers(ctx context.Context, project string, roles []string, offset, limit int) (*membersPage, error) {
+	client, err := pool.GetGatewayServiceClient(pool.Endpoint(p.c.GatewaySvc))
+	if err != nil {
+		return nil, err
+	}
+
+	memberRoles := make(map[string]string)
+	ids := make(map[string]*userpb.UserId)
+	for _, role := range roles {
+		members, err := getGroupMembers(ctx, client, projectGroup(project, role))
+		if _, ok := err.(errtypes.NotFound); ok {
+			// not all the projects have all the groups
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range members {
+			memberRoles[m.OpaqueId] = getHigherPermission(memberRoles[m.OpaqueId], role)
+			ids[m.OpaqueId] = m
+		}
+	}
+
+	sorted := make([]string, 0, len(ids))
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+	sort.Strings(sorted)
+
+	page := &membersPage{Members: []member{}, Total: len(sorted)}
+	if offset >= len(sorted) {
+		return page, nil
+	}
+	for _, id := range sorted[offset:min(offset+limit, len(sorted))] {
+		res, err := client.GetUser(ctx, &userpb.GetUserRequest{UserId: ids[id], SkipFetchingUserGroups: true})
+		switch {
+		case err != nil:
+			return nil, err
+		case res.Status.Code == rpc.Code_CODE_NOT_FOUND:
+			continue
+		case res.Status.Code != rpc.Code_CODE_OK:
+			return nil, errtypes.InternalError(res.Status.Message)
+		}
+
+		u := res.GetUser()
+		page.Members = append(page.Members, member{
+			user: user{
+				Username:    u.Username,
+				Mail:        u.Mail,
+				DisplayName: u.DisplayName,
+			},
+			Role: memberRoles[id],
+		})
+	}
+	return page, nil
+}
+
+func getGroupMembers(ctx context.Context, client gateway.GatewayAPIClient, g string) ([]*userpb.UserId, error) {
+	res, err := client.GetMembers(ctx, &group.GetMembersRequest{
+		GroupId: &group.GroupId{
+			OpaqueId: g,
+		},
+	})
+
+	switch {
+	case err != nil:
+		return nil, err
+	case res.Status.Code == rpc.Code_CODE_NOT_FOUND:
+		return nil, errtypes.NotFound(fmt.Sprintf("group %s not found", g))
+	case res.Status.Code != rpc.Code_CODE_OK:
+		return nil, errtypes.InternalError(res.Status.Message)
+	}
+	return res.Members, nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package cernboxspaces
+
+import (
+	"context"
+	"strings"
+
+	"github.com/cs3org/reva/pkg/eosclient"
+	"github.com/cs3org/reva/pkg/eosclient/eosbinary"
+	"github.com/pkg/errors"
+)
+
+// quotaConfig configures the quota set on EOS for the provisioned spaces.
+type quotaConfig struct {
+	// MGMURL is the EOS instance of the spaces, e.g. root://eosproject.cern.ch.
+	// The quota is not set if empty.
+	MGMURL    string `mapstructure:"mgm_url"`
+	EosBinary string `mapstructure:"eos_binary"`
+	UseKeytab bool   `mapstructure:"use_keytab"`
+	Keytab    string `mapstructure:"keytab"`
+	// UID and GID are the ids the quota is given to on the node of the space.
+	UID string `mapstructure:"uid"`
+	GID string `mapstructure:"gid"`
+	// MaxBytes and MaxFiles are the quota of the spaces not requesting one.
+	MaxBytes uint64 `mapstructure:"max_bytes"`
+	MaxFiles uint64 `mapstructure:"max_files"`
+	// LimitBytes and LimitFiles are the highest quota a space can
+	// request. They default to MaxBytes and MaxFiles.
+	LimitBytes uint64 `mapstructure:"limit_bytes"`
+	LimitFiles uint64 `mapstructure:"limit_files"`
+}
+
+func (c *quotaConfig) ApplyDefaults() {
+	if c.EosBinary == "" {
+		c.EosBinary = "/usr/bin/eos"
+	}
+	if c.UID == "" {
+		c.UID = "0"
+	}
+	if c.GID == "" {
+		c.GID = "0"
+	}
+	if c.MaxBytes == 0 {
+		c.MaxBytes = 1000000000000 // 1 TB
+	}
+	if c.MaxFiles == 0 {
+		c.MaxFiles = 1000000
+	}
+	if c.LimitBytes == 0 {
+		c.LimitBytes = c.MaxBytes
+	}
+	if c.LimitFiles == 0 {
+		c.LimitFiles = c.MaxFiles
+	}
+}
+
+// newQuotaClient returns the client setting the quota of the spaces,
+// nil if the quota is not configured.
+func newQuotaClient(c *quotaConfig) (eosclient.EOSClient, error) {
+	if c.MGMURL == "" {
+		return nil, nil
+	}
+	client, err := eosbinary.New(&eosbinary.Options{
+		URL:       c.MGMURL,
+		EosBinary: c.EosBinary,
+		UseKeytab: c.UseKeytab,
+		Keytab:    c.Keytab,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating eos client for the quota")
+	}
+	return client, nil
+}
+
+// setSpaceQuota sets the quota of the space on its node,
+// with the defaults for the limits not given.
+func (p *cboxProj) setSpaceQuota(ctx context.Context, spacePath string, maxBytes, maxFiles uint64) error {
+	if maxBytes == 0 {
+		maxBytes = p.c.Quota.MaxBytes
+	}
+	if maxFiles == 0 {
+		maxFiles = p.c.Quota.MaxFiles
+	}
+	root := eosclient.Authorization{Role: eosclient.Role{UID: "0", GID: "0"}}
+	return p.quota.SetQuota(ctx, root, &eosclient.SetQuotaInfo{
+		UID:      p.c.Quota.UID,
+		GID:      p.c.Quota.GID,
+		MaxBytes: maxBytes,
+		MaxFiles: maxFiles,
+		// eos requires the trailing slash of the quota nodes
+		QuotaNode: strings.TrimSuffix(spacePath, "/") + "/",
+	})
+}
@@ -0,0 +1,145 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package cernboxspaces
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cs3org/reva/pkg/appctx"
+	"github.com/pkg/errors"
+)
+
+// spaceRow is a row of the table of the spaces.
+type spaceRow struct {
+	name    string
+	path    string
+	storage string
+}
+
+// spacesSnapshot is the content of the table of the spaces, read at most
+// once every spaces_cache_ttl seconds instead of at each request.
+type spacesSnapshot struct {
+	mu     sync.Mutex
+	rows   []spaceRow
+	loaded time.Time
+}
+
+// statements are the prepared statements, prepared at their first use.
+type statements struct {
+	db *sql.DB
+
+	mu    sync.Mutex
+	stmts map[string]*sql.Stmt
+}
+
+func newStatements(db *sql.DB) *statements {
+	return &statements{db: db, stmts: map[string]*sql.Stmt{}}
+}
+
+func (s *statements) get(ctx context.Context, query string) (*sql.Stmt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stmt, ok := s.stmts[query]; ok {
+		return stmt, nil
+	}
+	stmt, err := s.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	s.stmts[query] = stmt
+	return stmt, nil
+}
+
+func (s *statements) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, stmt := range s.stmts {
+		_ = stmt.Close()
+	}
+	s.stmts = map[string]*sql.Stmt{}
+}
+
+// loadSpaces returns the snapshot of the table of the spaces,
+// reading it again if expired.
+func (p *cboxProj) loadSpaces(ctx context.Context) ([]spaceRow, error) {
+	s := p.snapshot
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.loaded.IsZero() && time.Since(s.loaded) < time.Duration(p.c.SpacesCacheTTL)*time.Second {
+		return s.rows, nil
+	}
+
+	stmt, err := p.stmts.get(ctx, p.selectSpacesQuery())
+	if err != nil {
+		return nil, errors.Wrap(err, "error preparing query")
+	}
+	results, err := stmt.QueryContext(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting projects from db")
+	}
+	defer results.Close()
+
+	var rows []spaceRow
+	for results.Next() {
+		var r spaceRow
+		if err := results.Scan(&r.name, &r.path, &r.storage); err != nil {
+			return nil, errors.Wrap(err, "error scanning rows from db")
+		}
+		rows = append(rows, r)
+	}
+	if err := results.Err(); err != nil {
+		return nil, errors.Wrap(err, "error getting projects from db")
+	}
+
+	s.rows, s.loaded = rows, time.Now()
+	return rows, nil
+}
+
+// invalidateSpaces drops the snapshot of the table of the spaces,
+// e.g. after it was changed by hand.
+func (p *cboxProj) invalidateSpaces() {
+	p.snapshot.mu.Lock()
+	p.snapshot.loaded = time.Time{}
+	p.snapshot.mu.Unlock()
+}
+
+func (p *cboxProj) InvalidateSpacesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := appctx.ContextGetUser(ctx)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	groups, err := p.userGroups(ctx, user)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if p.c.SpaceCreatorsGroup == "" || !contains(groups, p.c.SpaceCreatorsGroup) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	p.invalidateSpaces()
+	w.WriteHeader(http.StatusNoContent)
+}
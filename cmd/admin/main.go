@@ -0,0 +1,127 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Command admin is a support tool to inspect and modify the shares,
+// public links and cback restores of CERNBox users.
+//
+// Usage:
+//
+//	admin [global flags] <shares|links|restores|migrate> <action> [flags]
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	// Provides mysql drivers.
+	_ "github.com/go-sql-driver/mysql"
+)
+
+type globalConfig struct {
+	DBUsername string
+	DBPassword string
+	DBHost     string
+	DBPort     int
+	DBName     string
+
+	CbackURL   string
+	CbackToken string
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: admin [global flags] <command> <action> [flags]
+
+Commands:
+  shares   list|get|delete   user and group shares
+  links    list|get|delete   public links
+  restores list|get|create   cback restore jobs
+  migrate  export|import     shares, links and their states, to move them to another instance
+
+Global flags:
+`)
+	flag.PrintDefaults()
+}
+
+func main() {
+	var c globalConfig
+	flag.StringVar(&c.DBUsername, "db-username", os.Getenv("ADMIN_DB_USERNAME"), "username of the shares database")
+	flag.StringVar(&c.DBPassword, "db-password", os.Getenv("ADMIN_DB_PASSWORD"), "password of the shares database")
+	flag.StringVar(&c.DBHost, "db-host", os.Getenv("ADMIN_DB_HOST"), "host of the shares database")
+	flag.IntVar(&c.DBPort, "db-port", envInt("ADMIN_DB_PORT", 3306), "port of the shares database")
+	flag.StringVar(&c.DBName, "db-name", os.Getenv("ADMIN_DB_NAME"), "name of the shares database")
+	flag.StringVar(&c.CbackURL, "cback-url", os.Getenv("ADMIN_CBACK_URL"), "url of the cback API")
+	flag.StringVar(&c.CbackToken, "cback-token", os.Getenv("ADMIN_CBACK_TOKEN"), "token of the cback API")
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	var err error
+	ctx := context.Background()
+	switch cmd, action, rest := args[0], args[1], args[2:]; cmd {
+	case "shares":
+		err = withDB(&c, func(db *sql.DB) error { return sharesCmd(ctx, db, action, rest, false) })
+	case "links":
+		err = withDB(&c, func(db *sql.DB) error { return sharesCmd(ctx, db, action, rest, true) })
+	case "migrate":
+		err = withDB(&c, func(db *sql.DB) error { return migrateCmd(ctx, db, action, rest) })
+	case "restores":
+		err = restoresCmd(ctx, &c, action, rest)
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func withDB(c *globalConfig, f func(*sql.DB) error) error {
+	db, err := sql.Open("mysql", fmt.Sprintf("%s:%s@tcp(%s:%d)/%s", c.DBUsername, c.DBPassword, c.DBHost, c.DBPort, c.DBName))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return f(db)
+}
+
+// envInt returns the value of the environment variable key,
+// or def if it is not set or not a number.
+func envInt(key string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return v
+	}
+	return def
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
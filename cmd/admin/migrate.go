@@ -0,0 +1,77 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/cernbox/reva-plugins/share/export"
+)
+
+func migrateCmd(ctx context.Context, db *sql.DB, action string, args []string) error {
+	fs := flag.NewFlagSet(action, flag.ExitOnError)
+	var f export.Filter
+	fs.StringVar(&f.User, "user", "", "export the shares owned or created by the user")
+	fs.StringVar(&f.Owner, "owner", "", "export the shares owned by the user, e.g. the owner of a project space")
+	file := fs.String("file", "", "file to write the export to or to read the import from, defaults to stdout/stdin")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	switch action {
+	case "export":
+		var w io.Writer = os.Stdout
+		if *file != "" {
+			out, err := os.Create(*file)
+			if err != nil {
+				return err
+			}
+			defer out.Close()
+			w = out
+		}
+		n, err := export.Export(ctx, db, &f, w)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "exported %d share(s)\n", n)
+		return nil
+	case "import":
+		var r io.Reader = os.Stdin
+		if *file != "" {
+			in, err := os.Open(*file)
+			if err != nil {
+				return err
+			}
+			defer in.Close()
+			r = in
+		}
+		res, err := export.Import(ctx, db, r)
+		if res != nil {
+			_ = printJSON(res)
+		}
+		return err
+	default:
+		return fmt.Errorf("unknown action %q", action)
+	}
+}
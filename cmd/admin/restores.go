@@ -0,0 +1,81 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	cback "github.com/cernbox/reva-plugins/cback/utils"
+	"github.com/pkg/errors"
+)
+
+func restoresCmd(ctx context.Context, c *globalConfig, action string, args []string) error {
+	fs := flag.NewFlagSet(action, flag.ExitOnError)
+	username := fs.String("user", "", "username the restores belong to")
+	id := fs.Int("id", 0, "id of the restore")
+	backupID := fs.Int("backup-id", 0, "id of the backup to restore from")
+	snapshot := fs.String("snapshot", "", "id of the snapshot to restore from")
+	path := fs.String("path", "", "path (in the cback namespace) to restore")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *username == "" {
+		return errors.New("user is required")
+	}
+	if c.CbackURL == "" {
+		return errors.New("cback-url is required")
+	}
+
+	client := cback.New(&cback.Config{
+		URL:   c.CbackURL,
+		Token: c.CbackToken,
+	})
+
+	switch action {
+	case "list":
+		restores, err := client.ListRestores(ctx, *username, nil)
+		if err != nil {
+			return err
+		}
+		return printJSON(restores)
+	case "get":
+		if *id == 0 {
+			return errors.New("id is required")
+		}
+		restore, err := client.GetRestore(ctx, *username, *id)
+		if err != nil {
+			return err
+		}
+		return printJSON(restore)
+	case "create":
+		if *backupID == 0 || *snapshot == "" || *path == "" {
+			return errors.New("backup-id, snapshot and path are required")
+		}
+		restore, err := client.NewRestore(ctx, *username, *backupID, *path, *snapshot, false)
+		if err != nil {
+			return err
+		}
+		return printJSON(restore)
+	default:
+		return fmt.Errorf("unknown action %q", action)
+	}
+}
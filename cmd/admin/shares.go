@@ -0,0 +1,209 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	sharesql "github.com/cernbox/reva-plugins/share/sql"
+	"github.com/pkg/errors"
+)
+
+const shareTypePublicLink = 3
+
+type dbShare struct {
+	ID           int64  `json:"id"`
+	ShareType    int    `json:"share_type"`
+	UIDOwner     string `json:"uid_owner"`
+	UIDInitiator string `json:"uid_initiator"`
+	ShareWith    string `json:"share_with,omitempty"`
+	Token        string `json:"token,omitempty"`
+	Prefix       string `json:"fileid_prefix"`
+	ItemSource   string `json:"item_source"`
+	ItemType     string `json:"item_type"`
+	FileTarget   string `json:"file_target"`
+	Permissions  int    `json:"permissions"`
+	STime        int64  `json:"stime"`
+	Expiration   string `json:"expiration,omitempty"`
+	Orphan       bool   `json:"orphan"`
+}
+
+type shareFilters struct {
+	id       int64
+	owner    string
+	resource string
+	token    string
+}
+
+func (f *shareFilters) register(fs *flag.FlagSet, links bool) {
+	fs.Int64Var(&f.id, "id", 0, "id of the share")
+	fs.StringVar(&f.owner, "owner", "", "owner or initiator of the shares")
+	fs.StringVar(&f.resource, "resource", "", "resource id of the shared item, in the form <storage_id>:<opaque_id>")
+	if links {
+		fs.StringVar(&f.token, "token", "", "token of the public link")
+	}
+}
+
+func (f *shareFilters) empty() bool {
+	return f.id == 0 && f.owner == "" && f.resource == "" && f.token == ""
+}
+
+// where returns the sql condition and its params matching the filters.
+func (f *shareFilters) where(links bool) (string, []interface{}, error) {
+	var conds []string
+	var params []interface{}
+
+	if links {
+		conds = append(conds, "share_type=?")
+	} else {
+		conds = append(conds, "share_type!=?")
+	}
+	params = append(params, shareTypePublicLink)
+
+	if f.id != 0 {
+		conds = append(conds, "id=?")
+		params = append(params, f.id)
+	}
+	if f.owner != "" {
+		conds = append(conds, "(uid_owner=? OR uid_initiator=?)")
+		params = append(params, f.owner, f.owner)
+	}
+	if f.resource != "" {
+		storageID, opaqueID, ok := strings.Cut(f.resource, ":")
+		if !ok {
+			return "", nil, errors.New("resource must be in the form <storage_id>:<opaque_id>")
+		}
+		conds = append(conds, "fileid_prefix=? AND item_source=?")
+		params = append(params, storageID, opaqueID)
+	}
+	if f.token != "" {
+		conds = append(conds, "token=?")
+		params = append(params, f.token)
+	}
+
+	return strings.Join(conds, " AND "), params, nil
+}
+
+func sharesCmd(ctx context.Context, db *sql.DB, action string, args []string, links bool) error {
+	fs := flag.NewFlagSet(action, flag.ExitOnError)
+	var filters shareFilters
+	filters.register(fs, links)
+	dryRun := fs.Bool("dry-run", false, "only print what would be deleted")
+	actor := fs.String("actor", os.Getenv("USER"), "admin recorded in the history of the deleted shares")
+	reshareUnshare := fs.String("reshare-unshare", "keep", "what happens to the reshares of the deleted shares: keep, cascade or orphan, as configured in the share manager")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	switch action {
+	case "list":
+		if filters.empty() {
+			return errors.New("at least one filter is required")
+		}
+		shares, err := listShares(ctx, db, &filters, links)
+		if err != nil {
+			return err
+		}
+		return printJSON(shares)
+	case "get":
+		if filters.id == 0 && filters.token == "" {
+			return errors.New("id or token is required")
+		}
+		shares, err := listShares(ctx, db, &filters, links)
+		if err != nil {
+			return err
+		}
+		if len(shares) == 0 {
+			return errors.New("share not found")
+		}
+		return printJSON(shares[0])
+	case "delete":
+		if filters.id == 0 && filters.token == "" {
+			// deleting by owner or resource is on purpose not allowed,
+			// to prevent accidental mass deletions
+			return errors.New("id or token is required")
+		}
+		switch *reshareUnshare {
+		case "keep", "cascade", "orphan":
+		default:
+			return fmt.Errorf("unknown reshare-unshare policy %q", *reshareUnshare)
+		}
+		shares, err := listShares(ctx, db, &filters, links)
+		if err != nil {
+			return err
+		}
+		if len(shares) == 0 {
+			return errors.New("share not found")
+		}
+		if *dryRun {
+			return printJSON(shares)
+		}
+		return deleteShares(ctx, db, shares, *actor, *reshareUnshare)
+	default:
+		return fmt.Errorf("unknown action %q", action)
+	}
+}
+
+func listShares(ctx context.Context, db *sql.DB, f *shareFilters, links bool) ([]*dbShare, error) {
+	where, params, err := f.where(links)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT id, share_type, coalesce(uid_owner, ''), coalesce(uid_initiator, ''), coalesce(share_with, ''), coalesce(token, ''),
+				coalesce(fileid_prefix, ''), coalesce(item_source, ''), coalesce(item_type, ''), coalesce(file_target, ''),
+				permissions, stime, coalesce(expiration, ''), coalesce(orphan, 0)
+			  FROM oc_share WHERE ` + where
+	rows, err := db.QueryContext(ctx, query, params...)
+	if err != nil {
+		return nil, errors.Wrap(err, "error querying shares")
+	}
+	defer rows.Close()
+
+	shares := []*dbShare{}
+	for rows.Next() {
+		var s dbShare
+		if err := rows.Scan(&s.ID, &s.ShareType, &s.UIDOwner, &s.UIDInitiator, &s.ShareWith, &s.Token, &s.Prefix, &s.ItemSource, &s.ItemType, &s.FileTarget, &s.Permissions, &s.STime, &s.Expiration, &s.Orphan); err != nil {
+			return nil, errors.Wrap(err, "error scanning share")
+		}
+		shares = append(shares, &s)
+	}
+	return shares, rows.Err()
+}
+
+// deleteShares deletes the shares through the share manager, so that they
+// can be restored and their history and reshares are updated.
+func deleteShares(ctx context.Context, db *sql.DB, shares []*dbShare, actor, reshareUnshare string) error {
+	ids := make([]string, 0, len(shares))
+	for _, s := range shares {
+		ids = append(ids, strconv.FormatInt(s.ID, 10))
+	}
+	n, err := sharesql.DeleteShares(ctx, db, actor, reshareUnshare, ids)
+	if err != nil {
+		return errors.Wrap(err, "error deleting shares")
+	}
+	fmt.Printf("deleted %d share(s)\n", n)
+	return nil
+}
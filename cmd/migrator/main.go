@@ -0,0 +1,102 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Command migrator applies the versioned migrations of the shares
+// database, defined in the share/schema package.
+//
+// Usage:
+//
+//	migrator [global flags] <status|up|down> [flags]
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	// Provides mysql drivers.
+	_ "github.com/go-sql-driver/mysql"
+)
+
+type globalConfig struct {
+	DBUsername string
+	DBPassword string
+	DBHost     string
+	DBPort     int
+	DBName     string
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: migrator [global flags] <action> [flags]
+
+Actions:
+  status   state of the migrations
+  up       apply the migrations, up to -to if set, or report
+           the rows they would change with -dry-run
+  down     revert the migrations down to -to, or -all
+
+Global flags:
+`)
+	flag.PrintDefaults()
+}
+
+func main() {
+	var c globalConfig
+	flag.StringVar(&c.DBUsername, "db-username", os.Getenv("MIGRATOR_DB_USERNAME"), "username of the shares database")
+	flag.StringVar(&c.DBPassword, "db-password", os.Getenv("MIGRATOR_DB_PASSWORD"), "password of the shares database")
+	flag.StringVar(&c.DBHost, "db-host", os.Getenv("MIGRATOR_DB_HOST"), "host of the shares database")
+	flag.IntVar(&c.DBPort, "db-port", envInt("MIGRATOR_DB_PORT", 3306), "port of the shares database")
+	flag.StringVar(&c.DBName, "db-name", os.Getenv("MIGRATOR_DB_NAME"), "name of the shares database")
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	db, err := sql.Open("mysql", fmt.Sprintf("%s:%s@tcp(%s:%d)/%s", c.DBUsername, c.DBPassword, c.DBHost, c.DBPort, c.DBName))
+	if err == nil {
+		err = migrateCmd(context.Background(), db, args[0], args[1:])
+		db.Close()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+// envInt returns the value of the environment variable key,
+// or def if it is not set or not a number.
+func envInt(key string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return v
+	}
+	return def
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
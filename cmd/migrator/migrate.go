@@ -0,0 +1,67 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/cernbox/reva-plugins/share/schema"
+)
+
+func migrateCmd(ctx context.Context, db *sql.DB, action string, args []string) error {
+	fs := flag.NewFlagSet(action, flag.ExitOnError)
+	target := fs.Int("to", 0, "version to migrate to, defaults to the latest for up")
+	all := fs.Bool("all", false, "revert all the migrations, for down")
+	dryRun := fs.Bool("dry-run", false, "report the rows the migrations would change without applying them, for up")
+	format := fs.String("format", "json", "format of the dry run report: json or csv")
+	userConfig := fs.String("user-config", "", "json config of the user manager, to look up the emails of the owners in the dry run report")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	switch action {
+	case "status":
+		states, err := schema.Status(ctx, db)
+		if err != nil {
+			return err
+		}
+		return printJSON(states)
+	case "up":
+		if *dryRun {
+			return dryRunReport(ctx, db, *target, *format, *userConfig)
+		}
+		versions, err := schema.Up(ctx, db, *target)
+		fmt.Fprintf(os.Stderr, "applied migration(s) %v\n", versions)
+		return err
+	case "down":
+		if *target == 0 && !*all {
+			return fmt.Errorf("down requires -to or -all")
+		}
+		versions, err := schema.Down(ctx, db, *target)
+		fmt.Fprintf(os.Stderr, "reverted migration(s) %v\n", versions)
+		return err
+	default:
+		usage()
+		return fmt.Errorf("unknown action %q", action)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/cernbox/reva-plugins/share/schema"
+	rest "github.com/cernbox/reva-plugins/user"
+)
+
+// dryRunReport writes to stdout the rows the migrations up to target would
+// change, with the emails of their owners if a user manager is configured,
+// so that the owners can be warned before the migrations are applied.
+func dryRunReport(ctx context.Context, db *sql.DB, target int, format, userConfig string) error {
+	if format != "json" && format != "csv" {
+		return fmt.Errorf("unknown format %q", format)
+	}
+
+	changes, err := schema.DryRun(ctx, db, target)
+	if err != nil {
+		return err
+	}
+	if userConfig != "" {
+		if err := lookupEmails(ctx, changes, userConfig); err != nil {
+			return err
+		}
+	}
+
+	if format == "json" {
+		if changes == nil {
+			changes = []*schema.Change{}
+		}
+		return printJSON(changes)
+	}
+	w := csv.NewWriter(os.Stdout)
+	_ = w.Write([]string{"version", "table", "id", "owner", "email", "change"})
+	for _, c := range changes {
+		_ = w.Write([]string{strconv.Itoa(c.Version), c.Table, strconv.FormatInt(c.ID, 10), c.Owner, c.Email, c.Change})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// lookupEmails sets the emails of the owners of the changes, looked
+// up with the user manager configured in the json file.
+func lookupEmails(ctx context.Context, changes []*schema.Change, userConfig string) error {
+	b, err := os.ReadFile(userConfig)
+	if err != nil {
+		return err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return fmt.Errorf("error decoding the user manager config: %w", err)
+	}
+	users, err := rest.New(ctx, m)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if c, ok := users.(interface{ Close() error }); ok {
+			_ = c.Close()
+		}
+	}()
+
+	emails := map[string]string{}
+	for _, c := range changes {
+		email, ok := emails[c.Owner]
+		if !ok {
+			if u, err := users.GetUserByClaim(ctx, "username", c.Owner, true); err == nil {
+				email = u.Mail
+			} else {
+				fmt.Fprintf(os.Stderr, "could not look up the owner %s: %v\n", c.Owner, err)
+			}
+			emails[c.Owner] = email
+		}
+		c.Email = email
+	}
+	return nil
+}
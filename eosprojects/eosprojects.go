@@ -43,12 +43,12 @@ func (eosProj) RevaPlugin() reva.PluginInfo {
 }
 
 type config struct {
-	Username              string `mapstructure:"username"`
+	Username              string `mapstructure:"username" validate:"required"`
 	Password              string `mapstructure:"password"`
-	Host                  string `mapstructure:"host"`
-	Port                  int    `mapstructure:"port"`
-	Name                  string `mapstructure:"name"`
-	Table                 string `mapstructure:"table"`
+	Host                  string `mapstructure:"host"     validate:"required"`
+	Port                  int    `mapstructure:"port"     validate:"required,min=1,max=65535"`
+	Name                  string `mapstructure:"name"     validate:"required"`
+	Table                 string `mapstructure:"table"    validate:"required"`
 	Prefix                string `mapstructure:"prefix"`
 	GatewaySvc            string `mapstructure:"gatewaysvc"`
 	SkipUserGroupsInToken bool   `mapstructure:"skip_user_groups_in_token"`
@@ -0,0 +1,113 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cs3org/reva/pkg/httpclient"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrQueueFull is returned when an event cannot be queued for delivery.
+var ErrQueueFull = errors.New("events: queue is full, event dropped")
+
+type httpPublisher struct {
+	c      *Config
+	client *httpclient.Client
+	queue  chan *Event
+	done   chan struct{}
+	once   sync.Once
+}
+
+func newHTTPPublisher(c *Config) *httpPublisher {
+	p := &httpPublisher{
+		c:      c,
+		client: httpclient.New(httpclient.Timeout(time.Duration(c.Timeout) * time.Second)),
+		queue:  make(chan *Event, c.QueueSize),
+		done:   make(chan struct{}),
+	}
+	go p.deliver()
+	return p
+}
+
+// Publish queues the event for delivery.
+func (p *httpPublisher) Publish(_ context.Context, e *Event) error {
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now()
+	}
+	select {
+	case p.queue <- e:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+func (p *httpPublisher) deliver() {
+	for {
+		select {
+		case <-p.done:
+			return
+		case e := <-p.queue:
+			if err := p.send(e); err != nil {
+				log.Error().Err(err).Str("type", e.Type).Msg("events: error delivering event")
+			}
+		}
+	}
+}
+
+func (p *httpPublisher) send(e *Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, p.c.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.c.Token)
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return errors.New("events: endpoint returned " + res.Status)
+	}
+	return nil
+}
+
+// Close stops the delivery of the queued events.
+func (p *httpPublisher) Close() error {
+	p.once.Do(func() { close(p.done) })
+	return nil
+}
@@ -0,0 +1,224 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package events
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// natsPublisher publishes the events on a NATS server, on the subject
+// <subject>.<event type>. It speaks the plain text client protocol,
+// which for publishing only requires CONNECT, PUB and answering the
+// PINGs of the server.
+type natsPublisher struct {
+	c     *Config
+	queue chan *Event
+	done  chan struct{}
+	once  sync.Once
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func newNATSPublisher(c *Config) (*natsPublisher, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, errors.Wrap(err, "events: error parsing the nats url")
+	}
+	if u.Scheme != "nats" && u.Scheme != "tls" {
+		return nil, errors.Errorf("events: unsupported nats url scheme %q", u.Scheme)
+	}
+
+	p := &natsPublisher{
+		c:     c,
+		queue: make(chan *Event, c.QueueSize),
+		done:  make(chan struct{}),
+	}
+	go p.deliver()
+	return p, nil
+}
+
+func (p *natsPublisher) Publish(_ context.Context, e *Event) error {
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now()
+	}
+	select {
+	case p.queue <- e:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+func (p *natsPublisher) deliver() {
+	for {
+		select {
+		case <-p.done:
+			return
+		case e := <-p.queue:
+			if err := p.send(e); err != nil {
+				log.Error().Err(err).Str("type", e.Type).Msg("events: error delivering event")
+			}
+		}
+	}
+}
+
+func (p *natsPublisher) send(e *Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == nil {
+		if p.conn, err = p.connect(); err != nil {
+			return err
+		}
+	}
+
+	msg := fmt.Sprintf("PUB %s.%s %d\r\n%s\r\n", p.c.Subject, e.Type, len(body), body)
+	_ = p.conn.SetWriteDeadline(time.Now().Add(time.Duration(p.c.Timeout) * time.Second))
+	if _, err := p.conn.Write([]byte(msg)); err != nil {
+		// the connection is established again on the next event
+		_ = p.conn.Close()
+		p.conn = nil
+		return err
+	}
+	return nil
+}
+
+// connect opens a connection to the server and performs the handshake.
+// It must be called with p.mu held.
+func (p *natsPublisher) connect() (net.Conn, error) {
+	u, _ := url.Parse(p.c.URL)
+	timeout := time.Duration(p.c.Timeout) * time.Second
+
+	// the server sends INFO in plain text, and the client then
+	// upgrades the connection to TLS if either side requires it
+	conn, err := (&net.Dialer{Timeout: timeout}).Dial("tcp", u.Host)
+	if err != nil {
+		return nil, errors.Wrap(err, "events: error connecting to nats")
+	}
+
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return nil, errors.New("events: nats server did not send INFO")
+	}
+	var info struct {
+		TLSRequired bool `json:"tls_required"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(strings.TrimSpace(line), "INFO ")), &info); err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "events: error parsing the nats INFO")
+	}
+
+	if u.Scheme == "tls" || info.TLSRequired {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12})
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, errors.Wrap(err, "events: error in the nats tls handshake")
+		}
+		conn = tlsConn
+		r = bufio.NewReader(conn)
+	}
+
+	opts := map[string]any{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     "reva-plugins",
+		"lang":     "go",
+		"version":  "1.0.0",
+	}
+	if p.c.Token != "" {
+		opts["auth_token"] = p.c.Token
+	}
+	if u.User != nil {
+		opts["user"] = u.User.Username()
+		opts["pass"], _ = u.User.Password()
+	}
+	connect, _ := json.Marshal(opts)
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\nPING\r\n", connect); err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "events: error sending CONNECT to nats")
+	}
+	// the server answers the PING only if CONNECT was accepted
+	if line, err = r.ReadString('\n'); err != nil || strings.TrimSpace(line) != "PONG" {
+		conn.Close()
+		return nil, errors.Errorf("events: nats server refused the connection: %s", strings.TrimSpace(line))
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	go p.read(conn, r)
+	return conn, nil
+}
+
+// read answers the PINGs of the server, which otherwise closes the
+// connection as stale, until the connection is closed.
+func (p *natsPublisher) read(conn net.Conn, r *bufio.Reader) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			p.mu.Lock()
+			if p.conn == conn {
+				p.conn = nil
+			}
+			p.mu.Unlock()
+			conn.Close()
+			return
+		}
+
+		switch line = strings.TrimSpace(line); {
+		case line == "PING":
+			p.mu.Lock()
+			_, _ = conn.Write([]byte("PONG\r\n"))
+			p.mu.Unlock()
+		case strings.HasPrefix(line, "-ERR"):
+			log.Error().Str("error", line).Msg("events: nats server returned an error")
+		}
+	}
+}
+
+func (p *natsPublisher) Close() error {
+	p.once.Do(func() {
+		close(p.done)
+		p.mu.Lock()
+		if p.conn != nil {
+			_ = p.conn.Close()
+			p.conn = nil
+		}
+		p.mu.Unlock()
+	})
+	return nil
+}
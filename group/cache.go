@@ -24,10 +24,8 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
-	"time"
 
 	grouppb "github.com/cs3org/go-cs3apis/cs3/identity/group/v1beta1"
-	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
 	"github.com/gomodule/redigo/redis"
 )
 
@@ -36,47 +34,10 @@ const (
 	idPrefix              = "id:"
 	namePrefix            = "name:"
 	gidPrefix             = "gid:"
-	groupMembersPrefix    = "members:"
+	groupMembersPrefix    = "memberlist:"
 	groupInternalIDPrefix = "internal:"
 )
 
-func initRedisPool(address, username, password string) *redis.Pool {
-	return &redis.Pool{
-
-		MaxIdle:     50,
-		MaxActive:   1000,
-		IdleTimeout: 240 * time.Second,
-
-		Dial: func() (redis.Conn, error) {
-			var c redis.Conn
-			var err error
-			switch {
-			case username != "":
-				c, err = redis.Dial("tcp", address,
-					redis.DialUsername(username),
-					redis.DialPassword(password),
-				)
-			case password != "":
-				c, err = redis.Dial("tcp", address,
-					redis.DialPassword(password),
-				)
-			default:
-				c, err = redis.Dial("tcp", address)
-			}
-
-			if err != nil {
-				return nil, err
-			}
-			return c, err
-		},
-
-		TestOnBorrow: func(c redis.Conn, t time.Time) error {
-			_, err := c.Do("PING")
-			return err
-		},
-	}
-}
-
 func (m *manager) setVal(key, val string, expiration int) error {
 	conn := m.redisPool.Get()
 	defer conn.Close()
@@ -191,23 +152,3 @@ func (m *manager) fetchCachedGroupByParam(field, claim string) (*grouppb.Group,
 	}
 	return &g, nil
 }
-
-func (m *manager) fetchCachedGroupMembers(gid *grouppb.GroupId) ([]*userpb.UserId, error) {
-	members, err := m.getVal(groupPrefix + groupMembersPrefix + strings.ToLower(gid.OpaqueId))
-	if err != nil {
-		return nil, err
-	}
-	u := []*userpb.UserId{}
-	if err = json.Unmarshal([]byte(members), &u); err != nil {
-		return nil, err
-	}
-	return u, nil
-}
-
-func (m *manager) cacheGroupMembers(gid *grouppb.GroupId, members []*userpb.UserId) error {
-	u, err := json.Marshal(&members)
-	if err != nil {
-		return err
-	}
-	return m.setVal(groupPrefix+groupMembersPrefix+strings.ToLower(gid.OpaqueId), string(u), m.conf.GroupMembersCacheExpiration*60)
-}
@@ -0,0 +1,208 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	user "github.com/cernbox/reva-plugins/user"
+	grouppb "github.com/cs3org/go-cs3apis/cs3/identity/group/v1beta1"
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	"github.com/cs3org/reva/pkg/appctx"
+	"github.com/cs3org/reva/pkg/utils/list"
+	"github.com/gomodule/redigo/redis"
+)
+
+// membersListMarker is the first element of the cached lists of members,
+// to tell an empty group from one not cached.
+const membersListMarker = ""
+
+var errTooManyMembers = errors.New("rest: too many members to cache")
+
+// MembersWalker is implemented by the group managers able to pass on
+// the members of the groups page by page, without holding them all.
+type MembersWalker interface {
+	WalkMembers(ctx context.Context, gid *grouppb.GroupId, fn func([]*userpb.UserId) error) error
+}
+
+// WalkMembers calls fn with the pages of the recursive members of the group.
+// The members are read from the cache if present, otherwise they are fetched
+// from the API and cached, unless the group has more than max_cached_members.
+func (m *manager) WalkMembers(ctx context.Context, gid *grouppb.GroupId, fn func([]*userpb.UserId) error) error {
+	found, err := m.walkCachedGroupMembers(gid, fn)
+	if err != nil || found {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/api/v1.0/Group/%s/memberidentities/recursive?limit=%d&field=upn&field=primaryAccountEmail&field=displayName&field=uid&field=gid&field=type&field=source", m.conf.APIBaseURL, gid.OpaqueId, m.conf.MembersPageSize)
+
+	w := m.newMembersWriter(gid)
+	for {
+		var r user.IdentitiesResponse
+		if err := m.apiTokenManager.SendAPIGetRequest(ctx, url, false, &r); err != nil {
+			w.abort()
+			return err
+		}
+
+		users := list.Map(r.Data, func(i *user.Identity) *userpb.UserId {
+			return &userpb.UserId{OpaqueId: i.Upn, Idp: m.conf.IDProvider, Type: i.UserType()}
+		})
+		w.append(users)
+		if err := fn(users); err != nil {
+			w.abort()
+			return err
+		}
+
+		if r.Pagination.Next == nil {
+			break
+		}
+		url = fmt.Sprintf("%s%s", m.conf.APIBaseURL, *r.Pagination.Next)
+	}
+
+	if err := w.commit(); err != nil {
+		appctx.GetLogger(ctx).Error().Err(err).Msg("rest: error caching group members")
+	}
+	return nil
+}
+
+// walkCachedGroupMembers calls fn with the pages of the cached members
+// of the group, returning false if they are not cached.
+func (m *manager) walkCachedGroupMembers(gid *grouppb.GroupId, fn func([]*userpb.UserId) error) (bool, error) {
+	conn := m.redisPool.Get()
+	defer conn.Close()
+
+	key := groupPrefix + groupMembersPrefix + strings.ToLower(gid.OpaqueId)
+	size := m.conf.MembersPageSize
+	for start := 0; ; start += size {
+		vals, err := redis.Strings(conn.Do("LRANGE", key, start, start+size-1))
+		if err != nil {
+			return start > 0, err
+		}
+		last := len(vals) < size
+		if start == 0 {
+			if len(vals) == 0 {
+				return false, nil
+			}
+			vals = vals[1:]
+		}
+
+		page := make([]*userpb.UserId, 0, len(vals))
+		for _, v := range vals {
+			u := &userpb.UserId{}
+			if err := json.Unmarshal([]byte(v), u); err != nil {
+				return true, err
+			}
+			page = append(page, u)
+		}
+		if len(page) > 0 {
+			if err := fn(page); err != nil {
+				return true, err
+			}
+		}
+		if last {
+			return true, nil
+		}
+	}
+}
+
+// membersWriter caches the members of a group while they are fetched,
+// in a temporary list renamed once complete.
+type membersWriter struct {
+	m     *manager
+	key   string
+	tmp   string
+	count int
+	err   error
+}
+
+func (m *manager) newMembersWriter(gid *grouppb.GroupId) *membersWriter {
+	key := groupPrefix + groupMembersPrefix + strings.ToLower(gid.OpaqueId)
+	w := &membersWriter{
+		m:   m,
+		key: key,
+		tmp: key + ":tmp:" + strconv.FormatInt(time.Now().UnixNano(), 36),
+	}
+	w.push(membersListMarker)
+	return w
+}
+
+func (w *membersWriter) push(vals ...interface{}) {
+	conn := w.m.redisPool.Get()
+	defer conn.Close()
+
+	args := append([]interface{}{w.tmp}, vals...)
+	_ = conn.Send("MULTI")
+	_ = conn.Send("RPUSH", args...)
+	_ = conn.Send("EXPIRE", w.tmp, w.m.conf.GroupMembersCacheExpiration*60)
+	_, w.err = conn.Do("EXEC")
+}
+
+func (w *membersWriter) append(users []*userpb.UserId) {
+	if w.err != nil || len(users) == 0 {
+		return
+	}
+	w.count += len(users)
+	if w.count > w.m.conf.MaxCachedMembers {
+		w.abort()
+		w.err = errTooManyMembers
+		return
+	}
+
+	vals := make([]interface{}, 0, len(users))
+	for _, u := range users {
+		b, err := json.Marshal(u)
+		if err != nil {
+			w.abort()
+			w.err = err
+			return
+		}
+		vals = append(vals, b)
+	}
+	w.push(vals...)
+}
+
+func (w *membersWriter) abort() {
+	conn := w.m.redisPool.Get()
+	defer conn.Close()
+	_, _ = conn.Do("DEL", w.tmp)
+}
+
+func (w *membersWriter) commit() error {
+	if w.err == errTooManyMembers {
+		return nil
+	}
+	if w.err != nil {
+		w.abort()
+		return w.err
+	}
+
+	conn := w.m.redisPool.Get()
+	defer conn.Close()
+	_ = conn.Send("MULTI")
+	_ = conn.Send("RENAME", w.tmp, w.key)
+	_ = conn.Send("EXPIRE", w.key, w.m.conf.GroupMembersCacheExpiration*60)
+	_, err := conn.Do("EXEC")
+	return err
+}
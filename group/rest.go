@@ -21,21 +21,17 @@ package rest
 import (
 	"context"
 	"fmt"
-	"os"
-	"os/signal"
 	"strings"
-	"syscall"
 	"time"
 
-	user "github.com/cernbox/reva-plugins/user"
+	"github.com/cernbox/reva-plugins/redispool"
+	"github.com/cernbox/reva-plugins/runner"
 	grouppb "github.com/cs3org/go-cs3apis/cs3/identity/group/v1beta1"
 	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
 	"github.com/cs3org/reva"
-	"github.com/cs3org/reva/pkg/appctx"
 	utils "github.com/cs3org/reva/pkg/cbox/utils"
 	"github.com/cs3org/reva/pkg/group"
 	"github.com/cs3org/reva/pkg/utils/cfg"
-	"github.com/cs3org/reva/pkg/utils/list"
 	"github.com/gomodule/redigo/redis"
 	"github.com/rs/zerolog/log"
 )
@@ -48,6 +44,7 @@ type manager struct {
 	conf            *config
 	redisPool       *redis.Pool
 	apiTokenManager *utils.APITokenManager
+	runner          *runner.Runner
 }
 
 func (manager) RevaPlugin() reva.PluginInfo {
@@ -66,17 +63,27 @@ type config struct {
 	RedisPassword string `mapstructure:"redis_password" docs:""`
 	// The time in minutes for which the members of a group would be cached
 	GroupMembersCacheExpiration int `mapstructure:"group_members_cache_expiration" docs:"5"`
+	// The number of members fetched and passed on at once when expanding a group
+	MembersPageSize int `mapstructure:"members_page_size" docs:"1000"`
+	// The groups with more members than this are expanded each time instead of being cached
+	MaxCachedMembers int `mapstructure:"max_cached_members" docs:"100000"`
+	// The maximum number of groups returned by FindGroups, the most relevant first.
+	// 0 returns all the matching groups.
+	FindGroupsLimit int `mapstructure:"find_groups_limit" docs:"0" validate:"min=0"`
+	// The patterns of the names of the groups never returned by FindGroups,
+	// e.g. the huge groups that should not be offered in the share dialog
+	FindGroupsBlacklist []string `mapstructure:"find_groups_blacklist" docs:""`
 	// The OIDC Provider
 	IDProvider string `mapstructure:"id_provider" docs:"http://cernbox.cern.ch"`
 	// Base API Endpoint
-	APIBaseURL string `mapstructure:"api_base_url" docs:"https://authorization-service-api-dev.web.cern.ch"`
+	APIBaseURL string `mapstructure:"api_base_url" docs:"https://authorization-service-api-dev.web.cern.ch" validate:"url"`
 	// Client ID needed to authenticate
-	ClientID string `mapstructure:"client_id" docs:"-"`
+	ClientID string `mapstructure:"client_id" docs:"-" validate:"required"`
 	// Client Secret
-	ClientSecret string `mapstructure:"client_secret" docs:"-"`
+	ClientSecret string `mapstructure:"client_secret" docs:"-" validate:"required"`
 
 	// Endpoint to generate token to access the API
-	OIDCTokenEndpoint string `mapstructure:"oidc_token_endpoint" docs:"https://keycloak-dev.cern.ch/auth/realms/cern/api-access/token"`
+	OIDCTokenEndpoint string `mapstructure:"oidc_token_endpoint" docs:"https://keycloak-dev.cern.ch/auth/realms/cern/api-access/token" validate:"url"`
 	// The target application for which token needs to be generated
 	TargetAPI string `mapstructure:"target_api" docs:"authorization-service-api"`
 	// The time in seconds between bulk fetch of groups
@@ -87,6 +94,12 @@ func (c *config) ApplyDefaults() {
 	if c.GroupMembersCacheExpiration == 0 {
 		c.GroupMembersCacheExpiration = 5
 	}
+	if c.MembersPageSize == 0 {
+		c.MembersPageSize = 1000
+	}
+	if c.MaxCachedMembers == 0 {
+		c.MaxCachedMembers = 100000
+	}
 	if c.RedisAddress == "" {
 		c.RedisAddress = ":6379"
 	}
@@ -114,7 +127,7 @@ func New(ctx context.Context, m map[string]interface{}) (group.Manager, error) {
 		return nil, err
 	}
 
-	redisPool := initRedisPool(c.RedisAddress, c.RedisUsername, c.RedisPassword)
+	redisPool := redispool.New(&redispool.Config{Address: c.RedisAddress, Username: c.RedisUsername, Password: c.RedisPassword})
 	apiTokenManager, err := utils.InitAPITokenManager(m)
 	if err != nil {
 		return nil, err
@@ -124,25 +137,15 @@ func New(ctx context.Context, m map[string]interface{}) (group.Manager, error) {
 		conf:            &c,
 		redisPool:       redisPool,
 		apiTokenManager: apiTokenManager,
+		runner:          runner.New(context.Background()),
 	}
-	go mgr.fetchAllGroups(context.Background())
+	mgr.runner.Every("rest: fetch all groups", time.Duration(c.GroupFetchInterval)*time.Second, true, mgr.fetchAllGroupAccounts)
 	return mgr, nil
 }
 
-func (m *manager) fetchAllGroups(ctx context.Context) {
-	_ = m.fetchAllGroupAccounts(ctx)
-	ticker := time.NewTicker(time.Duration(m.conf.GroupFetchInterval) * time.Second)
-	work := make(chan os.Signal, 1)
-	signal.Notify(work, syscall.SIGHUP, syscall.SIGINT, syscall.SIGQUIT)
-
-	for {
-		select {
-		case <-work:
-			return
-		case <-ticker.C:
-			_ = m.fetchAllGroupAccounts(ctx)
-		}
-	}
+// Close stops the background fetch of the groups.
+func (m *manager) Close() error {
+	return m.runner.Close()
 }
 
 // Group contains the information about a group.
@@ -253,53 +256,53 @@ func (m *manager) GetGroupByClaim(ctx context.Context, claim, value string, skip
 
 func (m *manager) FindGroups(ctx context.Context, query string, skipFetchingMembers bool) ([]*grouppb.Group, error) {
 	// Look at namespaces filters. If the query starts with:
-	// "a" or none => get egroups
+	// "a" or none => get all the groups
+	// "e" => get the egroups
+	// "u" => get the unix groups, i.e. the egroups with a gid
 	// other filters => get empty list
 
 	parts := strings.SplitN(query, ":", 2)
 
+	var namespace string
 	if len(parts) == 2 {
-		if parts[0] == "a" {
-			query = parts[1]
-		} else {
-			return []*grouppb.Group{}, nil
-		}
+		namespace, query = parts[0], parts[1]
 	}
 
-	return m.findCachedGroups(query)
-}
-
-func (m *manager) GetMembers(ctx context.Context, gid *grouppb.GroupId) ([]*userpb.UserId, error) {
-	users, err := m.fetchCachedGroupMembers(gid)
-	if err == nil {
-		return users, nil
+	var filter func(*grouppb.Group) bool
+	switch namespace {
+	case "", "a", "e":
+		filter = func(*grouppb.Group) bool { return true }
+	case "u":
+		filter = func(g *grouppb.Group) bool { return g.GidNumber != 0 }
+	default:
+		return []*grouppb.Group{}, nil
 	}
 
-	url := fmt.Sprintf("%s/api/v1.0/Group/%s/memberidentities/recursive?limit=10&field=upn&field=primaryAccountEmail&field=displayName&field=uid&field=gid&field=type&field=source", m.conf.APIBaseURL, gid.OpaqueId)
-
-	var r user.IdentitiesResponse
-	members := []*userpb.UserId{}
-	for {
-		if err := m.apiTokenManager.SendAPIGetRequest(ctx, url, false, &r); err != nil {
-			return nil, err
-		}
-
-		users := list.Map(r.Data, func(i *user.Identity) *userpb.UserId {
-			return &userpb.UserId{OpaqueId: i.Upn, Idp: m.conf.IDProvider, Type: i.UserType()}
-		})
-		members = append(members, users...)
+	groups, err := m.findCachedGroups(query)
+	if err != nil {
+		return nil, err
+	}
 
-		if r.Pagination.Next == nil {
-			break
+	groupSlice := []*grouppb.Group{}
+	for _, g := range groups {
+		if filter(g) && !m.blacklisted(g) {
+			groupSlice = append(groupSlice, g)
 		}
-		url = fmt.Sprintf("%s%s", m.conf.APIBaseURL, *r.Pagination.Next)
 	}
 
-	if err = m.cacheGroupMembers(gid, members); err != nil {
-		appctx.GetLogger(ctx).Error().Err(err).Msg("rest: error caching group members")
-	}
+	return rankGroups(groupSlice, query, m.conf.FindGroupsLimit), nil
+}
 
-	return users, nil
+func (m *manager) GetMembers(ctx context.Context, gid *grouppb.GroupId) ([]*userpb.UserId, error) {
+	members := []*userpb.UserId{}
+	err := m.WalkMembers(ctx, gid, func(page []*userpb.UserId) error {
+		members = append(members, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return members, nil
 }
 
 func (m *manager) HasMember(ctx context.Context, gid *grouppb.GroupId, uid *userpb.UserId) (bool, error) {
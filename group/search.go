@@ -0,0 +1,102 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package rest
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	grouppb "github.com/cs3org/go-cs3apis/cs3/identity/group/v1beta1"
+)
+
+// Weights of the fields matched by the query, for the ranking of the groups.
+const (
+	groupNameWeight   = 2
+	displayNameWeight = 1
+)
+
+// Scores of the kinds of matches, multiplied by the weight of the field.
+const (
+	exactScore    = 100
+	prefixScore   = 50
+	wordScore     = 30
+	containsScore = 10
+)
+
+// matchScore scores how well the value of a field matches the query.
+func matchScore(value, query string) int {
+	value = strings.ToLower(value)
+	switch {
+	case value == "" || query == "":
+		return 0
+	case value == query:
+		return exactScore
+	case strings.HasPrefix(value, query):
+		return prefixScore
+	}
+	for _, w := range strings.FieldsFunc(value, func(r rune) bool { return r == ' ' || r == '.' || r == '-' || r == '_' }) {
+		if strings.HasPrefix(w, query) {
+			return wordScore
+		}
+	}
+	if strings.Contains(value, query) {
+		return containsScore
+	}
+	return 0
+}
+
+func groupScore(g *grouppb.Group, query string) int {
+	return groupNameWeight*matchScore(g.GroupName, query) +
+		displayNameWeight*matchScore(g.DisplayName, query)
+}
+
+// rankGroups sorts the groups by relevance for the query, so that the exact
+// and prefix matches come first, and keeps at most limit groups if limit > 0.
+func rankGroups(groups []*grouppb.Group, query string, limit int) []*grouppb.Group {
+	query = strings.ToLower(strings.TrimSpace(query))
+	scores := make(map[*grouppb.Group]int, len(groups))
+	for _, g := range groups {
+		scores[g] = groupScore(g, query)
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		if si, sj := scores[groups[i]], scores[groups[j]]; si != sj {
+			return si > sj
+		}
+		return groups[i].GroupName < groups[j].GroupName
+	})
+
+	if limit > 0 && len(groups) > limit {
+		groups = groups[:limit]
+	}
+	return groups
+}
+
+// blacklisted returns true if the name of the group matches
+// one of the patterns of the groups never offered by FindGroups.
+func (m *manager) blacklisted(g *grouppb.Group) bool {
+	name := strings.ToLower(g.GroupName)
+	for _, p := range m.conf.FindGroupsBlacklist {
+		if ok, _ := path.Match(strings.ToLower(p), name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,218 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Package metrics provides counters and histograms with labels, published
+// through expvar and exposed in the prometheus text format by Handler,
+// served by the http.services.pluginmetrics service.
+package metrics
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the upper bounds in seconds of the latency histograms.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var (
+	mu         sync.Mutex
+	registered = map[string]metric{}
+)
+
+type metric interface {
+	expvar.Var
+	writeText(w *strings.Builder, name string)
+}
+
+// register returns the metric already registered with the given name,
+// or registers the one created by newMetric. The plugins can be
+// instantiated multiple times, sharing the same metrics.
+func register[T metric](name string, newMetric func() T) T {
+	mu.Lock()
+	defer mu.Unlock()
+	if m, ok := registered[name]; ok {
+		return m.(T)
+	}
+	m := newMetric()
+	registered[name] = m
+	expvar.Publish(name, m)
+	return m
+}
+
+// CounterVec is a set of counters, one for each combination of the labels.
+type CounterVec struct {
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewCounterVec creates or returns the counter with the given name.
+func NewCounterVec(name string, labels ...string) *CounterVec {
+	return register(name, func() *CounterVec {
+		return &CounterVec{labels: labels, values: map[string]float64{}}
+	})
+}
+
+// Add adds v to the counter with the given label values.
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	key := formatLabels(c.labels, labelValues)
+	c.mu.Lock()
+	c.values[key] += v
+	c.mu.Unlock()
+}
+
+// Inc increments by one the counter with the given label values.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, _ := json.Marshal(c.values)
+	return string(b)
+}
+
+func (c *CounterVec) writeText(w *strings.Builder, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# TYPE %s counter\n", name)
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s{%s} %g\n", name, key, c.values[key])
+	}
+}
+
+// HistogramVec is a set of histograms, one for each combination of the labels.
+type HistogramVec struct {
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	values map[string]*histogram
+}
+
+type histogram struct {
+	Counts []uint64 `json:"counts"`
+	Sum    float64  `json:"sum"`
+	Count  uint64   `json:"count"`
+}
+
+// NewHistogramVec creates or returns the histogram with the given name.
+func NewHistogramVec(name string, buckets []float64, labels ...string) *HistogramVec {
+	return register(name, func() *HistogramVec {
+		return &HistogramVec{labels: labels, buckets: buckets, values: map[string]*histogram{}}
+	})
+}
+
+// Observe adds an observation to the histogram with the given label values.
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	key := formatLabels(h.labels, labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hist, ok := h.values[key]
+	if !ok {
+		hist = &histogram{Counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hist
+	}
+	for i, b := range h.buckets {
+		if v <= b {
+			hist.Counts[i]++
+		}
+	}
+	hist.Sum += v
+	hist.Count++
+}
+
+// ObserveSince adds the seconds elapsed since start to the histogram.
+func (h *HistogramVec) ObserveSince(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (h *HistogramVec) String() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	b, _ := json.Marshal(h.values)
+	return string(b)
+}
+
+func (h *HistogramVec) writeText(w *strings.Builder, name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+	for _, key := range sortedKeys(h.values) {
+		hist := h.values[key]
+		sep := ""
+		if key != "" {
+			sep = ","
+		}
+		for i, b := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket{%s%sle=\"%g\"} %d\n", name, key, sep, b, hist.Counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, key, sep, hist.Count)
+		fmt.Fprintf(w, "%s_sum{%s} %g\n", name, key, hist.Sum)
+		fmt.Fprintf(w, "%s_count{%s} %d\n", name, key, hist.Count)
+	}
+}
+
+func formatLabels(labels, values []string) string {
+	pairs := make([]string, 0, len(labels))
+	for i, l := range labels {
+		v := ""
+		if i < len(values) {
+			v = values[i]
+		}
+		pairs = append(pairs, fmt.Sprintf("%s=%q", l, v))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Handler serves all the registered metrics in the prometheus text format.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		names := sortedKeys(registered)
+		metrics := make([]metric, 0, len(names))
+		for _, n := range names {
+			metrics = append(metrics, registered[n])
+		}
+		mu.Unlock()
+
+		var b strings.Builder
+		for i, m := range metrics {
+			m.writeText(&b, names[i])
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_, _ = w.Write([]byte(b.String()))
+	})
+}
@@ -0,0 +1,54 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	c := NewCounterVec("test_requests_total", "method")
+	c.Inc("GET")
+	c.Inc("GET")
+	if NewCounterVec("test_requests_total", "method") != c {
+		t.Fatal("registering a metric twice should return the same metric")
+	}
+
+	h := NewHistogramVec("test_duration_seconds", []float64{1, 2}, "method")
+	h.Observe(1.5, "GET")
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+
+	for _, line := range []string{
+		`test_requests_total{method="GET"} 2`,
+		`test_duration_seconds_bucket{method="GET",le="1"} 0`,
+		`test_duration_seconds_bucket{method="GET",le="2"} 1`,
+		`test_duration_seconds_bucket{method="GET",le="+Inf"} 1`,
+		`test_duration_seconds_sum{method="GET"} 1.5`,
+		`test_duration_seconds_count{method="GET"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("missing line %q in:\n%s", line, body)
+		}
+	}
+}
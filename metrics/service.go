@@ -0,0 +1,97 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package metrics
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/cs3org/reva"
+	"github.com/cs3org/reva/pkg/rhttp/global"
+	"github.com/cs3org/reva/pkg/utils/cfg"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	reva.RegisterPlugin(svc{})
+}
+
+type config struct {
+	Prefix string `mapstructure:"prefix"`
+	// Token must be sent as bearer token by the scrapers, as the
+	// endpoint is not behind the reva authentication.
+	Token string `mapstructure:"token" validate:"required"`
+}
+
+func (c *config) ApplyDefaults() {
+	if c.Prefix == "" {
+		c.Prefix = "pluginmetrics"
+	}
+}
+
+// svc is an HTTP service serving the metrics of all the plugins
+// running in the same process, in the prometheus text format.
+type svc struct {
+	config *config
+}
+
+func (svc) RevaPlugin() reva.PluginInfo {
+	return reva.PluginInfo{
+		ID:  "http.services.pluginmetrics",
+		New: New,
+	}
+}
+
+var _ global.NewService = New
+
+// New returns a new metrics http service.
+func New(ctx context.Context, m map[string]interface{}) (global.Service, error) {
+	c := &config{}
+	if err := cfg.Decode(m, c); err != nil {
+		return nil, errors.Wrap(err, "metrics: invalid config")
+	}
+	return &svc{config: c}, nil
+}
+
+func (s *svc) Prefix() string {
+	return s.config.Prefix
+}
+
+func (s *svc) Unprotected() []string {
+	return []string{"/"}
+}
+
+func (s *svc) Close() error {
+	return nil
+}
+
+func (s *svc) Handler() http.Handler {
+	metrics := Handler()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.config.Token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "invalid metrics token", http.StatusUnauthorized)
+			return
+		}
+		metrics.ServeHTTP(w, r)
+	})
+}
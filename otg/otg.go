@@ -19,11 +19,11 @@ func init() {
 
 type config struct {
 	Prefix     string `mapstructure:"prefix"`
-	DbUsername string `mapstructure:"db_username"`
+	DbUsername string `mapstructure:"db_username" validate:"required"`
 	DbPassword string `mapstructure:"db_password"`
-	DbHost     string `mapstructure:"db_host"`
-	DbPort     int    `mapstructure:"db_port"`
-	DbName     string `mapstructure:"db_name"`
+	DbHost     string `mapstructure:"db_host"     validate:"required"`
+	DbPort     int    `mapstructure:"db_port"     validate:"required,min=1,max=65535"`
+	DbName     string `mapstructure:"db_name"     validate:"required"`
 }
 
 // New returns a new otg service
@@ -0,0 +1,84 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryAllow(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := &memory{
+		buckets: make(map[string]*bucket),
+		now:     func() time.Time { return now },
+	}
+	ctx := context.Background()
+
+	// the bucket starts full
+	for i := 0; i < 3; i++ {
+		if ok, _, _ := l.Allow(ctx, "a", 1, 3); !ok {
+			t.Fatalf("request %d should be allowed", i)
+		}
+	}
+
+	ok, wait, _ := l.Allow(ctx, "a", 1, 3)
+	if ok {
+		t.Fatal("request should be limited when the bucket is empty")
+	}
+	if wait != time.Second {
+		t.Fatalf("expected to wait 1s, got %s", wait)
+	}
+
+	// other keys have their own bucket
+	if ok, _, _ := l.Allow(ctx, "b", 1, 3); !ok {
+		t.Fatal("request for another key should be allowed")
+	}
+
+	now = now.Add(time.Second)
+	if ok, _, _ := l.Allow(ctx, "a", 1, 3); !ok {
+		t.Fatal("request should be allowed after the bucket is refilled")
+	}
+	if ok, _, _ := l.Allow(ctx, "a", 1, 3); ok {
+		t.Fatal("only one token should have been refilled")
+	}
+}
+
+func TestMemorySweep(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := &memory{
+		buckets: make(map[string]*bucket),
+		now:     func() time.Time { return now },
+	}
+	ctx := context.Background()
+
+	_, _, _ = l.Allow(ctx, "a", 1, 2)
+	_, _, _ = l.Allow(ctx, "a", 1, 2)
+
+	l.sweep(now.Add(time.Second))
+	if _, ok := l.buckets["a"]; !ok {
+		t.Fatal("bucket not yet full should not be removed")
+	}
+
+	l.sweep(now.Add(2 * time.Second))
+	if _, ok := l.buckets["a"]; ok {
+		t.Fatal("full bucket should be removed")
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package ratelimit
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cs3org/reva/pkg/appctx"
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+)
+
+// Rule limits the requests matching a path prefix and a set of methods.
+type Rule struct {
+	// Path is the prefix of the paths (relative to the service prefix) the rule applies to.
+	Path string `mapstructure:"path"`
+	// Methods the rule applies to. If empty, the rule applies to all methods.
+	Methods []string `mapstructure:"methods"`
+	// Rate is the number of requests per second allowed.
+	Rate float64 `mapstructure:"rate"`
+	// Burst is the maximum number of requests allowed at once.
+	Burst int `mapstructure:"burst"`
+	// Key is what identifies a client: "user" (default, falling back to the ip
+	// for anonymous requests) or "ip".
+	Key string `mapstructure:"key"`
+}
+
+// MiddlewareConfig is the configuration of the rate limiting middleware.
+type MiddlewareConfig struct {
+	StoreConfig `mapstructure:",squash"`
+	Rules       []*Rule `mapstructure:"rules"`
+	// TrustedProxies are the ips or CIDRs of the proxies whose
+	// X-Forwarded-For and X-Real-IP headers are honoured. The headers
+	// of the other peers are ignored, as they can be set by anyone.
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
+}
+
+// Middleware returns an http middleware limiting the requests according
+// to the rules in the given config map. The first matching rule is applied.
+// Requests not matching any rule are not limited.
+func Middleware(m map[string]interface{}) (func(http.Handler) http.Handler, error) {
+	c := &MiddlewareConfig{}
+	if err := mapstructure.Decode(m, c); err != nil {
+		return nil, errors.Wrap(err, "ratelimit: error decoding config")
+	}
+
+	if len(c.Rules) == 0 {
+		return func(h http.Handler) http.Handler { return h }, nil
+	}
+
+	for i, r := range c.Rules {
+		if r.Rate <= 0 {
+			return nil, errors.Errorf("ratelimit: rule %d: rate must be positive", i)
+		}
+		if r.Burst <= 0 {
+			r.Burst = int(math.Max(1, math.Ceil(r.Rate)))
+		}
+		switch r.Key {
+		case "":
+			r.Key = "user"
+		case "user", "ip":
+		default:
+			return nil, errors.Errorf("ratelimit: rule %d: unknown key %q", i, r.Key)
+		}
+	}
+
+	trusted, err := ParseCIDRs(c.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	limiter, err := NewLimiter(&c.StoreConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			i, rule := matchRule(c.Rules, r)
+			if rule == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx := r.Context()
+			key := "rule" + strconv.Itoa(i) + ":" + clientKey(r, rule.Key, trusted)
+			ok, wait, err := limiter.Allow(ctx, key, rule.Rate, rule.Burst)
+			if err != nil {
+				// do not block the users if the limiter is not working
+				appctx.GetLogger(ctx).Error().Err(err).Msg("ratelimit: error checking rate limit")
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+func matchRule(rules []*Rule, r *http.Request) (int, *Rule) {
+	for i, rule := range rules {
+		if !strings.HasPrefix(r.URL.Path, rule.Path) {
+			continue
+		}
+		if len(rule.Methods) == 0 {
+			return i, rule
+		}
+		for _, m := range rule.Methods {
+			if strings.EqualFold(m, r.Method) {
+				return i, rule
+			}
+		}
+	}
+	return 0, nil
+}
+
+func clientKey(r *http.Request, key string, trusted []*net.IPNet) string {
+	if key == "user" {
+		if u, ok := appctx.ContextGetUser(r.Context()); ok && u.Username != "" {
+			return "user:" + u.Username
+		}
+	}
+	return "ip:" + ClientIP(r, trusted)
+}
+
+// ParseCIDRs parses a list of CIDRs. Plain ips are accepted
+// and match only themselves.
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		if !strings.Contains(c, "/") {
+			ip := net.ParseIP(c)
+			if ip == nil {
+				return nil, errors.Errorf("ratelimit: invalid trusted proxy %q", c)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, errors.Wrapf(err, "ratelimit: invalid trusted proxy %q", c)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func isTrusted(ip string, trusted []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range trusted {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the ip of the client that made the request.
+// The X-Forwarded-For and X-Real-IP headers are honoured only if the
+// request comes from one of the trusted proxies: the client is then the
+// last hop of X-Forwarded-For that is not a trusted proxy itself.
+func ClientIP(r *http.Request, trusted []*net.IPNet) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	return ForwardedClientIP(remote, r.Header.Get("X-Forwarded-For"), r.Header.Get("X-Real-IP"), trusted)
+}
+
+// ForwardedClientIP returns the ip of the client behind the remote peer,
+// given the forwarded for and real ip values the peer sent along, e.g. in
+// the grpc metadata. They are honoured as in ClientIP.
+func ForwardedClientIP(remote, forwardedFor, realIP string, trusted []*net.IPNet) string {
+	if !isTrusted(remote, trusted) {
+		return remote
+	}
+
+	if forwardedFor != "" {
+		// the hops on the left can be forged by the client,
+		// so walk the list from the closest one
+		hops := strings.Split(forwardedFor, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := strings.TrimSpace(hops[i])
+			if net.ParseIP(ip) == nil {
+				break
+			}
+			if i == 0 || !isTrusted(ip, trusted) {
+				return ip
+			}
+		}
+	}
+	if ip := strings.TrimSpace(realIP); net.ParseIP(ip) != nil {
+		return ip
+	}
+	return remote
+}
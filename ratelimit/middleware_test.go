@@ -0,0 +1,62 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package ratelimit
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ParseCIDRs([]string{"10.0.0.0/8", "192.168.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		remote string
+		fwd    string
+		realIP string
+		want   string
+	}{
+		{name: "direct", remote: "1.2.3.4:5000", want: "1.2.3.4"},
+		{name: "untrusted peer forging headers", remote: "1.2.3.4:5000", fwd: "5.6.7.8", realIP: "5.6.7.8", want: "1.2.3.4"},
+		{name: "trusted proxy", remote: "10.0.0.1:5000", fwd: "5.6.7.8", want: "5.6.7.8"},
+		{name: "trusted proxy chain", remote: "10.0.0.1:5000", fwd: "5.6.7.8, 192.168.1.1", want: "5.6.7.8"},
+		{name: "client forging the first hop", remote: "10.0.0.1:5000", fwd: "9.9.9.9, 5.6.7.8", want: "5.6.7.8"},
+		{name: "real ip from trusted proxy", remote: "10.0.0.1:5000", realIP: "5.6.7.8", want: "5.6.7.8"},
+		{name: "invalid header", remote: "10.0.0.1:5000", fwd: "garbage", want: "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &http.Request{RemoteAddr: tt.remote, Header: http.Header{}}
+			if tt.fwd != "" {
+				r.Header.Set("X-Forwarded-For", tt.fwd)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if got := ClientIP(r, trusted); got != tt.want {
+				t.Fatalf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
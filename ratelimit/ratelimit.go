@@ -0,0 +1,73 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Package ratelimit implements token bucket rate limiters, either in memory
+// or backed by redis to share the buckets between multiple replicas.
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+)
+
+// Limiter is a token bucket rate limiter.
+type Limiter interface {
+	// Allow takes a token from the bucket identified by key, that is refilled
+	// with rate tokens per second up to burst tokens.
+	// When no token is available, it returns false and the time
+	// to wait before a new token will be available.
+	Allow(ctx context.Context, key string, rate float64, burst int) (bool, time.Duration, error)
+}
+
+// StoreConfig is the configuration of the store of the buckets.
+type StoreConfig struct {
+	// Driver is the store of the buckets. Possible values are "memory" (default) and "redis".
+	Driver        string `mapstructure:"driver"`
+	RedisAddress  string `mapstructure:"redis_address"`
+	RedisUsername string `mapstructure:"redis_username"`
+	RedisPassword string `mapstructure:"redis_password"`
+	// Prefix is prepended to all the keys stored in redis.
+	Prefix string `mapstructure:"prefix"`
+}
+
+// NewLimiter creates a limiter from the given config.
+func NewLimiter(c *StoreConfig) (Limiter, error) {
+	switch c.Driver {
+	case "", "memory":
+		return NewMemory(), nil
+	case "redis":
+		if c.RedisAddress == "" {
+			return nil, errors.New("ratelimit: redis_address is required for the redis driver")
+		}
+		return NewRedis(c.RedisAddress, c.RedisUsername, c.RedisPassword, c.Prefix), nil
+	default:
+		return nil, errors.Errorf("ratelimit: unknown driver %q", c.Driver)
+	}
+}
+
+// NewLimiterFromMap creates a limiter from a config map.
+func NewLimiterFromMap(m map[string]interface{}) (Limiter, error) {
+	c := &StoreConfig{}
+	if err := mapstructure.Decode(m, c); err != nil {
+		return nil, errors.Wrap(err, "ratelimit: error decoding config")
+	}
+	return NewLimiter(c)
+}
@@ -0,0 +1,94 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/cernbox/reva-plugins/redispool"
+	"github.com/gomodule/redigo/redis"
+)
+
+// tokenBucketScript atomically refills and takes a token from the bucket.
+// It returns {allowed, milliseconds to wait}.
+var tokenBucketScript = redis.NewScript(1, `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local data = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(data[1])
+local ts = tonumber(data[2])
+if tokens == nil then
+	tokens = burst
+	ts = now
+end
+
+tokens = math.min(burst, tokens + (now - ts) / 1000 * rate)
+
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) / rate * 1000)
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) / rate * 1000) + 1000)
+return {allowed, wait}
+`)
+
+type redisLimiter struct {
+	pool   *redis.Pool
+	prefix string
+}
+
+// NewRedis returns a limiter storing the buckets in redis,
+// so that they are shared among multiple processes.
+func NewRedis(address, username, password, prefix string) Limiter {
+	if prefix == "" {
+		prefix = "ratelimit:"
+	}
+	return &redisLimiter{
+		pool:   redispool.New(&redispool.Config{Address: address, Username: username, Password: password}),
+		prefix: prefix,
+	}
+}
+
+func (l *redisLimiter) Allow(ctx context.Context, key string, rate float64, burst int) (bool, time.Duration, error) {
+	conn, err := l.pool.GetContext(ctx)
+	if err != nil {
+		return false, 0, err
+	}
+	defer conn.Close()
+
+	now := time.Now().UnixMilli()
+	res, err := redis.Int64s(tokenBucketScript.Do(conn, l.prefix+key, rate, burst, now))
+	if err != nil {
+		return false, 0, err
+	}
+	if len(res) != 2 {
+		return false, 0, redis.ErrNil
+	}
+
+	return res[0] == 1, time.Duration(res[1]) * time.Millisecond, nil
+}
@@ -0,0 +1,126 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Package redispool creates the pools of connections to redis
+// shared by the plugins.
+package redispool
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Config configures a pool of connections to redis.
+type Config struct {
+	Address  string
+	Username string
+	Password string
+	// TLS enables TLS for the connections to redis and to the sentinels.
+	TLS           bool
+	TLSSkipVerify bool
+	// SentinelAddresses are the addresses of the redis sentinels. If set,
+	// the address of the master is asked to the sentinels on each dial.
+	SentinelAddresses []string
+	MasterName        string
+	// MaxIdle, MaxActive and IdleTimeout (in seconds) size the pool,
+	// they default to 50, 1000 and 240.
+	MaxIdle     int
+	MaxActive   int
+	IdleTimeout int
+}
+
+// New returns a pool of connections to the redis server.
+// The connections are checked with a PING when taken from the pool.
+func New(c *Config) *redis.Pool {
+	maxIdle, maxActive, idleTimeout := c.MaxIdle, c.MaxActive, c.IdleTimeout
+	if maxIdle == 0 {
+		maxIdle = 50
+	}
+	if maxActive == 0 {
+		maxActive = 1000
+	}
+	if idleTimeout == 0 {
+		idleTimeout = 240
+	}
+
+	return &redis.Pool{
+		MaxIdle:     maxIdle,
+		MaxActive:   maxActive,
+		IdleTimeout: time.Duration(idleTimeout) * time.Second,
+
+		Dial: func() (redis.Conn, error) {
+			var opts []redis.DialOption
+			if c.Username != "" {
+				opts = append(opts, redis.DialUsername(c.Username))
+			}
+			if c.Password != "" {
+				opts = append(opts, redis.DialPassword(c.Password))
+			}
+			if c.TLS {
+				opts = append(opts, redis.DialUseTLS(true), redis.DialTLSSkipVerify(c.TLSSkipVerify))
+			}
+
+			address := c.Address
+			if len(c.SentinelAddresses) > 0 {
+				var err error
+				if address, err = sentinelMaster(c); err != nil {
+					return nil, err
+				}
+			}
+			return redis.Dial("tcp", address, opts...)
+		},
+
+		TestOnBorrow: func(c redis.Conn, t time.Time) error {
+			_, err := c.Do("PING")
+			return err
+		},
+	}
+}
+
+// sentinelMaster asks the sentinels the address of the current master.
+func sentinelMaster(c *Config) (string, error) {
+	var errs []error
+	for _, s := range c.SentinelAddresses {
+		var opts []redis.DialOption
+		opts = append(opts, redis.DialConnectTimeout(time.Second))
+		if c.TLS {
+			opts = append(opts, redis.DialUseTLS(true), redis.DialTLSSkipVerify(c.TLSSkipVerify))
+		}
+		conn, err := redis.Dial("tcp", s, opts...)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		addr, err := redis.Strings(conn.Do("SENTINEL", "get-master-addr-by-name", c.MasterName))
+		conn.Close()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if len(addr) != 2 {
+			errs = append(errs, fmt.Errorf("redis: unexpected master address %v from sentinel %s", addr, s))
+			continue
+		}
+		return net.JoinHostPort(addr[0], addr[1]), nil
+	}
+	return "", fmt.Errorf("redis: no sentinel could resolve the master %s: %w", c.MasterName, errors.Join(errs...))
+}
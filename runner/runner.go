@@ -0,0 +1,167 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Package runner manages the background tasks of the plugins, so that they
+// are stopped when the plugin is closed and restarted when they panic.
+package runner
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// RestartPolicy defines when a task is restarted.
+type RestartPolicy int
+
+const (
+	// RestartOnPanic restarts the task only if it panicked.
+	RestartOnPanic RestartPolicy = iota
+	// RestartAlways restarts the task whenever it returns, unless the runner is closed.
+	RestartAlways
+	// RestartNever never restarts the task.
+	RestartNever
+)
+
+const (
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+)
+
+// Task is a background task. It must return when the context is cancelled.
+type Task func(ctx context.Context) error
+
+// Runner runs background tasks.
+type Runner struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	log    *zerolog.Logger
+}
+
+// New creates a runner. The tasks are stopped when the parent context
+// is cancelled or the runner is closed.
+func New(ctx context.Context) *Runner {
+	ctx, cancel := context.WithCancel(ctx)
+	return &Runner{
+		ctx:    ctx,
+		cancel: cancel,
+		log:    &log.Logger,
+	}
+}
+
+// WithLogger sets the logger used to report the failures of the tasks.
+func (r *Runner) WithLogger(l *zerolog.Logger) *Runner {
+	r.log = l
+	return r
+}
+
+// Go runs the task in background, restarting it according to the policy.
+func (r *Runner) Go(name string, policy RestartPolicy, task Task) {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		backoff := minBackoff
+		for {
+			start := time.Now()
+			panicked, err := r.run(r.ctx, task)
+
+			if r.ctx.Err() != nil {
+				return
+			}
+
+			switch {
+			case panicked:
+				r.log.Error().Err(err).Str("task", name).Msg("runner: task panicked")
+			case err != nil:
+				r.log.Error().Err(err).Str("task", name).Msg("runner: task failed")
+			}
+
+			if policy == RestartNever || (policy == RestartOnPanic && !panicked) {
+				return
+			}
+
+			// reset the backoff if the task run for a while
+			if time.Since(start) > maxBackoff {
+				backoff = minBackoff
+			}
+
+			r.log.Info().Str("task", name).Dur("backoff", backoff).Msg("runner: restarting task")
+			select {
+			case <-r.ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(2*backoff, maxBackoff)
+		}
+	}()
+}
+
+func (r *Runner) run(ctx context.Context, task Task) (panicked bool, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			panicked = true
+			err = fmt.Errorf("%v\n%s", p, debug.Stack())
+		}
+	}()
+	return false, task(ctx)
+}
+
+// Every runs f every interval, and immediately if now is true.
+// A failed or panicking execution does not stop the following ones.
+func (r *Runner) Every(name string, interval time.Duration, now bool, f Task) {
+	r.Go(name, RestartOnPanic, func(ctx context.Context) error {
+		if now {
+			r.runOnce(ctx, name, f)
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+				r.runOnce(ctx, name, f)
+			}
+		}
+	})
+}
+
+func (r *Runner) runOnce(ctx context.Context, name string, f Task) {
+	panicked, err := r.run(ctx, f)
+	switch {
+	case panicked:
+		r.log.Error().Err(err).Str("task", name).Msg("runner: periodic task panicked")
+	case err != nil && ctx.Err() == nil:
+		r.log.Error().Err(err).Str("task", name).Msg("runner: periodic task failed")
+	}
+}
+
+// Close stops all the tasks and waits for them to return.
+func (r *Runner) Close() error {
+	r.cancel()
+	r.wg.Wait()
+	return nil
+}
@@ -0,0 +1,169 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"time"
+
+	sharesql "github.com/cernbox/reva-plugins/share/sql"
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	collaboration "github.com/cs3org/go-cs3apis/cs3/sharing/collaboration/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/cs3org/reva/pkg/appctx"
+)
+
+// requireAdmin allows the request only to the members of the admin group.
+func (s *svc) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := appctx.ContextGetUser(r.Context())
+		if !ok {
+			writeError(w, http.StatusUnauthorized, "user not authenticated")
+			return
+		}
+		if s.c.AdminGroup == "" || !slices.Contains(user.Groups, s.c.AdminGroup) {
+			writeError(w, http.StatusForbidden, "user is not an administrator")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type resourceIn struct {
+	StorageID string `json:"storage_id"`
+	OpaqueID  string `json:"opaque_id"`
+}
+
+// recheckOrphans checks again the existence of the resource in the body,
+// or of all the shared resources if the body is empty, and updates the
+// orphan flag of their shares.
+func (s *svc) recheckOrphans(w http.ResponseWriter, r *http.Request) {
+	var in resourceIn
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid body")
+			return
+		}
+	}
+	var id *provider.ResourceId
+	if in.StorageID != "" || in.OpaqueID != "" {
+		id = &provider.ResourceId{StorageId: in.StorageID, OpaqueId: in.OpaqueID}
+	}
+
+	changed, err := s.mgr.RecheckOrphans(r.Context(), id)
+	if err != nil {
+		writeManagerError(w, r, err)
+		return
+	}
+	if changed == nil {
+		changed = []string{}
+	}
+	writeJSON(w, map[string]any{"changed": changed})
+}
+
+type transferIn struct {
+	// From and To are the usernames of the users.
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Resource optionally restricts the transfer to the shares of a resource.
+	Resource *resourceIn `json:"resource,omitempty"`
+}
+
+// transferShares moves the shares owned or created by a user to another one.
+func (s *svc) transferShares(w http.ResponseWriter, r *http.Request) {
+	var in transferIn
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+	if in.From == "" || in.To == "" {
+		writeError(w, http.StatusBadRequest, "from and to are required")
+		return
+	}
+	var filters []*collaboration.Filter
+	if in.Resource != nil {
+		filters = append(filters, &collaboration.Filter{
+			Type: collaboration.Filter_TYPE_RESOURCE_ID,
+			Term: &collaboration.Filter_ResourceId{ResourceId: &provider.ResourceId{StorageId: in.Resource.StorageID, OpaqueId: in.Resource.OpaqueID}},
+		})
+	}
+
+	ids, err := s.mgr.TransferShares(r.Context(), &userpb.UserId{OpaqueId: in.From}, &userpb.UserId{OpaqueId: in.To}, filters)
+	if err != nil {
+		writeManagerError(w, r, err)
+		return
+	}
+	if ids == nil {
+		ids = []string{}
+	}
+	writeJSON(w, map[string]any{"transferred": ids})
+}
+
+// restoreShare restores a share deleted less than the restore window ago,
+// with its grant on the resource.
+func (s *svc) restoreShare(w http.ResponseWriter, r *http.Request) {
+	share, err := s.mgr.RestoreShare(r.Context(), shareID(r), s.granter)
+	if err != nil {
+		writeManagerError(w, r, err)
+		return
+	}
+	writeJSON(w, &shareOut{ID: share.Id.OpaqueId})
+}
+
+type revokeLinksIn struct {
+	// Owner is the username of the user owning or creating the links.
+	Owner    string      `json:"owner"`
+	Resource *resourceIn `json:"resource,omitempty"`
+	// CreatedBefore selects the links created before the time, in RFC3339 format.
+	CreatedBefore string `json:"created_before"`
+}
+
+// revokeLinks revokes at once the links selected by the body,
+// e.g. of a compromised account or of a blocked project.
+func (s *svc) revokeLinks(w http.ResponseWriter, r *http.Request) {
+	var in revokeLinksIn
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+	f := &sharesql.RevokeFilter{}
+	if in.Owner != "" {
+		f.Owner = &userpb.UserId{OpaqueId: in.Owner}
+	}
+	if in.Resource != nil {
+		f.ResourceID = &provider.ResourceId{StorageId: in.Resource.StorageID, OpaqueId: in.Resource.OpaqueID}
+	}
+	if in.CreatedBefore != "" {
+		t, err := time.Parse(time.RFC3339, in.CreatedBefore)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid created_before")
+			return
+		}
+		f.CreatedBefore = t
+	}
+
+	n, err := s.mgr.RevokePublicShares(r.Context(), f)
+	if err != nil {
+		writeManagerError(w, r, err)
+		return
+	}
+	writeJSON(w, map[string]any{"revoked": n})
+}
@@ -0,0 +1,176 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Package api is an http service exposing the operations of the sql share
+// manager that the share and the public share providers do not support,
+// e.g. setting the expiration of a share, and the admin operations on
+// the shares.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/cernbox/reva-plugins/share/grants"
+	sharesql "github.com/cernbox/reva-plugins/share/sql"
+	collaboration "github.com/cs3org/go-cs3apis/cs3/sharing/collaboration/v1beta1"
+	"github.com/cs3org/reva"
+	"github.com/cs3org/reva/pkg/appctx"
+	"github.com/cs3org/reva/pkg/errtypes"
+	"github.com/cs3org/reva/pkg/rhttp/global"
+	"github.com/cs3org/reva/pkg/sharedconf"
+	"github.com/cs3org/reva/pkg/utils/cfg"
+	"github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	reva.RegisterPlugin(svc{})
+}
+
+type config struct {
+	Prefix     string `mapstructure:"prefix"`
+	GatewaySvc string `mapstructure:"gatewaysvc"`
+	// StorageRegistrySvc is the storage registry resolving the storage
+	// provider where the grants are added. Defaults to the gateway.
+	StorageRegistrySvc string `mapstructure:"storageregistrysvc"`
+	// AdminGroup is the group whose members can access the admin
+	// endpoints. If empty, the admin endpoints are disabled.
+	AdminGroup string `mapstructure:"admin_group"`
+	// Driver is the configuration of the sql share manager,
+	// the same as the one of the share provider.
+	Driver map[string]interface{} `mapstructure:"driver" validate:"required"`
+}
+
+func (c *config) ApplyDefaults() {
+	if c.Prefix == "" {
+		c.Prefix = "shareapi"
+	}
+	c.GatewaySvc = sharedconf.GetGatewaySVC(c.GatewaySvc)
+	if c.StorageRegistrySvc == "" {
+		c.StorageRegistrySvc = c.GatewaySvc
+	}
+}
+
+type svc struct {
+	c       *config
+	router  *chi.Mux
+	mgr     sharesql.ExtendedManager
+	granter *grants.Granter
+}
+
+func (svc) RevaPlugin() reva.PluginInfo {
+	return reva.PluginInfo{
+		ID:  "http.services.shareapi",
+		New: New,
+	}
+}
+
+var _ global.NewService = New
+
+// New returns the http service of the share manager operations.
+func New(ctx context.Context, m map[string]interface{}) (global.Service, error) {
+	var c config
+	if err := cfg.Decode(m, &c); err != nil {
+		return nil, err
+	}
+
+	mgr, err := sharesql.NewExtendedManager(ctx, c.Driver)
+	if err != nil {
+		return nil, errors.Wrap(err, "shareapi: error creating the share manager")
+	}
+
+	s := &svc{c: &c, router: chi.NewRouter(), mgr: mgr, granter: grants.New(c.StorageRegistrySvc)}
+	s.initRouter()
+	return s, nil
+}
+
+func (s *svc) initRouter() {
+	s.router.Put("/shares/{id}/expiration", s.setExpiration)
+	s.router.Get("/shares/{id}/history", s.getHistory)
+	s.router.Get("/shares/{id}/provenance", s.getProvenance)
+	s.router.Get("/shares/{id}/reshares", s.getReshares)
+	s.router.Get("/received/{id}/notifications", s.getNotificationPrefs)
+	s.router.Put("/received/{id}/notifications", s.setNotificationPrefs)
+	s.router.Put("/received/{id}/synced", s.setSynced)
+	s.router.Post("/links", s.createLink)
+	s.router.Post("/links/{token}/access", s.consumeAccess)
+	s.router.Post("/links/{token}/upload", s.getUploadTarget)
+	s.router.Get("/links/{id}/stats", s.getLinkStats)
+	s.router.Get("/links/expiring", s.listExpiringLinks)
+	s.router.Put("/links/{id}/networks", s.setLinkNetworks)
+
+	s.router.Route("/admin", func(r chi.Router) {
+		r.Use(s.requireAdmin)
+		r.Post("/orphans/recheck", s.recheckOrphans)
+		r.Post("/transfer", s.transferShares)
+		r.Post("/shares/{id}/restore", s.restoreShare)
+		r.Post("/links/revoke", s.revokeLinks)
+	})
+}
+
+func (s *svc) Handler() http.Handler {
+	return s.router
+}
+
+func (s *svc) Prefix() string {
+	return s.c.Prefix
+}
+
+func (s *svc) Unprotected() []string {
+	return nil
+}
+
+func (s *svc) Close() error {
+	return s.mgr.Close()
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+// writeManagerError writes the error returned by the share manager
+// with the matching status code.
+func writeManagerError(w http.ResponseWriter, r *http.Request, err error) {
+	switch errors.Cause(err).(type) {
+	case errtypes.NotFound:
+		writeError(w, http.StatusNotFound, "share not found")
+	case errtypes.BadRequest, errtypes.NotSupported:
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errtypes.AlreadyExists:
+		writeError(w, http.StatusConflict, err.Error())
+	case errtypes.PermissionDenied:
+		writeError(w, http.StatusForbidden, err.Error())
+	default:
+		appctx.GetLogger(r.Context()).Error().Err(err).Str("path", r.URL.Path).Msg("shareapi: error from the share manager")
+		writeError(w, http.StatusInternalServerError, "internal error")
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// shareID returns the id of the share in the path.
+func shareID(r *http.Request) *collaboration.ShareId {
+	return &collaboration.ShareId{OpaqueId: chi.URLParam(r, "id")}
+}
@@ -0,0 +1,276 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	sharesql "github.com/cernbox/reva-plugins/share/sql"
+	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	link "github.com/cs3org/go-cs3apis/cs3/sharing/link/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	typespb "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
+	"github.com/cs3org/reva/pkg/appctx"
+	conversions "github.com/cs3org/reva/pkg/cbox/utils"
+	"github.com/cs3org/reva/pkg/rgrpc/todo/pool"
+	"github.com/go-chi/chi/v5"
+)
+
+type linkIn struct {
+	Path                         string `json:"path"`
+	Permissions                  int    `json:"permissions"`
+	Password                     string `json:"password"`
+	Expiration                   string `json:"expiration"`
+	Description                  string `json:"description"`
+	Internal                     bool   `json:"internal"`
+	NotifyUploads                bool   `json:"notify_uploads"`
+	NotifyUploadsExtraRecipients string `json:"notify_uploads_extra_recipients"`
+
+	MaxAccesses     int      `json:"max_accesses"`
+	NotBefore       string   `json:"not_before"`
+	Token           string   `json:"token"`
+	AllowedNetworks []string `json:"allowed_networks"`
+	FileRequest     bool     `json:"file_request"`
+	UploadTarget    string   `json:"upload_target"`
+}
+
+type linkOut struct {
+	ID    string `json:"id"`
+	Token string `json:"token"`
+}
+
+// parseTime parses an optional time in RFC3339 format.
+func parseTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// createLink creates a public link to the resource at the given path,
+// with the options the public share provider does not support.
+func (s *svc) createLink(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, ok := appctx.ContextGetUser(ctx)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not authenticated")
+		return
+	}
+
+	var in linkIn
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+	if in.Path == "" {
+		writeError(w, http.StatusBadRequest, "path is required")
+		return
+	}
+	expiration, err := parseTime(in.Expiration)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid expiration")
+		return
+	}
+	notBefore, err := parseTime(in.NotBefore)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid not_before")
+		return
+	}
+	if in.MaxAccesses < 0 {
+		writeError(w, http.StatusBadRequest, "invalid max_accesses")
+		return
+	}
+
+	client, err := pool.GetGatewayServiceClient(pool.Endpoint(s.c.GatewaySvc))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "error getting the gateway client")
+		return
+	}
+
+	// the resource is stat-ed on behalf of the user, so that only
+	// the users allowed to share it can create the links
+	stat, err := client.Stat(ctx, &provider.StatRequest{Ref: &provider.Reference{Path: in.Path}})
+	switch {
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, "error stating the resource")
+		return
+	case stat.Status.Code == rpc.Code_CODE_NOT_FOUND:
+		writeError(w, http.StatusNotFound, "resource not found")
+		return
+	case stat.Status.Code != rpc.Code_CODE_OK:
+		writeError(w, http.StatusInternalServerError, stat.Status.Message)
+		return
+	}
+	md := stat.Info
+	if md.PermissionSet == nil || !md.PermissionSet.AddGrant {
+		writeError(w, http.StatusForbidden, "the resource cannot be shared by the user")
+		return
+	}
+
+	g := &link.Grant{
+		Permissions: &link.PublicSharePermissions{
+			Permissions: conversions.IntTosharePerm(in.Permissions, conversions.ResourceTypeToItem(md.Type)),
+		},
+		Password: in.Password,
+	}
+	if !expiration.IsZero() {
+		g.Expiration = &typespb.Timestamp{Seconds: uint64(expiration.Unix())}
+	}
+	opts := &sharesql.LinkOptions{
+		MaxAccesses:     in.MaxAccesses,
+		NotBefore:       notBefore,
+		Token:           in.Token,
+		AllowedNetworks: in.AllowedNetworks,
+		FileRequest:     in.FileRequest,
+		UploadTarget:    in.UploadTarget,
+	}
+
+	l, err := s.mgr.CreatePublicShareWithOptions(ctx, user, md, g, in.Description, in.Internal, in.NotifyUploads, in.NotifyUploadsExtraRecipients, opts)
+	if err != nil {
+		writeManagerError(w, r, err)
+		return
+	}
+	writeJSON(w, &linkOut{ID: l.Id.OpaqueId, Token: l.Token})
+}
+
+// consumeAccess consumes one of the accesses left to a link, called
+// when the link is opened or one of its files downloaded.
+func (s *svc) consumeAccess(w http.ResponseWriter, r *http.Request) {
+	if err := s.mgr.ConsumePublicShareAccess(r.Context(), chi.URLParam(r, "token")); err != nil {
+		writeManagerError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// linkRef returns the reference to the link with the id in the path.
+func linkRef(r *http.Request) *link.PublicShareReference {
+	return &link.PublicShareReference{Spec: &link.PublicShareReference_Id{Id: &link.PublicShareId{OpaqueId: chi.URLParam(r, "id")}}}
+}
+
+// getLinkStats returns the statistics of the accesses to a link
+// owned or created by the user.
+func (s *svc) getLinkStats(w http.ResponseWriter, r *http.Request) {
+	user, ok := appctx.ContextGetUser(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not authenticated")
+		return
+	}
+	stats, err := s.mgr.GetPublicShareStats(r.Context(), user, linkRef(r))
+	if err != nil {
+		writeManagerError(w, r, err)
+		return
+	}
+	writeJSON(w, stats)
+}
+
+type expiringLinkOut struct {
+	ID         string `json:"id"`
+	Token      string `json:"token"`
+	Name       string `json:"name"`
+	Expiration string `json:"expiration"`
+}
+
+// listExpiringLinks returns the links of the user expiring within the
+// days in the query, 7 by default.
+func (s *svc) listExpiringLinks(w http.ResponseWriter, r *http.Request) {
+	user, ok := appctx.ContextGetUser(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not authenticated")
+		return
+	}
+	days := 7
+	if v := r.URL.Query().Get("days"); v != "" {
+		var err error
+		if days, err = strconv.Atoi(v); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid days")
+			return
+		}
+	}
+
+	links, err := s.mgr.ListExpiringPublicShares(r.Context(), user.Id, days)
+	if err != nil {
+		writeManagerError(w, r, err)
+		return
+	}
+	out := make([]*expiringLinkOut, 0, len(links))
+	for _, l := range links {
+		out = append(out, &expiringLinkOut{
+			ID:         l.Id.OpaqueId,
+			Token:      l.Token,
+			Name:       l.DisplayName,
+			Expiration: time.Unix(int64(l.Expiration.GetSeconds()), 0).UTC().Format(time.RFC3339),
+		})
+	}
+	writeJSON(w, map[string]any{"links": out})
+}
+
+type networksIn struct {
+	// Networks are the networks allowed to access the link, in CIDR
+	// notation. The restriction is lifted if empty.
+	Networks []string `json:"networks"`
+}
+
+// setLinkNetworks restricts the accesses to a link owned or
+// created by the user to the given networks.
+func (s *svc) setLinkNetworks(w http.ResponseWriter, r *http.Request) {
+	user, ok := appctx.ContextGetUser(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "user not authenticated")
+		return
+	}
+	var in networksIn
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+	if err := s.mgr.SetPublicShareAllowedNetworks(r.Context(), user, linkRef(r), in.Networks); err != nil {
+		writeManagerError(w, r, err)
+		return
+	}
+	if in.Networks == nil {
+		in.Networks = []string{}
+	}
+	writeJSON(w, &in)
+}
+
+type uploadTargetIn struct {
+	// Uploader is the name given by the anonymous user uploading.
+	Uploader string `json:"uploader"`
+}
+
+// getUploadTarget returns the subfolder of the file request where the
+// uploader stores the files, notifying the owner of the link of the upload.
+func (s *svc) getUploadTarget(w http.ResponseWriter, r *http.Request) {
+	var in uploadTargetIn
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+	target, err := s.mgr.FileRequestTarget(r.Context(), chi.URLParam(r, "token"), in.Uploader)
+	if err != nil {
+		writeManagerError(w, r, err)
+		return
+	}
+	writeJSON(w, map[string]string{"target": target})
+}
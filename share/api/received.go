@@ -0,0 +1,83 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	sharesql "github.com/cernbox/reva-plugins/share/sql"
+)
+
+type notificationPrefs struct {
+	NotifyUploads                bool   `json:"notify_uploads"`
+	NotifyUploadsExtraRecipients string `json:"notify_uploads_extra_recipients"`
+}
+
+// getNotificationPrefs returns the notification preferences of the
+// user for a received share.
+func (s *svc) getNotificationPrefs(w http.ResponseWriter, r *http.Request) {
+	prefs, err := s.mgr.GetNotificationPrefs(r.Context(), shareID(r))
+	if err != nil {
+		writeManagerError(w, r, err)
+		return
+	}
+	writeJSON(w, &notificationPrefs{
+		NotifyUploads:                prefs.NotifyUploads,
+		NotifyUploadsExtraRecipients: prefs.NotifyUploadsExtraRecipients,
+	})
+}
+
+// setNotificationPrefs sets the notification preferences of the
+// user for a received share.
+func (s *svc) setNotificationPrefs(w http.ResponseWriter, r *http.Request) {
+	var in notificationPrefs
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+	prefs := &sharesql.NotificationPrefs{
+		NotifyUploads:                in.NotifyUploads,
+		NotifyUploadsExtraRecipients: in.NotifyUploadsExtraRecipients,
+	}
+	if err := s.mgr.SetNotificationPrefs(r.Context(), shareID(r), prefs); err != nil {
+		writeManagerError(w, r, err)
+		return
+	}
+	writeJSON(w, &in)
+}
+
+type syncedIn struct {
+	Synced bool `json:"synced"`
+}
+
+// setSynced sets whether the user syncs a received share
+// with the desktop client.
+func (s *svc) setSynced(w http.ResponseWriter, r *http.Request) {
+	var in syncedIn
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+	if err := s.mgr.SetSynced(r.Context(), shareID(r), in.Synced); err != nil {
+		writeManagerError(w, r, err)
+		return
+	}
+	writeJSON(w, &in)
+}
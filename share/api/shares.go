@@ -0,0 +1,142 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	collaboration "github.com/cs3org/go-cs3apis/cs3/sharing/collaboration/v1beta1"
+	typespb "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
+	conversions "github.com/cs3org/reva/pkg/cbox/utils"
+)
+
+type expirationIn struct {
+	// Expiration is the new expiration of the share in RFC3339
+	// format, empty to make the share permanent.
+	Expiration string `json:"expiration"`
+}
+
+type shareOut struct {
+	ID         string `json:"id"`
+	Expiration string `json:"expiration,omitempty"`
+}
+
+// setExpiration sets the expiration of a share of the user.
+func (s *svc) setExpiration(w http.ResponseWriter, r *http.Request) {
+	var in expirationIn
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+	var expiration *typespb.Timestamp
+	if in.Expiration != "" {
+		t, err := time.Parse(time.RFC3339, in.Expiration)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid expiration")
+			return
+		}
+		expiration = &typespb.Timestamp{Seconds: uint64(t.Unix())}
+	}
+
+	ref := &collaboration.ShareReference{Spec: &collaboration.ShareReference_Id{Id: shareID(r)}}
+	share, err := s.mgr.UpdateShareExpiration(r.Context(), ref, expiration)
+	if err != nil {
+		writeManagerError(w, r, err)
+		return
+	}
+	out := &shareOut{ID: share.Id.OpaqueId}
+	if share.Expiration != nil {
+		out.Expiration = time.Unix(int64(share.Expiration.Seconds), 0).UTC().Format(time.RFC3339)
+	}
+	writeJSON(w, out)
+}
+
+type historyEntryOut struct {
+	Action         string    `json:"action"`
+	Actor          string    `json:"actor"`
+	Owner          string    `json:"owner"`
+	Time           time.Time `json:"time"`
+	OldPermissions int       `json:"old_permissions"`
+	NewPermissions int       `json:"new_permissions"`
+}
+
+// getHistory returns the changes of a share, oldest first.
+func (s *svc) getHistory(w http.ResponseWriter, r *http.Request) {
+	entries, err := s.mgr.ListShareHistory(r.Context(), shareID(r))
+	if err != nil {
+		writeManagerError(w, r, err)
+		return
+	}
+	out := make([]*historyEntryOut, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, &historyEntryOut{
+			Action:         e.Action,
+			Actor:          e.Actor,
+			Owner:          e.Owner,
+			Time:           e.Time,
+			OldPermissions: e.OldPermissions,
+			NewPermissions: e.NewPermissions,
+		})
+	}
+	writeJSON(w, map[string]any{"history": out})
+}
+
+// getProvenance returns the ids of the shares the share reshares,
+// from its direct parent up to the share created by the owner.
+func (s *svc) getProvenance(w http.ResponseWriter, r *http.Request) {
+	chain, err := s.mgr.GetShareProvenance(r.Context(), shareID(r))
+	if err != nil {
+		writeManagerError(w, r, err)
+		return
+	}
+	ids := make([]string, 0, len(chain))
+	for _, id := range chain {
+		ids = append(ids, id.OpaqueId)
+	}
+	writeJSON(w, map[string]any{"provenance": ids})
+}
+
+type reshareOut struct {
+	ID          string `json:"id"`
+	Creator     string `json:"creator"`
+	Grantee     string `json:"grantee"`
+	Permissions int    `json:"permissions"`
+}
+
+// getReshares returns the shares resharing the share.
+func (s *svc) getReshares(w http.ResponseWriter, r *http.Request) {
+	shares, err := s.mgr.ListReshares(r.Context(), shareID(r))
+	if err != nil {
+		writeManagerError(w, r, err)
+		return
+	}
+	out := make([]*reshareOut, 0, len(shares))
+	for _, sh := range shares {
+		_, grantee := conversions.FormatGrantee(sh.Grantee)
+		out = append(out, &reshareOut{
+			ID:          sh.Id.OpaqueId,
+			Creator:     sh.Creator.GetOpaqueId(),
+			Grantee:     grantee,
+			Permissions: conversions.SharePermToInt(sh.Permissions.GetPermissions()),
+		})
+	}
+	writeJSON(w, map[string]any{"reshares": out})
+}
@@ -0,0 +1,281 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Package batch is an http service creating the shares of a resource
+// with many grantees at once, in a single transaction of the sql
+// share manager.
+package batch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cernbox/reva-plugins/share/grants"
+	sharesql "github.com/cernbox/reva-plugins/share/sql"
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	grouppb "github.com/cs3org/go-cs3apis/cs3/identity/group/v1beta1"
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	collaboration "github.com/cs3org/go-cs3apis/cs3/sharing/collaboration/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	types "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
+	"github.com/cs3org/reva"
+	"github.com/cs3org/reva/pkg/appctx"
+	conversions "github.com/cs3org/reva/pkg/cbox/utils"
+	"github.com/cs3org/reva/pkg/rgrpc/todo/pool"
+	"github.com/cs3org/reva/pkg/rhttp/global"
+	"github.com/cs3org/reva/pkg/sharedconf"
+	"github.com/cs3org/reva/pkg/utils/cfg"
+	"github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	reva.RegisterPlugin(svc{})
+}
+
+type config struct {
+	Prefix     string `mapstructure:"prefix"`
+	GatewaySvc string `mapstructure:"gatewaysvc"`
+	// StorageRegistrySvc is the storage registry resolving the storage
+	// provider where the grants are added. Defaults to the gateway.
+	StorageRegistrySvc string `mapstructure:"storageregistrysvc"`
+	// MaxGrants is the maximum number of grantees of a batch.
+	MaxGrants int `mapstructure:"max_grants" validate:"min=0"`
+	// Driver is the configuration of the sql share manager,
+	// the same as the one of the share provider.
+	Driver map[string]interface{} `mapstructure:"driver" validate:"required"`
+}
+
+func (c *config) ApplyDefaults() {
+	if c.Prefix == "" {
+		c.Prefix = "sharebatch"
+	}
+	if c.MaxGrants == 0 {
+		c.MaxGrants = 200
+	}
+	c.GatewaySvc = sharedconf.GetGatewaySVC(c.GatewaySvc)
+	if c.StorageRegistrySvc == "" {
+		c.StorageRegistrySvc = c.GatewaySvc
+	}
+}
+
+type svc struct {
+	c       *config
+	router  *chi.Mux
+	mgr     sharesql.BatchManager
+	granter *grants.Granter
+}
+
+func (svc) RevaPlugin() reva.PluginInfo {
+	return reva.PluginInfo{
+		ID:  "http.services.sharebatch",
+		New: New,
+	}
+}
+
+// New returns the http service creating shares in batches.
+func New(ctx context.Context, m map[string]interface{}) (global.Service, error) {
+	var c config
+	if err := cfg.Decode(m, &c); err != nil {
+		return nil, err
+	}
+
+	mgr, err := sharesql.NewBatchManager(ctx, c.Driver)
+	if err != nil {
+		return nil, errors.Wrap(err, "sharebatch: error creating the share manager")
+	}
+
+	s := &svc{c: &c, router: chi.NewRouter(), mgr: mgr, granter: grants.New(c.StorageRegistrySvc)}
+	s.router.Post("/", s.createShares)
+	return s, nil
+}
+
+func (s *svc) Handler() http.Handler {
+	return s.router
+}
+
+func (s *svc) Prefix() string {
+	return s.c.Prefix
+}
+
+func (s *svc) Unprotected() []string {
+	return nil
+}
+
+func (s *svc) Close() error {
+	return s.mgr.Close()
+}
+
+type grantIn struct {
+	// Type is the type of the grantee, "user" or "group".
+	Type string `json:"type"`
+	// ID is the username of the user or the name of the group.
+	ID string `json:"id"`
+	// Permissions are the share permissions in the ocs format,
+	// e.g. 1 for viewers and 15 for editors.
+	Permissions int `json:"permissions"`
+	// Expiration is the optional expiration of the share, in RFC3339 format.
+	Expiration string `json:"expiration,omitempty"`
+}
+
+type batchIn struct {
+	Path   string     `json:"path"`
+	Grants []*grantIn `json:"grants"`
+}
+
+type resultOut struct {
+	Type    string `json:"type"`
+	ID      string `json:"id"`
+	ShareID string `json:"share_id,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+// createShares shares the resource at the given path with all the
+// grantees. The shares that could not be created are reported in the
+// results with the reason, and do not fail the whole batch.
+func (s *svc) createShares(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := appctx.GetLogger(ctx)
+
+	var in batchIn
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+	if in.Path == "" || len(in.Grants) == 0 {
+		writeError(w, http.StatusBadRequest, "path and grants are required")
+		return
+	}
+	if len(in.Grants) > s.c.MaxGrants {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("at most %d grants are allowed", s.c.MaxGrants))
+		return
+	}
+
+	client, err := pool.GetGatewayServiceClient(pool.Endpoint(s.c.GatewaySvc))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "error getting the gateway client")
+		return
+	}
+
+	// the resource is stat-ed on behalf of the user, so that only
+	// the users allowed to share it can create the shares
+	stat, err := client.Stat(ctx, &provider.StatRequest{Ref: &provider.Reference{Path: in.Path}})
+	switch {
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, "error stating the resource")
+		return
+	case stat.Status.Code == rpc.Code_CODE_NOT_FOUND:
+		writeError(w, http.StatusNotFound, "resource not found")
+		return
+	case stat.Status.Code != rpc.Code_CODE_OK:
+		writeError(w, http.StatusInternalServerError, stat.Status.Message)
+		return
+	}
+	md := stat.Info
+	if md.PermissionSet == nil || !md.PermissionSet.AddGrant {
+		writeError(w, http.StatusForbidden, "the resource cannot be shared by the user")
+		return
+	}
+
+	results := make([]*resultOut, len(in.Grants))
+	var grants []*collaboration.ShareGrant
+	var indexes []int
+	for i, g := range in.Grants {
+		results[i] = &resultOut{Type: g.Type, ID: g.ID}
+		grant, err := s.toShareGrant(ctx, client, md, g)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		grants = append(grants, grant)
+		indexes = append(indexes, i)
+	}
+
+	if len(grants) != 0 {
+		created, err := s.mgr.BatchShare(ctx, md, grants, s.granter)
+		if err != nil {
+			log.Error().Err(err).Str("path", in.Path).Msg("sharebatch: error creating shares")
+			writeError(w, http.StatusInternalServerError, "error creating the shares")
+			return
+		}
+		for j, res := range created {
+			out := results[indexes[j]]
+			if res.Err != nil {
+				out.Error = res.Err.Error()
+				continue
+			}
+			out.ShareID = res.Share.Id.OpaqueId
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"results": results})
+}
+
+// toShareGrant resolves the grantee and converts the grant in the format
+// of the share manager.
+func (s *svc) toShareGrant(ctx context.Context, client gateway.GatewayAPIClient, md *provider.ResourceInfo, g *grantIn) (*collaboration.ShareGrant, error) {
+	if g.Permissions <= 0 {
+		return nil, errors.New("invalid permissions")
+	}
+
+	grantee := &provider.Grantee{}
+	switch g.Type {
+	case "user":
+		res, err := client.GetUserByClaim(ctx, &userpb.GetUserByClaimRequest{Claim: "username", Value: g.ID, SkipFetchingUserGroups: true})
+		if err != nil || res.Status.Code != rpc.Code_CODE_OK {
+			return nil, errors.New("user not found")
+		}
+		grantee.Type = provider.GranteeType_GRANTEE_TYPE_USER
+		grantee.Id = &provider.Grantee_UserId{UserId: res.User.Id}
+	case "group":
+		res, err := client.GetGroupByClaim(ctx, &grouppb.GetGroupByClaimRequest{Claim: "group_name", Value: g.ID, SkipFetchingMembers: true})
+		if err != nil || res.Status.Code != rpc.Code_CODE_OK {
+			return nil, errors.New("group not found")
+		}
+		grantee.Type = provider.GranteeType_GRANTEE_TYPE_GROUP
+		grantee.Id = &provider.Grantee_GroupId{GroupId: res.Group.Id}
+	default:
+		return nil, errors.Errorf("invalid grantee type %q", g.Type)
+	}
+
+	grant := &collaboration.ShareGrant{
+		Grantee: grantee,
+		Permissions: &collaboration.SharePermissions{
+			Permissions: conversions.IntTosharePerm(g.Permissions, conversions.ResourceTypeToItem(md.Type)),
+		},
+	}
+	if g.Expiration != "" {
+		t, err := time.Parse(time.RFC3339, g.Expiration)
+		if err != nil {
+			return nil, errors.New("invalid expiration")
+		}
+		grant.Expiration = &types.Timestamp{Seconds: uint64(t.Unix())}
+	}
+	return grant, nil
+}
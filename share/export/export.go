@@ -0,0 +1,318 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Package export dumps the shares and public links of the sql share
+// managers, with the states of their recipients, and re-creates them
+// on another instance.
+package export
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Share is an exported row of oc_share, with the states of its recipients.
+type Share struct {
+	ID           int64  `json:"id"`
+	ShareType    int    `json:"share_type"`
+	UIDOwner     string `json:"uid_owner"`
+	UIDInitiator string `json:"uid_initiator"`
+	ShareWith    string `json:"share_with,omitempty"`
+	Token        string `json:"token,omitempty"`
+	Prefix       string `json:"fileid_prefix"`
+	ItemSource   string `json:"item_source"`
+	ItemType     string `json:"item_type"`
+	FileSource   int64  `json:"file_source"`
+	FileTarget   string `json:"file_target"`
+	Permissions  int    `json:"permissions"`
+	STime        int64  `json:"stime"`
+	Expiration   string `json:"expiration,omitempty"`
+	Orphan       bool   `json:"orphan"`
+	// ShareName, Quicklink, Description, NotifyUploads and
+	// NotifyUploadsExtraRecipients are set for the public links.
+	ShareName                    string `json:"share_name,omitempty"`
+	Quicklink                    bool   `json:"quicklink,omitempty"`
+	Description                  string `json:"description,omitempty"`
+	NotifyUploads                bool   `json:"notify_uploads,omitempty"`
+	NotifyUploadsExtraRecipients string `json:"notify_uploads_extra_recipients,omitempty"`
+	// ParentShareID is the share reshared by this one, if tracked.
+	ParentShareID int64 `json:"parent_share_id,omitempty"`
+	// RemainingAccesses is the number of accesses left to a link, if limited.
+	RemainingAccesses *int64 `json:"remaining_accesses,omitempty"`
+	// NotBefore is the time a link becomes accessible, if scheduled.
+	NotBefore string `json:"not_before,omitempty"`
+	// Internal is set for the links only accessible to the authenticated users.
+	Internal bool `json:"internal,omitempty"`
+	// AllowedNetworks are the comma separated networks a link is restricted to, if any.
+	AllowedNetworks string `json:"allowed_networks,omitempty"`
+	// LinkType and UploadTarget are set for the file requests.
+	LinkType     int    `json:"link_type,omitempty"`
+	UploadTarget string `json:"upload_target,omitempty"`
+	// InitialPath is the path of the resource when the share was created.
+	InitialPath string   `json:"initial_path,omitempty"`
+	States      []*State `json:"states,omitempty"`
+}
+
+// State is the state of a share for one of its recipients.
+type State struct {
+	Recipient                    string `json:"recipient"`
+	State                        int    `json:"state"`
+	Alias                        string `json:"alias,omitempty"`
+	Hidden                       bool   `json:"hidden,omitempty"`
+	Synced                       bool   `json:"synced,omitempty"`
+	NotifyUploads                bool   `json:"notify_uploads,omitempty"`
+	NotifyUploadsExtraRecipients string `json:"notify_uploads_extra_recipients,omitempty"`
+}
+
+// Filter selects the shares to export. At least one field must be set.
+type Filter struct {
+	// User selects the shares owned or created by the user.
+	User string
+	// Owner selects the shares owned by the user, e.g. the
+	// service account owning a project space.
+	Owner string
+}
+
+func (f *Filter) where() (string, []interface{}, error) {
+	var conds []string
+	var params []interface{}
+	if f.User != "" {
+		conds = append(conds, "(uid_owner=? OR uid_initiator=?)")
+		params = append(params, f.User, f.User)
+	}
+	if f.Owner != "" {
+		conds = append(conds, "uid_owner=?")
+		params = append(params, f.Owner)
+	}
+	if len(conds) == 0 {
+		return "", nil, errors.New("export: at least one filter is required")
+	}
+	return "deleted_at IS NULL AND " + strings.Join(conds, " AND "), params, nil
+}
+
+// Export writes to w the shares selected by the filter, one json object per
+// line. It returns the number of exported shares.
+func Export(ctx context.Context, db *sql.DB, f *Filter, w io.Writer) (int, error) {
+	where, params, err := f.where()
+	if err != nil {
+		return 0, err
+	}
+
+	query := `SELECT id, share_type, coalesce(uid_owner, ''), coalesce(uid_initiator, ''), coalesce(share_with, ''), coalesce(token, ''),
+				coalesce(fileid_prefix, ''), coalesce(item_source, ''), coalesce(item_type, ''), coalesce(file_source, 0), coalesce(file_target, ''),
+				permissions, stime, coalesce(expiration, ''), coalesce(orphan, 0), coalesce(share_name, ''), coalesce(quicklink, 0),
+				coalesce(description, ''), coalesce(notify_uploads, 0), coalesce(notify_uploads_extra_recipients, ''), coalesce(parent_share_id, 0),
+				remaining_accesses, coalesce(not_before, ''), coalesce(internal, 0), coalesce(allowed_networks, ''),
+				coalesce(link_type, 0), coalesce(upload_target, ''), coalesce(initial_path, '')
+			  FROM oc_share WHERE ` + where + ` ORDER BY id`
+	rows, err := db.QueryContext(ctx, query, params...)
+	if err != nil {
+		return 0, errors.Wrap(err, "export: error querying shares")
+	}
+	var shares []*Share
+	for rows.Next() {
+		var s Share
+		if err := rows.Scan(&s.ID, &s.ShareType, &s.UIDOwner, &s.UIDInitiator, &s.ShareWith, &s.Token, &s.Prefix, &s.ItemSource, &s.ItemType, &s.FileSource, &s.FileTarget, &s.Permissions, &s.STime, &s.Expiration, &s.Orphan,
+			&s.ShareName, &s.Quicklink, &s.Description, &s.NotifyUploads, &s.NotifyUploadsExtraRecipients, &s.ParentShareID,
+			&s.RemainingAccesses, &s.NotBefore, &s.Internal, &s.AllowedNetworks, &s.LinkType, &s.UploadTarget, &s.InitialPath); err != nil {
+			rows.Close()
+			return 0, errors.Wrap(err, "export: error scanning share")
+		}
+		shares = append(shares, &s)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	enc := json.NewEncoder(w)
+	for _, s := range shares {
+		if s.States, err = states(ctx, db, s.ID); err != nil {
+			return 0, err
+		}
+		if err := enc.Encode(s); err != nil {
+			return 0, err
+		}
+	}
+	return len(shares), nil
+}
+
+func states(ctx context.Context, db *sql.DB, id int64) ([]*State, error) {
+	rows, err := db.QueryContext(ctx, `SELECT recipient, coalesce(state, 0), coalesce(alias, ''), coalesce(hidden, 0), coalesce(synced, 0),
+				coalesce(notify_uploads, 0), coalesce(notify_uploads_extra_recipients, '')
+			  FROM oc_share_status WHERE id=?`, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "export: error querying share states")
+	}
+	defer rows.Close()
+
+	var states []*State
+	for rows.Next() {
+		var s State
+		if err := rows.Scan(&s.Recipient, &s.State, &s.Alias, &s.Hidden, &s.Synced, &s.NotifyUploads, &s.NotifyUploadsExtraRecipients); err != nil {
+			return nil, errors.Wrap(err, "export: error scanning share state")
+		}
+		states = append(states, &s)
+	}
+	return states, rows.Err()
+}
+
+// ImportResult reports the outcome of an import.
+type ImportResult struct {
+	Imported int `json:"imported"`
+	// Existing is the number of shares already present in the target
+	// instance, e.g. imported by a previous run, that were skipped.
+	Existing int `json:"existing"`
+	// Renumbered maps the ids of the shares that could not be preserved,
+	// as already used in the target instance, to their new ids, and the
+	// ids of the existing shares to the ones in the target instance.
+	Renumbered map[int64]int64 `json:"renumbered,omitempty"`
+}
+
+// Import re-creates the shares read from r, as written by Export. Each share
+// is imported in its own transaction, together with its states, keeping its
+// id if not already used. The import is idempotent: the shares already
+// present in the target instance, with the same resource, owner and grantee
+// or token, are not created again, and only their missing states are added.
+func Import(ctx context.Context, db *sql.DB, r io.Reader) (*ImportResult, error) {
+	res := &ImportResult{Renumbered: map[int64]int64{}}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var s Share
+		if err := json.Unmarshal([]byte(line), &s); err != nil {
+			return res, errors.Wrapf(err, "export: error decoding share after %d imported", res.Imported)
+		}
+		if parent, ok := res.Renumbered[s.ParentShareID]; ok {
+			s.ParentShareID = parent
+		}
+		id, existing, err := importShare(ctx, db, &s)
+		if err != nil {
+			return res, errors.Wrapf(err, "export: error importing share %d", s.ID)
+		}
+		if id != s.ID {
+			res.Renumbered[s.ID] = id
+		}
+		if existing {
+			res.Existing++
+		} else {
+			res.Imported++
+		}
+	}
+	return res, scanner.Err()
+}
+
+// findShare returns the id of the share in the target instance matching s,
+// or 0 if there is none.
+func findShare(ctx context.Context, tx *sql.Tx, s *Share) (int64, error) {
+	query := "SELECT id FROM oc_share WHERE deleted_at IS NULL AND share_type=? AND uid_owner=? AND fileid_prefix=? AND item_source=?"
+	params := []interface{}{s.ShareType, s.UIDOwner, s.Prefix, s.ItemSource}
+	if s.Token != "" {
+		query += " AND token=?"
+		params = append(params, s.Token)
+	} else {
+		query += " AND lower(share_with)=lower(?)"
+		params = append(params, s.ShareWith)
+	}
+
+	var id int64
+	err := tx.QueryRowContext(ctx, query+" LIMIT 1", params...).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+func importShare(ctx context.Context, db *sql.DB, s *Share) (int64, bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	id, err := findShare(ctx, tx, s)
+	if err != nil {
+		return 0, false, err
+	}
+	existing := id != 0
+
+	if !existing {
+		var used int
+		if err := tx.QueryRowContext(ctx, "SELECT count(*) FROM oc_share WHERE id=?", s.ID).Scan(&used); err != nil {
+			return 0, false, err
+		}
+
+		var expiration, parent interface{}
+		if s.Expiration != "" {
+			expiration = s.Expiration
+		}
+		if s.ParentShareID != 0 {
+			parent = s.ParentShareID
+		}
+		query := `insert into oc_share set share_type=?,uid_owner=?,uid_initiator=?,share_with=?,token=?,fileid_prefix=?,item_source=?,item_type=?,file_source=?,file_target=?,permissions=?,stime=?,expiration=?,orphan=?,
+				share_name=?,quicklink=?,description=?,notify_uploads=?,notify_uploads_extra_recipients=?,parent_share_id=?,remaining_accesses=?,not_before=?,internal=?,allowed_networks=?,link_type=?,upload_target=?,initial_path=?`
+		params := []interface{}{s.ShareType, s.UIDOwner, s.UIDInitiator, nullable(s.ShareWith), nullable(s.Token), s.Prefix, s.ItemSource, s.ItemType, s.FileSource, s.FileTarget, s.Permissions, s.STime, expiration, s.Orphan,
+			nullable(s.ShareName), s.Quicklink, nullable(s.Description), s.NotifyUploads, nullable(s.NotifyUploadsExtraRecipients), parent, s.RemainingAccesses, nullable(s.NotBefore), s.Internal, nullable(s.AllowedNetworks), s.LinkType, nullable(s.UploadTarget), nullable(s.InitialPath)}
+		if used == 0 {
+			query += ",id=?"
+			params = append(params, s.ID)
+		}
+		result, err := tx.ExecContext(ctx, query, params...)
+		if err != nil {
+			return 0, false, err
+		}
+		if id, err = result.LastInsertId(); err != nil {
+			return 0, false, err
+		}
+	}
+
+	for _, st := range s.States {
+		var n int
+		if err := tx.QueryRowContext(ctx, "SELECT count(*) FROM oc_share_status WHERE id=? AND recipient=?", id, st.Recipient).Scan(&n); err != nil {
+			return 0, false, err
+		}
+		if n != 0 {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "insert into oc_share_status(id, recipient, state, alias, hidden, synced, notify_uploads, notify_uploads_extra_recipients) values(?, ?, ?, ?, ?, ?, ?, ?)",
+			id, st.Recipient, st.State, nullable(st.Alias), st.Hidden, st.Synced, st.NotifyUploads, nullable(st.NotifyUploadsExtraRecipients)); err != nil {
+			return 0, false, err
+		}
+	}
+	return id, existing, tx.Commit()
+}
+
+func nullable(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,107 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Package grants adds and removes the storage grants of the shares created
+// outside of the gateway, as the gateway does for the shares created
+// through it. The grants are not exposed by the gateway, and are set in
+// the storage provider of the resource, resolved by the storage registry.
+package grants
+
+import (
+	"context"
+
+	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	collaboration "github.com/cs3org/go-cs3apis/cs3/sharing/collaboration/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	registry "github.com/cs3org/go-cs3apis/cs3/storage/registry/v1beta1"
+	"github.com/cs3org/reva/pkg/rgrpc/todo/pool"
+	"github.com/pkg/errors"
+)
+
+// Granter sets the grants of the shares in the storage providers.
+type Granter struct {
+	registrySvc string
+}
+
+// New returns a granter resolving the storage providers with the given
+// storage registry, or gateway.
+func New(registrySvc string) *Granter {
+	return &Granter{registrySvc: registrySvc}
+}
+
+// AddGrant gives the grantee of the share access to the resource md.
+func (g *Granter) AddGrant(ctx context.Context, md *provider.ResourceInfo, s *collaboration.Share) error {
+	client, err := g.storageProvider(ctx, md.Id)
+	if err != nil {
+		return err
+	}
+	res, err := client.AddGrant(ctx, &provider.AddGrantRequest{
+		Ref: &provider.Reference{ResourceId: md.Id},
+		Grant: &provider.Grant{
+			Grantee:     s.Grantee,
+			Permissions: s.Permissions.Permissions,
+		},
+	})
+	switch {
+	case err != nil:
+		return errors.Wrap(err, "grants: error adding grant")
+	case res.Status.Code != rpc.Code_CODE_OK:
+		return errors.New("grants: error adding grant: " + res.Status.Message)
+	}
+	return nil
+}
+
+// RemoveGrant removes the access of the grantee of the share to the resource md.
+func (g *Granter) RemoveGrant(ctx context.Context, md *provider.ResourceInfo, s *collaboration.Share) error {
+	client, err := g.storageProvider(ctx, md.Id)
+	if err != nil {
+		return err
+	}
+	res, err := client.RemoveGrant(ctx, &provider.RemoveGrantRequest{
+		Ref: &provider.Reference{ResourceId: md.Id},
+		Grant: &provider.Grant{
+			Grantee:     s.Grantee,
+			Permissions: s.Permissions.Permissions,
+		},
+	})
+	switch {
+	case err != nil:
+		return errors.Wrap(err, "grants: error removing grant")
+	case res.Status.Code != rpc.Code_CODE_OK && res.Status.Code != rpc.Code_CODE_NOT_FOUND:
+		return errors.New("grants: error removing grant: " + res.Status.Message)
+	}
+	return nil
+}
+
+// storageProvider returns the client of the storage provider of the resource.
+func (g *Granter) storageProvider(ctx context.Context, id *provider.ResourceId) (provider.ProviderAPIClient, error) {
+	client, err := pool.GetStorageRegistryClient(pool.Endpoint(g.registrySvc))
+	if err != nil {
+		return nil, errors.Wrap(err, "grants: error getting the storage registry client")
+	}
+	res, err := client.GetStorageProviders(ctx, &registry.GetStorageProvidersRequest{Ref: &provider.Reference{ResourceId: id}})
+	switch {
+	case err != nil:
+		return nil, errors.Wrap(err, "grants: error resolving the storage provider")
+	case res.Status.Code != rpc.Code_CODE_OK:
+		return nil, errors.New("grants: error resolving the storage provider: " + res.Status.Message)
+	case len(res.Providers) == 0:
+		return nil, errors.New("grants: no storage provider found")
+	}
+	return pool.GetStorageProviderServiceClient(pool.Endpoint(res.Providers[0].Address))
+}
@@ -0,0 +1,167 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package schema
+
+// Migrations are the changes of the schema of the oc_share and
+// oc_share_status tables required by the share managers, and of
+// the tables they own. New migrations are appended with a new version.
+// The columns added to oc_share are added to oc_share_archive too.
+var Migrations = []*Migration{
+	{
+		Version:     1,
+		Description: "soft deletion of the shares",
+		Up:          []string{"alter table oc_share add column deleted_at bigint null"},
+		Down:        []string{"alter table oc_share drop column deleted_at"},
+	},
+	{
+		Version:     2,
+		Description: "alias, hidden flag and upload notifications of the received shares",
+		Up: []string{
+			"alter table oc_share_status add column alias varchar(255) null, add column hidden tinyint(1) not null default 0, add column notify_uploads tinyint(1) not null default 0, add column notify_uploads_extra_recipients varchar(4096) null",
+		},
+		Down: []string{
+			"alter table oc_share_status drop column alias, drop column hidden, drop column notify_uploads, drop column notify_uploads_extra_recipients",
+		},
+	},
+	{
+		Version:     3,
+		Description: "history of the shares",
+		Up: []string{`create table share_history (
+	id bigint not null auto_increment primary key,
+	share_id bigint not null,
+	action varchar(32) not null,
+	actor varchar(255) not null,
+	uid_owner varchar(255) not null,
+	htime bigint not null,
+	old_permissions int not null,
+	new_permissions int not null,
+	key share_history_share_id (share_id)
+)`},
+		Down: []string{"drop table share_history"},
+	},
+	{
+		Version:     4,
+		Description: "audit of the transfers of shares",
+		Up: []string{`create table oc_share_transfer (
+	id bigint not null auto_increment primary key,
+	share_id bigint not null,
+	uid_from varchar(255) not null,
+	uid_to varchar(255) not null,
+	actor varchar(255) not null,
+	ttime bigint not null
+)`},
+		Down: []string{"drop table oc_share_transfer"},
+	},
+	{
+		Version:     5,
+		Description: "provenance of the reshares",
+		Up:          []string{"alter table oc_share add column parent_share_id bigint null"},
+		Down:        []string{"alter table oc_share drop column parent_share_id"},
+	},
+	{
+		Version:     6,
+		Description: "archive of the expired shares",
+		Up:          []string{"create table if not exists oc_share_archive like oc_share"},
+		Down:        []string{"drop table oc_share_archive"},
+	},
+	{
+		Version:     7,
+		Description: "indexes for the lookups of shares by resource, owner, recipient and token",
+		Up: []string{
+			"create index oc_share_resource on oc_share (fileid_prefix, item_source)",
+			"create index oc_share_owner_orphan on oc_share (uid_owner, orphan)",
+			"create index oc_share_recipient on oc_share (share_with, share_type)",
+			"create index oc_share_token on oc_share (token)",
+		},
+		Down: []string{
+			"drop index oc_share_resource on oc_share",
+			"drop index oc_share_owner_orphan on oc_share",
+			"drop index oc_share_recipient on oc_share",
+			"drop index oc_share_token on oc_share",
+		},
+	},
+	{
+		Version:     8,
+		Description: "synced flag of the received shares",
+		Up:          []string{"alter table oc_share_status add column synced tinyint(1) not null default 0"},
+		Down:        []string{"alter table oc_share_status drop column synced"},
+	},
+	{
+		Version:     9,
+		Description: "access statistics of the public links",
+		Up: []string{`create table oc_share_link_stats (
+	share_id bigint not null primary key,
+	access_count bigint not null default 0,
+	last_access bigint not null
+)`},
+		Down: []string{"drop table oc_share_link_stats"},
+	},
+	{
+		Version:     10,
+		Description: "maximum number of accesses of the public links",
+		Up:          []string{"alter table oc_share add column remaining_accesses int null"},
+		Down:        []string{"alter table oc_share drop column remaining_accesses"},
+	},
+	{
+		Version:     11,
+		Description: "scheduled activation of the public links",
+		Up:          []string{"alter table oc_share add column not_before datetime null"},
+		Down:        []string{"alter table oc_share drop column not_before"},
+	},
+	{
+		Version:     12,
+		Description: "internal public links",
+		Up:          []string{"alter table oc_share add column internal tinyint(1) not null default 0"},
+		Down:        []string{"alter table oc_share drop column internal"},
+	},
+	{
+		Version:     13,
+		Description: "networks allowed to access the public links",
+		Up:          []string{"alter table oc_share add column allowed_networks varchar(1024) null"},
+		Down:        []string{"alter table oc_share drop column allowed_networks"},
+	},
+	{
+		Version:     14,
+		Description: "file requests",
+		Up:          []string{"alter table oc_share add column link_type tinyint not null default 0, add column upload_target varchar(255) null"},
+		Down:        []string{"alter table oc_share drop column link_type, drop column upload_target"},
+	},
+	{
+		Version:     15,
+		Description: "case insensitive index for the lookups of shares by recipient",
+		Up:          []string{"create index oc_share_recipient_lower on oc_share ((lower(share_with)), share_type)"},
+		Down:        []string{"drop index oc_share_recipient_lower on oc_share"},
+	},
+	{
+		Version:     16,
+		Description: "initial path of the shares and links",
+		Up:          []string{"alter table oc_share add column initial_path varchar(4096) null"},
+		Down:        []string{"alter table oc_share drop column initial_path"},
+	},
+	{
+		Version:     17,
+		Description: "columns of the shares added after the archive",
+		Up: []string{
+			"alter table oc_share_archive add column remaining_accesses int null, add column not_before datetime null, add column internal tinyint(1) not null default 0, add column allowed_networks varchar(1024) null, add column link_type tinyint not null default 0, add column upload_target varchar(255) null, add column initial_path varchar(4096) null",
+		},
+		Down: []string{
+			"alter table oc_share_archive drop column remaining_accesses, drop column not_before, drop column internal, drop column allowed_networks, drop column link_type, drop column upload_target, drop column initial_path",
+		},
+	},
+}
@@ -0,0 +1,283 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Package schema contains the versioned migrations of the tables
+// used by the share managers, and applies them to a database.
+package schema
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Migration is a change of the schema. The migrations are applied in
+// the order of their version, and each version is applied only once.
+type Migration struct {
+	Version     int
+	Description string
+	// Up are the statements applying the migration.
+	Up []string
+	// Down are the statements reverting the migration.
+	Down []string
+	// Affected selects the rows changed by a data migration, for the dry
+	// runs, as the table, the id, the owner and a description of the change.
+	// The migrations only changing the schema do not set it.
+	Affected string
+}
+
+// Checksum identifies the statements of the migration. A migration
+// must not be modified once it has been applied.
+func (m *Migration) Checksum() string {
+	h := sha256.Sum256([]byte(strings.Join(m.Up, ";\n")))
+	return hex.EncodeToString(h[:])
+}
+
+// State is the state of a migration in a database.
+type State struct {
+	Version     int       `json:"version"`
+	Description string    `json:"description"`
+	Applied     bool      `json:"applied"`
+	AppliedAt   time.Time `json:"applied_at,omitempty"`
+	// Modified is set if the migration changed after being applied.
+	Modified bool `json:"modified,omitempty"`
+}
+
+const createMigrationsTable = `create table if not exists share_schema_migrations (
+	version int not null primary key,
+	description varchar(255) not null,
+	checksum char(64) not null,
+	applied_at bigint not null
+)`
+
+type applied struct {
+	checksum  string
+	appliedAt int64
+}
+
+func sorted(migrations []*Migration) []*Migration {
+	s := make([]*Migration, len(migrations))
+	copy(s, migrations)
+	sort.Slice(s, func(i, j int) bool { return s[i].Version < s[j].Version })
+	return s
+}
+
+func appliedMigrations(ctx context.Context, db *sql.DB) (map[int]applied, error) {
+	if _, err := db.ExecContext(ctx, createMigrationsTable); err != nil {
+		return nil, errors.Wrap(err, "schema: error creating the migrations table")
+	}
+	rows, err := db.QueryContext(ctx, "select version, checksum, applied_at from share_schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := map[int]applied{}
+	for rows.Next() {
+		var v int
+		var a applied
+		if err := rows.Scan(&v, &a.checksum, &a.appliedAt); err != nil {
+			return nil, err
+		}
+		res[v] = a
+	}
+	return res, rows.Err()
+}
+
+// Status returns the state of all the migrations in db.
+func Status(ctx context.Context, db *sql.DB) ([]*State, error) {
+	done, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]*State, 0, len(Migrations))
+	for _, m := range sorted(Migrations) {
+		s := &State{Version: m.Version, Description: m.Description}
+		if a, ok := done[m.Version]; ok {
+			s.Applied = true
+			s.AppliedAt = time.Unix(a.appliedAt, 0)
+			s.Modified = a.checksum != m.Checksum()
+		}
+		states = append(states, s)
+	}
+	return states, nil
+}
+
+// Missing returns the versions, among the given ones, of the migrations
+// not yet applied to db. Unlike the other functions, it does not create
+// the migrations table, so it can be used with a read-only user.
+func Missing(ctx context.Context, db *sql.DB, versions []int) ([]int, error) {
+	var exists int
+	if err := db.QueryRowContext(ctx, "select count(*) from information_schema.tables where table_schema=database() and table_name='share_schema_migrations'").Scan(&exists); err != nil {
+		return nil, errors.Wrap(err, "schema: error looking for the migrations table")
+	}
+
+	done := map[int]bool{}
+	if exists != 0 {
+		rows, err := db.QueryContext(ctx, "select version from share_schema_migrations")
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var v int
+			if err := rows.Scan(&v); err != nil {
+				return nil, err
+			}
+			done[v] = true
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	var missing []int
+	for _, v := range versions {
+		if !done[v] {
+			missing = append(missing, v)
+		}
+	}
+	sort.Ints(missing)
+	return missing, nil
+}
+
+// Up applies the migrations not yet applied, up to the target version
+// included. All the migrations are applied if target is 0.
+// It fails if an applied migration has been modified.
+func Up(ctx context.Context, db *sql.DB, target int) ([]int, error) {
+	done, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []int
+	for _, m := range sorted(Migrations) {
+		if target > 0 && m.Version > target {
+			break
+		}
+		if a, ok := done[m.Version]; ok {
+			if a.checksum != m.Checksum() {
+				return versions, fmt.Errorf("schema: migration %d was modified after being applied", m.Version)
+			}
+			continue
+		}
+
+		// most of the DDL statements cause an implicit commit in mysql,
+		// so a failed migration may need to be fixed by hand
+		for _, stmt := range m.Up {
+			if _, err := db.ExecContext(ctx, stmt); err != nil {
+				return versions, errors.Wrapf(err, "schema: error applying migration %d", m.Version)
+			}
+		}
+		if _, err := db.ExecContext(ctx, "insert into share_schema_migrations(version, description, checksum, applied_at) values(?, ?, ?, ?)",
+			m.Version, m.Description, m.Checksum(), time.Now().Unix()); err != nil {
+			return versions, errors.Wrapf(err, "schema: error recording migration %d", m.Version)
+		}
+		versions = append(versions, m.Version)
+	}
+	return versions, nil
+}
+
+// Down reverts the applied migrations with a version greater than target,
+// starting from the most recent one.
+func Down(ctx context.Context, db *sql.DB, target int) ([]int, error) {
+	done, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := sorted(Migrations)
+	var versions []int
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Version <= target {
+			break
+		}
+		if _, ok := done[m.Version]; !ok {
+			continue
+		}
+
+		for _, stmt := range m.Down {
+			if _, err := db.ExecContext(ctx, stmt); err != nil {
+				return versions, errors.Wrapf(err, "schema: error reverting migration %d", m.Version)
+			}
+		}
+		if _, err := db.ExecContext(ctx, "delete from share_schema_migrations where version=?", m.Version); err != nil {
+			return versions, errors.Wrapf(err, "schema: error recording the revert of migration %d", m.Version)
+		}
+		versions = append(versions, m.Version)
+	}
+	return versions, nil
+}
+
+// Change is a row changed by a migration.
+type Change struct {
+	Version int    `json:"version"`
+	Table   string `json:"table"`
+	ID      int64  `json:"id"`
+	Owner   string `json:"owner"`
+	// Email is the email of the owner, if looked up.
+	Email  string `json:"email,omitempty"`
+	Change string `json:"change"`
+}
+
+// DryRun returns the rows changed by the migrations Up would apply
+// with the same target, without applying them.
+func DryRun(ctx context.Context, db *sql.DB, target int) ([]*Change, error) {
+	done, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []*Change
+	for _, m := range sorted(Migrations) {
+		if target > 0 && m.Version > target {
+			break
+		}
+		if _, ok := done[m.Version]; ok || m.Affected == "" {
+			continue
+		}
+
+		rows, err := db.QueryContext(ctx, m.Affected)
+		if err != nil {
+			return changes, errors.Wrapf(err, "schema: error selecting the rows changed by migration %d", m.Version)
+		}
+		for rows.Next() {
+			c := &Change{Version: m.Version}
+			if err := rows.Scan(&c.Table, &c.ID, &c.Owner, &c.Change); err != nil {
+				rows.Close()
+				return changes, err
+			}
+			changes = append(changes, c)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return changes, err
+		}
+	}
+	return changes, nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sql
+
+import (
+	"context"
+	"strings"
+
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	collaboration "github.com/cs3org/go-cs3apis/cs3/sharing/collaboration/v1beta1"
+	"github.com/cs3org/reva/pkg/appctx"
+	conversions "github.com/cs3org/reva/pkg/cbox/utils"
+	"github.com/cs3org/reva/pkg/errtypes"
+)
+
+const maxAliasLength = 255
+
+// shareStatus is the state of a received share specific
+// to the recipient, stored in oc_share_status.
+type shareStatus struct {
+	// Alias is the name given by the recipient to the share,
+	// returned as the path of the mount point.
+	Alias string
+	// Hidden tells if the recipient hid the share.
+	Hidden bool
+}
+
+// checkAlias validates the alias of a received share. An empty alias
+// resets it, otherwise it must be a valid file name not used by the
+// user for any other share.
+func (m *mgr) checkAlias(ctx context.Context, id *collaboration.ShareId, alias string) error {
+	if alias == "" {
+		return nil
+	}
+	if len(alias) > maxAliasLength || alias == "." || alias == ".." || strings.ContainsAny(alias, "/\\") {
+		return errtypes.BadRequest("invalid alias " + alias)
+	}
+
+	user := appctx.ContextMustGetUser(ctx)
+	var count int
+	query := "select count(*) FROM oc_share_status WHERE recipient=? AND lower(alias)=lower(?) AND id!=?"
+	if err := m.db.QueryRowContext(ctx, query, conversions.FormatUserID(user.Id), alias, id.OpaqueId).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return errtypes.AlreadyExists("alias " + alias)
+	}
+	return nil
+}
+
+// SetSynced sets whether the user in context syncs the received share
+// with the desktop client. The cs3 received shares have no field for it.
+func (m *mgr) SetSynced(ctx context.Context, id *collaboration.ShareId, synced bool) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+	user := appctx.ContextMustGetUser(ctx)
+	rs, err := m.getReceivedByID(ctx, id, userpb.UserType_USER_TYPE_INVALID)
+	if err != nil {
+		return err
+	}
+	return m.setShareStatus(ctx, user, id, []string{"state", "synced"}, []interface{}{stateToDB(rs.GetState()), synced})
+}
@@ -0,0 +1,198 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	collaboration "github.com/cs3org/go-cs3apis/cs3/sharing/collaboration/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/cs3org/reva/pkg/appctx"
+	conversions "github.com/cs3org/reva/pkg/cbox/utils"
+	"github.com/cs3org/reva/pkg/errtypes"
+	"github.com/cs3org/reva/pkg/share"
+	"github.com/cs3org/reva/pkg/utils"
+	"github.com/pkg/errors"
+)
+
+// BatchSharer is implemented by the share managers able to create
+// multiple shares of the same resource at once.
+type BatchSharer interface {
+	BatchShare(ctx context.Context, md *provider.ResourceInfo, grants []*collaboration.ShareGrant, granter Granter) ([]*BatchShareResult, error)
+}
+
+// Granter sets the grants of the shares in the storage, for the shares
+// created outside of the gateway.
+type Granter interface {
+	AddGrant(ctx context.Context, md *provider.ResourceInfo, s *collaboration.Share) error
+	RemoveGrant(ctx context.Context, md *provider.ResourceInfo, s *collaboration.Share) error
+}
+
+// BatchManager is a share manager able to create shares in batches.
+type BatchManager interface {
+	share.Manager
+	BatchSharer
+	Close() error
+}
+
+// NewBatchManager returns a share manager for the services creating
+// shares in batches, next to the share provider. The background tasks
+// of the manager are left to the share provider.
+func NewBatchManager(ctx context.Context, m map[string]interface{}) (BatchManager, error) {
+	manager, err := newManager(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedMgr{mgr: manager}, nil
+}
+
+// BatchShareResult is the outcome of the creation of the share
+// for one of the grantees of a batch.
+type BatchShareResult struct {
+	Grantee *provider.Grantee
+	// Share is the created share, nil if the creation was skipped.
+	Share *collaboration.Share
+	// Err is the reason why the share was not created.
+	Err error
+}
+
+// BatchShare creates the shares of md with all the given grants in a single
+// transaction. The grants for the owner or the creator and the ones already
+// existing are skipped, and reported in the results. The storage grant of
+// each share is added with granter before the transaction is committed,
+// and the shares whose grant failed are not created.
+func (m *mgr) BatchShare(ctx context.Context, md *provider.ResourceInfo, grants []*collaboration.ShareGrant, granter Granter) ([]*BatchShareResult, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+	user := appctx.ContextMustGetUser(ctx)
+	now := time.Now().Unix()
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "sql: error starting transaction")
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	parent := m.parentShare(ctx, user, md)
+	results := make([]*BatchShareResult, 0, len(grants))
+	committed := false
+	defer func() {
+		// the grants already added for a batch not committed are
+		// removed, also when the batch timed out
+		if !committed {
+			m.removeGrants(context.WithoutCancel(ctx), md, results, granter)
+		}
+	}()
+	for _, g := range grants {
+		res := &BatchShareResult{Grantee: g.Grantee}
+		results = append(results, res)
+
+		if g.Grantee.Type == provider.GranteeType_GRANTEE_TYPE_USER &&
+			(utils.UserEqual(g.Grantee.GetUserId(), user.Id) || utils.UserEqual(g.Grantee.GetUserId(), md.Owner)) {
+			res.Err = errtypes.BadRequest("sql: owner/creator and grantee are the same")
+			continue
+		}
+		if isExpired(g.Expiration) {
+			res.Err = errtypes.BadRequest("sql: expiration must be in the future")
+			continue
+		}
+		if err := m.checkDenial(user, md.Path, g.Permissions); err != nil {
+			res.Err = err
+			continue
+		}
+		if err := m.checkPolicy(ctx, conversions.ResourceTypeToItem(md.Type), g); err != nil {
+			res.Err = err
+			continue
+		}
+
+		exists, err := shareExists(ctx, tx, md, g)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			res.Err = errtypes.AlreadyExists(g.Grantee.String())
+			continue
+		}
+
+		stmtString, stmtValues := newShareStatement(user, md, g, parent, now)
+		result, err := tx.ExecContext(ctx, stmtString, stmtValues...)
+		if err != nil {
+			return nil, err
+		}
+		lastID, err := result.LastInsertId()
+		if err != nil {
+			return nil, err
+		}
+		s := newShare(user, md, g, lastID, now)
+		if err := granter.AddGrant(ctx, md, s); err != nil {
+			appctx.GetLogger(ctx).Error().Err(err).Int64("share_id", lastID).Msg("sql: error adding the grant of the share")
+			if _, err := tx.ExecContext(ctx, "delete from oc_share where id=?", lastID); err != nil {
+				return nil, err
+			}
+			res.Err = errtypes.InternalError("sql: error adding the grant to the resource")
+			continue
+		}
+		res.Share = s
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "sql: error committing transaction")
+	}
+	committed = true
+
+	for _, res := range results {
+		if res.Share != nil {
+			m.publishShareCreated(ctx, user, md, res.Share)
+			m.recordHistory(ctx, res.Share, HistoryCreated, 0, conversions.SharePermToInt(res.Share.Permissions.Permissions))
+		}
+	}
+	return results, nil
+}
+
+// removeGrants removes the grants added for the shares of a batch.
+func (m *mgr) removeGrants(ctx context.Context, md *provider.ResourceInfo, results []*BatchShareResult, granter Granter) {
+	for _, res := range results {
+		if res.Share == nil {
+			continue
+		}
+		if err := granter.RemoveGrant(ctx, md, res.Share); err != nil {
+			appctx.GetLogger(ctx).Error().Err(err).Str("share_id", res.Share.Id.OpaqueId).Msg("sql: error removing the grant of the share")
+		}
+	}
+}
+
+// shareExists checks in the transaction if md is already shared with the grantee.
+func shareExists(ctx context.Context, tx *sql.Tx, md *provider.ResourceInfo, g *collaboration.ShareGrant) (bool, error) {
+	shareType, shareWith := conversions.FormatGrantee(g.Grantee)
+	query := "select id from oc_share WHERE (orphan = 0 or orphan IS NULL) AND deleted_at IS NULL AND uid_owner=? AND fileid_prefix=? AND item_source=? AND share_type=? AND lower(share_with)=lower(?) LIMIT 1"
+	params := []interface{}{conversions.FormatUserID(md.Owner), md.Id.StorageId, md.Id.OpaqueId, shareType, shareWith}
+
+	var id int
+	if err := tx.QueryRowContext(ctx, query, params...).Scan(&id); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
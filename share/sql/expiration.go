@@ -0,0 +1,69 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sql
+
+import (
+	"time"
+
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	collaboration "github.com/cs3org/go-cs3apis/cs3/sharing/collaboration/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	typespb "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
+	conversions "github.com/cs3org/reva/pkg/cbox/utils"
+)
+
+func isExpired(ts *typespb.Timestamp) bool {
+	return ts != nil && ts.Seconds != 0 && int64(ts.Seconds) <= time.Now().Unix()
+}
+
+// formatExpiration returns the value of the expiration column,
+// nil for the shares not expiring.
+func formatExpiration(ts *typespb.Timestamp) interface{} {
+	if ts == nil || ts.Seconds == 0 {
+		return nil
+	}
+	return time.Unix(int64(ts.Seconds), 0).UTC().Format(expirationFormat)
+}
+
+func parseExpiration(s string) *typespb.Timestamp {
+	if s == "" {
+		return nil
+	}
+	t, err := time.Parse(expirationFormat, s)
+	if err != nil {
+		return nil
+	}
+	return &typespb.Timestamp{Seconds: uint64(t.Unix())}
+}
+
+func convertToCS3Share(s conversions.DBShare, gtype userpb.UserType) *collaboration.Share {
+	share := conversions.ConvertToCS3Share(s, gtype)
+	share.Expiration = parseExpiration(s.Expiration)
+	return share
+}
+
+func convertToCS3ReceivedShare(s conversions.DBShare, st shareStatus, gtype userpb.UserType) *collaboration.ReceivedShare {
+	rs := conversions.ConvertToCS3ReceivedShare(s, gtype)
+	rs.Share.Expiration = parseExpiration(s.Expiration)
+	rs.Hidden = st.Hidden
+	if st.Alias != "" {
+		rs.MountPoint = &provider.Reference{Path: st.Alias}
+	}
+	return rs
+}
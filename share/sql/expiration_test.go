@@ -0,0 +1,142 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	grouppb "github.com/cs3org/go-cs3apis/cs3/identity/group/v1beta1"
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	collaboration "github.com/cs3org/go-cs3apis/cs3/sharing/collaboration/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	typespb "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
+	"github.com/cs3org/reva/pkg/appctx"
+	"google.golang.org/grpc"
+)
+
+// testGateway resolves the path of every resource to the same path.
+type testGateway struct {
+	gateway.UnimplementedGatewayAPIServer
+	path string
+}
+
+func (g *testGateway) GetPath(context.Context, *provider.GetPathRequest) (*provider.GetPathResponse, error) {
+	return &provider.GetPathResponse{Status: &rpc.Status{Code: rpc.Code_CODE_OK}, Path: g.path}, nil
+}
+
+// startTestGateway serves a testGateway and returns its address.
+func startTestGateway(t *testing.T, path string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	gateway.RegisterGatewayAPIServer(srv, &testGateway{path: path})
+	go func() {
+		_ = srv.Serve(l)
+	}()
+	t.Cleanup(srv.Stop)
+	return l.Addr().String()
+}
+
+// TestUpdateShareExpiration runs against the database given in
+// SHARE_SQL_TEST_DSN, e.g. "user:password@tcp(localhost:3306)/cernboxshares",
+// with the schema of share/schema applied.
+func TestUpdateShareExpiration(t *testing.T) {
+	dsn := os.Getenv("SHARE_SQL_TEST_DSN")
+	if dsn == "" {
+		t.Skip("SHARE_SQL_TEST_DSN not set")
+	}
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	gw := startTestGateway(t, "/eos/user/t/testowner/expiration.txt")
+	m := &mgr{c: &config{GatewaySvc: gw, QueryTimeout: 30}, db: db}
+
+	owner := &userpb.User{Id: &userpb.UserId{OpaqueId: "testowner"}, Username: "testowner"}
+	ctx := appctx.ContextSetUser(context.Background(), owner)
+	md := &provider.ResourceInfo{
+		Id:    &provider.ResourceId{StorageId: "test-storage", OpaqueId: time.Now().Format("20060102150405.000000000")},
+		Path:  "/eos/user/t/testowner/expiration.txt",
+		Type:  provider.ResourceType_RESOURCE_TYPE_FILE,
+		Owner: owner.Id,
+	}
+	g := &collaboration.ShareGrant{
+		Grantee: &provider.Grantee{
+			Type: provider.GranteeType_GRANTEE_TYPE_GROUP,
+			Id:   &provider.Grantee_GroupId{GroupId: &grouppb.GroupId{OpaqueId: "test-group"}},
+		},
+		Permissions: &collaboration.SharePermissions{Permissions: &provider.ResourcePermissions{Stat: true, InitiateFileDownload: true}},
+	}
+	query, params := newShareStatement(owner, md, g, nil, time.Now().Unix())
+	res, err := db.ExecContext(ctx, query, params...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_, _ = db.Exec("delete from oc_share where id=?", id)
+	})
+	ref := &collaboration.ShareReference{Spec: &collaboration.ShareReference_Id{Id: &collaboration.ShareId{OpaqueId: strconv.FormatInt(id, 10)}}}
+
+	expiration := &typespb.Timestamp{Seconds: uint64(time.Now().Add(48 * time.Hour).Unix())}
+	s, err := m.UpdateShareExpiration(ctx, ref, expiration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Expiration.GetSeconds() != expiration.Seconds {
+		t.Errorf("expected the share to expire at %d, got %d", expiration.Seconds, s.Expiration.GetSeconds())
+	}
+	var stored sql.NullString
+	if err := db.QueryRow("select expiration from oc_share where id=?", id).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.String != formatExpiration(expiration).(string) {
+		t.Errorf("expected the expiration %v in the db, got %q", formatExpiration(expiration), stored.String)
+	}
+
+	if _, err := m.UpdateShareExpiration(ctx, ref, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow("select expiration from oc_share where id=?", id).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Valid {
+		t.Errorf("expected the share to be permanent, got the expiration %q", stored.String)
+	}
+
+	past := &typespb.Timestamp{Seconds: uint64(time.Now().Add(-time.Hour).Unix())}
+	if _, err := m.UpdateShareExpiration(ctx, ref, past); err == nil {
+		t.Error("expected an expiration in the past to be rejected")
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sql
+
+import (
+	"context"
+	"time"
+
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	collaboration "github.com/cs3org/go-cs3apis/cs3/sharing/collaboration/v1beta1"
+	link "github.com/cs3org/go-cs3apis/cs3/sharing/link/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	typespb "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
+	"github.com/cs3org/reva/pkg/share"
+)
+
+// ExtendedManager is the share manager with the operations that are not
+// part of the share.Manager interface, served by the share api service.
+type ExtendedManager interface {
+	share.Manager
+	UpdateShareExpiration(ctx context.Context, ref *collaboration.ShareReference, expiration *typespb.Timestamp) (*collaboration.Share, error)
+	RecheckOrphans(ctx context.Context, id *provider.ResourceId) ([]string, error)
+	TransferShares(ctx context.Context, fromUser, toUser *userpb.UserId, filters []*collaboration.Filter) ([]string, error)
+	GetNotificationPrefs(ctx context.Context, id *collaboration.ShareId) (*NotificationPrefs, error)
+	SetNotificationPrefs(ctx context.Context, id *collaboration.ShareId, prefs *NotificationPrefs) error
+	SetSynced(ctx context.Context, id *collaboration.ShareId, synced bool) error
+	ListShareHistory(ctx context.Context, id *collaboration.ShareId) ([]*ShareHistoryEntry, error)
+	RestoreShare(ctx context.Context, id *collaboration.ShareId, granter Granter) (*collaboration.Share, error)
+	GetShareProvenance(ctx context.Context, id *collaboration.ShareId) ([]*collaboration.ShareId, error)
+	ListReshares(ctx context.Context, id *collaboration.ShareId) ([]*collaboration.Share, error)
+	CreatePublicShareWithOptions(ctx context.Context, u *userpb.User, md *provider.ResourceInfo, g *link.Grant, description string, internal bool, notifyUploads bool, notifyUploadsExtraRecipients string, opts *LinkOptions) (*link.PublicShare, error)
+	ConsumePublicShareAccess(ctx context.Context, token string) error
+	FileRequestTarget(ctx context.Context, token, uploader string) (string, error)
+	SetPublicShareAllowedNetworks(ctx context.Context, u *userpb.User, ref *link.PublicShareReference, networks []string) error
+	RevokePublicShares(ctx context.Context, f *RevokeFilter) (int64, error)
+	ListExpiringPublicShares(ctx context.Context, owner *userpb.UserId, days int) ([]*link.PublicShare, error)
+	GetPublicShareStats(ctx context.Context, u *userpb.User, ref *link.PublicShareReference) (*LinkStats, error)
+	Close() error
+}
+
+// NewExtendedManager returns a share manager for the services exposing
+// the operations not supported by the share and the public share
+// providers, next to them. The background tasks of the manager are left
+// to the providers.
+func NewExtendedManager(ctx context.Context, m map[string]interface{}) (ExtendedManager, error) {
+	lm, err := newLinkManager(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+	return &extendedMgr{instrumentedMgr: &instrumentedMgr{mgr: lm.mgr}, links: lm}, nil
+}
+
+// extendedMgr adds the public link operations to the share manager,
+// sharing its database.
+type extendedMgr struct {
+	*instrumentedMgr
+	links *linkMgr
+}
+
+func (m *extendedMgr) CreatePublicShareWithOptions(ctx context.Context, u *userpb.User, md *provider.ResourceInfo, g *link.Grant, description string, internal bool, notifyUploads bool, notifyUploadsExtraRecipients string, opts *LinkOptions) (*link.PublicShare, error) {
+	start := time.Now()
+	s, err := m.links.CreatePublicShareWithOptions(ctx, u, md, g, description, internal, notifyUploads, notifyUploadsExtraRecipients, opts)
+	observe("CreatePublicShareWithOptions", start, err)
+	return s, err
+}
+
+func (m *extendedMgr) ConsumePublicShareAccess(ctx context.Context, token string) error {
+	start := time.Now()
+	err := m.links.ConsumePublicShareAccess(ctx, token)
+	observe("ConsumePublicShareAccess", start, err)
+	return err
+}
+
+func (m *extendedMgr) GetPublicShareStats(ctx context.Context, u *userpb.User, ref *link.PublicShareReference) (*LinkStats, error) {
+	start := time.Now()
+	s, err := m.links.GetPublicShareStats(ctx, u, ref)
+	observe("GetPublicShareStats", start, err)
+	return s, err
+}
+
+func (m *extendedMgr) ListExpiringPublicShares(ctx context.Context, owner *userpb.UserId, days int) ([]*link.PublicShare, error) {
+	start := time.Now()
+	s, err := m.links.ListExpiringPublicShares(ctx, owner, days)
+	observe("ListExpiringPublicShares", start, err)
+	return s, err
+}
+
+func (m *extendedMgr) RevokePublicShares(ctx context.Context, f *RevokeFilter) (int64, error) {
+	start := time.Now()
+	n, err := m.links.RevokePublicShares(ctx, f)
+	observe("RevokePublicShares", start, err)
+	return n, err
+}
+
+func (m *extendedMgr) SetPublicShareAllowedNetworks(ctx context.Context, u *userpb.User, ref *link.PublicShareReference, networks []string) error {
+	start := time.Now()
+	err := m.links.SetPublicShareAllowedNetworks(ctx, u, ref, networks)
+	observe("SetPublicShareAllowedNetworks", start, err)
+	return err
+}
+
+func (m *extendedMgr) FileRequestTarget(ctx context.Context, token, uploader string) (string, error) {
+	start := time.Now()
+	target, err := m.links.FileRequestTarget(ctx, token, uploader)
+	observe("FileRequestTarget", start, err)
+	return target, err
+}
+
+func (m *extendedMgr) Close() error {
+	return m.links.Close()
+}
@@ -0,0 +1,79 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sql
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+)
+
+// groupsInsertBatch is the number of groups inserted
+// in the temporary table by each statement.
+const groupsInsertBatch = 500
+
+// groupsCondition returns the condition matching share_with with one of the
+// groups. For the users in many groups the groups are inserted in a temporary
+// table of the connection, dropped by the returned cleanup function. Each call
+// uses a table with a new name, so that a table left on a pooled connection
+// by a failed cleanup can never be read on behalf of another user.
+func (m *mgr) groupsCondition(ctx context.Context, conn *sql.Conn, groups []string) (string, []interface{}, func(), error) {
+	nop := func() {}
+	if len(groups) == 0 {
+		return "", nil, nop, nil
+	}
+
+	if len(groups) <= m.c.GroupsJoinThreshold {
+		params := make([]interface{}, 0, len(groups))
+		for _, g := range groups {
+			params = append(params, g)
+		}
+		return "lower(share_with) in (?" + strings.Repeat(",?", len(groups)-1) + ")", params, nop, nil
+	}
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", nil, nop, err
+	}
+	table := "tmp_user_groups_" + hex.EncodeToString(suffix)
+
+	if _, err := conn.ExecContext(ctx, "create temporary table "+table+" (name varchar(255) NOT NULL PRIMARY KEY)"); err != nil {
+		return "", nil, nop, err
+	}
+	cleanup := func() {
+		// the connection goes back to the pool, so the table must not outlive the query
+		_, _ = conn.ExecContext(context.Background(), "drop temporary table if exists "+table)
+	}
+
+	for start := 0; start < len(groups); start += groupsInsertBatch {
+		batch := groups[start:min(start+groupsInsertBatch, len(groups))]
+		params := make([]interface{}, 0, len(batch))
+		for _, g := range batch {
+			params = append(params, strings.ToLower(g))
+		}
+		query := "insert ignore into " + table + "(name) values (?)" + strings.Repeat(",(?)", len(batch)-1)
+		if _, err := conn.ExecContext(ctx, query, params...); err != nil {
+			cleanup()
+			return "", nil, nop, err
+		}
+	}
+	return "lower(share_with) in (select name from " + table + ")", nil, cleanup, nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+)
+
+// BenchmarkGroupsCondition compares the listing of the group shares with the
+// groups passed as parameters and joined from the temporary table. It runs
+// against the database given in SHARE_SQL_BENCH_DSN, e.g.
+// "user:password@tcp(localhost:3306)/cernboxshares".
+func BenchmarkGroupsCondition(b *testing.B) {
+	dsn := os.Getenv("SHARE_SQL_BENCH_DSN")
+	if dsn == "" {
+		b.Skip("SHARE_SQL_BENCH_DSN not set")
+	}
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	for _, n := range []int{10, 100, 1000} {
+		groups := make([]string, n)
+		for i := range groups {
+			groups[i] = fmt.Sprintf("bench-group-%d", i)
+		}
+		for _, strategy := range []struct {
+			name      string
+			threshold int
+		}{{"params", n}, {"join", 0}} {
+			m := &mgr{c: &config{GroupsJoinThreshold: strategy.threshold}, db: db}
+			b.Run(fmt.Sprintf("%s/%d", strategy.name, n), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					benchmarkGroupsQuery(ctx, b, m, groups)
+				}
+			})
+		}
+	}
+}
+
+func benchmarkGroupsQuery(ctx context.Context, b *testing.B, m *mgr, groups []string) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+
+	cond, params, cleanup, err := m.groupsCondition(ctx, conn, groups)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer cleanup()
+
+	var count int
+	if err := conn.QueryRowContext(ctx, "select count(*) FROM oc_share WHERE share_type = 1 AND "+cond, params...).Scan(&count); err != nil {
+		b.Fatal(err)
+	}
+}
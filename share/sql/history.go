@@ -0,0 +1,116 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sql
+
+import (
+	"context"
+	"time"
+
+	collaboration "github.com/cs3org/go-cs3apis/cs3/sharing/collaboration/v1beta1"
+	"github.com/cs3org/reva/pkg/appctx"
+	conversions "github.com/cs3org/reva/pkg/cbox/utils"
+	"github.com/cs3org/reva/pkg/errtypes"
+)
+
+// The actions recorded in the history of the shares.
+const (
+	HistoryCreated  = "created"
+	HistoryUpdated  = "updated"
+	HistoryDeleted  = "deleted"
+	HistoryAccepted = "accepted"
+	HistoryRejected = "rejected"
+	HistoryRestored = "restored"
+)
+
+// ShareHistoryEntry is a change of a share, stored in the share_history table.
+type ShareHistoryEntry struct {
+	ShareID string
+	Action  string
+	// Actor is the user who made the change.
+	Actor string
+	Owner string
+	Time  time.Time
+	// OldPermissions and NewPermissions are the permissions
+	// of the share before and after the change.
+	OldPermissions int
+	NewPermissions int
+}
+
+// recordHistory adds an entry to the history of the share. The failures are
+// only logged, so that the history never prevents changing a share.
+func (m *mgr) recordHistory(ctx context.Context, s *collaboration.Share, action string, oldPermissions, newPermissions int) {
+	user := appctx.ContextMustGetUser(ctx)
+	m.insertHistory(ctx, s.Id.OpaqueId, action, conversions.FormatUserID(user.Id), conversions.FormatUserID(s.Owner), oldPermissions, newPermissions)
+}
+
+func (m *mgr) insertHistory(ctx context.Context, id, action, actor, owner string, oldPermissions, newPermissions int) {
+	query := "insert into share_history(share_id, action, actor, uid_owner, htime, old_permissions, new_permissions) values(?, ?, ?, ?, ?, ?, ?)"
+	_, err := m.db.ExecContext(ctx, query, id, action, actor, owner, time.Now().Unix(), oldPermissions, newPermissions)
+	if err != nil {
+		appctx.GetLogger(ctx).Error().Err(err).Str("share_id", id).Str("action", action).Msg("sql: error recording share history")
+	}
+}
+
+// ListShareHistory returns the changes of a share, oldest first. The history
+// is visible to the owner of the share, to the users who changed it and
+// to the admins of the project the share belongs to.
+func (m *mgr) ListShareHistory(ctx context.Context, id *collaboration.ShareId) ([]*ShareHistoryEntry, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+	user := appctx.ContextMustGetUser(ctx)
+	uid := conversions.FormatUserID(user.Id)
+
+	query := "select share_id, action, actor, uid_owner, htime, old_permissions, new_permissions FROM share_history WHERE share_id=? ORDER BY htime, id"
+	rows, err := m.db.QueryContext(ctx, query, id.OpaqueId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*ShareHistoryEntry{}
+	allowed := false
+	for rows.Next() {
+		var (
+			e     ShareHistoryEntry
+			htime int64
+		)
+		if err := rows.Scan(&e.ShareID, &e.Action, &e.Actor, &e.Owner, &htime, &e.OldPermissions, &e.NewPermissions); err != nil {
+			return nil, err
+		}
+		e.Time = time.Unix(htime, 0)
+		if e.Owner == uid || e.Actor == uid {
+			allowed = true
+		}
+		entries = append(entries, &e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if !allowed {
+		ref := &collaboration.ShareReference{Spec: &collaboration.ShareReference_Id{Id: id}}
+		if ctx, err := m.addPathIntoCtx(ctx, ref); err == nil && m.isProjectAdminFromCtx(ctx, user) {
+			allowed = true
+		}
+	}
+	if !allowed {
+		return nil, errtypes.NotFound(id.OpaqueId)
+	}
+	return entries, nil
+}
@@ -0,0 +1,143 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/cs3org/reva/pkg/appctx"
+	"github.com/pkg/errors"
+)
+
+// JanitorReport is the number of rows processed by a run of the janitor.
+type JanitorReport struct {
+	Shares    int64 `json:"shares"`
+	States    int64 `json:"states"`
+	LinkStats int64 `json:"link_stats"`
+	Archived  bool  `json:"archived"`
+}
+
+// expiredCondition selects the shares and links expired more than ? days ago.
+const expiredCondition = "share_type IN (?, ?, ?) AND expiration IS NOT NULL AND expiration < UTC_TIMESTAMP() - INTERVAL ? DAY"
+
+// PurgeExpiredShares deletes the user and group shares and the public links
+// expired more than the configured retention ago, with the states of the
+// shares and the access statistics of the links. If configured, they are
+// first copied in the oc_share_archive table.
+func (m *mgr) PurgeExpiredShares(ctx context.Context) (*JanitorReport, error) {
+	report := &JanitorReport{Archived: m.c.ArchiveExpired}
+	params := []interface{}{shareTypeUser, shareTypeGroup, shareTypePublicLink, m.c.ExpiredRetention}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "sql: error starting transaction")
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if m.c.ArchiveExpired {
+		columns, err := archiveColumns(ctx, tx)
+		if err != nil {
+			return nil, errors.Wrap(err, "sql: error reading the columns of the archive")
+		}
+		if _, err := tx.ExecContext(ctx, "insert into oc_share_archive ("+columns+") select "+columns+" from oc_share where "+expiredCondition, params...); err != nil {
+			return nil, errors.Wrap(err, "sql: error archiving expired shares")
+		}
+	}
+
+	res, err := tx.ExecContext(ctx, "delete tr from oc_share_status tr join oc_share ts on tr.id = ts.id where "+expiredCondition, params...)
+	if err != nil {
+		return nil, errors.Wrap(err, "sql: error deleting the states of expired shares")
+	}
+	if report.States, err = res.RowsAffected(); err != nil {
+		return nil, err
+	}
+
+	res, err = tx.ExecContext(ctx, "delete st from oc_share_link_stats st join oc_share ts on st.share_id = ts.id where "+expiredCondition, params...)
+	if err != nil {
+		return nil, errors.Wrap(err, "sql: error deleting the statistics of expired links")
+	}
+	if report.LinkStats, err = res.RowsAffected(); err != nil {
+		return nil, err
+	}
+
+	res, err = tx.ExecContext(ctx, "delete from oc_share where "+expiredCondition, params...)
+	if err != nil {
+		return nil, errors.Wrap(err, "sql: error deleting expired shares")
+	}
+	if report.Shares, err = res.RowsAffected(); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "sql: error committing transaction")
+	}
+	return report, nil
+}
+
+// runJanitor is the periodic task purging the expired shares.
+func (m *mgr) runJanitor(ctx context.Context) error {
+	report, err := m.PurgeExpiredShares(ctx)
+	if err != nil {
+		return err
+	}
+	appctx.GetLogger(ctx).Info().Int64("shares", report.Shares).Int64("states", report.States).Int64("link_stats", report.LinkStats).Bool("archived", report.Archived).Msg("sql: purged expired shares")
+	return nil
+}
+
+// archiveColumns returns the columns of oc_share, failing if some are
+// missing in oc_share_archive, as their values would be lost. The archive
+// is created as a copy of oc_share by the migration 6, and the following
+// migrations add the new columns of oc_share to both.
+func archiveColumns(ctx context.Context, tx *sql.Tx) (string, error) {
+	rows, err := tx.QueryContext(ctx, `select s.column_name, a.column_name is not null from information_schema.columns s
+				left join information_schema.columns a on a.table_schema = s.table_schema and a.column_name = s.column_name and a.table_name = 'oc_share_archive'
+				where s.table_schema = database() and s.table_name = 'oc_share' order by s.ordinal_position`)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var columns, missing []string
+	for rows.Next() {
+		var c string
+		var archived bool
+		if err := rows.Scan(&c, &archived); err != nil {
+			return "", err
+		}
+		if !archived {
+			missing = append(missing, c)
+			continue
+		}
+		columns = append(columns, "`"+c+"`")
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	if len(columns) == 0 {
+		return "", errors.New("sql: the oc_share_archive table does not exist")
+	}
+	if len(missing) > 0 {
+		return "", errors.Errorf("sql: the columns %s of oc_share are missing in oc_share_archive, apply the migrations", strings.Join(missing, ", "))
+	}
+	return strings.Join(columns, ", "), nil
+}
@@ -0,0 +1,212 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sql
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bluele/gcache"
+	"github.com/cernbox/reva-plugins/redispool"
+	"github.com/cs3org/reva/pkg/appctx"
+	"github.com/cs3org/reva/pkg/errtypes"
+	"github.com/gomodule/redigo/redis"
+)
+
+// maxFailureDelay caps the delay of the responses to the failed attempts.
+const maxFailureDelay = 10 * time.Second
+
+// bruteForceConfig protects the password protected links against brute
+// force attacks, counting the failed password attempts per link and per
+// client IP, as forwarded by the trusted proxies of the links.
+type bruteForceConfig struct {
+	// MaxFailures is the number of failed attempts, on a link or from a
+	// client IP, after which the link or the client are locked out until
+	// the end of the window. The protection is disabled if 0.
+	MaxFailures int `mapstructure:"max_failures" validate:"min=0"`
+	// Window is the time in seconds the failures are counted, from the
+	// first one. Defaults to 15 minutes.
+	Window int `mapstructure:"window" validate:"min=0"`
+	// Delay is the delay in milliseconds of the response to the first
+	// failure in the window, doubled for each following one up to 10
+	// seconds. Defaults to 250 milliseconds.
+	Delay int `mapstructure:"delay" validate:"min=0"`
+	// Size is the number of counters kept in memory. Defaults to 100000.
+	Size int `mapstructure:"size" validate:"min=0"`
+	// RedisAddress keeps the counters in redis, shared among the reva instances.
+	RedisAddress  string `mapstructure:"redis_address"`
+	RedisUsername string `mapstructure:"redis_username"`
+	RedisPassword string `mapstructure:"redis_password"`
+	// RedisPrefix is the prefix of the redis keys. Defaults to "linkfailures:".
+	RedisPrefix string `mapstructure:"redis_prefix"`
+}
+
+func (c *bruteForceConfig) applyDefaults() {
+	if c.Window == 0 {
+		c.Window = 15 * 60
+	}
+	if c.Delay == 0 {
+		c.Delay = 250
+	}
+	if c.Size == 0 {
+		c.Size = 100000
+	}
+	if c.RedisPrefix == "" {
+		c.RedisPrefix = "linkfailures:"
+	}
+}
+
+// failureStore counts the failures by key in fixed windows.
+type failureStore interface {
+	failures(key string) int
+	// fail counts a failure, starting the window of the key if it is the
+	// first one, and returns the failures in the window.
+	fail(key string, window time.Duration) int
+	close() error
+}
+
+func newFailureStore(c *bruteForceConfig) failureStore {
+	if c.RedisAddress != "" {
+		return &redisFailureStore{
+			pool: redispool.New(&redispool.Config{
+				Address:  c.RedisAddress,
+				Username: c.RedisUsername,
+				Password: c.RedisPassword,
+			}),
+			prefix: c.RedisPrefix,
+		}
+	}
+	return &memoryFailureStore{cache: gcache.New(c.Size).LRU().Build()}
+}
+
+// failureKeys returns the keys of the counters of the failures on the
+// link and from the client, if known. The ip of the client must be the one
+// given by the trusted proxies, see clientIP, or the clients could spread
+// their attempts on forged ips, or lock out the ip of another client.
+func failureKeys(token, ip string) []string {
+	keys := []string{"token:" + token}
+	if ip != "" {
+		keys = append(keys, "ip:"+ip)
+	}
+	return keys
+}
+
+// checkLockout fails if the link or the client are locked out.
+func (m *linkMgr) checkLockout(ctx context.Context, token string) error {
+	max := m.c.Links.BruteForce.MaxFailures
+	ip := m.clientIP(ctx)
+	for _, key := range failureKeys(token, ip) {
+		if m.failures.failures(key) >= max {
+			appctx.GetLogger(ctx).Warn().Str("token", token).Str("ip", ip).Str("locked", key).Msg("sql: refused password attempt on a locked out public link")
+			return errtypes.PermissionDenied("sql: too many failed attempts, retry later")
+		}
+	}
+	return nil
+}
+
+// recordFailure counts a failed password attempt and delays the response,
+// the more the more failures in the window.
+func (m *linkMgr) recordFailure(ctx context.Context, token string) {
+	c := &m.c.Links.BruteForce
+	window := time.Duration(c.Window) * time.Second
+	ip := m.clientIP(ctx)
+	failures := 0
+	for _, key := range failureKeys(token, ip) {
+		if n := m.failures.fail(key, window); n > failures {
+			failures = n
+		}
+	}
+	appctx.GetLogger(ctx).Warn().Str("token", token).Str("ip", ip).Int("failures", failures).Msg("sql: failed password attempt on public link")
+
+	delay := time.Duration(c.Delay) * time.Millisecond
+	for i := 1; i < failures && delay < maxFailureDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxFailureDelay {
+		delay = maxFailureDelay
+	}
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+	}
+}
+
+type memoryFailureStore struct {
+	mu    sync.Mutex
+	cache gcache.Cache
+}
+
+func (s *memoryFailureStore) failures(key string) int {
+	v, err := s.cache.Get(key)
+	if err != nil {
+		return 0
+	}
+	return v.(int)
+}
+
+func (s *memoryFailureStore) fail(key string, window time.Duration) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, err := s.cache.Get(key)
+	if err != nil {
+		_ = s.cache.SetWithExpire(key, 1, window)
+		return 1
+	}
+	// keep the expiration of the window
+	n := v.(int) + 1
+	_ = s.cache.Set(key, n)
+	return n
+}
+
+func (s *memoryFailureStore) close() error {
+	s.cache.Purge()
+	return nil
+}
+
+type redisFailureStore struct {
+	pool   *redis.Pool
+	prefix string
+}
+
+func (s *redisFailureStore) failures(key string) int {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	n, _ := redis.Int(conn.Do("GET", s.prefix+key))
+	return n
+}
+
+func (s *redisFailureStore) fail(key string, window time.Duration) int {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	n, err := redis.Int(conn.Do("INCR", s.prefix+key))
+	if err != nil {
+		return 0
+	}
+	if n == 1 {
+		_, _ = conn.Do("PEXPIRE", s.prefix+key, window.Milliseconds())
+	}
+	return n
+}
+
+func (s *redisFailureStore) close() error {
+	return s.pool.Close()
+}
@@ -0,0 +1,55 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sql
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/bluele/gcache"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func TestLockoutForgedIP(t *testing.T) {
+	m := &linkMgr{
+		mgr: &mgr{c: &config{Links: linksConfig{BruteForce: bruteForceConfig{MaxFailures: 2, Window: 60, Delay: 1}}}},
+		// no trusted proxies: the forwarded ips are ignored
+		failures: &memoryFailureStore{cache: gcache.New(10).LRU().Build()},
+	}
+	attempt := func(token, forged string) context.Context {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 5000}})
+		return metadata.NewIncomingContext(ctx, metadata.Pairs("x-forwarded-for", forged))
+	}
+
+	m.recordFailure(attempt("token-a", "5.6.7.8"), "token-a")
+	m.recordFailure(attempt("token-b", "9.9.9.9"), "token-b")
+
+	// the client is locked out by its own ip on any link,
+	// whatever the ip it forges
+	if err := m.checkLockout(attempt("token-c", "8.8.8.8"), "token-c"); err == nil {
+		t.Fatal("expected the client to be locked out")
+	}
+	// and the forged ip is not locked out
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("5.6.7.8"), Port: 5000}})
+	if err := m.checkLockout(ctx, "token-c"); err != nil {
+		t.Fatalf("expected the forged ip not to be locked out, got %v", err)
+	}
+}
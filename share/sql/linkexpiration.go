@@ -0,0 +1,132 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sql
+
+import (
+	"context"
+	"time"
+
+	"github.com/cernbox/reva-plugins/events"
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	link "github.com/cs3org/go-cs3apis/cs3/sharing/link/v1beta1"
+	"github.com/cs3org/reva/pkg/appctx"
+	conversions "github.com/cs3org/reva/pkg/cbox/utils"
+	"github.com/cs3org/reva/pkg/errtypes"
+	"github.com/pkg/errors"
+)
+
+// expirationReminderConfig configures the reminders of the links about to expire.
+type expirationReminderConfig struct {
+	// Days is the number of days before the expiration of a link its owner
+	// is reminded, with a link.expiring event. The reminders are disabled if 0.
+	Days int `mapstructure:"days" validate:"min=0"`
+	// Interval is the interval in seconds between the runs of the
+	// reminders. Defaults to 1 hour.
+	Interval int `mapstructure:"interval" validate:"min=0"`
+}
+
+func (c *expirationReminderConfig) applyDefaults() {
+	if c.Interval == 0 {
+		c.Interval = 3600
+	}
+}
+
+// ListExpiringPublicShares returns the links owned or created by owner
+// expiring within the given number of days, so that the users can
+// be warned before their links stop working.
+func (m *linkMgr) ListExpiringPublicShares(ctx context.Context, owner *userpb.UserId, days int) ([]*link.PublicShare, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	if days <= 0 {
+		return nil, errtypes.BadRequest("sql: the number of days must be positive")
+	}
+	uid := conversions.FormatUserID(owner)
+	query := "select " + linkColumns + ` FROM oc_share WHERE share_type=? AND deleted_at IS NULL AND (uid_owner=? or uid_initiator=?)
+				AND expiration IS NOT NULL AND expiration <= UTC_TIMESTAMP() + INTERVAL ? DAY AND ` + linkNotExpiredCondition + " ORDER BY expiration"
+	links, err := m.queryLinks(ctx, query, shareTypePublicLink, uid, uid, days)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*link.PublicShare, 0, len(links))
+	for _, l := range links {
+		res = append(res, l.toCS3())
+	}
+	return res, nil
+}
+
+// queryLinks returns the links selected by the query on linkColumns.
+func (m *linkMgr) queryLinks(ctx context.Context, query string, params ...interface{}) ([]*dbLink, error) {
+	rows, err := m.db.QueryContext(ctx, query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []*dbLink
+	for rows.Next() {
+		var l dbLink
+		if err := rows.Scan(l.scanArgs()...); err != nil {
+			return nil, err
+		}
+		links = append(links, &l)
+	}
+	return links, rows.Err()
+}
+
+// remindExpiringLinks is the periodic task publishing a link.expiring event
+// for the links entering the reminder window since the previous run, so
+// that each link is reminded once.
+func (m *linkMgr) remindExpiringLinks(ctx context.Context) error {
+	c := m.c.Links.ExpirationReminder
+	query := "select " + linkColumns + ` FROM oc_share WHERE share_type=? AND deleted_at IS NULL AND expiration IS NOT NULL
+				AND expiration <= UTC_TIMESTAMP() + INTERVAL ? DAY AND expiration > UTC_TIMESTAMP() + INTERVAL ? DAY - INTERVAL ? SECOND AND ` + linkNotExpiredCondition
+	links, err := m.queryLinks(ctx, query, shareTypePublicLink, c.Days, c.Days, c.Interval)
+	if err != nil {
+		return errors.Wrap(err, "sql: error listing the expiring links")
+	}
+
+	for _, l := range links {
+		err := m.events.Publish(ctx, &events.Event{
+			Type:       events.LinkExpiring,
+			Recipients: []string{l.UIDOwner},
+			Data: map[string]string{
+				"share_id":   l.ID,
+				"token":      l.Token,
+				"name":       l.ShareName,
+				"storage_id": l.Prefix,
+				"opaque_id":  l.ItemSource,
+				"expiration": l.Expiration,
+			},
+		})
+		if err != nil {
+			appctx.GetLogger(ctx).Error().Err(err).Str("share_id", l.ID).Msg("sql: error publishing link expiration event")
+		}
+	}
+	appctx.GetLogger(ctx).Info().Int("links", len(links)).Msg("sql: reminded expiring links")
+	return nil
+}
+
+// startExpirationReminders starts the reminders of the links about to expire, if enabled.
+func (m *linkMgr) startExpirationReminders() {
+	c := m.c.Links.ExpirationReminder
+	if c.Days > 0 {
+		m.runner.Every("sql: remind expiring links", time.Duration(c.Interval)*time.Second, false, m.remindExpiringLinks)
+	}
+}
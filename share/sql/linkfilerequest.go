@@ -0,0 +1,135 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sql
+
+import (
+	"context"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/cernbox/reva-plugins/events"
+	link "github.com/cs3org/go-cs3apis/cs3/sharing/link/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/cs3org/reva/pkg/appctx"
+	"github.com/cs3org/reva/pkg/errtypes"
+)
+
+// Types of the public links, in the link_type column.
+const (
+	linkTypeRegular     = 0
+	linkTypeFileRequest = 1
+)
+
+// fileRequestPermissions are the permissions stored for the file requests,
+// only used for the expiration policy: the file requests are granted
+// fileRequestResourcePermissions regardless of the column.
+const fileRequestPermissions = 4
+
+// defaultUploadTarget is the subfolder of the uploads to a file request.
+const defaultUploadTarget = "{uploader}"
+
+// FilterTypeFileRequests lists only the file requests.
+const FilterTypeFileRequests link.ListPublicSharesRequest_Filter_Type = 102
+
+// fileRequestResourcePermissions are the permissions of the file requests:
+// the uploaders can create files and folders, but neither list nor read
+// the content of the folder.
+func fileRequestResourcePermissions() *provider.ResourcePermissions {
+	return &provider.ResourcePermissions{
+		Stat:               true,
+		CreateContainer:    true,
+		InitiateFileUpload: true,
+	}
+}
+
+// checkUploadTarget validates the template of the subfolder of the uploads
+// to a file request, with the {uploader} and {date} placeholders.
+func checkUploadTarget(target string) error {
+	if len(target) > 255 {
+		return errtypes.BadRequest("sql: the upload target is too long")
+	}
+	resolved := path.Clean(strings.NewReplacer("{uploader}", "u", "{date}", "d").Replace(target))
+	if path.IsAbs(resolved) || resolved == ".." || strings.HasPrefix(resolved, "../") {
+		return errtypes.BadRequest("sql: the upload target must be relative to the shared folder")
+	}
+	return nil
+}
+
+// uploaderReplacer strips from the name of an uploader
+// the characters not allowed in a file name.
+var uploaderReplacer = strings.NewReplacer("/", "_", "\\", "_", "\x00", "")
+
+// FileRequestTarget returns the path, relative to the shared folder, where
+// the uploader stores the files uploaded to the file request with the
+// given token. The uploader is the name given by the anonymous user.
+// It is called by the upload to the file request, and notifies the
+// owner of the link and the extra recipients of the upload.
+func (m *linkMgr) FileRequestTarget(ctx context.Context, token, uploader string) (string, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	l, err := m.getLink(ctx, &link.PublicShareReference{Spec: &link.PublicShareReference_Token{Token: token}})
+	if err != nil {
+		return "", err
+	}
+	if l.LinkType != linkTypeFileRequest || l.expired() || !l.active() {
+		return "", errtypes.NotFound(token)
+	}
+
+	uploader = strings.Trim(uploaderReplacer.Replace(strings.TrimSpace(uploader)), ".")
+	if uploader == "" {
+		uploader = "anonymous"
+	}
+	target := l.UploadTarget
+	if target == "" {
+		target = defaultUploadTarget
+	}
+	target = path.Clean(strings.NewReplacer("{uploader}", uploader, "{date}", time.Now().UTC().Format("2006-01-02")).Replace(target))
+	m.publishLinkUpload(ctx, l, uploader, target)
+	return target, nil
+}
+
+func (m *linkMgr) publishLinkUpload(ctx context.Context, l *dbLink, uploader, target string) {
+	if !l.NotifyUploads {
+		return
+	}
+	recipients := []string{l.UIDOwner}
+	for _, r := range strings.Split(l.NotifyUploadsExtraRecipients, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			recipients = append(recipients, r)
+		}
+	}
+	err := m.events.Publish(ctx, &events.Event{
+		Type:       events.LinkUpload,
+		Actor:      uploader,
+		Recipients: recipients,
+		Data: map[string]string{
+			"share_id":   l.ID,
+			"token":      l.Token,
+			"name":       l.ShareName,
+			"storage_id": l.Prefix,
+			"opaque_id":  l.ItemSource,
+			"target":     target,
+		},
+	})
+	if err != nil {
+		appctx.GetLogger(ctx).Error().Err(err).Str("share_id", l.ID).Msg("sql: error publishing upload event")
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sql
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/cernbox/reva-plugins/ratelimit"
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	link "github.com/cs3org/go-cs3apis/cs3/sharing/link/v1beta1"
+	conversions "github.com/cs3org/reva/pkg/cbox/utils"
+	"github.com/cs3org/reva/pkg/errtypes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// maxAllowedNetworksLength is the size of the allowed_networks column.
+const maxAllowedNetworksLength = 1024
+
+// formatNetworks validates the networks a link is restricted to
+// and returns the value of the allowed_networks column.
+func formatNetworks(networks []string) (string, error) {
+	cidrs := make([]string, 0, len(networks))
+	for _, n := range networks {
+		n = strings.TrimSpace(n)
+		if n == "" {
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(n)
+		if err != nil {
+			return "", errtypes.BadRequest("sql: invalid network " + n)
+		}
+		cidrs = append(cidrs, ipnet.String())
+	}
+	value := strings.Join(cidrs, ",")
+	if len(value) > maxAllowedNetworksLength {
+		return "", errtypes.BadRequest("sql: too many allowed networks")
+	}
+	return value, nil
+}
+
+// clientIP returns the ip of the client accessing a link. The gateway
+// forwards it in the x-forwarded-for and x-real-ip grpc metadata, honoured
+// only if the gateway is one of the trusted proxies, as they can be set by
+// anyone reaching the service. It is empty if unknown.
+func (m *linkMgr) clientIP(ctx context.Context) string {
+	var remote string
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		remote = p.Addr.String()
+		if host, _, err := net.SplitHostPort(remote); err == nil {
+			remote = host
+		}
+	}
+	var forwardedFor, realIP string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		forwardedFor = strings.Join(md.Get("x-forwarded-for"), ",")
+		if v := md.Get("x-real-ip"); len(v) > 0 {
+			realIP = v[0]
+		}
+	}
+	return ratelimit.ForwardedClientIP(remote, forwardedFor, realIP, m.trustedProxies)
+}
+
+// networksAllow returns true if the ip is in one of the comma separated
+// networks. The clients with an unknown ip are not allowed.
+func networksAllow(networks, ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, n := range strings.Split(networks, ",") {
+		if _, ipnet, err := net.ParseCIDR(n); err == nil && ipnet.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetPublicShareAllowedNetworks restricts the accesses to a link owned or
+// created by the user to the given networks, lifting the restriction if
+// there are none. The cs3 update of the links does not support it.
+func (m *linkMgr) SetPublicShareAllowedNetworks(ctx context.Context, u *userpb.User, ref *link.PublicShareReference, networks []string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	value, err := formatNetworks(networks)
+	if err != nil {
+		return err
+	}
+	cond, params, err := linkRefCondition(ref)
+	if err != nil {
+		return err
+	}
+	uid := conversions.FormatUserID(u.Id)
+	query := "update oc_share set allowed_networks=? where share_type=? AND deleted_at IS NULL AND (uid_owner=? or uid_initiator=?) AND " + cond
+	params = append([]interface{}{nullIfEmpty(value), shareTypePublicLink, uid, uid}, params...)
+	res, err := m.db.ExecContext(ctx, query, params...)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		// mysql does not count the rows left unchanged by the update
+		if _, err := m.getLinkOf(ctx, u, ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
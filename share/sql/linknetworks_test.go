@@ -0,0 +1,97 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sql
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/cernbox/reva-plugins/ratelimit"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func TestNetworksAllow(t *testing.T) {
+	networks, err := formatNetworks([]string{"137.138.0.0/16", " 2001:1458::/32", ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ip      string
+		allowed bool
+	}{
+		{"137.138.12.34", true},
+		{"2001:1458:201::1", true},
+		{"8.8.8.8", false},
+		{"", false},
+		{"not-an-ip", false},
+	}
+	for _, tt := range tests {
+		if got := networksAllow(networks, tt.ip); got != tt.allowed {
+			t.Errorf("networksAllow(%q) = %t, want %t", tt.ip, got, tt.allowed)
+		}
+	}
+
+	if _, err := formatNetworks([]string{"137.138.0.0"}); err == nil {
+		t.Error("formatNetworks accepted an address without mask")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ratelimit.ParseCIDRs([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &linkMgr{trustedProxies: trusted}
+
+	tests := []struct {
+		name   string
+		peer   string
+		fwd    string
+		realIP string
+		want   string
+	}{
+		{name: "no peer", fwd: "5.6.7.8", want: ""},
+		{name: "direct", peer: "1.2.3.4", want: "1.2.3.4"},
+		{name: "untrusted peer forging metadata", peer: "1.2.3.4", fwd: "5.6.7.8", realIP: "5.6.7.8", want: "1.2.3.4"},
+		{name: "trusted gateway", peer: "10.0.0.1", fwd: "9.9.9.9, 5.6.7.8", want: "5.6.7.8"},
+		{name: "real ip from trusted gateway", peer: "10.0.0.1", realIP: "5.6.7.8", want: "5.6.7.8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.peer != "" {
+				ctx = peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(tt.peer), Port: 5000}})
+			}
+			md := metadata.MD{}
+			if tt.fwd != "" {
+				md.Set("x-forwarded-for", tt.fwd)
+			}
+			if tt.realIP != "" {
+				md.Set("x-real-ip", tt.realIP)
+			}
+			ctx = metadata.NewIncomingContext(ctx, md)
+			if got := m.clientIP(ctx); got != tt.want {
+				t.Fatalf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
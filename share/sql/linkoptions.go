@@ -0,0 +1,46 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sql
+
+import (
+	"time"
+)
+
+// LinkOptions are the settings of a new public link not supported
+// by the cs3 public share provider, see CreatePublicShareWithOptions.
+type LinkOptions struct {
+	// MaxAccesses expires the link after the given number of accesses,
+	// see ConsumePublicShareAccess. The accesses are unlimited if 0.
+	MaxAccesses int
+	// NotBefore is the time the link becomes accessible, for the
+	// links created ahead of an embargo. Accessible at once if zero.
+	NotBefore time.Time
+	// Token is the custom token requested for the link, instead of a
+	// random one. It requires the user to be allowed by the configuration.
+	Token string
+	// AllowedNetworks restricts the accesses to the link to the clients
+	// in the given networks, in CIDR notation, e.g. the CERN network.
+	AllowedNetworks []string
+	// FileRequest creates a file request: a link to a folder where
+	// anonymous users can only upload, each in its own subfolder.
+	FileRequest bool
+	// UploadTarget is the template of the subfolder of the uploads
+	// to a file request, see FileRequestTarget. Defaults to "{uploader}".
+	UploadTarget string
+}
@@ -0,0 +1,156 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sql
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+
+	typespb "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
+	"github.com/cs3org/reva/pkg/errtypes"
+)
+
+// linkExpirationPolicy caps the expiration of the public links, in days,
+// by permission level. The links of a level are not capped if 0.
+type linkExpirationPolicy struct {
+	// Editable is the maximum expiration of the links granting write access.
+	Editable int `mapstructure:"editable" validate:"min=0"`
+	// ReadOnly is the maximum expiration of the other links.
+	ReadOnly int `mapstructure:"read_only" validate:"min=0"`
+}
+
+// check evaluates the policy for a link with the given permissions and
+// expiration. The expiration can be up to the end of the last allowed day,
+// as set by the clients picking a date.
+func (p *linkExpirationPolicy) check(permissions int, expiration *typespb.Timestamp) error {
+	maxDays, level := p.ReadOnly, "read-only"
+	if permissions&writePermissions != 0 {
+		maxDays, level = p.Editable, "editable"
+	}
+	if maxDays == 0 {
+		return nil
+	}
+
+	limit := time.Now().UTC().AddDate(0, 0, maxDays+1).Truncate(24 * time.Hour)
+	if expiration == nil || expiration.Seconds == 0 || int64(expiration.Seconds) > limit.Unix() {
+		return errtypes.BadRequest(fmt.Sprintf("sql: %s links must expire within %d days", level, maxDays))
+	}
+	return nil
+}
+
+// The rules of the password policy, reported by PasswordPolicyError.
+const (
+	PasswordRuleMinLength        = "min_length"
+	PasswordRuleCharacterClasses = "character_classes"
+	PasswordRuleBanned           = "banned"
+)
+
+// PasswordPolicyError is returned when the password of a link does not
+// comply with the password policy. It is a bad request, and its message
+// starts with the rule, so that the clients can tell which one failed.
+type PasswordPolicyError struct {
+	// Rule is the rule that failed, one of the PasswordRule constants.
+	Rule    string
+	Message string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return "password policy " + e.Rule + ": " + e.Message
+}
+
+// IsBadRequest marks the error as a bad request.
+func (e *PasswordPolicyError) IsBadRequest() {}
+
+// linkPasswordPolicy are the rules the passwords of the links must follow.
+type linkPasswordPolicy struct {
+	// MinLength is the minimum number of characters of the passwords.
+	MinLength int `mapstructure:"min_length" validate:"min=0"`
+	// MinCharacterClasses is the minimum number of character classes,
+	// among lowercase and uppercase letters, digits and symbols,
+	// the passwords must contain.
+	MinCharacterClasses int `mapstructure:"min_character_classes" validate:"min=0,max=4"`
+	// BannedPasswords are the passwords not allowed, case insensitive.
+	BannedPasswords []string `mapstructure:"banned_passwords"`
+	// BannedPasswordsFile is a file with more banned passwords, one per line.
+	BannedPasswordsFile string `mapstructure:"banned_passwords_file"`
+}
+
+// bannedPasswords returns the banned passwords, lowercase.
+func (p *linkPasswordPolicy) bannedPasswords() (map[string]struct{}, error) {
+	banned := make(map[string]struct{}, len(p.BannedPasswords))
+	for _, pw := range p.BannedPasswords {
+		banned[strings.ToLower(pw)] = struct{}{}
+	}
+	if p.BannedPasswordsFile == "" {
+		return banned, nil
+	}
+
+	f, err := os.Open(p.BannedPasswordsFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if pw := strings.TrimSpace(scanner.Text()); pw != "" {
+			banned[strings.ToLower(pw)] = struct{}{}
+		}
+	}
+	return banned, scanner.Err()
+}
+
+// checkPassword evaluates the password policy for the password of a link.
+func (m *linkMgr) checkPassword(password string) error {
+	p := &m.c.Links.PasswordPolicy
+	if len([]rune(password)) < p.MinLength {
+		return &PasswordPolicyError{Rule: PasswordRuleMinLength, Message: fmt.Sprintf("the password must be at least %d characters long", p.MinLength)}
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	classes := 0
+	for _, c := range []bool{lower, upper, digit, symbol} {
+		if c {
+			classes++
+		}
+	}
+	if classes < p.MinCharacterClasses {
+		return &PasswordPolicyError{Rule: PasswordRuleCharacterClasses, Message: fmt.Sprintf("the password must contain at least %d of lowercase and uppercase letters, digits and symbols", p.MinCharacterClasses)}
+	}
+
+	if _, ok := m.bannedPasswords[strings.ToLower(password)]; ok {
+		return &PasswordPolicyError{Rule: PasswordRuleBanned, Message: "the password is too common"}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sql
+
+import (
+	"testing"
+)
+
+func TestCheckPassword(t *testing.T) {
+	policy := linkPasswordPolicy{MinLength: 8, MinCharacterClasses: 3, BannedPasswords: []string{"Password-123"}}
+	banned, err := policy.bannedPasswords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &linkMgr{mgr: &mgr{c: &config{Links: linksConfig{PasswordPolicy: policy}}}, bannedPasswords: banned}
+
+	tests := []struct {
+		password string
+		rule     string
+	}{
+		{"Ab1!", PasswordRuleMinLength},
+		{"abcdefgh1", PasswordRuleCharacterClasses},
+		{"PASSWORD-123", PasswordRuleBanned},
+		{"Correct-horse-1", ""},
+		{"àèìòù-ÀÈÌ", ""},
+	}
+	for _, tt := range tests {
+		err := m.checkPassword(tt.password)
+		switch {
+		case tt.rule == "" && err != nil:
+			t.Errorf("%q: unexpected error %v", tt.password, err)
+		case tt.rule != "":
+			perr, ok := err.(*PasswordPolicyError)
+			if !ok || perr.Rule != tt.rule {
+				t.Errorf("%q: expected a failure of the %s rule, got %v", tt.password, tt.rule, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,884 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cernbox/reva-plugins/events"
+	"github.com/cernbox/reva-plugins/ratelimit"
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	link "github.com/cs3org/go-cs3apis/cs3/sharing/link/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	typespb "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
+	"github.com/cs3org/reva"
+	"github.com/cs3org/reva/pkg/appctx"
+	conversions "github.com/cs3org/reva/pkg/cbox/utils"
+	"github.com/cs3org/reva/pkg/errtypes"
+	"github.com/cs3org/reva/pkg/publicshare"
+	"github.com/cs3org/reva/pkg/utils"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	shareTypePublicLink = 3
+
+	// linkTokenLength is the length of the generated tokens of the links.
+	linkTokenLength = 15
+	// passwordHashPrefix prefixes the bcrypt hashes of the link
+	// passwords, stored in the share_with column.
+	passwordHashPrefix = "1|"
+)
+
+// linkColumns are the columns of oc_share read for a public link, in the
+// order of dbLink.scanArgs.
+const linkColumns = `id, coalesce(uid_owner, ''), coalesce(uid_initiator, ''), coalesce(fileid_prefix, ''), coalesce(item_source, ''), coalesce(item_type, ''),
+				coalesce(token, ''), coalesce(share_with, ''), coalesce(expiration, ''), coalesce(share_name, ''), coalesce(description, ''),
+				coalesce(notify_uploads_extra_recipients, ''), permissions, stime, coalesce(quicklink, 0), coalesce(notify_uploads, 0),
+				coalesce(remaining_accesses, -1), coalesce(not_before, ''), coalesce(internal, 0), coalesce(allowed_networks, ''),
+				coalesce(link_type, 0), coalesce(upload_target, '')`
+
+// linkNotExpiredCondition excludes the expired links from the queries,
+// including the ones with no accesses left.
+const linkNotExpiredCondition = notExpiredCondition + " AND (remaining_accesses IS NULL OR remaining_accesses > 0)"
+
+// linkActiveCondition excludes the links not yet accessible.
+const linkActiveCondition = "(not_before IS NULL OR not_before <= UTC_TIMESTAMP())"
+
+// FilterTypeScheduledLinks lists only the links not yet accessible,
+// excluded otherwise from the listings as the expired ones.
+const FilterTypeScheduledLinks link.ListPublicSharesRequest_Filter_Type = 100
+
+func init() {
+	reva.RegisterPlugin(linkMgr{})
+}
+
+func (linkMgr) RevaPlugin() reva.PluginInfo {
+	return reva.PluginInfo{
+		ID:  "grpc.services.publicshareprovider.drivers.sql",
+		New: NewPublicShareManager,
+	}
+}
+
+// linksConfig configures the public links, stored in
+// oc_share with the share type 3.
+type linksConfig struct {
+	// PasswordHashCost is the bcrypt cost of the hashes
+	// of the link passwords. Defaults to 11.
+	PasswordHashCost int `mapstructure:"password_hash_cost" validate:"min=0,max=31"`
+	// MaxExpiration caps the expiration of the links, by permission level.
+	MaxExpiration linkExpirationPolicy `mapstructure:"max_expiration"`
+	// PasswordPolicy are the rules the passwords of the links must follow.
+	PasswordPolicy linkPasswordPolicy `mapstructure:"password_policy"`
+	// BruteForce protects the password protected links against brute force attacks.
+	BruteForce bruteForceConfig `mapstructure:"brute_force"`
+	// CustomTokens configures the custom tokens of the links.
+	CustomTokens customTokensConfig `mapstructure:"custom_tokens"`
+	// SignWorkers is the number of workers computing the signatures
+	// of the listed links. Defaults to the number of cpus.
+	SignWorkers int `mapstructure:"sign_workers" validate:"min=0"`
+	// ExpirationReminder reminds the owners of the links about to expire.
+	ExpirationReminder expirationReminderConfig `mapstructure:"expiration_reminder"`
+	// TrustedProxies are the ips or networks of the gateways, whose
+	// x-forwarded-for and x-real-ip metadata give the ip of the clients
+	// accessing the links. The ip of the peer is used otherwise.
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
+}
+
+func (c *linksConfig) applyDefaults() {
+	if c.PasswordHashCost == 0 {
+		c.PasswordHashCost = 11
+	}
+	c.BruteForce.applyDefaults()
+	if c.CustomTokens.MinLength == 0 {
+		c.CustomTokens.MinLength = 8
+	}
+	if c.SignWorkers == 0 {
+		c.SignWorkers = runtime.NumCPU()
+	}
+	c.ExpirationReminder.applyDefaults()
+}
+
+// linkMgr is the manager of the public links. It shares the
+// configuration and the db of the user share manager.
+type linkMgr struct {
+	*mgr
+	bannedPasswords map[string]struct{}
+	// trustedProxies are the peers forwarding the ip of the clients.
+	trustedProxies []*net.IPNet
+	// failures counts the failed password attempts, nil if not protected.
+	failures failureStore
+	signer   *signer
+}
+
+// NewPublicShareManager returns a new public link manager.
+func NewPublicShareManager(ctx context.Context, m map[string]interface{}) (publicshare.Manager, error) {
+	lm, err := newLinkManager(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+	lm.startExpirationReminders()
+	return &instrumentedLinkMgr{linkMgr: lm}, nil
+}
+
+func newLinkManager(ctx context.Context, m map[string]interface{}) (*linkMgr, error) {
+	manager, err := newManager(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+	banned, err := manager.c.Links.PasswordPolicy.bannedPasswords()
+	if err != nil {
+		manager.Close()
+		return nil, errors.Wrap(err, "sql: error reading the banned passwords")
+	}
+	trusted, err := ratelimit.ParseCIDRs(manager.c.Links.TrustedProxies)
+	if err != nil {
+		manager.Close()
+		return nil, errors.Wrap(err, "sql: error parsing the trusted proxies")
+	}
+	lm := &linkMgr{
+		mgr:             manager,
+		bannedPasswords: banned,
+		trustedProxies:  trusted,
+		signer:          newSigner(manager.runner, manager.c.Links.SignWorkers),
+	}
+	if manager.c.Links.BruteForce.MaxFailures > 0 {
+		lm.failures = newFailureStore(&manager.c.Links.BruteForce)
+	}
+	return lm, nil
+}
+
+// Close releases the counters of the failed attempts and closes the manager.
+func (m *linkMgr) Close() error {
+	if m.failures != nil {
+		_ = m.failures.close()
+	}
+	return m.mgr.Close()
+}
+
+// dbLink is a row of oc_share of a public link.
+type dbLink struct {
+	ID           string
+	UIDOwner     string
+	UIDInitiator string
+	Prefix       string
+	ItemSource   string
+	ItemType     string
+	Token        string
+	// Password is the hash of the password, empty if the link is not protected.
+	Password                     string
+	Expiration                   string
+	ShareName                    string
+	Description                  string
+	NotifyUploadsExtraRecipients string
+	Permissions                  int
+	STime                        int64
+	Quicklink                    bool
+	NotifyUploads                bool
+	// RemainingAccesses is the number of accesses left
+	// before the link expires, -1 if unlimited.
+	RemainingAccesses int
+	// NotBefore is the time the link becomes accessible, empty if it is.
+	NotBefore string
+	// Internal links are only accessible to the authenticated users.
+	Internal bool
+	// AllowedNetworks are the comma separated networks the link
+	// is accessible from, empty if not restricted.
+	AllowedNetworks string
+	// LinkType is the type of the link, linkTypeFileRequest for the file requests.
+	LinkType int
+	// UploadTarget is the template of the subfolder of the uploads to a file request.
+	UploadTarget string
+}
+
+func (l *dbLink) scanArgs() []interface{} {
+	return []interface{}{&l.ID, &l.UIDOwner, &l.UIDInitiator, &l.Prefix, &l.ItemSource, &l.ItemType,
+		&l.Token, &l.Password, &l.Expiration, &l.ShareName, &l.Description,
+		&l.NotifyUploadsExtraRecipients, &l.Permissions, &l.STime, &l.Quicklink, &l.NotifyUploads,
+		&l.RemainingAccesses, &l.NotBefore, &l.Internal, &l.AllowedNetworks,
+		&l.LinkType, &l.UploadTarget}
+}
+
+// expired returns true if the link expired or has no accesses left.
+func (l *dbLink) expired() bool {
+	return isExpired(parseExpiration(l.Expiration)) || l.RemainingAccesses == 0
+}
+
+// active returns true if the link is already accessible.
+func (l *dbLink) active() bool {
+	notBefore := parseExpiration(l.NotBefore)
+	return notBefore == nil || int64(notBefore.Seconds) <= time.Now().Unix()
+}
+
+func (l *dbLink) toCS3() *link.PublicShare {
+	ts := &typespb.Timestamp{Seconds: uint64(l.STime)}
+	permissions := conversions.IntTosharePerm(l.Permissions, l.ItemType)
+	if l.LinkType == linkTypeFileRequest {
+		permissions = fileRequestResourcePermissions()
+	}
+	return &link.PublicShare{
+		Id:         &link.PublicShareId{OpaqueId: l.ID},
+		Token:      l.Token,
+		ResourceId: &provider.ResourceId{StorageId: l.Prefix, OpaqueId: l.ItemSource},
+		Permissions: &link.PublicSharePermissions{
+			Permissions: permissions,
+		},
+		Owner:                        &userpb.UserId{OpaqueId: l.UIDOwner},
+		Creator:                      &userpb.UserId{OpaqueId: l.UIDInitiator},
+		Ctime:                        ts,
+		Mtime:                        ts,
+		PasswordProtected:            l.Password != "",
+		Expiration:                   parseExpiration(l.Expiration),
+		DisplayName:                  l.ShareName,
+		Quicklink:                    l.Quicklink,
+		Description:                  l.Description,
+		NotifyUploads:                l.NotifyUploads,
+		NotifyUploadsExtraRecipients: l.NotifyUploadsExtraRecipients,
+	}
+}
+
+func (m *linkMgr) CreatePublicShare(ctx context.Context, u *userpb.User, md *provider.ResourceInfo, g *link.Grant, description string, internal bool, notifyUploads bool, notifyUploadsExtraRecipients string) (*link.PublicShare, error) {
+	return m.CreatePublicShareWithOptions(ctx, u, md, g, description, internal, notifyUploads, notifyUploadsExtraRecipients, &LinkOptions{})
+}
+
+// CreatePublicShareWithOptions creates a public link with the options
+// not supported by the cs3 public share provider.
+func (m *linkMgr) CreatePublicShareWithOptions(ctx context.Context, u *userpb.User, md *provider.ResourceInfo, g *link.Grant, description string, internal bool, notifyUploads bool, notifyUploadsExtraRecipients string, opts *LinkOptions) (*link.PublicShare, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	if isExpired(g.Expiration) {
+		return nil, errtypes.BadRequest("sql: expiration must be in the future")
+	}
+	permissions := conversions.SharePermToInt(g.Permissions.GetPermissions())
+	linkType, uploadTarget := linkTypeRegular, ""
+	if opts.FileRequest {
+		if md.Type != provider.ResourceType_RESOURCE_TYPE_CONTAINER {
+			return nil, errtypes.BadRequest("sql: file requests can only be created on folders")
+		}
+		if err := checkUploadTarget(opts.UploadTarget); err != nil {
+			return nil, err
+		}
+		// the owners of a file request are always notified of the uploads
+		permissions, linkType, uploadTarget, notifyUploads = fileRequestPermissions, linkTypeFileRequest, opts.UploadTarget, true
+	}
+	if err := m.c.Links.MaxExpiration.check(permissions, g.Expiration); err != nil {
+		return nil, err
+	}
+	if opts.MaxAccesses < 0 {
+		return nil, errtypes.BadRequest("sql: the maximum number of accesses must be positive")
+	}
+	var notBefore *typespb.Timestamp
+	if !opts.NotBefore.IsZero() {
+		notBefore = &typespb.Timestamp{Seconds: uint64(opts.NotBefore.Unix())}
+		if g.Expiration != nil && g.Expiration.Seconds != 0 && notBefore.Seconds >= g.Expiration.Seconds {
+			return nil, errtypes.BadRequest("sql: the link must become accessible before expiring")
+		}
+	}
+
+	allowedNetworks, err := formatNetworks(opts.AllowedNetworks)
+	if err != nil {
+		return nil, err
+	}
+
+	token := utils.RandString(linkTokenLength)
+	if opts.Token != "" {
+		if err := m.checkCustomToken(ctx, u, opts.Token); err != nil {
+			return nil, err
+		}
+		token = opts.Token
+	}
+	displayName, quicklink := token, false
+	if md.ArbitraryMetadata != nil {
+		if name := md.ArbitraryMetadata.Metadata["name"]; name != "" {
+			displayName = name
+		}
+		quicklink, _ = strconv.ParseBool(md.ArbitraryMetadata.Metadata["quicklink"])
+	}
+	// a resource has at most one quicklink per owner
+	if quicklink {
+		s, err := m.GetQuicklinkForResource(ctx, md.Owner, md.Id)
+		if err == nil {
+			return s, nil
+		}
+		if _, ok := err.(errtypes.NotFound); !ok {
+			return nil, err
+		}
+	}
+
+	var password string
+	if g.Password != "" {
+		if err := m.checkPassword(g.Password); err != nil {
+			return nil, err
+		}
+		var err error
+		if password, err = m.hashPassword(g.Password); err != nil {
+			return nil, err
+		}
+	}
+
+	fileSource, err := strconv.ParseUint(md.Id.OpaqueId, 10, 64)
+	if err != nil {
+		// the item source may be a character string
+		fileSource = 0
+	}
+
+	l := &dbLink{
+		UIDOwner:                     conversions.FormatUserID(md.Owner),
+		UIDInitiator:                 conversions.FormatUserID(u.Id),
+		Prefix:                       md.Id.StorageId,
+		ItemSource:                   md.Id.OpaqueId,
+		ItemType:                     conversions.ResourceTypeToItem(md.Type),
+		Token:                        token,
+		Password:                     password,
+		ShareName:                    displayName,
+		Description:                  description,
+		NotifyUploadsExtraRecipients: notifyUploadsExtraRecipients,
+		Permissions:                  permissions,
+		STime:                        time.Now().Unix(),
+		Quicklink:                    quicklink,
+		NotifyUploads:                notifyUploads,
+		RemainingAccesses:            -1,
+		Internal:                     internal,
+		AllowedNetworks:              allowedNetworks,
+		LinkType:                     linkType,
+		UploadTarget:                 uploadTarget,
+	}
+	var remainingAccesses interface{}
+	if opts.MaxAccesses > 0 {
+		l.RemainingAccesses = opts.MaxAccesses
+		remainingAccesses = opts.MaxAccesses
+	}
+	expiration := formatExpiration(g.Expiration)
+	if expiration != nil {
+		l.Expiration = expiration.(string)
+	}
+	notBeforeValue := formatExpiration(notBefore)
+	if notBeforeValue != nil {
+		l.NotBefore = notBeforeValue.(string)
+	}
+
+	query := `insert into oc_share set share_type=?,uid_owner=?,uid_initiator=?,item_type=?,fileid_prefix=?,item_source=?,file_source=?,permissions=?,stime=?,
+				token=?,share_with=?,expiration=?,share_name=?,quicklink=?,description=?,notify_uploads=?,notify_uploads_extra_recipients=?,remaining_accesses=?,not_before=?,internal=?,allowed_networks=?,link_type=?,upload_target=?,initial_path=?`
+	params := []interface{}{shareTypePublicLink, l.UIDOwner, l.UIDInitiator, l.ItemType, l.Prefix, l.ItemSource, fileSource, l.Permissions, l.STime,
+		l.Token, nullIfEmpty(l.Password), expiration, l.ShareName, l.Quicklink, l.Description, l.NotifyUploads, l.NotifyUploadsExtraRecipients, remainingAccesses, notBeforeValue, l.Internal, nullIfEmpty(l.AllowedNetworks),
+		l.LinkType, nullIfEmpty(l.UploadTarget), nullIfEmpty(md.Path)}
+	res, err := m.db.ExecContext(ctx, query, params...)
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	l.ID = strconv.FormatInt(id, 10)
+	m.publishLinkCreated(ctx, u, md, l)
+	return l.toCS3(), nil
+}
+
+func (m *linkMgr) publishLinkCreated(ctx context.Context, u *userpb.User, md *provider.ResourceInfo, l *dbLink) {
+	err := m.events.Publish(ctx, &events.Event{
+		Type:       events.LinkCreated,
+		Actor:      u.Username,
+		Recipients: []string{l.UIDOwner},
+		Data: map[string]string{
+			"share_id":     l.ID,
+			"token":        l.Token,
+			"name":         l.ShareName,
+			"path":         md.Path,
+			"storage_id":   l.Prefix,
+			"opaque_id":    l.ItemSource,
+			"permissions":  strconv.Itoa(l.Permissions),
+			"file_request": strconv.FormatBool(l.LinkType == linkTypeFileRequest),
+			"expiration":   l.Expiration,
+		},
+	})
+	if err != nil {
+		appctx.GetLogger(ctx).Error().Err(err).Str("share_id", l.ID).Msg("sql: error publishing link event")
+	}
+}
+
+func (m *linkMgr) UpdatePublicShare(ctx context.Context, u *userpb.User, req *link.UpdatePublicShareRequest, g *link.Grant) (*link.PublicShare, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	update := req.GetUpdate()
+
+	// the expiration policy depends on both the permissions and the expiration
+	var current *dbLink
+	switch update.GetType() {
+	case link.UpdatePublicShareRequest_Update_TYPE_PERMISSIONS, link.UpdatePublicShareRequest_Update_TYPE_EXPIRATION:
+		var err error
+		if current, err = m.getLinkOf(ctx, u, req.GetRef()); err != nil {
+			return nil, err
+		}
+	}
+
+	var set string
+	var value interface{}
+	switch update.GetType() {
+	case link.UpdatePublicShareRequest_Update_TYPE_PERMISSIONS:
+		if current.LinkType == linkTypeFileRequest {
+			return nil, errtypes.BadRequest("sql: the permissions of a file request can not be changed")
+		}
+		permissions := conversions.SharePermToInt(update.GetGrant().GetPermissions().GetPermissions())
+		if err := m.c.Links.MaxExpiration.check(permissions, parseExpiration(current.Expiration)); err != nil {
+			return nil, err
+		}
+		set, value = "permissions=?", permissions
+	case link.UpdatePublicShareRequest_Update_TYPE_PASSWORD:
+		set = "share_with=?"
+		if pw := update.GetGrant().GetPassword(); pw != "" {
+			if err := m.checkPassword(pw); err != nil {
+				return nil, err
+			}
+			hash, err := m.hashPassword(pw)
+			if err != nil {
+				return nil, err
+			}
+			value = hash
+		}
+	case link.UpdatePublicShareRequest_Update_TYPE_EXPIRATION:
+		expiration := update.GetGrant().GetExpiration()
+		if isExpired(expiration) {
+			return nil, errtypes.BadRequest("sql: expiration must be in the future")
+		}
+		if err := m.c.Links.MaxExpiration.check(current.Permissions, expiration); err != nil {
+			return nil, err
+		}
+		set, value = "expiration=?", formatExpiration(expiration)
+	case link.UpdatePublicShareRequest_Update_TYPE_DISPLAYNAME:
+		set, value = "share_name=?", update.GetDisplayName()
+	case link.UpdatePublicShareRequest_Update_TYPE_DESCRIPTION:
+		set, value = "description=?", update.GetDescription()
+	case link.UpdatePublicShareRequest_Update_TYPE_NOTIFYUPLOADS:
+		set, value = "notify_uploads=?", update.GetNotifyUploads()
+	case link.UpdatePublicShareRequest_Update_TYPE_NOTIFYUPLOADSEXTRARECIPIENTS:
+		set, value = "notify_uploads_extra_recipients=?", update.GetNotifyUploadsExtraRecipients()
+	default:
+		return nil, errtypes.NotSupported(fmt.Sprintf("sql: updating %s is not supported", update.GetType()))
+	}
+
+	cond, params, err := linkRefCondition(req.GetRef())
+	if err != nil {
+		return nil, err
+	}
+	uid := conversions.FormatUserID(u.Id)
+	query := "update oc_share set " + set + " where share_type=? AND deleted_at IS NULL AND (uid_owner=? or uid_initiator=?) AND " + cond
+	params = append([]interface{}{value, shareTypePublicLink, uid, uid}, params...)
+	res, err := m.db.ExecContext(ctx, query, params...)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		// mysql does not count the rows left unchanged by the update
+		if _, err := m.getLinkOf(ctx, u, req.GetRef()); err != nil {
+			return nil, err
+		}
+	}
+	return m.GetPublicShare(ctx, u, req.GetRef(), false)
+}
+
+func (m *linkMgr) GetPublicShare(ctx context.Context, u *userpb.User, ref *link.PublicShareReference, sign bool) (*link.PublicShare, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	l, err := m.getLinkOf(ctx, u, ref)
+	if err != nil {
+		return nil, err
+	}
+	if l.expired() || !l.active() {
+		return nil, errtypes.NotFound(ref.String())
+	}
+	s := l.toCS3()
+	if sign && l.Password != "" {
+		if err := publicshare.AddSignature(s, l.Password); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (m *linkMgr) ListPublicShares(ctx context.Context, u *userpb.User, filters []*link.ListPublicSharesRequest_Filter, md *provider.ResourceInfo, sign bool) ([]*link.PublicShare, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := "select " + linkColumns + " FROM oc_share WHERE share_type=? AND deleted_at IS NULL AND " + linkNotExpiredCondition
+	params := []interface{}{shareTypePublicLink}
+
+	// the project admins see all the links of the resources of their projects
+	if md == nil || !m.isProjectAdmin(u, md.Path) {
+		uid := conversions.FormatUserID(u.Id)
+		query += " AND (uid_owner=? or uid_initiator=?)"
+		params = append(params, uid, uid)
+	}
+
+	var scheduled bool
+	var otherFilters []*link.ListPublicSharesRequest_Filter
+	for _, f := range filters {
+		switch f.Type {
+		case FilterTypeScheduledLinks:
+			scheduled = true
+		case FilterTypeSignedLinks:
+		default:
+			otherFilters = append(otherFilters, f)
+		}
+	}
+	if scheduled {
+		query += " AND not_before > UTC_TIMESTAMP()"
+	} else {
+		query += " AND " + linkActiveCondition
+	}
+
+	filterQuery, filterParams, err := translateLinkFilters(otherFilters)
+	if err != nil {
+		return nil, err
+	}
+	if filterQuery != "" {
+		query = fmt.Sprintf("%s AND (%s)", query, filterQuery)
+		params = append(params, filterParams...)
+	}
+
+	dbLinks, err := m.queryLinks(ctx, query, params...)
+	if err != nil {
+		return nil, err
+	}
+	links := make([]*link.PublicShare, 0, len(dbLinks))
+	for _, l := range dbLinks {
+		links = append(links, l.toCS3())
+	}
+
+	// the signatures are only computed for the links the client opted in
+	signed, resources := signedResources(filters)
+	if sign && signed {
+		toSign, shares := dbLinks, links
+		if resources != nil {
+			toSign, shares = nil, nil
+			for i, l := range dbLinks {
+				if resources[l.Prefix+"!"+l.ItemSource] {
+					toSign, shares = append(toSign, l), append(shares, links[i])
+				}
+			}
+		}
+		if err := m.signer.sign(ctx, toSign, shares); err != nil {
+			return nil, err
+		}
+	}
+	return links, nil
+}
+
+// RevokePublicShare soft deletes the link, that is purged with
+// the deleted shares after the configured restore window.
+func (m *linkMgr) RevokePublicShare(ctx context.Context, u *userpb.User, ref *link.PublicShareReference) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	cond, params, err := linkRefCondition(ref)
+	if err != nil {
+		return err
+	}
+	uid := conversions.FormatUserID(u.Id)
+	query := "update oc_share set deleted_at=? where share_type=? AND deleted_at IS NULL AND (uid_owner=? or uid_initiator=?) AND " + cond
+	params = append([]interface{}{time.Now().Unix(), shareTypePublicLink, uid, uid}, params...)
+	res, err := m.db.ExecContext(ctx, query, params...)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errtypes.NotFound(ref.String())
+	}
+	return nil
+}
+
+func (m *linkMgr) GetPublicShareByToken(ctx context.Context, token string, auth *link.PublicShareAuthentication, sign bool) (*link.PublicShare, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	l, err := m.getLink(ctx, &link.PublicShareReference{Spec: &link.PublicShareReference_Token{Token: token}})
+	if err != nil {
+		return nil, err
+	}
+	if l.expired() || !l.active() {
+		return nil, errtypes.NotFound(token)
+	}
+	if l.Internal {
+		if _, ok := appctx.ContextGetUser(ctx); !ok {
+			return nil, errtypes.PermissionDenied("sql: the link " + token + " is only accessible to authenticated users")
+		}
+	}
+	if l.AllowedNetworks != "" && !networksAllow(l.AllowedNetworks, m.clientIP(ctx)) {
+		return nil, errtypes.PermissionDenied("sql: the link " + token + " is not accessible from this network")
+	}
+	s := l.toCS3()
+	if l.Password != "" {
+		// only the password attempts are counted, the signatures
+		// are derived from the password by the provider
+		protected := m.failures != nil && auth.GetPassword() != ""
+		if protected {
+			if err := m.checkLockout(ctx, token); err != nil {
+				return nil, err
+			}
+		}
+		if !authenticate(s, l.Password, auth) {
+			if protected {
+				m.recordFailure(ctx, token)
+			}
+			return nil, errtypes.InvalidCredentials(token)
+		}
+	}
+	if sign && l.Password != "" {
+		if err := publicshare.AddSignature(s, l.Password); err != nil {
+			return nil, err
+		}
+	}
+	m.recordAccess(ctx, s)
+	return s, nil
+}
+
+// ConsumePublicShareAccess consumes one of the accesses left to the link
+// with the given token, if limited. It is called by the services opening
+// the link or serving its downloads, and not when the token is resolved,
+// as the gateway resolves it on every request. It fails if there are no
+// accesses left, also when consumed by a concurrent access.
+func (m *linkMgr) ConsumePublicShareAccess(ctx context.Context, token string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	l, err := m.getLink(ctx, &link.PublicShareReference{Spec: &link.PublicShareReference_Token{Token: token}})
+	if err != nil {
+		return err
+	}
+	if l.expired() || !l.active() {
+		return errtypes.NotFound(token)
+	}
+	if l.RemainingAccesses < 0 {
+		return nil
+	}
+
+	res, err := m.db.ExecContext(ctx, "update oc_share set remaining_accesses = remaining_accesses - 1 where id=? AND remaining_accesses > 0", l.ID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errtypes.NotFound(token)
+	}
+	return nil
+}
+
+// getLink returns the link referenced by ref, expired or not.
+func (m *linkMgr) getLink(ctx context.Context, ref *link.PublicShareReference) (*dbLink, error) {
+	cond, params, err := linkRefCondition(ref)
+	if err != nil {
+		return nil, err
+	}
+	var l dbLink
+	query := "select " + linkColumns + " FROM oc_share WHERE share_type=? AND deleted_at IS NULL AND " + cond
+	if err := m.db.QueryRowContext(ctx, query, append([]interface{}{shareTypePublicLink}, params...)...).Scan(l.scanArgs()...); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errtypes.NotFound(ref.String())
+		}
+		return nil, err
+	}
+	return &l, nil
+}
+
+// getLinkOf returns the link referenced by ref, if owned or created by u.
+func (m *linkMgr) getLinkOf(ctx context.Context, u *userpb.User, ref *link.PublicShareReference) (*dbLink, error) {
+	l, err := m.getLink(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	uid := conversions.FormatUserID(u.Id)
+	if l.UIDOwner != uid && l.UIDInitiator != uid {
+		return nil, errtypes.NotFound(ref.String())
+	}
+	return l, nil
+}
+
+func linkRefCondition(ref *link.PublicShareReference) (string, []interface{}, error) {
+	switch {
+	case ref.GetId() != nil:
+		return "id=?", []interface{}{ref.GetId().OpaqueId}, nil
+	case ref.GetToken() != "":
+		return "token=?", []interface{}{ref.GetToken()}, nil
+	}
+	return "", nil, errtypes.NotFound(ref.String())
+}
+
+// translateLinkFilters translates the filters of the links to an sql
+// condition. The filters of the same type are combined with OR, the
+// different types with AND.
+func translateLinkFilters(filters []*link.ListPublicSharesRequest_Filter) (string, []interface{}, error) {
+	grouped := map[link.ListPublicSharesRequest_Filter_Type][]*link.ListPublicSharesRequest_Filter{}
+	var types []link.ListPublicSharesRequest_Filter_Type
+	for _, f := range filters {
+		if _, ok := grouped[f.Type]; !ok {
+			types = append(types, f.Type)
+		}
+		grouped[f.Type] = append(grouped[f.Type], f)
+	}
+
+	var (
+		conds  []string
+		params []interface{}
+	)
+	for _, t := range types {
+		var or []string
+		for _, f := range grouped[t] {
+			switch t {
+			case link.ListPublicSharesRequest_Filter_TYPE_RESOURCE_ID:
+				or = append(or, "(fileid_prefix=? AND item_source=?)")
+				params = append(params, f.GetResourceId().GetStorageId(), f.GetResourceId().GetOpaqueId())
+			case link.ListPublicSharesRequest_Filter_TYPE_OWNER:
+				or = append(or, "uid_owner=?")
+				params = append(params, conversions.FormatUserID(f.GetOwner()))
+			case link.ListPublicSharesRequest_Filter_TYPE_CREATOR:
+				or = append(or, "uid_initiator=?")
+				params = append(params, conversions.FormatUserID(f.GetCreator()))
+			case FilterTypeFileRequests:
+				or = append(or, "link_type=?")
+				params = append(params, linkTypeFileRequest)
+			default:
+				return "", nil, errtypes.BadRequest(fmt.Sprintf("sql: filter type %s is not supported", t))
+			}
+		}
+		conds = append(conds, "("+strings.Join(or, " OR ")+")")
+	}
+	return strings.Join(conds, " AND "), params, nil
+}
+
+func (m *linkMgr) hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), m.c.Links.PasswordHashCost)
+	if err != nil {
+		return "", err
+	}
+	return passwordHashPrefix + string(hash), nil
+}
+
+func checkPasswordHash(password, hash string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(strings.TrimPrefix(hash, passwordHashPrefix)), []byte(password)) == nil
+}
+
+// authenticate checks the password or the signature
+// of the requests to a password protected link.
+func authenticate(s *link.PublicShare, hash string, auth *link.PublicShareAuthentication) bool {
+	switch {
+	case auth.GetPassword() != "":
+		return checkPasswordHash(auth.GetPassword(), hash)
+	case auth.GetSignature() != nil:
+		sig := auth.GetSignature()
+		expiration := time.Unix(int64(sig.GetSignatureExpiration().GetSeconds()), int64(sig.GetSignatureExpiration().GetNanos()))
+		if time.Now().After(expiration) {
+			return false
+		}
+		expected, err := publicshare.CreateSignature(s.Token, hash, expiration)
+		return err == nil && sig.GetSignature() == expected
+	}
+	return false
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// GetQuicklinkForResource returns the quicklink of the resource owned by
+// owner. The quicklinks are created by CreatePublicShare only if missing.
+func (m *linkMgr) GetQuicklinkForResource(ctx context.Context, owner *userpb.UserId, id *provider.ResourceId) (*link.PublicShare, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var l dbLink
+	query := "select " + linkColumns + ` FROM oc_share WHERE share_type=? AND deleted_at IS NULL AND quicklink=1
+				AND uid_owner=? AND fileid_prefix=? AND item_source=? AND ` + linkNotExpiredCondition + " AND " + linkActiveCondition + " ORDER BY id LIMIT 1"
+	err := m.db.QueryRowContext(ctx, query, shareTypePublicLink, conversions.FormatUserID(owner), id.StorageId, id.OpaqueId).Scan(l.scanArgs()...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errtypes.NotFound("quicklink of " + id.String())
+		}
+		return nil, err
+	}
+	return l.toCS3(), nil
+}
+
+// RevokeFilter selects the links revoked by RevokePublicShares.
+// The conditions set are combined, and at least one must be set.
+type RevokeFilter struct {
+	// Owner selects the links owned or created by the user.
+	Owner *userpb.UserId
+	// ResourceID selects the links of the resource.
+	ResourceID *provider.ResourceId
+	// CreatedBefore selects the links created before the time.
+	CreatedBefore time.Time
+}
+
+// RevokePublicShares revokes at once all the links selected by the filter,
+// e.g. of a compromised account or of a blocked project, regardless of
+// the user in context. It returns the number of revoked links.
+func (m *linkMgr) RevokePublicShares(ctx context.Context, f *RevokeFilter) (int64, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var conds []string
+	var params []interface{}
+	if f.Owner != nil {
+		uid := conversions.FormatUserID(f.Owner)
+		conds = append(conds, "(uid_owner=? or uid_initiator=?)")
+		params = append(params, uid, uid)
+	}
+	if f.ResourceID != nil {
+		conds = append(conds, "fileid_prefix=? AND item_source=?")
+		params = append(params, f.ResourceID.StorageId, f.ResourceID.OpaqueId)
+	}
+	if !f.CreatedBefore.IsZero() {
+		conds = append(conds, "stime < ?")
+		params = append(params, f.CreatedBefore.Unix())
+	}
+	if len(conds) == 0 {
+		return 0, errtypes.BadRequest("sql: revoking links requires a filter")
+	}
+
+	query := "update oc_share set deleted_at=? where share_type=? AND deleted_at IS NULL AND " + strings.Join(conds, " AND ")
+	params = append([]interface{}{time.Now().Unix(), shareTypePublicLink}, params...)
+	res, err := m.db.ExecContext(ctx, query, params...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,125 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sql
+
+import (
+	"context"
+
+	"github.com/cernbox/reva-plugins/runner"
+	link "github.com/cs3org/go-cs3apis/cs3/sharing/link/v1beta1"
+	"github.com/cs3org/reva/pkg/publicshare"
+	"github.com/pkg/errors"
+)
+
+// FilterTypeSignedLinks opts in the signing of the listed links, that are
+// not signed otherwise even if requested. With a resource id, only the links
+// of the resource are signed, e.g. the ones of the folder being opened.
+const FilterTypeSignedLinks link.ListPublicSharesRequest_Filter_Type = 101
+
+// signJob is the signing of a link by the signer.
+type signJob struct {
+	share *link.PublicShare
+	hash  string
+	done  chan<- error
+}
+
+// run signs the link and sends the result on done, also if the
+// signing panics, so that sign never waits for it forever.
+func (j signJob) run() {
+	var err error
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("sql: panic signing the link %s: %v", j.share.GetId().GetOpaqueId(), r)
+		}
+		j.done <- err
+	}()
+	err = publicshare.AddSignature(j.share, j.hash)
+}
+
+// signer computes the signatures of the links in a pool of workers,
+// bounding the cpu spent deriving them from the password hashes.
+type signer struct {
+	jobs chan signJob
+}
+
+func newSigner(r *runner.Runner, workers int) *signer {
+	s := &signer{jobs: make(chan signJob)}
+	for i := 0; i < workers; i++ {
+		r.Go("sql: sign links", runner.RestartOnPanic, func(ctx context.Context) error {
+			for {
+				select {
+				case <-ctx.Done():
+					return nil
+				case j := <-s.jobs:
+					j.run()
+				}
+			}
+		})
+	}
+	return s
+}
+
+// sign adds the signatures to the password protected links,
+// returning once all of them are signed.
+func (s *signer) sign(ctx context.Context, links []*dbLink, shares []*link.PublicShare) error {
+	done := make(chan error, len(links))
+	n := 0
+	for i, l := range links {
+		if l.Password == "" {
+			continue
+		}
+		select {
+		case s.jobs <- signJob{share: shares[i], hash: l.Password, done: done}:
+			n++
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	for ; n > 0; n-- {
+		select {
+		case err := <-done:
+			if err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// signedResources returns whether the listed links are signed and, if
+// only the ones of some resources are, the keys of these resources.
+func signedResources(filters []*link.ListPublicSharesRequest_Filter) (bool, map[string]bool) {
+	var signed bool
+	resources := map[string]bool{}
+	for _, f := range filters {
+		if f.Type != FilterTypeSignedLinks {
+			continue
+		}
+		signed = true
+		if id := f.GetResourceId(); id != nil {
+			resources[id.StorageId+"!"+id.OpaqueId] = true
+		} else {
+			// a filter without resource signs all the links
+			return true, nil
+		}
+	}
+	return signed, resources
+}
@@ -0,0 +1,79 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	link "github.com/cs3org/go-cs3apis/cs3/sharing/link/v1beta1"
+	"github.com/cs3org/reva/pkg/appctx"
+)
+
+// LinkStats are the statistics of the accesses to a public link,
+// stored in the oc_share_link_stats table.
+type LinkStats struct {
+	// Accesses is the number of successful accesses with the token.
+	Accesses int64 `json:"accesses"`
+	// LastAccess is the time of the last access, zero if never accessed.
+	LastAccess time.Time `json:"last_access,omitempty"`
+}
+
+// RecordAccess counts an access to the link. It is called when the
+// link is resolved by its token, and can be called by the services
+// serving the downloads to count them as well.
+func (m *linkMgr) RecordAccess(ctx context.Context, id *link.PublicShareId) error {
+	query := "insert into oc_share_link_stats(share_id, access_count, last_access) values(?, 1, ?) ON DUPLICATE KEY UPDATE access_count = access_count + 1, last_access = values(last_access)"
+	_, err := m.db.ExecContext(ctx, query, id.OpaqueId, time.Now().Unix())
+	return err
+}
+
+// recordAccess counts an access to the link, only logging the
+// failures so that the statistics never prevent an access.
+func (m *linkMgr) recordAccess(ctx context.Context, s *link.PublicShare) {
+	if err := m.RecordAccess(ctx, s.Id); err != nil {
+		appctx.GetLogger(ctx).Error().Err(err).Str("share_id", s.Id.OpaqueId).Msg("sql: error recording link access")
+	}
+}
+
+// GetPublicShareStats returns the statistics of the accesses
+// to a link owned or created by the user.
+func (m *linkMgr) GetPublicShareStats(ctx context.Context, u *userpb.User, ref *link.PublicShareReference) (*LinkStats, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	l, err := m.getLinkOf(ctx, u, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &LinkStats{}
+	var lastAccess int64
+	err = m.db.QueryRowContext(ctx, "select access_count, last_access FROM oc_share_link_stats WHERE share_id=?", l.ID).Scan(&stats.Accesses, &lastAccess)
+	switch {
+	case err == sql.ErrNoRows:
+		return stats, nil
+	case err != nil:
+		return nil, err
+	}
+	stats.LastAccess = time.Unix(lastAccess, 0)
+	return stats, nil
+}
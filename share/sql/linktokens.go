@@ -0,0 +1,73 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sql
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	"github.com/cs3org/reva/pkg/errtypes"
+)
+
+// maxCustomTokenLength is the maximum length of the custom tokens.
+const maxCustomTokenLength = 64
+
+var customTokenRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// customTokensConfig configures the custom tokens, requested
+// instead of a random one e.g. for long-lived institutional links.
+type customTokensConfig struct {
+	// AllowedGroups are the groups of the users allowed to
+	// request a custom token. No one is allowed if empty.
+	AllowedGroups []string `mapstructure:"allowed_groups"`
+	// ReservedWords are the tokens that cannot be requested,
+	// case insensitive, e.g. the paths of the web UI.
+	ReservedWords []string `mapstructure:"reserved_words"`
+	// MinLength is the minimum length of the custom tokens. Defaults to 8.
+	MinLength int `mapstructure:"min_length" validate:"min=0,max=64"`
+}
+
+// checkCustomToken validates a custom token requested by the user,
+// that must be allowed, well formed and not used by any other share.
+func (m *linkMgr) checkCustomToken(ctx context.Context, u *userpb.User, token string) error {
+	c := &m.c.Links.CustomTokens
+	if !slices.ContainsFunc(u.Groups, func(g string) bool { return slices.Contains(c.AllowedGroups, g) }) {
+		return errtypes.PermissionDenied("sql: the user is not allowed to request custom tokens")
+	}
+	if len(token) < c.MinLength || len(token) > maxCustomTokenLength || !customTokenRegex.MatchString(token) {
+		return errtypes.BadRequest(fmt.Sprintf("sql: the token must have between %d and %d letters, digits, dashes and underscores", c.MinLength, maxCustomTokenLength))
+	}
+	if slices.ContainsFunc(c.ReservedWords, func(w string) bool { return strings.EqualFold(w, token) }) {
+		return errtypes.BadRequest("sql: the token " + token + " is reserved")
+	}
+
+	// the deleted links are included, as they can still be restored
+	var count int
+	if err := m.db.QueryRowContext(ctx, "select count(*) FROM oc_share WHERE token=?", token).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return errtypes.AlreadyExists("sql: the token " + token + " is already used")
+	}
+	return nil
+}
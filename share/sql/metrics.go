@@ -0,0 +1,187 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sql
+
+import (
+	"context"
+	"time"
+
+	"github.com/cernbox/reva-plugins/metrics"
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	collaboration "github.com/cs3org/go-cs3apis/cs3/sharing/collaboration/v1beta1"
+	link "github.com/cs3org/go-cs3apis/cs3/sharing/link/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	typespb "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
+	"github.com/cs3org/reva/pkg/errtypes"
+	"github.com/pkg/errors"
+	"google.golang.org/genproto/protobuf/field_mask"
+)
+
+var (
+	operationsTotal    = metrics.NewCounterVec("share_sql_operations_total", "method", "status")
+	operationsDuration = metrics.NewHistogramVec("share_sql_operation_duration_seconds", metrics.DefaultBuckets, "method")
+)
+
+// instrumentedMgr records the count, the failures and the latency
+// of the operations of the share manager. The other methods of the
+// manager are promoted unchanged.
+type instrumentedMgr struct {
+	*mgr
+}
+
+func observe(method string, start time.Time, err error) {
+	operationsDuration.ObserveSince(start, method)
+	operationsTotal.Inc(method, statusLabel(err))
+}
+
+func statusLabel(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	switch errors.Cause(err).(type) {
+	case errtypes.NotFound:
+		return "not_found"
+	case errtypes.AlreadyExists:
+		return "already_exists"
+	case errtypes.PermissionDenied:
+		return "permission_denied"
+	case errtypes.BadRequest, errtypes.NotSupported:
+		return "bad_request"
+	default:
+		return "error"
+	}
+}
+
+func (m *instrumentedMgr) Share(ctx context.Context, md *provider.ResourceInfo, g *collaboration.ShareGrant) (*collaboration.Share, error) {
+	start := time.Now()
+	s, err := m.mgr.Share(ctx, md, g)
+	observe("Share", start, err)
+	return s, err
+}
+
+func (m *instrumentedMgr) BatchShare(ctx context.Context, md *provider.ResourceInfo, grants []*collaboration.ShareGrant, granter Granter) ([]*BatchShareResult, error) {
+	start := time.Now()
+	res, err := m.mgr.BatchShare(ctx, md, grants, granter)
+	observe("BatchShare", start, err)
+	return res, err
+}
+
+func (m *instrumentedMgr) GetShare(ctx context.Context, ref *collaboration.ShareReference) (*collaboration.Share, error) {
+	start := time.Now()
+	s, err := m.mgr.GetShare(ctx, ref)
+	observe("GetShare", start, err)
+	return s, err
+}
+
+func (m *instrumentedMgr) Unshare(ctx context.Context, ref *collaboration.ShareReference) error {
+	start := time.Now()
+	err := m.mgr.Unshare(ctx, ref)
+	observe("Unshare", start, err)
+	return err
+}
+
+func (m *instrumentedMgr) UpdateShare(ctx context.Context, ref *collaboration.ShareReference, p *collaboration.SharePermissions) (*collaboration.Share, error) {
+	start := time.Now()
+	s, err := m.mgr.UpdateShare(ctx, ref, p)
+	observe("UpdateShare", start, err)
+	return s, err
+}
+
+func (m *instrumentedMgr) UpdateShareExpiration(ctx context.Context, ref *collaboration.ShareReference, expiration *typespb.Timestamp) (*collaboration.Share, error) {
+	start := time.Now()
+	s, err := m.mgr.UpdateShareExpiration(ctx, ref, expiration)
+	observe("UpdateShareExpiration", start, err)
+	return s, err
+}
+
+func (m *instrumentedMgr) ListShares(ctx context.Context, filters []*collaboration.Filter) ([]*collaboration.Share, error) {
+	start := time.Now()
+	s, err := m.mgr.ListShares(ctx, filters)
+	observe("ListShares", start, err)
+	return s, err
+}
+
+func (m *instrumentedMgr) ListReceivedShares(ctx context.Context, filters []*collaboration.Filter) ([]*collaboration.ReceivedShare, error) {
+	start := time.Now()
+	s, err := m.mgr.ListReceivedShares(ctx, filters)
+	observe("ListReceivedShares", start, err)
+	return s, err
+}
+
+func (m *instrumentedMgr) GetReceivedShare(ctx context.Context, ref *collaboration.ShareReference) (*collaboration.ReceivedShare, error) {
+	start := time.Now()
+	s, err := m.mgr.GetReceivedShare(ctx, ref)
+	observe("GetReceivedShare", start, err)
+	return s, err
+}
+
+func (m *instrumentedMgr) UpdateReceivedShare(ctx context.Context, share *collaboration.ReceivedShare, fieldMask *field_mask.FieldMask) (*collaboration.ReceivedShare, error) {
+	start := time.Now()
+	s, err := m.mgr.UpdateReceivedShare(ctx, share, fieldMask)
+	observe("UpdateReceivedShare", start, err)
+	return s, err
+}
+
+// instrumentedLinkMgr records the operations of the public link manager,
+// as instrumentedMgr does for the share manager.
+type instrumentedLinkMgr struct {
+	*linkMgr
+}
+
+func (m *instrumentedLinkMgr) CreatePublicShare(ctx context.Context, u *userpb.User, md *provider.ResourceInfo, g *link.Grant, description string, internal bool, notifyUploads bool, notifyUploadsExtraRecipients string) (*link.PublicShare, error) {
+	start := time.Now()
+	s, err := m.linkMgr.CreatePublicShare(ctx, u, md, g, description, internal, notifyUploads, notifyUploadsExtraRecipients)
+	observe("CreatePublicShare", start, err)
+	return s, err
+}
+
+func (m *instrumentedLinkMgr) UpdatePublicShare(ctx context.Context, u *userpb.User, req *link.UpdatePublicShareRequest, g *link.Grant) (*link.PublicShare, error) {
+	start := time.Now()
+	s, err := m.linkMgr.UpdatePublicShare(ctx, u, req, g)
+	observe("UpdatePublicShare", start, err)
+	return s, err
+}
+
+func (m *instrumentedLinkMgr) GetPublicShare(ctx context.Context, u *userpb.User, ref *link.PublicShareReference, sign bool) (*link.PublicShare, error) {
+	start := time.Now()
+	s, err := m.linkMgr.GetPublicShare(ctx, u, ref, sign)
+	observe("GetPublicShare", start, err)
+	return s, err
+}
+
+func (m *instrumentedLinkMgr) ListPublicShares(ctx context.Context, u *userpb.User, filters []*link.ListPublicSharesRequest_Filter, md *provider.ResourceInfo, sign bool) ([]*link.PublicShare, error) {
+	start := time.Now()
+	s, err := m.linkMgr.ListPublicShares(ctx, u, filters, md, sign)
+	observe("ListPublicShares", start, err)
+	return s, err
+}
+
+func (m *instrumentedLinkMgr) RevokePublicShare(ctx context.Context, u *userpb.User, ref *link.PublicShareReference) error {
+	start := time.Now()
+	err := m.linkMgr.RevokePublicShare(ctx, u, ref)
+	observe("RevokePublicShare", start, err)
+	return err
+}
+
+func (m *instrumentedLinkMgr) GetPublicShareByToken(ctx context.Context, token string, auth *link.PublicShareAuthentication, sign bool) (*link.PublicShare, error) {
+	start := time.Now()
+	s, err := m.linkMgr.GetPublicShareByToken(ctx, token, auth, sign)
+	observe("GetPublicShareByToken", start, err)
+	return s, err
+}
@@ -0,0 +1,88 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cernbox/reva-plugins/share/schema"
+	"github.com/cs3org/reva/pkg/appctx"
+)
+
+// requiredMigrations are the versions of the migrations of the schema
+// package the features of the manager depend on, with the feature.
+var requiredMigrations = map[int]string{
+	1:  "soft deletion of the shares",
+	2:  "alias, hidden flag and upload notifications of the received shares",
+	3:  "history of the shares",
+	4:  "transfer of the shares",
+	5:  "provenance of the reshares",
+	8:  "synced flag of the received shares",
+	9:  "access statistics of the public links",
+	10: "maximum number of accesses of the public links",
+	11: "scheduled activation of the public links",
+	12: "internal public links",
+	13: "networks allowed to access the public links",
+	14: "file requests",
+	16: "initial path of the shares and links",
+}
+
+// archiveMigration is the migration creating the oc_share_archive
+// table, required only if the expired shares are archived.
+const archiveMigration = 6
+
+// checkSchema verifies that the migrations the manager depends on were
+// applied, so that the manager does not start failing on each query using
+// a table or a column not yet created. The migrations are not applied by
+// the manager, as most of them lock the tables for a long time.
+func checkSchema(ctx context.Context, db *sql.DB, c *config) error {
+	required := make(map[int]string, len(requiredMigrations)+1)
+	for v, feature := range requiredMigrations {
+		required[v] = feature
+	}
+	if c.ExpiredRetention > 0 && c.ArchiveExpired {
+		required[archiveMigration] = "archive of the expired shares"
+	}
+
+	versions := make([]int, 0, len(required))
+	for v := range required {
+		versions = append(versions, v)
+	}
+	sort.Ints(versions)
+
+	missing, err := schema.Missing(ctx, db, versions)
+	if err != nil {
+		// the db may be temporarily unreachable, the queries will fail anyway
+		appctx.GetLogger(ctx).Warn().Err(err).Msg("sql: could not check the schema of the shares database")
+		return nil
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	features := make([]string, 0, len(missing))
+	for _, v := range missing {
+		features = append(features, fmt.Sprintf("%d (%s)", v, required[v]))
+	}
+	return fmt.Errorf("sql: the shares database misses the migrations %s, apply them with `migrator up`", strings.Join(features, ", "))
+}
@@ -0,0 +1,76 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sql
+
+import (
+	"context"
+	"database/sql"
+
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	collaboration "github.com/cs3org/go-cs3apis/cs3/sharing/collaboration/v1beta1"
+	"github.com/cs3org/reva/pkg/appctx"
+	conversions "github.com/cs3org/reva/pkg/cbox/utils"
+)
+
+// NotificationPrefs are the notification settings of the recipient of
+// a share, as the ones of the public links. The cs3 received shares have
+// no fields for them, they are set with SetNotificationPrefs.
+type NotificationPrefs struct {
+	// NotifyUploads notifies the recipient of the uploads in the shared folder.
+	NotifyUploads bool
+	// NotifyUploadsExtraRecipients is a comma separated list of
+	// emails notified in addition to the recipient.
+	NotifyUploadsExtraRecipients string
+}
+
+// GetNotificationPrefs returns the notification preferences of the user
+// in context for the received share.
+func (m *mgr) GetNotificationPrefs(ctx context.Context, id *collaboration.ShareId) (*NotificationPrefs, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+	// check that the share is visible to the user
+	if _, err := m.getReceivedByID(ctx, id, userpb.UserType_USER_TYPE_INVALID); err != nil {
+		return nil, err
+	}
+
+	user := appctx.ContextMustGetUser(ctx)
+	prefs := &NotificationPrefs{}
+	query := "select coalesce(notify_uploads, 0), coalesce(notify_uploads_extra_recipients, '') FROM oc_share_status WHERE id=? AND recipient=?"
+	err := m.db.QueryRowContext(ctx, query, id.OpaqueId, conversions.FormatUserID(user.Id)).Scan(&prefs.NotifyUploads, &prefs.NotifyUploadsExtraRecipients)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	return prefs, nil
+}
+
+// SetNotificationPrefs sets the notification preferences of the user
+// in context for the received share.
+func (m *mgr) SetNotificationPrefs(ctx context.Context, id *collaboration.ShareId, prefs *NotificationPrefs) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+	user := appctx.ContextMustGetUser(ctx)
+	rs, err := m.getReceivedByID(ctx, id, userpb.UserType_USER_TYPE_INVALID)
+	if err != nil {
+		return err
+	}
+
+	columns := []string{"state", "notify_uploads", "notify_uploads_extra_recipients"}
+	values := []interface{}{stateToDB(rs.GetState()), prefs.NotifyUploads, prefs.NotifyUploadsExtraRecipients}
+	return m.setShareStatus(ctx, user, id, columns, values)
+}
@@ -0,0 +1,86 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sql
+
+import (
+	"context"
+	"database/sql"
+
+	typespb "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
+	"github.com/cs3org/reva/pkg/appctx"
+	conversions "github.com/cs3org/reva/pkg/cbox/utils"
+	"github.com/cs3org/reva/pkg/errtypes"
+	"github.com/cs3org/reva/pkg/utils"
+)
+
+// Keys of the opaque data of the shares and links.
+const (
+	OpaqueInitialPath = "initial_path"
+	OpaqueDescription = "description"
+)
+
+// GetShareOpaque returns the opaque data of a share or link visible to the
+// user in context: the path of the resource when it was shared, that may
+// have moved since, and the description of the links. The cs3 messages of
+// the shares and links have no opaque, so the share providers add it to the
+// opaque of their responses.
+func (m *mgr) GetShareOpaque(ctx context.Context, id string) (*typespb.Opaque, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+	user := appctx.ContextMustGetUser(ctx)
+
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	uid := conversions.FormatUserID(user.Id)
+	query := `select coalesce(initial_path, ''), coalesce(description, ''), share_type FROM oc_share
+				WHERE id=? AND deleted_at IS NULL AND (uid_owner=? or uid_initiator=? or (share_type=? AND lower(share_with)=lower(?))`
+	params := []interface{}{id, uid, uid, shareTypeUser, user.Username}
+	groupsQuery, groupsParams, cleanup, err := m.groupsCondition(ctx, conn, user.Groups)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	if groupsQuery != "" {
+		query += " or (share_type=? AND " + groupsQuery + ")"
+		params = append(append(params, shareTypeGroup), groupsParams...)
+	}
+	query += ")"
+
+	var initialPath, description string
+	var shareType int
+	if err := conn.QueryRowContext(ctx, query, params...).Scan(&initialPath, &description, &shareType); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errtypes.NotFound(id)
+		}
+		return nil, err
+	}
+
+	var opaque *typespb.Opaque
+	if initialPath != "" {
+		opaque = utils.AppendPlainToOpaque(opaque, OpaqueInitialPath, initialPath)
+	}
+	if shareType == shareTypePublicLink && description != "" {
+		opaque = utils.AppendPlainToOpaque(opaque, OpaqueDescription, description)
+	}
+	return opaque, nil
+}
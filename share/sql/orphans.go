@@ -0,0 +1,167 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sql
+
+import (
+	"context"
+
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/cs3org/reva/pkg/appctx"
+	"github.com/cs3org/reva/pkg/rgrpc/status"
+	"github.com/cs3org/reva/pkg/rgrpc/todo/pool"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/metadata"
+)
+
+type sharedResource struct {
+	id       string
+	prefix   string
+	itemSrc  string
+	isOrphan bool
+}
+
+// checkOrphans is the periodic task marking orphan the shares
+// of the resources that do not exist anymore.
+func (m *mgr) checkOrphans(ctx context.Context) error {
+	ctx, err := m.machineContext(ctx)
+	if err != nil {
+		return err
+	}
+	query := "select id, coalesce(fileid_prefix, ''), coalesce(item_source, ''), coalesce(orphan, 0) FROM oc_share WHERE (orphan = 0 or orphan IS NULL) AND deleted_at IS NULL AND (share_type=? OR share_type=?)"
+	_, err = m.reconcileOrphans(ctx, query, shareTypeUser, shareTypeGroup)
+	return err
+}
+
+// RecheckOrphans checks again the existence of the resource shared by the
+// given shares, or of all the shared resources if the id is nil, updating
+// the orphan flag of the shares in both directions. It returns the ids of
+// the shares whose flag changed.
+func (m *mgr) RecheckOrphans(ctx context.Context, id *provider.ResourceId) ([]string, error) {
+	// the resources are stat-ed as the machine user, like the periodic
+	// task does, and not with the credentials of the caller
+	ctx, err := m.machineContext(metadata.NewOutgoingContext(ctx, metadata.MD{}))
+	if err != nil {
+		return nil, err
+	}
+	query := "select id, coalesce(fileid_prefix, ''), coalesce(item_source, ''), coalesce(orphan, 0) FROM oc_share WHERE deleted_at IS NULL AND (share_type=? OR share_type=?)"
+	params := []interface{}{shareTypeUser, shareTypeGroup}
+	if id != nil {
+		query += " AND fileid_prefix=? AND item_source=?"
+		params = append(params, id.StorageId, id.OpaqueId)
+	}
+	return m.reconcileOrphans(ctx, query, params...)
+}
+
+func (m *mgr) reconcileOrphans(ctx context.Context, query string, params ...interface{}) ([]string, error) {
+	log := appctx.GetLogger(ctx)
+
+	rows, err := m.db.QueryContext(ctx, query, params...)
+	if err != nil {
+		return nil, err
+	}
+	var shares []sharedResource
+	for rows.Next() {
+		var s sharedResource
+		if err := rows.Scan(&s.id, &s.prefix, &s.itemSrc, &s.isOrphan); err != nil {
+			continue
+		}
+		shares = append(shares, s)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	// many shares point to the same resource, stat each one only once
+	exists := map[string]bool{}
+	var changed []string
+	for _, s := range shares {
+		if ctx.Err() != nil {
+			return changed, ctx.Err()
+		}
+		key := s.prefix + "!" + s.itemSrc
+		found, ok := exists[key]
+		if !ok {
+			found, err = m.resourceExists(ctx, &provider.ResourceId{StorageId: s.prefix, OpaqueId: s.itemSrc})
+			if err != nil {
+				log.Warn().Err(err).Str("share_id", s.id).Msg("sql: error checking the shared resource, skipping")
+				continue
+			}
+			exists[key] = found
+		}
+		if found != s.isOrphan {
+			continue
+		}
+
+		if _, err := m.db.ExecContext(ctx, "update oc_share set orphan=? where id=?", !found, s.id); err != nil {
+			return changed, err
+		}
+		log.Info().Str("share_id", s.id).Bool("orphan", !found).Msg("sql: updated orphan flag of share")
+		changed = append(changed, s.id)
+	}
+	return changed, nil
+}
+
+// resourceExists stats the resource in the gateway. Only a not found
+// status is reported as a missing resource, any other failure is an error.
+func (m *mgr) resourceExists(ctx context.Context, id *provider.ResourceId) (bool, error) {
+	client, err := pool.GetGatewayServiceClient(pool.Endpoint(m.c.GatewaySvc))
+	if err != nil {
+		return false, err
+	}
+	res, err := client.Stat(ctx, &provider.StatRequest{
+		Ref: &provider.Reference{ResourceId: id},
+	})
+	if err != nil {
+		return false, err
+	}
+	switch res.Status.Code {
+	case rpc.Code_CODE_OK:
+		return true, nil
+	case rpc.Code_CODE_NOT_FOUND:
+		return false, nil
+	default:
+		return false, status.NewErrorFromCode(res.Status.Code, "sql")
+	}
+}
+
+// machineContext returns a context authenticated in the gateway
+// with the machine credentials, used by the background tasks.
+func (m *mgr) machineContext(ctx context.Context) (context.Context, error) {
+	client, err := pool.GetGatewayServiceClient(pool.Endpoint(m.c.GatewaySvc))
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.Authenticate(ctx, &gateway.AuthenticateRequest{
+		Type:         "machine",
+		ClientId:     "username:" + m.c.MachineUser,
+		ClientSecret: m.c.MachineSecret,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "sql: error authenticating in the gateway")
+	}
+	if res.Status.Code != rpc.Code_CODE_OK {
+		return nil, errors.New("sql: error authenticating in the gateway: " + res.Status.Message)
+	}
+	ctx = metadata.AppendToOutgoingContext(ctx, appctx.TokenHeader, res.Token)
+	return appctx.ContextSetToken(ctx, res.Token), nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sql
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	collaboration "github.com/cs3org/go-cs3apis/cs3/sharing/collaboration/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	conversions "github.com/cs3org/reva/pkg/cbox/utils"
+	"github.com/cs3org/reva/pkg/errtypes"
+)
+
+// permissions that allow to modify the shared resource.
+const writePermissions = 2 | 4 | 8
+
+// policy are the rules of the instance on the shares, evaluated before
+// creating or updating them.
+type policy struct {
+	// MaxPermissions maps an item type, file or folder, to the maximum
+	// permissions of its shares, in the oc share format.
+	MaxPermissions map[string]int `mapstructure:"max_permissions"`
+	// ForbiddenGroups are the patterns, as in path.Match, of the
+	// groups that cannot be shared with.
+	ForbiddenGroups []string `mapstructure:"forbidden_groups"`
+	// ExternalWriteExpiration requires an expiration for the shares
+	// granting write permissions to external users.
+	ExternalWriteExpiration bool `mapstructure:"external_write_expiration"`
+}
+
+// checkPolicy evaluates the policy for a share of an item of the given type.
+func (m *mgr) checkPolicy(ctx context.Context, itemType string, g *collaboration.ShareGrant) error {
+	p := &m.c.Policy
+	permissions := conversions.SharePermToInt(g.Permissions.GetPermissions())
+
+	if maxPerm, ok := p.MaxPermissions[itemType]; ok && permissions&^maxPerm != 0 {
+		return errtypes.PermissionDenied(fmt.Sprintf("sql: permissions %d exceed the maximum %d for a %s", permissions, maxPerm, itemType))
+	}
+
+	switch g.Grantee.Type {
+	case provider.GranteeType_GRANTEE_TYPE_GROUP:
+		group := g.Grantee.GetGroupId().GetOpaqueId()
+		for _, pattern := range p.ForbiddenGroups {
+			if ok, _ := path.Match(pattern, group); ok {
+				return errtypes.PermissionDenied("sql: sharing with group " + group + " is not allowed")
+			}
+		}
+	case provider.GranteeType_GRANTEE_TYPE_USER:
+		if p.ExternalWriteExpiration && permissions&writePermissions != 0 && g.Expiration == nil && m.isExternal(ctx, g.Grantee.GetUserId()) {
+			return errtypes.BadRequest("sql: shares granting write access to external users must expire")
+		}
+	}
+	return nil
+}
+
+// isExternal returns true if the user is not a CERN account.
+func (m *mgr) isExternal(ctx context.Context, u *userpb.UserId) bool {
+	t := u.GetType()
+	if t == userpb.UserType_USER_TYPE_INVALID {
+		t, _ = m.getUserType(ctx, u.GetOpaqueId())
+	}
+	switch t {
+	case userpb.UserType_USER_TYPE_LIGHTWEIGHT, userpb.UserType_USER_TYPE_FEDERATED, userpb.UserType_USER_TYPE_GUEST:
+		return true
+	}
+	return false
+}
+
+// itemType returns the item type of a share, empty if it cannot be found.
+func (m *mgr) itemType(ctx context.Context, id *collaboration.ShareId) string {
+	var itemType string
+	_ = m.db.QueryRowContext(ctx, "select coalesce(item_type, '') FROM oc_share WHERE id=?", id.OpaqueId).Scan(&itemType)
+	return itemType
+}
@@ -0,0 +1,116 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sql
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/cs3org/reva/pkg/appctx"
+	conversions "github.com/cs3org/reva/pkg/cbox/utils"
+	"github.com/cs3org/reva/pkg/rgrpc/todo/pool"
+	"github.com/pkg/errors"
+)
+
+// projectOwnersCacheSize is the number of project spaces whose owner is cached.
+const projectOwnersCacheSize = 10000
+
+// appendProjectOwnerFilters restricts the query to the shares of the user,
+// and to the ones of the projects the user administers.
+func (m *mgr) appendProjectOwnerFilters(ctx context.Context, query string, params []interface{}) (string, []interface{}, error) {
+	uidOwnersQuery, uidOwnersParams, err := m.uidOwnerFilters(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	if uidOwnersQuery == "" {
+		return query, params, nil
+	}
+
+	owners := m.projectOwners(ctx, appctx.ContextMustGetUser(ctx))
+	if len(owners) > 0 {
+		uidOwnersQuery += " or uid_owner in (?" + strings.Repeat(",?", len(owners)-1) + ")"
+		for _, o := range owners {
+			uidOwnersParams = append(uidOwnersParams, o)
+		}
+	}
+
+	params = append(params, uidOwnersParams...)
+	return fmt.Sprintf("%s AND (%s)", query, uidOwnersQuery), params, nil
+}
+
+// projectOwners returns the owners of the projects the user administers,
+// i.e. the projects whose admin group the user belongs to. The shares of
+// a project are owned by the owner of the project space, which is cached
+// as it is needed on every listing of the shares of the admins.
+func (m *mgr) projectOwners(ctx context.Context, u *userpb.User) []string {
+	log := appctx.GetLogger(ctx)
+
+	var owners []string
+	for _, g := range u.Groups {
+		if !strings.HasPrefix(g, projectSpaceGroupsPrefix) || !strings.HasSuffix(g, projectSpaceAdminGroupsSuffix) {
+			continue
+		}
+		project := strings.TrimSuffix(strings.TrimPrefix(g, projectSpaceGroupsPrefix), projectSpaceAdminGroupsSuffix)
+		if project == "" {
+			continue
+		}
+		projectPath := path.Join(projectPathPrefix, project[:1], project)
+		if !m.isProjectAdmin(u, projectPath) {
+			continue
+		}
+
+		owner, err := m.projectOwner(ctx, projectPath)
+		if err != nil {
+			log.Warn().Err(err).Str("project", projectPath).Msg("sql: error resolving the owner of the project")
+			continue
+		}
+		owners = append(owners, owner)
+	}
+	return owners
+}
+
+// projectOwner returns the owner of the project space at projectPath.
+func (m *mgr) projectOwner(ctx context.Context, projectPath string) (string, error) {
+	if v, err := m.ownersCache.Get(projectPath); err == nil {
+		return v.(string), nil
+	}
+
+	client, err := pool.GetGatewayServiceClient(pool.Endpoint(m.c.GatewaySvc))
+	if err != nil {
+		return "", err
+	}
+	res, err := client.Stat(ctx, &provider.StatRequest{
+		Ref: &provider.Reference{Path: projectPath},
+	})
+	switch {
+	case err != nil:
+		return "", err
+	case res.Status.Code != rpc.Code_CODE_OK:
+		return "", errors.New(res.Status.Message)
+	}
+
+	owner := conversions.FormatUserID(res.Info.Owner)
+	_ = m.ownersCache.Set(projectPath, owner)
+	return owner, nil
+}
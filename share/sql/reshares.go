@@ -0,0 +1,189 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	collaboration "github.com/cs3org/go-cs3apis/cs3/sharing/collaboration/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/cs3org/reva/pkg/appctx"
+	conversions "github.com/cs3org/reva/pkg/cbox/utils"
+	"github.com/cs3org/reva/pkg/errtypes"
+)
+
+// parentShare returns the id of the share through which the user received
+// the resource, to be recorded as the parent of a reshare, or nil.
+func (m *mgr) parentShare(ctx context.Context, u *userpb.User, md *provider.ResourceInfo) interface{} {
+	if !m.c.TrackReshares || md.Owner == nil || conversions.FormatUserID(md.Owner) == conversions.FormatUserID(u.Id) {
+		return nil
+	}
+
+	uid := conversions.FormatUserID(u.Id)
+	params := []interface{}{md.Id.StorageId, md.Id.OpaqueId, uid}
+	query := "select id FROM oc_share WHERE (orphan = 0 or orphan IS NULL) AND deleted_at IS NULL AND fileid_prefix=? AND item_source=?"
+	if len(u.Groups) > 0 {
+		query += " AND ((lower(share_with)=lower(?) AND share_type = 0) OR (share_type = 1 AND lower(share_with) in (?" + strings.Repeat(",?", len(u.Groups)-1) + ")))"
+		for _, g := range u.Groups {
+			params = append(params, g)
+		}
+	} else {
+		query += " AND (lower(share_with)=lower(?) AND share_type = 0)"
+	}
+	// prefer the direct user shares to the group ones
+	query += " ORDER BY share_type, id LIMIT 1"
+
+	var id int64
+	if err := m.db.QueryRowContext(ctx, query, params...).Scan(&id); err != nil {
+		if err != sql.ErrNoRows {
+			appctx.GetLogger(ctx).Error().Err(err).Msg("sql: error looking up the parent share")
+		}
+		return nil
+	}
+	return id
+}
+
+// GetShareProvenance returns the chain of shares the given share reshares,
+// from its direct parent up to the share created by the owner.
+func (m *mgr) GetShareProvenance(ctx context.Context, id *collaboration.ShareId) ([]*collaboration.ShareId, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	// only the owner and the creator can see the provenance
+	if _, err := m.getByID(ctx, id, true); err != nil {
+		return nil, err
+	}
+
+	var chain []*collaboration.ShareId
+	seen := map[string]bool{id.OpaqueId: true}
+	current := id.OpaqueId
+	for {
+		var parent sql.NullString
+		if err := m.db.QueryRowContext(ctx, "select parent_share_id FROM oc_share WHERE id=?", current).Scan(&parent); err != nil {
+			if err == sql.ErrNoRows {
+				return chain, nil
+			}
+			return nil, err
+		}
+		if !parent.Valid || seen[parent.String] {
+			return chain, nil
+		}
+		seen[parent.String] = true
+		chain = append(chain, &collaboration.ShareId{OpaqueId: parent.String})
+		current = parent.String
+	}
+}
+
+// ListReshares returns the shares resharing the given share.
+func (m *mgr) ListReshares(ctx context.Context, id *collaboration.ShareId) ([]*collaboration.Share, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	if _, err := m.getByID(ctx, id, true); err != nil {
+		return nil, errtypes.NotFound(id.OpaqueId)
+	}
+
+	query := `select coalesce(uid_owner, '') as uid_owner, coalesce(uid_initiator, '') as uid_initiator, lower(coalesce(share_with, '')) as share_with,
+				coalesce(fileid_prefix, '') as fileid_prefix, coalesce(item_source, '') as item_source, coalesce(item_type, '') as item_type,
+			  	id, stime, permissions, share_type, coalesce(expiration, '') as expiration
+			  FROM oc_share WHERE (orphan = 0 or orphan IS NULL) AND deleted_at IS NULL AND parent_share_id=?`
+	rows, err := m.db.QueryContext(ctx, query, id.OpaqueId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var s conversions.DBShare
+	shares := []*collaboration.Share{}
+	for rows.Next() {
+		if err := rows.Scan(&s.UIDOwner, &s.UIDInitiator, &s.ShareWith, &s.Prefix, &s.ItemSource, &s.ItemType, &s.ID, &s.STime, &s.Permissions, &s.ShareType, &s.Expiration); err != nil {
+			continue
+		}
+		gtype, _ := m.getUserType(ctx, s.ShareWith)
+		shares = append(shares, convertToCS3Share(s, gtype))
+	}
+	return shares, rows.Err()
+}
+
+// unshareReshares applies the configured policy to the reshares
+// of a deleted share, down the whole chain.
+func (m *mgr) unshareReshares(ctx context.Context, id string) {
+	var query string
+	switch m.c.ReshareUnshare {
+	case "cascade":
+		query = "update oc_share set deleted_at=? where deleted_at IS NULL AND parent_share_id=?"
+	case "orphan":
+		query = "update oc_share set orphan=1 where deleted_at IS NULL AND parent_share_id=?"
+	default:
+		return
+	}
+	log := appctx.GetLogger(ctx)
+
+	pending := []string{id}
+	seen := map[string]bool{}
+	for len(pending) > 0 {
+		current := pending[0]
+		pending = pending[1:]
+		if seen[current] {
+			continue
+		}
+		seen[current] = true
+
+		children, err := m.childShares(ctx, current)
+		if err != nil {
+			log.Error().Err(err).Str("share_id", current).Msg("sql: error listing reshares")
+			continue
+		}
+		if len(children) == 0 {
+			continue
+		}
+		params := []interface{}{current}
+		if m.c.ReshareUnshare == "cascade" {
+			params = append([]interface{}{time.Now().Unix()}, params...)
+		}
+		if _, err := m.db.ExecContext(ctx, query, params...); err != nil {
+			log.Error().Err(err).Str("share_id", current).Msg("sql: error updating reshares")
+			continue
+		}
+		log.Info().Str("share_id", current).Strs("reshares", children).Str("policy", m.c.ReshareUnshare).Msg("sql: updated reshares of deleted share")
+		pending = append(pending, children...)
+	}
+}
+
+func (m *mgr) childShares(ctx context.Context, id string) ([]string, error) {
+	rows, err := m.db.QueryContext(ctx, "select id FROM oc_share WHERE deleted_at IS NULL AND parent_share_id=?", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var child string
+		if err := rows.Scan(&child); err != nil {
+			return nil, err
+		}
+		ids = append(ids, child)
+	}
+	return ids, rows.Err()
+}
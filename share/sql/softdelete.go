@@ -0,0 +1,189 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	collaboration "github.com/cs3org/go-cs3apis/cs3/sharing/collaboration/v1beta1"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/cs3org/reva/pkg/appctx"
+	conversions "github.com/cs3org/reva/pkg/cbox/utils"
+	"github.com/cs3org/reva/pkg/errtypes"
+	"github.com/pkg/errors"
+)
+
+// RestoreShare restores a share deleted less than the restore window ago,
+// on behalf of the admin in context, and gives its grantee access to the
+// resource again with granter before committing the restore. It fails if
+// in the meantime the resource was shared again with the same grantee.
+func (m *mgr) RestoreShare(ctx context.Context, id *collaboration.ShareId, granter Granter) (*collaboration.Share, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+	var deletedAt int64
+	s := conversions.DBShare{ID: id.OpaqueId}
+	query := `select coalesce(uid_owner, ''), coalesce(uid_initiator, ''), lower(coalesce(share_with, '')), coalesce(fileid_prefix, ''), coalesce(item_source, ''), coalesce(item_type, ''),
+				stime, permissions, share_type, coalesce(expiration, ''), deleted_at
+			  FROM oc_share WHERE id=? AND deleted_at IS NOT NULL AND (share_type=? OR share_type=?)`
+	if err := m.db.QueryRowContext(ctx, query, id.OpaqueId, shareTypeUser, shareTypeGroup).Scan(&s.UIDOwner, &s.UIDInitiator, &s.ShareWith, &s.Prefix, &s.ItemSource, &s.ItemType,
+		&s.STime, &s.Permissions, &s.ShareType, &s.Expiration, &deletedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errtypes.NotFound(id.OpaqueId)
+		}
+		return nil, err
+	}
+	if time.Since(time.Unix(deletedAt, 0)) > time.Duration(m.c.RestoreWindow)*time.Second {
+		return nil, errtypes.NotFound(id.OpaqueId)
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "sql: error starting transaction")
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var existing int
+	query = "select count(*) FROM oc_share WHERE deleted_at IS NULL AND uid_owner=? AND fileid_prefix=? AND item_source=? AND share_type=? AND lower(share_with)=lower(?)"
+	if err := tx.QueryRowContext(ctx, query, s.UIDOwner, s.Prefix, s.ItemSource, s.ShareType, s.ShareWith).Scan(&existing); err != nil {
+		return nil, err
+	}
+	if existing > 0 {
+		return nil, errtypes.AlreadyExists(id.OpaqueId)
+	}
+
+	res, err := tx.ExecContext(ctx, "update oc_share set deleted_at=NULL where deleted_at IS NOT NULL AND id=?", id.OpaqueId)
+	if err != nil {
+		return nil, err
+	}
+	rowCnt, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowCnt == 0 {
+		return nil, errtypes.NotFound(id.OpaqueId)
+	}
+
+	var gtype userpb.UserType
+	if s.ShareType == shareTypeUser {
+		gtype, _ = m.getUserType(ctx, s.ShareWith)
+	}
+	share := convertToCS3Share(s, gtype)
+	md := &provider.ResourceInfo{Id: share.ResourceId}
+	if err := granter.AddGrant(ctx, md, share); err != nil {
+		return nil, errors.Wrap(err, "sql: error adding the grant of the restored share")
+	}
+	if err := tx.Commit(); err != nil {
+		if err := granter.RemoveGrant(context.WithoutCancel(ctx), md, share); err != nil {
+			appctx.GetLogger(ctx).Error().Err(err).Str("share_id", id.OpaqueId).Msg("sql: error removing the grant of the share")
+		}
+		return nil, errors.Wrap(err, "sql: error committing transaction")
+	}
+
+	m.recordHistory(ctx, share, HistoryRestored, 0, s.Permissions)
+	return share, nil
+}
+
+// purgeDeletedShares is the periodic task deleting the shares
+// deleted more than the restore window ago, with their states.
+func (m *mgr) purgeDeletedShares(ctx context.Context) error {
+	before := time.Now().Add(-time.Duration(m.c.RestoreWindow) * time.Second).Unix()
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "sql: error starting transaction")
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if _, err := tx.ExecContext(ctx, "delete tr from oc_share_status tr join oc_share ts on tr.id = ts.id where ts.deleted_at IS NOT NULL AND ts.deleted_at < ?", before); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "delete st from oc_share_link_stats st join oc_share ts on st.share_id = ts.id where ts.deleted_at IS NOT NULL AND ts.deleted_at < ?", before); err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, "delete from oc_share where deleted_at IS NOT NULL AND deleted_at < ?", before)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "sql: error committing transaction")
+	}
+
+	if n, _ := res.RowsAffected(); n > 0 {
+		appctx.GetLogger(ctx).Info().Int64("shares", n).Msg("sql: purged deleted shares")
+	}
+	return nil
+}
+
+// CleanupShareStates deletes the states of the shares that do not exist
+// anymore, left behind before the states were deleted with their shares.
+// It returns the number of deleted states.
+func (m *mgr) CleanupShareStates(ctx context.Context) (int64, error) {
+	res, err := m.db.ExecContext(ctx, "delete tr from oc_share_status tr left join oc_share ts on tr.id = ts.id where ts.id IS NULL")
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	appctx.GetLogger(ctx).Info().Int64("states", n).Msg("sql: cleaned up share states")
+	return n, nil
+}
+
+// DeleteShares deletes the shares with the given ids on behalf of an admin,
+// the same way Unshare does: the shares are kept for the restore window,
+// the deletion is recorded in their history as done by actor and their
+// reshares follow reshareUnshare. It returns the number of deleted shares.
+func DeleteShares(ctx context.Context, db *sql.DB, actor, reshareUnshare string, ids []string) (int64, error) {
+	m := &mgr{c: &config{ReshareUnshare: reshareUnshare}, db: db}
+
+	var deleted int64
+	for _, id := range ids {
+		var (
+			owner       string
+			permissions int
+		)
+		query := "select coalesce(uid_owner, ''), permissions FROM oc_share WHERE id=? AND deleted_at IS NULL"
+		if err := db.QueryRowContext(ctx, query, id).Scan(&owner, &permissions); err != nil {
+			if err == sql.ErrNoRows {
+				continue
+			}
+			return deleted, err
+		}
+
+		res, err := db.ExecContext(ctx, "update oc_share set deleted_at=? where deleted_at IS NULL AND id=?", time.Now().Unix(), id)
+		if err != nil {
+			return deleted, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+		deleted++
+		m.insertHistory(ctx, id, HistoryDeleted, actor, owner, permissions, 0)
+		m.unshareReshares(ctx, id)
+	}
+	return deleted, nil
+}
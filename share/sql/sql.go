@@ -23,12 +23,16 @@ import (
 	"database/sql"
 	"fmt"
 	"path"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/bluele/gcache"
+	"github.com/cernbox/reva-plugins/events"
+	"github.com/cernbox/reva-plugins/runner"
+	"github.com/cernbox/reva-plugins/share/usertype"
 	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
-	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
 	collaboration "github.com/cs3org/go-cs3apis/cs3/sharing/collaboration/v1beta1"
 	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
 	typespb "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
@@ -36,7 +40,6 @@ import (
 	"github.com/cs3org/reva/pkg/appctx"
 	conversions "github.com/cs3org/reva/pkg/cbox/utils"
 	"github.com/cs3org/reva/pkg/errtypes"
-	"github.com/cs3org/reva/pkg/rgrpc/status"
 	"github.com/cs3org/reva/pkg/rgrpc/todo/pool"
 	"github.com/cs3org/reva/pkg/share"
 	"github.com/cs3org/reva/pkg/sharedconf"
@@ -57,6 +60,26 @@ const (
 	projectSpaceGroupsPrefix      = "cernbox-project-"
 	projectSpaceAdminGroupsSuffix = "-admins"
 	projectPathPrefix             = "/eos/project/"
+
+	// expirationFormat is the layout of the expiration column, stored in UTC.
+	expirationFormat = "2006-01-02 15:04:05"
+	// notExpiredCondition excludes the expired shares from the queries.
+	notExpiredCondition = "(expiration IS NULL OR expiration > UTC_TIMESTAMP())"
+)
+
+// Filter types in addition to the cs3 ones.
+const (
+	// FilterTypeExpired selects only the expired shares. Without
+	// it the expired shares are excluded from the listings.
+	FilterTypeExpired collaboration.Filter_Type = 100
+	// FilterTypeHidden and FilterTypeNotHidden select the received
+	// shares hidden or not hidden by the recipient.
+	FilterTypeHidden    collaboration.Filter_Type = 101
+	FilterTypeNotHidden collaboration.Filter_Type = 102
+	// FilterTypeProjectShares lists to the project admins all the
+	// shares of the projects they administer, including the ones
+	// created by the other admins.
+	FilterTypeProjectShares collaboration.Filter_Type = 103
 )
 
 func init() {
@@ -71,25 +94,143 @@ func (mgr) RevaPlugin() reva.PluginInfo {
 }
 
 type config struct {
-	DBUsername string `mapstructure:"db_username"`
+	DBUsername string `mapstructure:"db_username" validate:"required"`
 	DBPassword string `mapstructure:"db_password"`
-	DBHost     string `mapstructure:"db_host"`
-	DBPort     int    `mapstructure:"db_port"`
-	DBName     string `mapstructure:"db_name"`
+	DBHost     string `mapstructure:"db_host"     validate:"required"`
+	DBPort     int    `mapstructure:"db_port"     validate:"required,min=1,max=65535"`
+	DBName     string `mapstructure:"db_name"     validate:"required"`
 	GatewaySvc string `mapstructure:"gatewaysvc"`
+	// MaxOpenConns, MaxIdleConns and ConnMaxLifetime (in seconds)
+	// configure the pool of the db connections.
+	MaxOpenConns    int `mapstructure:"max_open_conns"    validate:"min=0"`
+	MaxIdleConns    int `mapstructure:"max_idle_conns"    validate:"min=0"`
+	ConnMaxLifetime int `mapstructure:"conn_max_lifetime" validate:"min=0"`
+	// QueryTimeout is the timeout in seconds of each operation
+	// of the manager. Defaults to 30 seconds.
+	QueryTimeout int `mapstructure:"query_timeout" validate:"min=0"`
+	// GroupsJoinThreshold is the number of groups of a user above which the
+	// received shares are listed joining a temporary table of the groups,
+	// instead of matching them with a list of parameters. Defaults to 50.
+	GroupsJoinThreshold int `mapstructure:"groups_join_threshold" validate:"min=0"`
+	// ProjectAdminListing always lists to the project admins all the shares
+	// of their projects, as with the FilterTypeProjectShares filter.
+	ProjectAdminListing bool `mapstructure:"project_admin_listing"`
+	// ProjectOwnersCacheTTL is the time in seconds the owners of the
+	// project spaces are cached. Defaults to 300 seconds.
+	ProjectOwnersCacheTTL int `mapstructure:"project_owners_cache_ttl" validate:"min=0"`
+	// TrackReshares records the share a new share reshares, when
+	// the creator received the resource through another share.
+	TrackReshares bool `mapstructure:"track_reshares"`
+	// ReshareUnshare is what happens to the reshares of a deleted share:
+	// "keep" them, "cascade" the deletion or mark them "orphan".
+	// Defaults to keep.
+	ReshareUnshare string `mapstructure:"reshare_unshare" validate:"oneof=keep cascade orphan"`
+	// Policy restricts the shares that can be created.
+	Policy policy `mapstructure:"policy"`
+	// Links configures the public links, when used by the public share provider.
+	Links linksConfig `mapstructure:"links"`
+	// UserTypeCache configures the cache of the types of the grantees.
+	UserTypeCache usertype.Config `mapstructure:"user_type_cache"`
+	// Events configures the publisher of the share events.
+	Events map[string]interface{} `mapstructure:"events"`
+	// OrphanCheckInterval is the interval in seconds between the checks
+	// of the shared resources, marking orphan the shares of the deleted
+	// ones. The check is disabled if 0.
+	OrphanCheckInterval int `mapstructure:"orphan_check_interval" validate:"min=0"`
+	// MachineUser and MachineSecret authenticate the orphan check in the gateway.
+	MachineUser   string `mapstructure:"machine_user"   validate:"required_with=OrphanCheckInterval"`
+	MachineSecret string `mapstructure:"machine_secret" validate:"required_with=OrphanCheckInterval"`
+	// RestoreWindow is the time in seconds a deleted share can be restored,
+	// before being purged. Defaults to 30 days.
+	RestoreWindow int `mapstructure:"restore_window" validate:"min=0"`
+	// PurgeInterval is the interval in seconds between the purges
+	// of the deleted shares. Defaults to 1 hour.
+	PurgeInterval int `mapstructure:"purge_interval" validate:"min=0"`
+	// ExpiredRetention is the number of days the expired shares and links
+	// are kept before being purged by the janitor. The janitor is disabled if 0.
+	ExpiredRetention int `mapstructure:"expired_retention" validate:"min=0"`
+	// ArchiveExpired copies the expired shares in the oc_share_archive
+	// table before purging them. It requires the migrations 6 and 17.
+	ArchiveExpired bool `mapstructure:"archive_expired"`
+	// JanitorInterval is the interval in seconds between the runs
+	// of the janitor. Defaults to 1 day.
+	JanitorInterval int `mapstructure:"janitor_interval" validate:"min=0"`
+	// CleanupShareStates deletes at startup the states of the shares
+	// that do not exist anymore.
+	CleanupShareStates bool `mapstructure:"cleanup_share_states"`
 }
 
 type mgr struct {
-	c  *config
-	db *sql.DB
+	c         *config
+	db        *sql.DB
+	events    events.Publisher
+	runner    *runner.Runner
+	userTypes *usertype.Resolver
+	// ownersCache caches the owners of the project spaces by path
+	ownersCache gcache.Cache
 }
 
 func (c *config) ApplyDefaults() {
 	c.GatewaySvc = sharedconf.GetGatewaySVC(c.GatewaySvc)
+	if c.MaxOpenConns == 0 {
+		c.MaxOpenConns = 50
+	}
+	if c.MaxIdleConns == 0 {
+		c.MaxIdleConns = 10
+	}
+	if c.ConnMaxLifetime == 0 {
+		c.ConnMaxLifetime = 3600
+	}
+	if c.QueryTimeout == 0 {
+		c.QueryTimeout = 30
+	}
+	if c.JanitorInterval == 0 {
+		c.JanitorInterval = 24 * 3600
+	}
+	if c.ReshareUnshare == "" {
+		c.ReshareUnshare = "keep"
+	}
+	if c.GroupsJoinThreshold == 0 {
+		c.GroupsJoinThreshold = 50
+	}
+	if c.RestoreWindow == 0 {
+		c.RestoreWindow = 30 * 24 * 3600
+	}
+	if c.PurgeInterval == 0 {
+		c.PurgeInterval = 3600
+	}
+	if c.ProjectOwnersCacheTTL == 0 {
+		c.ProjectOwnersCacheTTL = 300
+	}
+	c.Links.applyDefaults()
 }
 
 // New returns a new share manager.
 func New(ctx context.Context, m map[string]interface{}) (share.Manager, error) {
+	manager, err := newManager(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+
+	c := manager.c
+	if c.OrphanCheckInterval > 0 {
+		manager.runner.Every("sql: check orphan shares", time.Duration(c.OrphanCheckInterval)*time.Second, false, manager.checkOrphans)
+	}
+	manager.runner.Every("sql: purge deleted shares", time.Duration(c.PurgeInterval)*time.Second, false, manager.purgeDeletedShares)
+	if c.ExpiredRetention > 0 {
+		manager.runner.Every("sql: purge expired shares", time.Duration(c.JanitorInterval)*time.Second, false, manager.runJanitor)
+	}
+	if c.CleanupShareStates {
+		manager.runner.Go("sql: clean up share states", runner.RestartNever, func(ctx context.Context) error {
+			_, err := manager.CleanupShareStates(ctx)
+			return err
+		})
+	}
+	return &instrumentedMgr{mgr: manager}, nil
+}
+
+// newManager returns a share manager without its background tasks.
+func newManager(ctx context.Context, m map[string]interface{}) (*mgr, error) {
 	var c config
 	if err := cfg.Decode(m, &c); err != nil {
 		return nil, err
@@ -99,14 +240,51 @@ func New(ctx context.Context, m map[string]interface{}) (share.Manager, error) {
 	if err != nil {
 		return nil, err
 	}
+	db.SetMaxOpenConns(c.MaxOpenConns)
+	db.SetMaxIdleConns(c.MaxIdleConns)
+	db.SetConnMaxLifetime(time.Duration(c.ConnMaxLifetime) * time.Second)
+
+	if err := checkSchema(ctx, db, &c); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	publisher, err := events.New(c.Events)
+	if err != nil {
+		return nil, err
+	}
+
+	ownersCache := gcache.New(projectOwnersCacheSize).LRU().
+		Expiration(time.Duration(c.ProjectOwnersCacheTTL) * time.Second).Build()
 
 	return &mgr{
-		c:  &c,
-		db: db,
+		c:           &c,
+		db:          db,
+		events:      publisher,
+		runner:      runner.New(context.Background()),
+		userTypes:   usertype.New(c.GatewaySvc, c.UserTypeCache),
+		ownersCache: ownersCache,
 	}, nil
 }
 
+// withTimeout bounds the operations of the manager, so that
+// a stuck db does not block the share provider.
+func (m *mgr) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, time.Duration(m.c.QueryTimeout)*time.Second)
+}
+
+// Close stops the background tasks, the events publisher
+// and closes the db connections.
+func (m *mgr) Close() error {
+	_ = m.runner.Close()
+	_ = m.userTypes.Close()
+	_ = m.events.Close()
+	return m.db.Close()
+}
+
 func (m *mgr) Share(ctx context.Context, md *provider.ResourceInfo, g *collaboration.ShareGrant) (*collaboration.Share, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
 	user := appctx.ContextMustGetUser(ctx)
 
 	// do not allow share to myself or the owner if share is for a user
@@ -116,6 +294,18 @@ func (m *mgr) Share(ctx context.Context, md *provider.ResourceInfo, g *collabora
 		return nil, errors.New("sql: owner/creator and grantee are the same")
 	}
 
+	if isExpired(g.Expiration) {
+		return nil, errtypes.BadRequest("sql: expiration must be in the future")
+	}
+
+	if err := m.checkDenial(user, md.Path, g.Permissions); err != nil {
+		return nil, err
+	}
+
+	if err := m.checkPolicy(ctx, conversions.ResourceTypeToItem(md.Type), g); err != nil {
+		return nil, err
+	}
+
 	// check if share already exists.
 	key := &collaboration.ShareKey{
 		Owner:      md.Owner,
@@ -130,10 +320,26 @@ func (m *mgr) Share(ctx context.Context, md *provider.ResourceInfo, g *collabora
 	}
 
 	now := time.Now().Unix()
-	ts := &typespb.Timestamp{
-		Seconds: uint64(now),
+	stmtString, stmtValues := newShareStatement(user, md, g, m.parentShare(ctx, user, md), now)
+
+	result, err := m.db.ExecContext(ctx, stmtString, stmtValues...)
+	if err != nil {
+		return nil, err
+	}
+	lastID, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
 	}
 
+	s := newShare(user, md, g, lastID, now)
+	m.publishShareCreated(ctx, user, md, s)
+	m.recordHistory(ctx, s, HistoryCreated, 0, conversions.SharePermToInt(g.Permissions.Permissions))
+	return s, nil
+}
+
+// newShareStatement returns the statement inserting in the db a new share,
+// with the id of the share it reshares if any.
+func newShareStatement(user *userpb.User, md *provider.ResourceInfo, g *collaboration.ShareGrant, parent interface{}, now int64) (string, []interface{}) {
 	shareType, shareWith := conversions.FormatGrantee(g.Grantee)
 	itemType := conversions.ResourceTypeToItem(md.Type)
 	targetPath := path.Join("/", path.Base(md.Path))
@@ -147,25 +353,18 @@ func (m *mgr) Share(ctx context.Context, md *provider.ResourceInfo, g *collabora
 		fileSource = 0
 	}
 
-	stmtString := "insert into oc_share set share_type=?,uid_owner=?,uid_initiator=?,item_type=?,fileid_prefix=?,item_source=?,file_source=?,permissions=?,stime=?,share_with=?,file_target=?"
-	stmtValues := []interface{}{shareType, conversions.FormatUserID(md.Owner), conversions.FormatUserID(user.Id), itemType, prefix, itemSource, fileSource, permissions, now, shareWith, targetPath}
+	stmtString := "insert into oc_share set share_type=?,uid_owner=?,uid_initiator=?,item_type=?,fileid_prefix=?,item_source=?,file_source=?,permissions=?,stime=?,share_with=?,file_target=?,expiration=?,parent_share_id=?,initial_path=?"
+	stmtValues := []interface{}{shareType, conversions.FormatUserID(md.Owner), conversions.FormatUserID(user.Id), itemType, prefix, itemSource, fileSource, permissions, now, shareWith, targetPath, formatExpiration(g.Expiration), parent, nullIfEmpty(md.Path)}
+	return stmtString, stmtValues
+}
 
-	stmt, err := m.db.Prepare(stmtString)
-	if err != nil {
-		return nil, err
-	}
-	result, err := stmt.Exec(stmtValues...)
-	if err != nil {
-		return nil, err
-	}
-	lastID, err := result.LastInsertId()
-	if err != nil {
-		return nil, err
+func newShare(user *userpb.User, md *provider.ResourceInfo, g *collaboration.ShareGrant, id, now int64) *collaboration.Share {
+	ts := &typespb.Timestamp{
+		Seconds: uint64(now),
 	}
-
 	return &collaboration.Share{
 		Id: &collaboration.ShareId{
-			OpaqueId: strconv.FormatInt(lastID, 10),
+			OpaqueId: strconv.FormatInt(id, 10),
 		},
 		ResourceId:  md.Id,
 		Permissions: g.Permissions,
@@ -174,26 +373,49 @@ func (m *mgr) Share(ctx context.Context, md *provider.ResourceInfo, g *collabora
 		Creator:     user.Id,
 		Ctime:       ts,
 		Mtime:       ts,
-	}, nil
+		Expiration:  g.Expiration,
+	}
+}
+
+func (m *mgr) publishShareCreated(ctx context.Context, user *userpb.User, md *provider.ResourceInfo, s *collaboration.Share) {
+	id := s.Id.OpaqueId
+	shareType, shareWith := conversions.FormatGrantee(s.Grantee)
+	permissions := conversions.SharePermToInt(s.Permissions.Permissions)
+	err := m.events.Publish(ctx, &events.Event{
+		Type:       events.ShareCreated,
+		Actor:      user.Username,
+		Recipients: []string{shareWith},
+		Data: map[string]string{
+			"share_id":    id,
+			"share_type":  strconv.Itoa(shareType),
+			"path":        md.Path,
+			"storage_id":  md.Id.StorageId,
+			"opaque_id":   md.Id.OpaqueId,
+			"permissions": strconv.Itoa(permissions),
+		},
+	})
+	if err != nil {
+		appctx.GetLogger(ctx).Error().Err(err).Str("share_id", id).Msg("sql: error publishing share event")
+	}
 }
 
 func (m *mgr) getByID(ctx context.Context, id *collaboration.ShareId, checkOwner bool) (*collaboration.Share, error) {
 	uid := conversions.FormatUserID(appctx.ContextMustGetUser(ctx).Id)
 	s := conversions.DBShare{ID: id.OpaqueId}
-	query := "select coalesce(uid_owner, '') as uid_owner, coalesce(uid_initiator, '') as uid_initiator, lower(coalesce(share_with, '')) as share_with, coalesce(fileid_prefix, '') as fileid_prefix, coalesce(item_source, '') as item_source, coalesce(item_type, '') as item_type, stime, permissions, share_type FROM oc_share WHERE (orphan = 0 or orphan IS NULL) AND id=?"
+	query := "select coalesce(uid_owner, '') as uid_owner, coalesce(uid_initiator, '') as uid_initiator, lower(coalesce(share_with, '')) as share_with, coalesce(fileid_prefix, '') as fileid_prefix, coalesce(item_source, '') as item_source, coalesce(item_type, '') as item_type, stime, permissions, share_type, coalesce(expiration, '') as expiration FROM oc_share WHERE (orphan = 0 or orphan IS NULL) AND deleted_at IS NULL AND id=?"
 	params := []interface{}{id.OpaqueId}
 	if checkOwner {
 		query += " AND (uid_owner=? or uid_initiator=?)"
 		params = append(params, uid, uid)
 	}
-	if err := m.db.QueryRow(query, params...).Scan(&s.UIDOwner, &s.UIDInitiator, &s.ShareWith, &s.Prefix, &s.ItemSource, &s.ItemType, &s.STime, &s.Permissions, &s.ShareType); err != nil {
+	if err := m.db.QueryRowContext(ctx, query, params...).Scan(&s.UIDOwner, &s.UIDInitiator, &s.ShareWith, &s.Prefix, &s.ItemSource, &s.ItemType, &s.STime, &s.Permissions, &s.ShareType, &s.Expiration); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, errtypes.NotFound(id.OpaqueId)
 		}
 		return nil, err
 	}
 	// the grantee type is resolved afterwards when needed
-	return conversions.ConvertToCS3Share(s, userpb.UserType_USER_TYPE_INVALID), nil
+	return convertToCS3Share(s, userpb.UserType_USER_TYPE_INVALID), nil
 }
 
 func (m *mgr) getByKey(ctx context.Context, key *collaboration.ShareKey, checkOwner bool) (*collaboration.Share, error) {
@@ -202,23 +424,25 @@ func (m *mgr) getByKey(ctx context.Context, key *collaboration.ShareKey, checkOw
 
 	s := conversions.DBShare{}
 	shareType, shareWith := conversions.FormatGrantee(key.Grantee)
-	query := "select coalesce(uid_owner, '') as uid_owner, coalesce(uid_initiator, '') as uid_initiator, lower(coalesce(share_with, '')) as share_with, coalesce(fileid_prefix, '') as fileid_prefix, coalesce(item_source, '') as item_source, coalesce(item_type, '') as item_type, id, stime, permissions, share_type FROM oc_share WHERE (orphan = 0 or orphan IS NULL) AND uid_owner=? AND fileid_prefix=? AND item_source=? AND share_type=? AND lower(share_with)=lower(?)"
+	query := "select coalesce(uid_owner, '') as uid_owner, coalesce(uid_initiator, '') as uid_initiator, lower(coalesce(share_with, '')) as share_with, coalesce(fileid_prefix, '') as fileid_prefix, coalesce(item_source, '') as item_source, coalesce(item_type, '') as item_type, id, stime, permissions, share_type, coalesce(expiration, '') as expiration FROM oc_share WHERE (orphan = 0 or orphan IS NULL) AND deleted_at IS NULL AND uid_owner=? AND fileid_prefix=? AND item_source=? AND share_type=? AND lower(share_with)=lower(?)"
 	params := []interface{}{owner, key.ResourceId.StorageId, key.ResourceId.OpaqueId, shareType, shareWith}
 	if checkOwner {
 		query += " AND (uid_owner=? or uid_initiator=?)"
 		params = append(params, uid, uid)
 	}
-	if err := m.db.QueryRow(query, params...).Scan(&s.UIDOwner, &s.UIDInitiator, &s.ShareWith, &s.Prefix, &s.ItemSource, &s.ItemType, &s.ID, &s.STime, &s.Permissions, &s.ShareType); err != nil {
+	if err := m.db.QueryRowContext(ctx, query, params...).Scan(&s.UIDOwner, &s.UIDInitiator, &s.ShareWith, &s.Prefix, &s.ItemSource, &s.ItemType, &s.ID, &s.STime, &s.Permissions, &s.ShareType, &s.Expiration); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, errtypes.NotFound(key.String())
 		}
 		return nil, err
 	}
 	// the grantee type is resolved afterwards when needed
-	return conversions.ConvertToCS3Share(s, userpb.UserType_USER_TYPE_INVALID), nil
+	return convertToCS3Share(s, userpb.UserType_USER_TYPE_INVALID), nil
 }
 
 func (m *mgr) GetShare(ctx context.Context, ref *collaboration.ShareReference) (*collaboration.Share, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
 	var s *collaboration.Share
 	var err error
 	switch {
@@ -258,18 +482,22 @@ func (m *mgr) GetShare(ctx context.Context, ref *collaboration.ShareReference) (
 	return s, errtypes.NotFound("share not found")
 }
 
+// Unshare soft deletes the share, that can be restored with RestoreShare
+// until it is purged, after the configured restore window.
 func (m *mgr) Unshare(ctx context.Context, ref *collaboration.ShareReference) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
 	var query string
-	params := []interface{}{}
+	params := []interface{}{time.Now().Unix()}
 	switch {
 	case ref.GetId() != nil:
-		query = "delete from oc_share where id=?"
+		query = "update oc_share set deleted_at=? where deleted_at IS NULL AND id=?"
 		params = append(params, ref.GetId().OpaqueId)
 	case ref.GetKey() != nil:
 		key := ref.GetKey()
 		shareType, shareWith := conversions.FormatGrantee(key.Grantee)
 		owner := conversions.FormatUserID(key.Owner)
-		query = "delete from oc_share where uid_owner=? AND fileid_prefix=? AND item_source=? AND share_type=? AND lower(share_with)=lower(?)"
+		query = "update oc_share set deleted_at=? where deleted_at IS NULL AND uid_owner=? AND fileid_prefix=? AND item_source=? AND share_type=? AND lower(share_with)=lower(?)"
 		params = append(params, owner, key.ResourceId.StorageId, key.ResourceId.OpaqueId, shareType, shareWith)
 	default:
 		return errtypes.NotFound(ref.String())
@@ -285,11 +513,10 @@ func (m *mgr) Unshare(ctx context.Context, ref *collaboration.ShareReference) er
 		return err
 	}
 
-	stmt, err := m.db.Prepare(query)
-	if err != nil {
-		return err
-	}
-	res, err := stmt.Exec(params...)
+	// keep the share for its history
+	old, _ := m.GetShare(ctx, ref)
+
+	res, err := m.db.ExecContext(ctx, query, params...)
 	if err != nil {
 		return err
 	}
@@ -301,24 +528,59 @@ func (m *mgr) Unshare(ctx context.Context, ref *collaboration.ShareReference) er
 	if rowCnt == 0 {
 		return errtypes.NotFound(ref.String())
 	}
+	if old != nil {
+		m.recordHistory(ctx, old, HistoryDeleted, conversions.SharePermToInt(old.Permissions.Permissions), 0)
+		m.unshareReshares(ctx, old.Id.OpaqueId)
+	}
 	return nil
 }
 
 func (m *mgr) UpdateShare(ctx context.Context, ref *collaboration.ShareReference, p *collaboration.SharePermissions) (*collaboration.Share, error) {
-	permissions := conversions.SharePermToInt(p.Permissions)
+	if p == nil {
+		return nil, errtypes.BadRequest("sql: missing share permissions")
+	}
+	return m.updateShare(ctx, ref, p, false, nil)
+}
+
+// UpdateShareExpiration sets the expiration of a share. A nil
+// expiration makes the share permanent.
+func (m *mgr) UpdateShareExpiration(ctx context.Context, ref *collaboration.ShareReference, expiration *typespb.Timestamp) (*collaboration.Share, error) {
+	if isExpired(expiration) {
+		return nil, errtypes.BadRequest("sql: expiration must be in the future")
+	}
+	return m.updateShare(ctx, ref, nil, true, expiration)
+}
+
+// updateShare updates the permissions of a share if p is not nil,
+// and its expiration if updateExpiration is set.
+func (m *mgr) updateShare(ctx context.Context, ref *collaboration.ShareReference, p *collaboration.SharePermissions, updateExpiration bool, expiration *typespb.Timestamp) (*collaboration.Share, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	set := "stime=?"
+	params := []interface{}{time.Now().Unix()}
+	var permissions int
+	if p != nil {
+		permissions = conversions.SharePermToInt(p.Permissions)
+		set += ",permissions=?"
+		params = append(params, permissions)
+	}
+	if updateExpiration {
+		set += ",expiration=?"
+		params = append(params, formatExpiration(expiration))
+	}
 
 	var query string
-	params := []interface{}{}
 	switch {
 	case ref.GetId() != nil:
-		query = "update oc_share set permissions=?,stime=? where id=?"
-		params = append(params, permissions, time.Now().Unix(), ref.GetId().OpaqueId)
+		query = "update oc_share set " + set + " where deleted_at IS NULL AND id=?"
+		params = append(params, ref.GetId().OpaqueId)
 	case ref.GetKey() != nil:
 		key := ref.GetKey()
 		shareType, shareWith := conversions.FormatGrantee(key.Grantee)
 		owner := conversions.FormatUserID(key.Owner)
-		query = "update oc_share set permissions=?,stime=? where (uid_owner=? or uid_initiator=?) AND fileid_prefix=? AND item_source=? AND share_type=? AND lower(share_with)=lower(?)"
-		params = append(params, permissions, time.Now().Unix(), owner, owner, key.ResourceId.StorageId, key.ResourceId.OpaqueId, shareType, shareWith)
+		query = "update oc_share set " + set + " where deleted_at IS NULL AND (uid_owner=? or uid_initiator=?) AND fileid_prefix=? AND item_source=? AND share_type=? AND lower(share_with)=lower(?)"
+		params = append(params, owner, owner, key.ResourceId.StorageId, key.ResourceId.OpaqueId, shareType, shareWith)
 	default:
 		return nil, errtypes.NotFound(ref.String())
 	}
@@ -328,20 +590,47 @@ func (m *mgr) UpdateShare(ctx context.Context, ref *collaboration.ShareReference
 		return nil, err
 	}
 
+	if p != nil && isDenial(p) {
+		path, _ := appctx.ContextGetResourcePath(ctx)
+		if err := m.checkDenial(appctx.ContextMustGetUser(ctx), path, p); err != nil {
+			return nil, err
+		}
+	}
+
 	query, params, err = m.appendUidOwnerFilters(ctx, query, params)
 	if err != nil {
 		return nil, err
 	}
 
-	stmt, err := m.db.Prepare(query)
+	// the policy is checked on the updated share, and the update
+	// is refused if the share can not be read
+	old, err := m.GetShare(ctx, ref)
 	if err != nil {
 		return nil, err
 	}
-	if _, err = stmt.Exec(params...); err != nil {
+	grant := &collaboration.ShareGrant{Grantee: old.Grantee, Permissions: old.Permissions, Expiration: old.Expiration}
+	if p != nil {
+		grant.Permissions = p
+	}
+	if updateExpiration {
+		grant.Expiration = expiration
+	}
+	if err := m.checkPolicy(ctx, m.itemType(ctx, old.Id), grant); err != nil {
+		return nil, err
+	}
+
+	if _, err = m.db.ExecContext(ctx, query, params...); err != nil {
 		return nil, err
 	}
 
-	return m.GetShare(ctx, ref)
+	s, err := m.GetShare(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	if p != nil {
+		m.recordHistory(ctx, s, HistoryUpdated, conversions.SharePermToInt(old.Permissions.Permissions), permissions)
+	}
+	return s, nil
 }
 
 func (m *mgr) getPath(ctx context.Context, resID *provider.ResourceId) (string, error) {
@@ -394,6 +683,29 @@ func (m *mgr) isProjectAdminFromCtx(ctx context.Context, u *userpb.User) bool {
 	return m.isProjectAdmin(u, path)
 }
 
+// isDenial returns true if the permissions deny the access to the resource.
+func isDenial(p *collaboration.SharePermissions) bool {
+	return conversions.SharePermToInt(p.GetPermissions()) == 0
+}
+
+// checkDenial validates the permissions of a new share. Denials can only be
+// created on project spaces, by the admins of the project.
+func (m *mgr) checkDenial(u *userpb.User, path string, p *collaboration.SharePermissions) error {
+	if p == nil {
+		return errtypes.BadRequest("sql: missing share permissions")
+	}
+	if !isDenial(p) {
+		return nil
+	}
+	if !strings.HasPrefix(path, projectPathPrefix) {
+		return errtypes.BadRequest("sql: denials are only supported in project spaces")
+	}
+	if !m.isProjectAdmin(u, path) {
+		return errtypes.PermissionDenied("sql: only the project admins can create denials")
+	}
+	return nil
+}
+
 func (m *mgr) isProjectAdmin(u *userpb.User, path string) bool {
 	if strings.HasPrefix(path, projectPathPrefix) {
 		// The path will look like /eos/project/c/cernbox, we need to extract the project name
@@ -423,13 +735,23 @@ func (m *mgr) isProjectAdmin(u *userpb.User, path string) bool {
 }
 
 func (m *mgr) ListShares(ctx context.Context, filters []*collaboration.Filter) ([]*collaboration.Share, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
 	query := `select coalesce(uid_owner, '') as uid_owner, coalesce(uid_initiator, '') as uid_initiator, lower(coalesce(share_with, '')) as share_with,
 				coalesce(fileid_prefix, '') as fileid_prefix, coalesce(item_source, '') as item_source, coalesce(item_type, '') as item_type,
-			  	id, stime, permissions, share_type
-			  FROM oc_share WHERE (orphan = 0 or orphan IS NULL) AND (share_type=? OR share_type=?)`
+			  	id, stime, permissions, share_type, coalesce(expiration, '') as expiration
+			  FROM oc_share WHERE (orphan = 0 or orphan IS NULL) AND deleted_at IS NULL AND (share_type=? OR share_type=?)`
 	params := []interface{}{shareTypeUser, shareTypeGroup}
 
 	groupedFilters := share.GroupFiltersByType(filters)
+	if hasReceivedFilters(groupedFilters) {
+		return nil, errtypes.BadRequest("sql: filter type only supported for received shares")
+	}
+	_, projectShares := groupedFilters[FilterTypeProjectShares]
+	delete(groupedFilters, FilterTypeProjectShares)
+	if _, ok := groupedFilters[FilterTypeExpired]; !ok {
+		query += " AND " + notExpiredCondition
+	}
 	if len(groupedFilters) > 0 {
 		filterQuery, filterParams, err := translateFilters(groupedFilters)
 		if err != nil {
@@ -441,12 +763,17 @@ func (m *mgr) ListShares(ctx context.Context, filters []*collaboration.Filter) (
 		}
 	}
 
-	query, params, err := m.appendUidOwnerFilters(ctx, query, params)
+	var err error
+	if projectShares || m.c.ProjectAdminListing {
+		query, params, err = m.appendProjectOwnerFilters(ctx, query, params)
+	} else {
+		query, params, err = m.appendUidOwnerFilters(ctx, query, params)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	rows, err := m.db.Query(query, params...)
+	rows, err := m.db.QueryContext(ctx, query, params...)
 	if err != nil {
 		return nil, err
 	}
@@ -455,14 +782,14 @@ func (m *mgr) ListShares(ctx context.Context, filters []*collaboration.Filter) (
 	var s conversions.DBShare
 	shares := []*collaboration.Share{}
 	for rows.Next() {
-		if err := rows.Scan(&s.UIDOwner, &s.UIDInitiator, &s.ShareWith, &s.Prefix, &s.ItemSource, &s.ItemType, &s.ID, &s.STime, &s.Permissions, &s.ShareType); err != nil {
+		if err := rows.Scan(&s.UIDOwner, &s.UIDInitiator, &s.ShareWith, &s.Prefix, &s.ItemSource, &s.ItemType, &s.ID, &s.STime, &s.Permissions, &s.ShareType, &s.Expiration); err != nil {
 			continue
 		}
 		gtype, _ := m.getUserType(ctx, s.ShareWith)
 		// if err != nil {
 		// failed to resolve grantee's user type, TODO Log
 		// }
-		shares = append(shares, conversions.ConvertToCS3Share(s, gtype))
+		shares = append(shares, convertToCS3Share(s, gtype))
 	}
 	if err = rows.Err(); err != nil {
 		return nil, err
@@ -473,26 +800,42 @@ func (m *mgr) ListShares(ctx context.Context, filters []*collaboration.Filter) (
 
 // we list the shares that are targeted to the user in context or to the user groups.
 func (m *mgr) ListReceivedShares(ctx context.Context, filters []*collaboration.Filter) ([]*collaboration.ReceivedShare, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
 	user := appctx.ContextMustGetUser(ctx)
 	uid := conversions.FormatUserID(user.Id)
 
-	params := []interface{}{uid, uid, uid, uid}
-	for _, v := range user.Groups {
-		params = append(params, v)
+	// the temporary table of the groups only lives in the connection
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	groupsQuery, groupsParams, cleanup, err := m.groupsCondition(ctx, conn, user.Groups)
+	if err != nil {
+		return nil, err
 	}
+	defer cleanup()
+
+	params := []interface{}{uid, uid, uid, uid}
+	params = append(params, groupsParams...)
 
 	query := `SELECT coalesce(uid_owner, '') as uid_owner, coalesce(uid_initiator, '') as uid_initiator, lower(coalesce(share_with, '')) as share_with,
 	            coalesce(fileid_prefix, '') as fileid_prefix, coalesce(item_source, '') as item_source, coalesce(item_type, '') as item_type,
-				ts.id, stime, permissions, share_type, coalesce(tr.state, 0) as state
+				ts.id, stime, permissions, share_type, coalesce(tr.state, 0) as state, coalesce(expiration, '') as expiration, coalesce(tr.alias, '') as alias, coalesce(tr.hidden, 0) as hidden
 			  FROM oc_share ts LEFT JOIN oc_share_status tr ON (ts.id = tr.id AND tr.recipient = ?)
-			  WHERE (orphan = 0 or orphan IS NULL) AND (uid_owner != ? AND uid_initiator != ?)`
-	if len(user.Groups) > 0 {
-		query += " AND ((lower(share_with)=lower(?) AND share_type = 0) OR (share_type = 1 AND lower(share_with) in (?" + strings.Repeat(",?", len(user.Groups)-1) + ")))"
+			  WHERE (orphan = 0 or orphan IS NULL) AND deleted_at IS NULL AND (uid_owner != ? AND uid_initiator != ?)`
+	if groupsQuery != "" {
+		query += " AND ((lower(share_with)=lower(?) AND share_type = 0) OR (share_type = 1 AND " + groupsQuery + "))"
 	} else {
 		query += " AND (lower(share_with)=lower(?) AND share_type = 0)"
 	}
 
 	groupedFilters := share.GroupFiltersByType(filters)
+	if _, ok := groupedFilters[FilterTypeExpired]; !ok {
+		query += " AND " + notExpiredCondition
+	}
 	filterQuery, filterParams, err := translateFilters(groupedFilters)
 	if err != nil {
 		return nil, err
@@ -503,23 +846,26 @@ func (m *mgr) ListReceivedShares(ctx context.Context, filters []*collaboration.F
 		query = fmt.Sprintf("%s AND (%s)", query, filterQuery)
 	}
 
-	rows, err := m.db.Query(query, params...)
+	rows, err := conn.QueryContext(ctx, query, params...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var s conversions.DBShare
+	var (
+		s  conversions.DBShare
+		st shareStatus
+	)
 	shares := []*collaboration.ReceivedShare{}
 	for rows.Next() {
-		if err := rows.Scan(&s.UIDOwner, &s.UIDInitiator, &s.ShareWith, &s.Prefix, &s.ItemSource, &s.ItemType, &s.ID, &s.STime, &s.Permissions, &s.ShareType, &s.State); err != nil {
+		if err := rows.Scan(&s.UIDOwner, &s.UIDInitiator, &s.ShareWith, &s.Prefix, &s.ItemSource, &s.ItemType, &s.ID, &s.STime, &s.Permissions, &s.ShareType, &s.State, &s.Expiration, &st.Alias, &st.Hidden); err != nil {
 			continue
 		}
 		gtype, _ := m.getUserType(ctx, s.ShareWith)
 		// if err != nil {
 		// failed to resolve grantee's user type, TODO Log
 		// }
-		shares = append(shares, conversions.ConvertToCS3ReceivedShare(s, gtype))
+		shares = append(shares, convertToCS3ReceivedShare(s, st, gtype))
 	}
 	if err = rows.Err(); err != nil {
 		return nil, err
@@ -538,23 +884,24 @@ func (m *mgr) getReceivedByID(ctx context.Context, id *collaboration.ShareId, gt
 	}
 
 	s := conversions.DBShare{ID: id.OpaqueId}
+	var st shareStatus
 	query := `select coalesce(uid_owner, '') as uid_owner, coalesce(uid_initiator, '') as uid_initiator, lower(coalesce(share_with, '')) as share_with,
 			    coalesce(fileid_prefix, '') as fileid_prefix, coalesce(item_source, '') as item_source, coalesce(item_type, '') as item_type,
-				stime, permissions, share_type, coalesce(tr.state, 0) as state
+				stime, permissions, share_type, coalesce(tr.state, 0) as state, coalesce(expiration, '') as expiration, coalesce(tr.alias, '') as alias, coalesce(tr.hidden, 0) as hidden
 			  FROM oc_share ts LEFT JOIN oc_share_status tr ON (ts.id = tr.id AND tr.recipient = ?)
-			  WHERE (orphan = 0 or orphan IS NULL) AND ts.id=?`
+			  WHERE (orphan = 0 or orphan IS NULL) AND deleted_at IS NULL AND ts.id=? AND ` + notExpiredCondition
 	if len(user.Groups) > 0 {
 		query += " AND ((lower(share_with)=lower(?) AND share_type = 0) OR (share_type = 1 AND lower(share_with) in (?" + strings.Repeat(",?", len(user.Groups)-1) + ")))"
 	} else {
 		query += " AND (lower(share_with)=lower(?)  AND share_type = 0)"
 	}
-	if err := m.db.QueryRow(query, params...).Scan(&s.UIDOwner, &s.UIDInitiator, &s.ShareWith, &s.Prefix, &s.ItemSource, &s.ItemType, &s.STime, &s.Permissions, &s.ShareType, &s.State); err != nil {
+	if err := m.db.QueryRowContext(ctx, query, params...).Scan(&s.UIDOwner, &s.UIDInitiator, &s.ShareWith, &s.Prefix, &s.ItemSource, &s.ItemType, &s.STime, &s.Permissions, &s.ShareType, &s.State, &s.Expiration, &st.Alias, &st.Hidden); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, errtypes.NotFound(id.OpaqueId)
 		}
 		return nil, err
 	}
-	return conversions.ConvertToCS3ReceivedShare(s, gtype), nil
+	return convertToCS3ReceivedShare(s, st, gtype), nil
 }
 
 func (m *mgr) getReceivedByKey(ctx context.Context, key *collaboration.ShareKey, gtype userpb.UserType) (*collaboration.ReceivedShare, error) {
@@ -568,27 +915,30 @@ func (m *mgr) getReceivedByKey(ctx context.Context, key *collaboration.ShareKey,
 	}
 
 	s := conversions.DBShare{}
+	var st shareStatus
 	query := `select coalesce(uid_owner, '') as uid_owner, coalesce(uid_initiator, '') as uid_initiator, lower(coalesce(share_with, '')) as share_with,
 	            coalesce(fileid_prefix, '') as fileid_prefix, coalesce(item_source, '') as item_source, coalesce(item_type, '') as item_type,
-				ts.id, stime, permissions, share_type, coalesce(tr.state, 0) as state
+				ts.id, stime, permissions, share_type, coalesce(tr.state, 0) as state, coalesce(expiration, '') as expiration, coalesce(tr.alias, '') as alias, coalesce(tr.hidden, 0) as hidden
 			  FROM oc_share ts LEFT JOIN oc_share_status tr ON (ts.id = tr.id AND tr.recipient = ?)
-			  WHERE (orphan = 0 or orphan IS NULL) AND uid_owner=? AND fileid_prefix=? AND item_source=? AND share_type=? AND lower(share_with)=lower(?)`
+			  WHERE (orphan = 0 or orphan IS NULL) AND deleted_at IS NULL AND uid_owner=? AND fileid_prefix=? AND item_source=? AND share_type=? AND lower(share_with)=lower(?) AND ` + notExpiredCondition
 	if len(user.Groups) > 0 {
 		query += " AND ((lower(share_with)=lower(?) AND share_type = 0) OR (share_type = 1 AND lower(share_with) in (?" + strings.Repeat(",?", len(user.Groups)-1) + ")))"
 	} else {
 		query += " AND (lower(share_with)=lower(?) AND share_type = 0)"
 	}
 
-	if err := m.db.QueryRow(query, params...).Scan(&s.UIDOwner, &s.UIDInitiator, &s.ShareWith, &s.Prefix, &s.ItemSource, &s.ItemType, &s.ID, &s.STime, &s.Permissions, &s.ShareType, &s.State); err != nil {
+	if err := m.db.QueryRowContext(ctx, query, params...).Scan(&s.UIDOwner, &s.UIDInitiator, &s.ShareWith, &s.Prefix, &s.ItemSource, &s.ItemType, &s.ID, &s.STime, &s.Permissions, &s.ShareType, &s.State, &s.Expiration, &st.Alias, &st.Hidden); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, errtypes.NotFound(key.String())
 		}
 		return nil, err
 	}
-	return conversions.ConvertToCS3ReceivedShare(s, gtype), nil
+	return convertToCS3ReceivedShare(s, st, gtype), nil
 }
 
 func (m *mgr) GetReceivedShare(ctx context.Context, ref *collaboration.ShareReference) (*collaboration.ReceivedShare, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
 	var s *collaboration.ReceivedShare
 	var err error
 	switch {
@@ -613,6 +963,8 @@ func (m *mgr) GetReceivedShare(ctx context.Context, ref *collaboration.ShareRefe
 }
 
 func (m *mgr) UpdateReceivedShare(ctx context.Context, share *collaboration.ReceivedShare, fieldMask *field_mask.FieldMask) (*collaboration.ReceivedShare, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
 	user := appctx.ContextMustGetUser(ctx)
 
 	rs, err := m.GetReceivedShare(ctx, &collaboration.ShareReference{Spec: &collaboration.ShareReference_Id{Id: share.Share.Id}})
@@ -620,38 +972,63 @@ func (m *mgr) UpdateReceivedShare(ctx context.Context, share *collaboration.Rece
 		return nil, err
 	}
 
+	columns := []string{"state"}
+	var values []interface{}
 	for i := range fieldMask.Paths {
 		switch fieldMask.Paths[i] {
 		case "state":
 			rs.State = share.State
+		case "alias":
+			alias := strings.TrimSpace(share.GetMountPoint().GetPath())
+			if err := m.checkAlias(ctx, rs.Share.Id, alias); err != nil {
+				return nil, err
+			}
+			rs.MountPoint = share.MountPoint
+			columns = append(columns, "alias")
+			values = append(values, alias)
+		case "hidden":
+			rs.Hidden = share.Hidden
+			columns = append(columns, "hidden")
+			values = append(values, share.Hidden)
 		default:
 			return nil, errtypes.NotSupported("updating " + fieldMask.Paths[i] + " is not supported")
 		}
 	}
 
-	state := 0
-	switch rs.GetState() {
-	case collaboration.ShareState_SHARE_STATE_REJECTED:
-		state = -1
-	case collaboration.ShareState_SHARE_STATE_ACCEPTED:
-		state = 1
-	}
-
-	params := []interface{}{rs.Share.Id.OpaqueId, conversions.FormatUserID(user.Id), state, state}
-	query := "insert into oc_share_status(id, recipient, state) values(?, ?, ?) ON DUPLICATE KEY UPDATE state = ?"
-
-	stmt, err := m.db.Prepare(query)
-	if err != nil {
+	values = append([]interface{}{stateToDB(rs.GetState())}, values...)
+	if err := m.setShareStatus(ctx, user, rs.Share.Id, columns, values); err != nil {
 		return nil, err
 	}
-	_, err = stmt.Exec(params...)
-	if err != nil {
-		return nil, err
+
+	if slices.Contains(fieldMask.Paths, "state") {
+		perm := conversions.SharePermToInt(rs.Share.Permissions.Permissions)
+		switch rs.State {
+		case collaboration.ShareState_SHARE_STATE_ACCEPTED:
+			m.recordHistory(ctx, rs.Share, HistoryAccepted, perm, perm)
+		case collaboration.ShareState_SHARE_STATE_REJECTED:
+			m.recordHistory(ctx, rs.Share, HistoryRejected, perm, perm)
+		}
 	}
 
 	return rs, nil
 }
 
+// setShareStatus stores the given columns of the status of the received
+// share for the user, creating the status if it does not exist yet.
+func (m *mgr) setShareStatus(ctx context.Context, user *userpb.User, id *collaboration.ShareId, columns []string, values []interface{}) error {
+	updates := make([]string, 0, len(columns))
+	for _, c := range columns {
+		updates = append(updates, c+" = ?")
+	}
+	params := append([]interface{}{id.OpaqueId, conversions.FormatUserID(user.Id)}, values...)
+	params = append(params, values...)
+	query := fmt.Sprintf("insert into oc_share_status(id, recipient, %s) values(?, ?%s) ON DUPLICATE KEY UPDATE %s",
+		strings.Join(columns, ", "), strings.Repeat(", ?", len(columns)), strings.Join(updates, ", "))
+
+	_, err := m.db.ExecContext(ctx, query, params...)
+	return err
+}
+
 func (m *mgr) appendUidOwnerFilters(ctx context.Context, query string, params []interface{}) (string, []interface{}, error) {
 	uidOwnersQuery, uidOwnersParams, err := m.uidOwnerFilters(ctx)
 	if err != nil {
@@ -690,6 +1067,27 @@ func granteeTypeToShareType(granteeType provider.GranteeType) int {
 	return -1
 }
 
+// stateToDB returns the value of the state column for the state of a received share.
+func stateToDB(state collaboration.ShareState) int {
+	switch state {
+	case collaboration.ShareState_SHARE_STATE_REJECTED:
+		return -1
+	case collaboration.ShareState_SHARE_STATE_ACCEPTED:
+		return 1
+	}
+	return 0
+}
+
+// hasReceivedFilters returns true if some of the filters only apply to the received shares.
+func hasReceivedFilters(filters map[collaboration.Filter_Type][]*collaboration.Filter) bool {
+	for _, t := range []collaboration.Filter_Type{collaboration.Filter_TYPE_STATE, FilterTypeHidden, FilterTypeNotHidden} {
+		if _, ok := filters[t]; ok {
+			return true
+		}
+	}
+	return false
+}
+
 // translateFilters translates the filters to sql queries.
 func translateFilters(filters map[collaboration.Filter_Type][]*collaboration.Filter) (string, []interface{}, error) {
 	var (
@@ -729,6 +1127,23 @@ func translateFilters(filters map[collaboration.Filter_Type][]*collaboration.Fil
 		case collaboration.Filter_TYPE_EXCLUDE_DENIALS:
 			// TODO this may change once the mapping of permission to share types is completed (cf. pkg/cbox/utils/conversions.go)
 			filterQuery += "(permissions > 0)"
+		case FilterTypeExpired:
+			filterQuery += "(expiration IS NOT NULL AND expiration <= UTC_TIMESTAMP())"
+		case collaboration.Filter_TYPE_STATE:
+			filterQuery += "("
+			for i, f := range currFilters {
+				filterQuery += "coalesce(tr.state, 0)=?"
+				params = append(params, stateToDB(f.GetState()))
+
+				if i != len(currFilters)-1 {
+					filterQuery += " OR "
+				}
+			}
+			filterQuery += ")"
+		case FilterTypeHidden:
+			filterQuery += "(coalesce(tr.hidden, 0) = 1)"
+		case FilterTypeNotHidden:
+			filterQuery += "(coalesce(tr.hidden, 0) = 0)"
 		default:
 			return "", nil, fmt.Errorf("filter type is not supported")
 		}
@@ -741,20 +1156,5 @@ func translateFilters(filters map[collaboration.Filter_Type][]*collaboration.Fil
 }
 
 func (m *mgr) getUserType(ctx context.Context, username string) (userpb.UserType, error) {
-	client, err := pool.GetGatewayServiceClient(pool.Endpoint(m.c.GatewaySvc))
-	if err != nil {
-		return userpb.UserType_USER_TYPE_PRIMARY, err
-	}
-	userRes, err := client.GetUserByClaim(ctx, &userpb.GetUserByClaimRequest{
-		Claim: "username",
-		Value: username,
-	})
-	if err != nil {
-		return userpb.UserType_USER_TYPE_PRIMARY, errors.Wrapf(err, "error getting user by username '%v'", username)
-	}
-	if userRes.Status.Code != rpc.Code_CODE_OK {
-		return userpb.UserType_USER_TYPE_PRIMARY, status.NewErrorFromCode(userRes.Status.Code, "oidc")
-	}
-
-	return userRes.GetUser().Id.Type, nil
+	return m.userTypes.UserType(ctx, username)
 }
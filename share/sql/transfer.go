@@ -0,0 +1,124 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package sql
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	collaboration "github.com/cs3org/go-cs3apis/cs3/sharing/collaboration/v1beta1"
+	"github.com/cs3org/reva/pkg/appctx"
+	conversions "github.com/cs3org/reva/pkg/cbox/utils"
+	"github.com/cs3org/reva/pkg/errtypes"
+	"github.com/cs3org/reva/pkg/share"
+	"github.com/cs3org/reva/pkg/utils"
+	"github.com/pkg/errors"
+)
+
+// TransferShares moves the shares owned or created by fromUser to toUser,
+// optionally restricted by the filters. The shares with toUser as grantee
+// are left untouched, as they would become shares with themselves.
+// Every transferred share is recorded in the oc_share_transfer table.
+// It returns the ids of the transferred shares.
+func (m *mgr) TransferShares(ctx context.Context, fromUser, toUser *userpb.UserId, filters []*collaboration.Filter) ([]string, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+	if utils.UserEqual(fromUser, toUser) {
+		return nil, errtypes.BadRequest("sql: cannot transfer the shares to the same user")
+	}
+	actor := appctx.ContextMustGetUser(ctx)
+	from := conversions.FormatUserID(fromUser)
+	to := conversions.FormatUserID(toUser)
+
+	query := "select id FROM oc_share WHERE deleted_at IS NULL AND (uid_owner=? OR uid_initiator=?) AND (share_type=? OR share_type=?) AND NOT (share_type=? AND lower(share_with)=lower(?))"
+	params := []interface{}{from, from, shareTypeUser, shareTypeGroup, shareTypeUser, to}
+	groupedFilters := share.GroupFiltersByType(filters)
+	if hasReceivedFilters(groupedFilters) {
+		return nil, errtypes.BadRequest("sql: filter type only supported for received shares")
+	}
+	filterQuery, filterParams, err := translateFilters(groupedFilters)
+	if err != nil {
+		return nil, err
+	}
+	if filterQuery != "" {
+		query = fmt.Sprintf("%s AND (%s)", query, filterQuery)
+		params = append(params, filterParams...)
+	}
+	query += " FOR UPDATE"
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "sql: error starting transaction")
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	rows, err := tx.QueryContext(ctx, query, params...)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	in := "?" + strings.Repeat(",?", len(ids)-1)
+	idParams := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		idParams = append(idParams, id)
+	}
+
+	for _, column := range []string{"uid_owner", "uid_initiator"} {
+		update := fmt.Sprintf("update oc_share set %s=? where %s=? AND id in (%s)", column, column, in)
+		if _, err := tx.ExecContext(ctx, update, append([]interface{}{to, from}, idParams...)...); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now().Unix()
+	for _, id := range ids {
+		if _, err := tx.ExecContext(ctx, "insert into oc_share_transfer(share_id, uid_from, uid_to, actor, ttime) values(?, ?, ?, ?, ?)",
+			id, from, to, conversions.FormatUserID(actor.Id), now); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "sql: error committing transaction")
+	}
+	appctx.GetLogger(ctx).Info().Str("from", from).Str("to", to).Int("shares", len(ids)).Msg("sql: transferred shares")
+	return ids, nil
+}
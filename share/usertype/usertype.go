@@ -0,0 +1,182 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+// Package usertype resolves the type of the users by username through
+// the gateway, caching the results in memory or in redis.
+package usertype
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/bluele/gcache"
+	"github.com/cernbox/reva-plugins/redispool"
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	"github.com/cs3org/reva/pkg/rgrpc/status"
+	"github.com/cs3org/reva/pkg/rgrpc/todo/pool"
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+// Config configures the cache of the user types.
+type Config struct {
+	// TTL is the time in seconds a user type is cached. Defaults to 1 hour.
+	TTL int `mapstructure:"ttl"`
+	// Size is the number of users kept by the in-memory cache. Defaults to 100000.
+	Size int `mapstructure:"size"`
+	// RedisAddress enables the cache in redis, shared among the reva instances.
+	RedisAddress  string `mapstructure:"redis_address"`
+	RedisUsername string `mapstructure:"redis_username"`
+	RedisPassword string `mapstructure:"redis_password"`
+	// RedisPrefix is the prefix of the redis keys. Defaults to "usertype:".
+	RedisPrefix string `mapstructure:"redis_prefix"`
+}
+
+func (c *Config) applyDefaults() {
+	if c.TTL == 0 {
+		c.TTL = 3600
+	}
+	if c.Size == 0 {
+		c.Size = 100000
+	}
+	if c.RedisPrefix == "" {
+		c.RedisPrefix = "usertype:"
+	}
+}
+
+type store interface {
+	get(username string) (userpb.UserType, bool)
+	set(username string, t userpb.UserType, ttl time.Duration)
+	close() error
+}
+
+// Resolver resolves the type of the users.
+type Resolver struct {
+	gatewaySvc string
+	ttl        time.Duration
+	store      store
+}
+
+// New returns a resolver querying the given gateway.
+func New(gatewaySvc string, c Config) *Resolver {
+	c.applyDefaults()
+	r := &Resolver{
+		gatewaySvc: gatewaySvc,
+		ttl:        time.Duration(c.TTL) * time.Second,
+	}
+	if c.RedisAddress != "" {
+		r.store = newRedisStore(&c)
+	} else {
+		r.store = &memoryStore{cache: gcache.New(c.Size).LRU().Build()}
+	}
+	return r
+}
+
+// UserType returns the type of the user with the given username.
+// Only the successful lookups are cached.
+func (r *Resolver) UserType(ctx context.Context, username string) (userpb.UserType, error) {
+	if t, ok := r.store.get(username); ok {
+		return t, nil
+	}
+
+	client, err := pool.GetGatewayServiceClient(pool.Endpoint(r.gatewaySvc))
+	if err != nil {
+		return userpb.UserType_USER_TYPE_PRIMARY, err
+	}
+	userRes, err := client.GetUserByClaim(ctx, &userpb.GetUserByClaimRequest{
+		Claim: "username",
+		Value: username,
+	})
+	if err != nil {
+		return userpb.UserType_USER_TYPE_PRIMARY, errors.Wrapf(err, "error getting user by username '%v'", username)
+	}
+	if userRes.Status.Code != rpc.Code_CODE_OK {
+		return userpb.UserType_USER_TYPE_PRIMARY, status.NewErrorFromCode(userRes.Status.Code, "oidc")
+	}
+
+	t := userRes.GetUser().Id.Type
+	r.store.set(username, t, r.ttl)
+	return t, nil
+}
+
+// Close releases the resources of the cache.
+func (r *Resolver) Close() error {
+	return r.store.close()
+}
+
+type memoryStore struct {
+	cache gcache.Cache
+}
+
+func (s *memoryStore) get(username string) (userpb.UserType, bool) {
+	v, err := s.cache.Get(username)
+	if err != nil {
+		return userpb.UserType_USER_TYPE_INVALID, false
+	}
+	return v.(userpb.UserType), true
+}
+
+func (s *memoryStore) set(username string, t userpb.UserType, ttl time.Duration) {
+	_ = s.cache.SetWithExpire(username, t, ttl)
+}
+
+func (s *memoryStore) close() error {
+	s.cache.Purge()
+	return nil
+}
+
+type redisStore struct {
+	pool   *redis.Pool
+	prefix string
+}
+
+func newRedisStore(c *Config) *redisStore {
+	return &redisStore{
+		pool: redispool.New(&redispool.Config{
+			Address:  c.RedisAddress,
+			Username: c.RedisUsername,
+			Password: c.RedisPassword,
+		}),
+
+		prefix: c.RedisPrefix,
+	}
+}
+
+func (s *redisStore) get(username string) (userpb.UserType, bool) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	v, err := redis.Int(conn.Do("GET", s.prefix+username))
+	if err != nil {
+		return userpb.UserType_USER_TYPE_INVALID, false
+	}
+	return userpb.UserType(v), true
+}
+
+func (s *redisStore) set(username string, t userpb.UserType, ttl time.Duration) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	_, _ = conn.Do("SET", s.prefix+username, strconv.Itoa(int(t)), "PX", ttl.Milliseconds())
+}
+
+func (s *redisStore) close() error {
+	return s.pool.Close()
+}
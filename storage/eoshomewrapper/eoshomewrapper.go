@@ -19,27 +19,50 @@
 package eoshomewrapper
 
 import (
-	"bytes"
 	"context"
-	"text/template"
+	"path"
+	"slices"
+	"strconv"
+	"time"
 
-	"github.com/Masterminds/sprig"
+	"github.com/bluele/gcache"
 	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
 	"github.com/cs3org/reva"
 	"github.com/cs3org/reva/pkg/appctx"
 	"github.com/cs3org/reva/pkg/errtypes"
 	"github.com/cs3org/reva/pkg/storage"
 	"github.com/cs3org/reva/pkg/storage/utils/eosfs"
+	"github.com/cs3org/reva/pkg/utils"
 	"github.com/cs3org/reva/pkg/utils/cfg"
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
 )
 
 func init() {
 	reva.RegisterPlugin(wrapper{})
 }
 
+// includeHiddenKey is the metadata key to request the hidden files in the listings.
+const includeHiddenKey = "include_hidden"
+
+const (
+	quotaUsageCacheSize = 100000
+	quotaUsageCacheTTL  = 5 * time.Minute
+)
+
 type wrapper struct {
 	storage.FS
-	mountIDTemplate *template.Template
+	mountIDs *mountIDs
+	// metadataKeys are the arbitrary metadata keys the users can set,
+	// stored as extended attributes. All the keys are allowed if empty.
+	metadataKeys []string
+	// hiddenFiles are the globs of the names of the files hidden in
+	// the listings, unless the include_hidden metadata key is requested.
+	hiddenFiles []string
+	// provisioner creates the homes in background, nil if not enabled.
+	provisioner *provisioner
+	// quotaUsage caches the percentage of the quota used by each user.
+	quotaUsage gcache.Cache
 }
 
 func (wrapper) RevaPlugin() reva.PluginInfo {
@@ -62,9 +85,24 @@ func New(ctx context.Context, m map[string]interface{}) (storage.FS, error) {
 	}
 	c.EnableHome = true
 
-	t, ok := m["mount_id_template"].(string)
-	if !ok || t == "" {
-		t = "eoshome-{{substr 0 1 .Username}}"
+	mountIDs, err := parseMountIDs(m)
+	if err != nil {
+		return nil, err
+	}
+
+	var metadataKeys []string
+	if err := mapstructure.Decode(m["metadata_keys"], &metadataKeys); err != nil {
+		return nil, errors.Wrap(err, "eos: error decoding metadata_keys")
+	}
+
+	var hiddenFiles []string
+	if err := mapstructure.Decode(m["hidden_files"], &hiddenFiles); err != nil {
+		return nil, errors.Wrap(err, "eos: error decoding hidden_files")
+	}
+	for _, g := range hiddenFiles {
+		if _, err := path.Match(g, ""); err != nil {
+			return nil, errors.Wrapf(err, "eos: invalid hidden file %q", g)
+		}
 	}
 
 	eos, err := eosfs.NewEOSFS(ctx, &c)
@@ -72,12 +110,19 @@ func New(ctx context.Context, m map[string]interface{}) (storage.FS, error) {
 		return nil, err
 	}
 
-	mountIDTemplate, err := template.New("mountID").Funcs(sprig.TxtFuncMap()).Parse(t)
+	provisioner, err := newProvisioner(eos, m)
 	if err != nil {
 		return nil, err
 	}
 
-	return &wrapper{FS: eos, mountIDTemplate: mountIDTemplate}, nil
+	return &wrapper{
+		FS:           eos,
+		mountIDs:     mountIDs,
+		metadataKeys: metadataKeys,
+		hiddenFiles:  hiddenFiles,
+		provisioner:  provisioner,
+		quotaUsage:   gcache.New(quotaUsageCacheSize).LRU().Expiration(quotaUsageCacheTTL).Build(),
+	}, nil
 }
 
 // We need to override the two methods, GetMD and ListFolder to fill the
@@ -94,29 +139,132 @@ func (w *wrapper) GetMD(ctx context.Context, ref *provider.Reference, mdKeys []s
 	// Take the first letter of the username of the logged-in user, as the home
 	// storage provider restricts requests only to the home namespace.
 	res.Id.StorageId = w.getMountID(ctx, res)
+
+	w.setQuotaUsage(ctx, res)
 	return res, nil
 }
 
 func (w *wrapper) ListFolder(ctx context.Context, ref *provider.Reference, mdKeys []string) ([]*provider.ResourceInfo, error) {
+	includeHidden := slices.Contains(mdKeys, includeHiddenKey)
+	if includeHidden {
+		mdKeys = slices.DeleteFunc(slices.Clone(mdKeys), func(k string) bool { return k == includeHiddenKey })
+	}
+
 	res, err := w.FS.ListFolder(ctx, ref, mdKeys)
 	if err != nil {
 		return nil, err
 	}
+	if !includeHidden {
+		res = w.filterHidden(res)
+	}
 	for _, r := range res {
 		r.Id.StorageId = w.getMountID(ctx, r)
 	}
 	return res, nil
 }
 
+// SetArbitraryMetadata stores the metadata, e.g. the favorites, as extended
+// attributes, returned by GetMD and ListFolder. Only the keys in
+// metadata_keys are allowed, if configured.
+func (w *wrapper) SetArbitraryMetadata(ctx context.Context, ref *provider.Reference, md *provider.ArbitraryMetadata) error {
+	for k := range md.GetMetadata() {
+		if err := w.checkMetadataKey(k); err != nil {
+			return err
+		}
+	}
+	return w.FS.SetArbitraryMetadata(ctx, ref, md)
+}
+
+func (w *wrapper) UnsetArbitraryMetadata(ctx context.Context, ref *provider.Reference, keys []string) error {
+	for _, k := range keys {
+		if err := w.checkMetadataKey(k); err != nil {
+			return err
+		}
+	}
+	return w.FS.UnsetArbitraryMetadata(ctx, ref, keys)
+}
+
+func (w *wrapper) checkMetadataKey(k string) error {
+	if len(w.metadataKeys) == 0 || slices.Contains(w.metadataKeys, k) {
+		return nil
+	}
+	return errtypes.PermissionDenied("eos: metadata key " + k + " is not allowed")
+}
+
+// filterHidden removes from a listing the files matching hidden_files,
+// e.g. the dotfiles or the .sys.v#. version folders.
+func (w *wrapper) filterHidden(res []*provider.ResourceInfo) []*provider.ResourceInfo {
+	if len(w.hiddenFiles) == 0 {
+		return res
+	}
+	return slices.DeleteFunc(res, func(r *provider.ResourceInfo) bool {
+		name := path.Base(r.Path)
+		for _, g := range w.hiddenFiles {
+			if ok, _ := path.Match(g, name); ok {
+				return true
+			}
+		}
+		return false
+	})
+}
+
 func (w *wrapper) DenyGrant(ctx context.Context, ref *provider.Reference, g *provider.Grantee) error {
 	return errtypes.NotSupported("eos: deny grant is only enabled for project spaces")
 }
 
-func (w *wrapper) getMountID(ctx context.Context, r *provider.ResourceInfo) string {
+// CreateHome creates the home of the user, in background if
+// deferred_home_creation is enabled.
+func (w *wrapper) CreateHome(ctx context.Context) error {
+	if w.provisioner == nil {
+		return w.FS.CreateHome(ctx)
+	}
+	return w.provisioner.createHome(ctx)
+}
+
+// GetHome fails with TooEarly while the home is being provisioned.
+func (w *wrapper) GetHome(ctx context.Context) (string, error) {
+	if w.provisioner != nil {
+		if err := w.provisioner.check(ctx); err != nil {
+			return "", err
+		}
+	}
+	return w.FS.GetHome(ctx)
+}
+
+func (w *wrapper) Shutdown(ctx context.Context) error {
+	if w.provisioner != nil {
+		_ = w.provisioner.close()
+	}
+	return w.FS.Shutdown(ctx)
+}
+
+// setQuotaUsage attaches to the home root the percentage of the quota used,
+// so that the clients can warn the users without asking for the quota.
+func (w *wrapper) setQuotaUsage(ctx context.Context, r *provider.ResourceInfo) {
+	home, err := w.FS.GetHome(ctx)
+	if err != nil || path.Clean(r.Path) != path.Clean(home) {
+		return
+	}
+
 	u := appctx.ContextMustGetUser(ctx)
-	b := bytes.Buffer{}
-	if err := w.mountIDTemplate.Execute(&b, u); err != nil {
-		return ""
+	var usage string
+	if v, err := w.quotaUsage.Get(u.Username); err == nil {
+		usage = v.(string)
+	} else {
+		total, used, err := w.FS.GetQuota(ctx, &provider.Reference{Path: home})
+		if err != nil {
+			appctx.GetLogger(ctx).Error().Err(err).Str("username", u.Username).Msg("eos: error getting home quota")
+			return
+		}
+		if total == 0 {
+			return
+		}
+		usage = strconv.FormatUint(used*100/total, 10)
+		_ = w.quotaUsage.Set(u.Username, usage)
 	}
-	return b.String()
+	r.Opaque = utils.AppendPlainToOpaque(r.Opaque, "quota_usage", usage)
+}
+
+func (w *wrapper) getMountID(ctx context.Context, r *provider.ResourceInfo) string {
+	return w.mountIDs.get(appctx.ContextMustGetUser(ctx))
 }
@@ -0,0 +1,109 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package eoshomewrapper
+
+import (
+	"bytes"
+	"regexp"
+	"text/template"
+
+	"github.com/Masterminds/sprig"
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+)
+
+// mountIDRule maps the users whose homes do not follow the letter bucket
+// scheme of mount_id_template, e.g. service or external accounts, to
+// their storage provider.
+type mountIDRule struct {
+	// Username is a regex matched against the username.
+	Username string `mapstructure:"username"`
+	// Template is the mount ID template used for the matching users.
+	Template string `mapstructure:"template"`
+
+	regex *regexp.Regexp
+	tpl   *template.Template
+}
+
+// mountIDs resolves the mount ID of the home of a user.
+type mountIDs struct {
+	template *template.Template
+	// users maps a username to its mount ID, before the rules.
+	users map[string]string
+	// rules are evaluated in order, before the default template.
+	rules []*mountIDRule
+}
+
+// parseMountIDs reads the mount_id_template, mount_ids and mount_id_rules configs, e.g.
+//
+//	[grpc.services.storageprovider.drivers.eoshomewrapper.mount_ids]
+//	"cboxsvc" = "eoshome-svc"
+//
+//	[[grpc.services.storageprovider.drivers.eoshomewrapper.mount_id_rules]]
+//	username = "^guest-"
+//	template = "eoshome-guest"
+func parseMountIDs(m map[string]interface{}) (*mountIDs, error) {
+	t, ok := m["mount_id_template"].(string)
+	if !ok || t == "" {
+		t = "eoshome-{{substr 0 1 .Username}}"
+	}
+
+	// parse the templates before connecting to EOS, to fail fast on bad configs
+	tpl, err := template.New("mountID").Funcs(sprig.TxtFuncMap()).Parse(t)
+	if err != nil {
+		return nil, errors.Wrap(err, "eos: error parsing mount_id_template")
+	}
+	ids := &mountIDs{template: tpl}
+
+	if err := mapstructure.Decode(m["mount_ids"], &ids.users); err != nil {
+		return nil, errors.Wrap(err, "eos: error decoding mount_ids")
+	}
+	if err := mapstructure.Decode(m["mount_id_rules"], &ids.rules); err != nil {
+		return nil, errors.Wrap(err, "eos: error decoding mount_id_rules")
+	}
+	for _, r := range ids.rules {
+		if r.regex, err = regexp.Compile(r.Username); err != nil {
+			return nil, errors.Wrapf(err, "eos: error parsing mount_id_rules username %q", r.Username)
+		}
+		if r.tpl, err = template.New("mountID").Funcs(sprig.TxtFuncMap()).Parse(r.Template); err != nil {
+			return nil, errors.Wrapf(err, "eos: error parsing mount_id_rules template %q", r.Template)
+		}
+	}
+	return ids, nil
+}
+
+func (ids *mountIDs) get(u *userpb.User) string {
+	if id, ok := ids.users[u.Username]; ok {
+		return id
+	}
+
+	tpl := ids.template
+	for _, r := range ids.rules {
+		if r.regex.MatchString(u.Username) {
+			tpl = r.tpl
+			break
+		}
+	}
+	b := bytes.Buffer{}
+	if err := tpl.Execute(&b, u); err != nil {
+		return ""
+	}
+	return b.String()
+}
@@ -0,0 +1,201 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package eoshomewrapper
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cernbox/reva-plugins/runner"
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	"github.com/cs3org/reva/pkg/appctx"
+	"github.com/cs3org/reva/pkg/errtypes"
+	"github.com/cs3org/reva/pkg/storage"
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+)
+
+// provisionConfig configures the deferred creation of the homes.
+type provisionConfig struct {
+	// Deferred queues the creation of the homes to background workers,
+	// so that the first login of the users does not block on EOS.
+	Deferred bool `mapstructure:"deferred_home_creation"`
+	// Workers is the number of homes created in parallel. Defaults to 4.
+	Workers int `mapstructure:"home_creation_workers"`
+	// QueueSize is the number of homes waiting to be created, above
+	// which the homes are created synchronously. Defaults to 1000.
+	QueueSize int `mapstructure:"home_creation_queue_size"`
+	// Timeout is the time in seconds after which the creation of a home
+	// in background is abandoned, and retried by the next CreateHome.
+	// Defaults to 60 seconds.
+	Timeout int `mapstructure:"home_creation_timeout"`
+}
+
+// States of a home being provisioned.
+const (
+	homeQueued   = "queued"
+	homeCreating = "creating"
+	homeFailed   = "failed"
+)
+
+type provisioning struct {
+	state string
+	since time.Time
+	err   error
+}
+
+// provisioner creates the homes in background.
+type provisioner struct {
+	fs      storage.FS
+	queue   chan *userpb.User
+	runner  *runner.Runner
+	timeout time.Duration
+
+	mu     sync.Mutex
+	states map[string]*provisioning
+}
+
+// newProvisioner returns nil if the deferred creation is not enabled.
+func newProvisioner(fs storage.FS, m map[string]interface{}) (*provisioner, error) {
+	var c provisionConfig
+	if err := mapstructure.Decode(m, &c); err != nil {
+		return nil, errors.Wrap(err, "eos: error decoding the home creation config")
+	}
+	if !c.Deferred {
+		return nil, nil
+	}
+	if c.Workers == 0 {
+		c.Workers = 4
+	}
+	if c.QueueSize == 0 {
+		c.QueueSize = 1000
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 60
+	}
+
+	p := &provisioner{
+		fs:      fs,
+		queue:   make(chan *userpb.User, c.QueueSize),
+		runner:  runner.New(context.Background()),
+		timeout: time.Duration(c.Timeout) * time.Second,
+		states:  map[string]*provisioning{},
+	}
+	for i := 0; i < c.Workers; i++ {
+		p.runner.Go("eos: home creation", runner.RestartOnPanic, p.work)
+	}
+	return p, nil
+}
+
+// createHome queues the creation of the home of the user in the context.
+func (p *provisioner) createHome(ctx context.Context) error {
+	u := appctx.ContextMustGetUser(ctx)
+
+	p.mu.Lock()
+	if s, ok := p.states[u.Username]; ok && s.state != homeFailed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.states[u.Username] = &provisioning{state: homeQueued, since: time.Now()}
+	p.mu.Unlock()
+
+	select {
+	case p.queue <- u:
+		return nil
+	default:
+		// too many homes waiting, do not make this user wait any longer
+		appctx.GetLogger(ctx).Warn().Str("username", u.Username).Msg("eos: home creation queue is full, creating home synchronously")
+		err := p.fs.CreateHome(ctx)
+		p.done(u.Username, err)
+		return err
+	}
+}
+
+// check returns an error while the home of the user is being provisioned.
+func (p *provisioner) check(ctx context.Context) error {
+	u := appctx.ContextMustGetUser(ctx)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s, ok := p.states[u.Username]
+	if !ok || s.state == homeFailed {
+		return nil
+	}
+	return errtypes.TooEarly("eos: the home of " + u.Username + " is being provisioned (" + s.state + " since " + s.since.Format(time.RFC3339) + ")")
+}
+
+func (p *provisioner) work(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case u := <-p.queue:
+			p.create(ctx, u)
+		}
+	}
+}
+
+// create creates the home of the user, recording the outcome also if the
+// creation panics, so that the home is not left in the creating state.
+func (p *provisioner) create(ctx context.Context, u *userpb.User) {
+	p.setState(u.Username, homeCreating)
+
+	log := appctx.GetLogger(ctx).With().Str("username", u.Username).Logger()
+	ctx, cancel := context.WithTimeout(appctx.WithLogger(appctx.ContextSetUser(ctx, u), &log), p.timeout)
+	defer cancel()
+
+	var err error
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("eos: panic creating home: %v", r)
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("eos: error creating home")
+		}
+		p.done(u.Username, err)
+	}()
+
+	start := time.Now()
+	if err = p.fs.CreateHome(ctx); err == nil {
+		log.Info().Dur("duration", time.Since(start)).Msg("eos: home created")
+	}
+}
+
+func (p *provisioner) setState(username, state string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.states[username] = &provisioning{state: state, since: time.Now()}
+}
+
+// done records the outcome of the creation of a home. A failed creation
+// is retried by the next CreateHome.
+func (p *provisioner) done(username string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err == nil {
+		delete(p.states, username)
+		return
+	}
+	p.states[username] = &provisioning{state: homeFailed, since: time.Now(), err: err}
+}
+
+func (p *provisioner) close() error {
+	return p.runner.Close()
+}
@@ -0,0 +1,137 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package eoswrapper
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/cs3org/reva/pkg/appctx"
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+)
+
+// adminConfig configures the admin endpoint of the wrapper.
+type adminConfig struct {
+	// Address is where the admin endpoint listens. It is disabled if empty.
+	Address string `mapstructure:"address"`
+	// Token authenticates the requests, as bearer token.
+	Token string `mapstructure:"token"`
+}
+
+func parseAdminConfig(m map[string]interface{}) (*adminConfig, error) {
+	var c adminConfig
+	if err := mapstructure.Decode(m["admin"], &c); err != nil {
+		return nil, errors.Wrap(err, "eos: error decoding admin")
+	}
+	if c.Address != "" && c.Token == "" {
+		return nil, errors.New("eos: admin.token is required with admin.address")
+	}
+	return &c, nil
+}
+
+// serveAdmin runs the admin endpoint, for the support to inspect
+// and change the state of the spaces.
+func (w *wrapper) serveAdmin(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/blocked", w.authorized(w.handleBlocked))
+	mux.HandleFunc("/read_only_projects", w.authorized(w.handleReadOnlyProjects))
+	srv := &http.Server{
+		Addr:              w.admin.Address,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = srv.Shutdown(context.Background())
+	}()
+
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (w *wrapper) authorized(h http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(w.admin.Token)) != 1 {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		h(rw, r)
+	}
+}
+
+// handleBlocked tells whether the path in the query is in a blocked space.
+func (w *wrapper) handleBlocked(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	p := r.URL.Query().Get("path")
+	if p == "" {
+		http.Error(rw, "missing path", http.StatusBadRequest)
+		return
+	}
+
+	space, blocked, err := w.IsBlocked(r.Context(), &provider.Reference{Path: p})
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(rw).Encode(map[string]interface{}{
+		"path":    p,
+		"blocked": blocked,
+		"space":   space,
+	})
+}
+
+// handleReadOnlyProjects lists the read-only projects, and with PUT or
+// DELETE sets the project in the query read-only or writable again.
+// Without read_only_redis, the changes only apply to this replica and are
+// lost on restart, so the projects must be added to read_only_projects.
+func (w *wrapper) handleReadOnlyProjects(rw http.ResponseWriter, r *http.Request) {
+	project := r.URL.Query().Get("project")
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodDelete:
+		if project == "" || strings.Contains(project, "/") {
+			http.Error(rw, "invalid project", http.StatusBadRequest)
+			return
+		}
+		if err := w.readOnlyProjects.set(project, r.Method == http.MethodPut); err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		appctx.GetLogger(r.Context()).Info().Str("project", project).Bool("read_only", r.Method == http.MethodPut).Msg("eos: changed read-only project")
+	default:
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(rw).Encode(map[string][]string{"read_only_projects": w.readOnlyProjects.list()})
+}
@@ -0,0 +1,141 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package eoswrapper
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/cernbox/reva-plugins/events"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/cs3org/reva/pkg/appctx"
+	conversions "github.com/cs3org/reva/pkg/cbox/utils"
+	"github.com/cs3org/reva/pkg/utils"
+)
+
+// Actions of the grant changes recorded in the audit trail.
+const (
+	grantAdded   = "add"
+	grantUpdated = "update"
+	grantRemoved = "remove"
+	grantDenied  = "deny"
+)
+
+// The grant changes in project spaces are logged, and published as
+// events if audit_events is configured, so that the changes of the
+// project ACLs can be reconstructed.
+
+func (w *wrapper) AddGrant(ctx context.Context, ref *provider.Reference, g *provider.Grant) error {
+	if err := w.checkWritable(ctx, ref); err != nil {
+		return err
+	}
+	err := w.FS.AddGrant(ctx, ref, g)
+	w.auditGrant(ctx, grantAdded, ref, g.Grantee, nil, g.Permissions, err)
+	return err
+}
+
+func (w *wrapper) UpdateGrant(ctx context.Context, ref *provider.Reference, g *provider.Grant) error {
+	if err := w.checkWritable(ctx, ref); err != nil {
+		return err
+	}
+	old := w.currentPermissions(ctx, ref, g.Grantee)
+	err := w.FS.UpdateGrant(ctx, ref, g)
+	w.auditGrant(ctx, grantUpdated, ref, g.Grantee, old, g.Permissions, err)
+	return err
+}
+
+func (w *wrapper) RemoveGrant(ctx context.Context, ref *provider.Reference, g *provider.Grant) error {
+	if err := w.checkWritable(ctx, ref); err != nil {
+		return err
+	}
+	old := w.currentPermissions(ctx, ref, g.Grantee)
+	err := w.FS.RemoveGrant(ctx, ref, g)
+	w.auditGrant(ctx, grantRemoved, ref, g.Grantee, old, nil, err)
+	return err
+}
+
+func (w *wrapper) auditEnabled() bool {
+	return strings.HasPrefix(w.conf.Namespace, eosProjectsNamespace)
+}
+
+// currentPermissions returns the permissions granted to the grantee
+// before a change, nil if unknown.
+func (w *wrapper) currentPermissions(ctx context.Context, ref *provider.Reference, grantee *provider.Grantee) *provider.ResourcePermissions {
+	if !w.auditEnabled() {
+		return nil
+	}
+	grants, err := w.FS.ListGrants(ctx, ref)
+	if err != nil {
+		return nil
+	}
+	for _, g := range grants {
+		if utils.GranteeEqual(g.Grantee, grantee) {
+			return g.Permissions
+		}
+	}
+	return nil
+}
+
+func (w *wrapper) auditGrant(ctx context.Context, action string, ref *provider.Reference, grantee *provider.Grantee, oldPermissions, newPermissions *provider.ResourcePermissions, err error) {
+	if !w.auditEnabled() {
+		return
+	}
+
+	p := ref.GetPath()
+	if ref.GetResourceId() != nil {
+		if md, err := w.FS.GetMD(ctx, ref, nil); err == nil {
+			p = md.Path
+		}
+	}
+	var actor string
+	if u, ok := appctx.ContextGetUser(ctx); ok {
+		actor = u.Username
+	}
+	granteeType, granteeName := conversions.FormatGrantee(grantee)
+	data := map[string]string{
+		"action":          action,
+		"path":            p,
+		"grantee":         granteeName,
+		"grantee_type":    strconv.Itoa(granteeType),
+		"old_permissions": formatPermissions(oldPermissions),
+		"new_permissions": formatPermissions(newPermissions),
+		"status":          "ok",
+	}
+	if err != nil {
+		data["status"] = err.Error()
+	}
+
+	log := appctx.GetLogger(ctx)
+	entry := log.Info().Str("actor", actor)
+	for k, v := range data {
+		entry = entry.Str(k, v)
+	}
+	entry.Msg("eos: project grant changed")
+	if err := w.audit.Publish(ctx, &events.Event{Type: events.GrantChanged, Actor: actor, Data: data}); err != nil {
+		log.Error().Err(err).Str("path", p).Msg("eos: error publishing grant change")
+	}
+}
+
+func formatPermissions(p *provider.ResourcePermissions) string {
+	if p == nil {
+		return ""
+	}
+	return strconv.Itoa(conversions.SharePermToInt(p))
+}
@@ -0,0 +1,81 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package eoswrapper
+
+import (
+	"context"
+	"path"
+	"slices"
+	"strings"
+
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+)
+
+// defaultBlockedFolder is the folder where the blocked spaces are moved.
+const defaultBlockedFolder = ".blocked"
+
+// SpaceBlocked is the error of the operations on a blocked space. It is a
+// permission denied, so that the users and the support can tell a blocked
+// space from a missing one.
+type SpaceBlocked string
+
+func (e SpaceBlocked) Error() string {
+	return "eosfs: the space " + string(e) + " is blocked"
+}
+
+// IsPermissionDenied marks the error as a permission denied.
+func (e SpaceBlocked) IsPermissionDenied() {}
+
+// blockedSpace returns the blocked space of the path, if the path is in one:
+// the blocked spaces are under a folder named as the blocked folder.
+func (w *wrapper) blockedSpace(p string) (string, bool) {
+	elems := strings.Split(strings.Trim(p, "/"), "/")
+	i := slices.Index(elems, w.blockedFolder)
+	if i < 0 {
+		return "", false
+	}
+	// the space is the first element below the blocked folder, if any
+	return "/" + path.Join(elems[:min(i+2, len(elems))]...), true
+}
+
+// checkBlocked fails with SpaceBlocked if the path is in a blocked space.
+func (w *wrapper) checkBlocked(p string) error {
+	if space, ok := w.blockedSpace(p); ok {
+		return SpaceBlocked(space)
+	}
+	return nil
+}
+
+// filterBlocked removes the blocked spaces from a listing.
+func (w *wrapper) filterBlocked(res []*provider.ResourceInfo) []*provider.ResourceInfo {
+	return slices.DeleteFunc(res, func(r *provider.ResourceInfo) bool {
+		_, ok := w.blockedSpace(r.Path)
+		return ok
+	})
+}
+
+// IsBlocked returns whether the reference is in a blocked space, and the space.
+func (w *wrapper) IsBlocked(ctx context.Context, ref *provider.Reference) (string, bool, error) {
+	p, err := w.refPath(ctx, ref)
+	if err != nil {
+		return "", false, err
+	}
+	space, ok := w.blockedSpace(p)
+	return space, ok, nil
+}
@@ -22,19 +22,28 @@ import (
 	"bytes"
 	"context"
 	"io"
+	"path"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/Masterminds/sprig"
+	"github.com/bluele/gcache"
+	"github.com/cernbox/reva-plugins/events"
+	"github.com/cernbox/reva-plugins/runner"
 	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	types "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
 	"github.com/cs3org/reva"
 	"github.com/cs3org/reva/pkg/appctx"
+	"github.com/cs3org/reva/pkg/eosclient"
 	"github.com/cs3org/reva/pkg/errtypes"
 	"github.com/cs3org/reva/pkg/storage"
 	"github.com/cs3org/reva/pkg/storage/fs/registry"
 	"github.com/cs3org/reva/pkg/storage/utils/eosfs"
 	"github.com/cs3org/reva/pkg/utils"
 	"github.com/cs3org/reva/pkg/utils/cfg"
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
 )
 
 func init() {
@@ -46,14 +55,48 @@ const (
 	eosProjectsNamespace = "/eos/project"
 
 	// We can use a regex for these, but that might have inferior performance.
-	projectSpaceGroupsPrefix      = "cernbox-project-"
-	projectSpaceAdminGroupsSuffix = "-admins"
+	projectSpaceGroupsPrefix        = "cernbox-project-"
+	projectSpaceAdminGroupsSuffix   = "-admins"
+	projectSpaceWritersGroupsSuffix = "-writers"
+
+	// the projects are at /$letter/$project in the namespace
+	projectQuotaNodeDepth = 2
+
+	quotaNodesCacheSize = 10000
+	quotaNodesCacheTTL  = time.Hour
+
+	inodeQuotasCacheSize = 10000
+	inodeQuotasCacheTTL  = time.Minute
 )
 
 type wrapper struct {
 	storage.FS
 	conf            *eosfs.Config
 	mountIDTemplate *template.Template
+	// projectRoles maps the suffix of the project groups to
+	// the capabilities of their members.
+	projectRoles map[string][]string
+	hiddenPaths  *hiddenPaths
+	// readOnlyProjects are the projects in maintenance.
+	readOnlyProjects *readOnlyProjects
+	externalPolicy   *externalPolicy
+	// audit publishes the grant changes in project spaces.
+	audit events.Publisher
+	// quotaNodeDepth is the depth in the namespace of the quota nodes,
+	// 0 if the quota is not resolved by the wrapper.
+	quotaNodeDepth int
+	// quotaNodes caches the quota node of the resources
+	// referenced by id in GetQuota.
+	quotaNodes gcache.Cache
+	// quotaClient reads the inode quota of the quota nodes, which
+	// eosfs does not report. Nil if not configured.
+	quotaClient eosclient.EOSClient
+	// inodeQuotas caches the inode quota of the quota nodes.
+	inodeQuotas gcache.Cache
+	// blockedFolder is the folder where the blocked spaces are moved.
+	blockedFolder string
+	admin         *adminConfig
+	runner        *runner.Runner
 }
 
 func (wrapper) RevaPlugin() reva.PluginInfo {
@@ -87,27 +130,117 @@ func New(ctx context.Context, m map[string]interface{}) (storage.FS, error) {
 		t = "eoshome-{{ trimAll \"/\" .Path | substr 0 1 }}"
 	}
 
+	// parse the template before connecting to EOS, to fail fast on bad configs
+	mountIDTemplate, err := template.New("mountID").Funcs(sprig.TxtFuncMap()).Parse(t)
+	if err != nil {
+		return nil, errors.Wrap(err, "eos: error parsing mount_id_template")
+	}
+
+	projectRoles, err := parseProjectRoles(m)
+	if err != nil {
+		return nil, err
+	}
+
+	quotaNodeDepth, err := parseQuotaNodes(m, c.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	quotaClient, err := newQuotaClient(&c, quotaNodeDepth)
+	if err != nil {
+		return nil, err
+	}
+
+	hiddenPaths, err := parseHiddenPaths(m)
+	if err != nil {
+		return nil, err
+	}
+
+	var readOnly []string
+	if err := mapstructure.Decode(m["read_only_projects"], &readOnly); err != nil {
+		return nil, errors.Wrap(err, "eos: error decoding read_only_projects")
+	}
+	readOnlyRedis, err := parseReadOnlyRedisConfig(m)
+	if err != nil {
+		return nil, err
+	}
+	readOnlyProjects := newReadOnlyProjects(readOnly, readOnlyRedis)
+
+	externalPolicy, err := parseExternalPolicy(m)
+	if err != nil {
+		return nil, err
+	}
+
+	blockedFolder, _ := m["blocked_folder"].(string)
+	if blockedFolder == "" {
+		blockedFolder = defaultBlockedFolder
+	}
+
+	admin, err := parseAdminConfig(m)
+	if err != nil {
+		return nil, err
+	}
+
+	auditConf, _ := m["audit_events"].(map[string]interface{})
+	audit, err := events.New(auditConf)
+	if err != nil {
+		return nil, err
+	}
+
 	eos, err := eosfs.NewEOSFS(ctx, &c)
 	if err != nil {
+		_ = audit.Close()
 		return nil, err
 	}
 
-	mountIDTemplate, err := template.New("mountID").Funcs(sprig.TxtFuncMap()).Parse(t)
+	fs, err := newThrottledFS(eos, m, c.Namespace)
 	if err != nil {
+		_ = audit.Close()
 		return nil, err
 	}
 
-	return &wrapper{FS: eos, conf: &c, mountIDTemplate: mountIDTemplate}, nil
+	w := &wrapper{
+		FS:               fs,
+		conf:             &c,
+		mountIDTemplate:  mountIDTemplate,
+		projectRoles:     projectRoles,
+		hiddenPaths:      hiddenPaths,
+		readOnlyProjects: readOnlyProjects,
+		externalPolicy:   externalPolicy,
+		audit:            audit,
+		quotaNodeDepth:   quotaNodeDepth,
+		quotaNodes:       gcache.New(quotaNodesCacheSize).LRU().Expiration(quotaNodesCacheTTL).Build(),
+		quotaClient:      quotaClient,
+		inodeQuotas:      gcache.New(inodeQuotasCacheSize).LRU().Expiration(inodeQuotasCacheTTL).Build(),
+		blockedFolder:    blockedFolder,
+		admin:            admin,
+		runner:           runner.New(context.Background()),
+	}
+	if admin.Address != "" {
+		w.runner.Go("eos: admin endpoint", runner.RestartAlways, w.serveAdmin)
+	}
+	if readOnlyProjects.pool != nil {
+		w.runner.Every("eos: refresh read-only projects", time.Duration(readOnlyRedis.RefreshInterval)*time.Second, true, readOnlyProjects.refresh)
+	}
+	return w, nil
 }
 
 // We need to override the two methods, GetMD and ListFolder to fill the
 // StorageId in the ResourceInfo objects.
 
 func (w *wrapper) GetMD(ctx context.Context, ref *provider.Reference, mdKeys []string) (*provider.ResourceInfo, error) {
+	if err := w.checkBlocked(ref.GetPath()); err != nil {
+		return nil, err
+	}
 	res, err := w.FS.GetMD(ctx, ref, mdKeys)
 	if err != nil {
 		return nil, err
 	}
+	if err := w.checkBlocked(res.Path); err != nil {
+		return nil, err
+	}
+	if w.hiddenPaths.hidden(ctx, res.Path) {
+		return nil, errtypes.NotFound(res.Path)
+	}
 
 	// We need to extract the mount ID based on the mapping template.
 	//
@@ -118,15 +251,27 @@ func (w *wrapper) GetMD(ctx context.Context, ref *provider.Reference, mdKeys []s
 	if err = w.setProjectSharingPermissions(ctx, res); err != nil {
 		return nil, err
 	}
+	w.setInodeQuota(ctx, res)
 
 	return res, nil
 }
 
 func (w *wrapper) ListFolder(ctx context.Context, ref *provider.Reference, mdKeys []string) ([]*provider.ResourceInfo, error) {
+	if err := w.checkBlocked(ref.GetPath()); err != nil {
+		return nil, err
+	}
 	res, err := w.FS.ListFolder(ctx, ref, mdKeys)
 	if err != nil {
 		return nil, err
 	}
+	// the path of a folder referenced by id is known from its content
+	if len(res) > 0 {
+		if err := w.checkBlocked(path.Dir(res[0].Path)); err != nil {
+			return nil, err
+		}
+	}
+	res = w.filterBlocked(res)
+	res = w.hiddenPaths.filterHidden(ctx, res)
 	for _, r := range res {
 		r.Id.StorageId = w.getMountID(ctx, r)
 		if err = w.setProjectSharingPermissions(ctx, r); err != nil {
@@ -137,7 +282,7 @@ func (w *wrapper) ListFolder(ctx context.Context, ref *provider.Reference, mdKey
 }
 
 func (w *wrapper) ListRevisions(ctx context.Context, ref *provider.Reference) ([]*provider.FileVersion, error) {
-	if err := w.userIsProjectAdmin(ctx, ref); err != nil {
+	if err := w.userHasProjectCapability(ctx, ref, capRevisions); err != nil {
 		return nil, err
 	}
 
@@ -145,7 +290,7 @@ func (w *wrapper) ListRevisions(ctx context.Context, ref *provider.Reference) ([
 }
 
 func (w *wrapper) DownloadRevision(ctx context.Context, ref *provider.Reference, revisionKey string) (io.ReadCloser, error) {
-	if err := w.userIsProjectAdmin(ctx, ref); err != nil {
+	if err := w.userHasProjectCapability(ctx, ref, capRevisions); err != nil {
 		return nil, err
 	}
 
@@ -153,25 +298,92 @@ func (w *wrapper) DownloadRevision(ctx context.Context, ref *provider.Reference,
 }
 
 func (w *wrapper) RestoreRevision(ctx context.Context, ref *provider.Reference, revisionKey string) error {
-	if err := w.userIsProjectAdmin(ctx, ref); err != nil {
+	if err := w.userHasProjectCapability(ctx, ref, capRevisions); err != nil {
+		return err
+	}
+	if err := w.checkWritable(ctx, ref); err != nil {
 		return err
 	}
 
 	return w.FS.RestoreRevision(ctx, ref, revisionKey)
 }
 
+// ListRecycle fills the StorageId of the references of the recycle items,
+// so that the follow-up operations by id reach the right storage provider.
+func (w *wrapper) ListRecycle(ctx context.Context, basePath, key, relativePath string, from, to *types.Timestamp) ([]*provider.RecycleItem, error) {
+	res, err := w.FS.ListRecycle(ctx, basePath, key, relativePath, from, to)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range res {
+		if item.Ref.GetResourceId() != nil {
+			item.Ref.ResourceId.StorageId = w.getMountID(ctx, &provider.ResourceInfo{Path: item.Ref.Path})
+		}
+	}
+	return res, nil
+}
+
+// RestoreRecycleItem restores the item in its original location, or in
+// restoreRef if given. In project spaces, the alternate location must be
+// in the same project and can only be chosen by the roles allowed to.
+func (w *wrapper) RestoreRecycleItem(ctx context.Context, basePath, key, relativePath string, restoreRef *provider.Reference) error {
+	if err := w.checkWritable(ctx, &provider.Reference{Path: basePath}); err != nil {
+		return err
+	}
+	if restoreRef != nil {
+		if err := w.checkWritable(ctx, restoreRef); err != nil {
+			return err
+		}
+		if strings.HasPrefix(w.conf.Namespace, eosProjectsNamespace) {
+			if err := w.userCanRestoreTo(ctx, basePath, restoreRef); err != nil {
+				return err
+			}
+		}
+	}
+
+	return w.FS.RestoreRecycleItem(ctx, basePath, key, relativePath, restoreRef)
+}
+
+// GetQuota returns the quota of the node enclosing the reference, at any
+// depth, for project spaces and the namespaces configured in quota_nodes.
+// The inode quota is attached to the quota nodes by GetMD instead, as
+// GetQuota only returns the bytes.
+func (w *wrapper) GetQuota(ctx context.Context, ref *provider.Reference) (uint64, uint64, error) {
+	if w.quotaNodeDepth == 0 {
+		return w.FS.GetQuota(ctx, ref)
+	}
+
+	node, err := w.resolveQuotaNode(ctx, ref)
+	if err != nil {
+		return 0, 0, err
+	}
+	return w.FS.GetQuota(ctx, &provider.Reference{Path: node})
+}
+
 func (w *wrapper) DenyGrant(ctx context.Context, ref *provider.Reference, g *provider.Grantee) error {
-	// This is only allowed for project space admins
+	// This is only allowed to the project roles with the deny_grant capability
 	if strings.HasPrefix(w.conf.Namespace, eosProjectsNamespace) {
-		if err := w.userIsProjectAdmin(ctx, ref); err != nil {
+		if err := w.userHasProjectCapability(ctx, ref, capDenyGrant); err != nil {
+			return err
+		}
+		if err := w.checkWritable(ctx, ref); err != nil {
 			return err
 		}
-		return w.FS.DenyGrant(ctx, ref, g)
+		err := w.FS.DenyGrant(ctx, ref, g)
+		w.auditGrant(ctx, grantDenied, ref, g, nil, nil, err)
+		return err
 	}
 
 	return errtypes.NotSupported("eos: deny grant is only enabled for project spaces")
 }
 
+func (w *wrapper) Shutdown(ctx context.Context) error {
+	_ = w.runner.Close()
+	_ = w.readOnlyProjects.close()
+	_ = w.audit.Close()
+	return w.FS.Shutdown(ctx)
+}
+
 func (w *wrapper) getMountID(ctx context.Context, r *provider.ResourceInfo) string {
 	if r == nil {
 		return ""
@@ -186,36 +398,112 @@ func (w *wrapper) getMountID(ctx context.Context, r *provider.ResourceInfo) stri
 func (w *wrapper) setProjectSharingPermissions(ctx context.Context, r *provider.ResourceInfo) error {
 	// Check if this storage provider corresponds to a project spaces instance
 	if strings.HasPrefix(w.conf.Namespace, eosProjectsNamespace) {
-		// Extract project name from the path resembling /c/cernbox or /c/cernbox/minutes/..
-		parts := strings.SplitN(r.Path, "/", 4)
-		if len(parts) != 4 && len(parts) != 3 {
+		project, ok := projectName(r.Path)
+		if !ok {
 			// The request might be for / or /$letter
 			// Nothing to do in that case
 			return nil
 		}
-		adminGroup := projectSpaceGroupsPrefix + parts[2] + projectSpaceAdminGroupsSuffix
 		user := appctx.ContextMustGetUser(ctx)
+		caps := w.projectCapabilities(user, project)
 
 		_, isPublicShare := utils.HasPublicShareRole(user)
 
-		for _, g := range user.Groups {
-			if g == adminGroup {
-				r.PermissionSet.AddGrant = true
-				r.PermissionSet.RemoveGrant = true
-				r.PermissionSet.UpdateGrant = true
-				r.PermissionSet.ListGrants = true
-				r.PermissionSet.GetQuota = true
-				if !isPublicShare {
-					r.PermissionSet.DenyGrant = true
-				}
-				return nil
-			}
+		r.PermissionSet.AddGrant = r.PermissionSet.AddGrant || caps[capAddGrant]
+		r.PermissionSet.RemoveGrant = r.PermissionSet.RemoveGrant || caps[capRemoveGrant]
+		r.PermissionSet.UpdateGrant = r.PermissionSet.UpdateGrant || caps[capUpdateGrant]
+		r.PermissionSet.ListGrants = r.PermissionSet.ListGrants || caps[capListGrants]
+		r.PermissionSet.GetQuota = r.PermissionSet.GetQuota || caps[capGetQuota]
+		if !isPublicShare {
+			r.PermissionSet.DenyGrant = r.PermissionSet.DenyGrant || caps[capDenyGrant]
 		}
 	}
 	return nil
 }
 
-func (w *wrapper) userIsProjectAdmin(ctx context.Context, ref *provider.Reference) error {
+func (w *wrapper) userCanRestoreTo(ctx context.Context, basePath string, restoreRef *provider.Reference) error {
+	// the destination may not exist anymore, so resolve only the path
+	dst, err := w.refPath(ctx, restoreRef)
+	if err != nil {
+		return err
+	}
+
+	project, ok := projectName(dst)
+	if !ok {
+		return errtypes.BadRequest("eosfs: the restore location must be in a project space")
+	}
+	if src, ok := projectName(basePath); ok && src != project {
+		return errtypes.PermissionDenied("eosfs: recycle items can only be restored in the same project")
+	}
+
+	user := appctx.ContextMustGetUser(ctx)
+	if !w.projectCapabilities(user, project)[capRestoreElsewhere] {
+		return errtypes.PermissionDenied("eosfs: the user is not allowed to restore recycle items elsewhere in this project space")
+	}
+	return nil
+}
+
+// projectName extracts the name of the project from a path resembling
+// /c/cernbox or /c/cernbox/minutes/.. It returns false for / or /$letter.
+func projectName(p string) (string, bool) {
+	root, ok := projectRoot(p)
+	if !ok {
+		return "", false
+	}
+	return path.Base(root), true
+}
+
+// projectRoot returns the root of the project of a path, e.g. /c/cernbox
+// for /c/cernbox/minutes/..
+func projectRoot(p string) (string, bool) {
+	return pathPrefix(p, projectQuotaNodeDepth)
+}
+
+// pathPrefix returns the first depth elements of the path.
+func pathPrefix(p string, depth int) (string, bool) {
+	parts := strings.Split(strings.Trim(path.Clean(p), "/"), "/")
+	if len(parts) < depth || parts[0] == "" {
+		return "", false
+	}
+	return "/" + strings.Join(parts[:depth], "/"), true
+}
+
+// resolveQuotaNode returns the quota node enclosing the reference.
+// The nodes of the resources referenced by id are cached, as the resolution
+// requires a stat.
+func (w *wrapper) resolveQuotaNode(ctx context.Context, ref *provider.Reference) (string, error) {
+	id := ref.GetResourceId()
+	if id == nil {
+		node, ok := pathPrefix(ref.GetPath(), w.quotaNodeDepth)
+		if !ok {
+			return "", errtypes.BadRequest("eosfs: quota is only available inside a quota node")
+		}
+		return node, nil
+	}
+
+	key := id.StorageId + "!" + id.OpaqueId
+	if v, err := w.quotaNodes.Get(key); err == nil {
+		return v.(string), nil
+	}
+
+	md, err := w.FS.GetMD(ctx, &provider.Reference{ResourceId: id}, nil)
+	if err != nil {
+		return "", err
+	}
+	if node, ok := pathPrefix(md.Path, w.quotaNodeDepth); ok {
+		_ = w.quotaNodes.Set(key, node)
+		return node, nil
+	}
+
+	// the id is above the quota nodes, so the relative path decides
+	node, ok := pathPrefix(path.Join(md.Path, ref.GetPath()), w.quotaNodeDepth)
+	if !ok {
+		return "", errtypes.BadRequest("eosfs: quota is only available inside a quota node")
+	}
+	return node, nil
+}
+
+func (w *wrapper) userHasProjectCapability(ctx context.Context, ref *provider.Reference, capability string) error {
 	// Check if this storage provider corresponds to a project spaces instance
 	if !strings.HasPrefix(w.conf.Namespace, eosProjectsNamespace) {
 		return nil
@@ -226,21 +514,16 @@ func (w *wrapper) userIsProjectAdmin(ctx context.Context, ref *provider.Referenc
 		return err
 	}
 
-	// Extract project name from the path resembling /c/cernbox or /c/cernbox/minutes/..
-	parts := strings.SplitN(res.Path, "/", 4)
-	if len(parts) != 4 && len(parts) != 3 {
+	project, ok := projectName(res.Path)
+	if !ok {
 		// The request might be for / or /$letter
 		// Nothing to do in that case
 		return nil
 	}
-	adminGroup := projectSpaceGroupsPrefix + parts[2] + projectSpaceAdminGroupsSuffix
 	user := appctx.ContextMustGetUser(ctx)
-
-	for _, g := range user.Groups {
-		if g == adminGroup {
-			return nil
-		}
+	if w.projectCapabilities(user, project)[capability] {
+		return nil
 	}
 
-	return errtypes.PermissionDenied("eosfs: project spaces revisions can only be accessed by admins")
+	return errtypes.PermissionDenied("eosfs: " + capability + " is not allowed to the user in this project space")
 }
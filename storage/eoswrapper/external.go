@@ -0,0 +1,98 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package eoswrapper
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	"github.com/cs3org/reva/pkg/appctx"
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+)
+
+// externalPolicy restricts what the lightweight and external users can do
+// in project spaces, on top of the permissions given by their roles.
+type externalPolicy struct {
+	// UserTypes are the types of the users the policy applies to, e.g.
+	// lightweight or federated. Defaults to lightweight.
+	UserTypes []string `mapstructure:"user_types"`
+	// DeniedCapabilities are removed from the capabilities of the project
+	// roles, e.g. revisions or deny_grant.
+	DeniedCapabilities []string `mapstructure:"denied_capabilities"`
+	// ReadOnly rejects all the operations modifying the projects.
+	ReadOnly bool `mapstructure:"read_only"`
+
+	types []userpb.UserType
+}
+
+func parseExternalPolicy(m map[string]interface{}) (*externalPolicy, error) {
+	p := &externalPolicy{}
+	v, ok := m["external_users"]
+	if !ok {
+		return p, nil
+	}
+	if err := mapstructure.Decode(v, p); err != nil {
+		return nil, errors.Wrap(err, "eos: error decoding external_users")
+	}
+
+	if len(p.UserTypes) == 0 {
+		p.UserTypes = []string{"lightweight"}
+	}
+	for _, t := range p.UserTypes {
+		ut, ok := userpb.UserType_value["USER_TYPE_"+strings.ToUpper(t)]
+		if !ok {
+			return nil, fmt.Errorf("eos: unknown user type %q in external_users", t)
+		}
+		p.types = append(p.types, userpb.UserType(ut))
+	}
+	for _, c := range p.DeniedCapabilities {
+		if !slices.Contains(capabilities, c) {
+			return nil, fmt.Errorf("eos: unknown capability %q in external_users", c)
+		}
+	}
+	return p, nil
+}
+
+// applies returns true if the user is restricted by the policy.
+func (p *externalPolicy) applies(u *userpb.User) bool {
+	return slices.Contains(p.types, u.GetId().GetType())
+}
+
+// restrict removes the denied capabilities of the user.
+func (p *externalPolicy) restrict(u *userpb.User, caps map[string]bool) {
+	if !p.applies(u) {
+		return
+	}
+	for _, c := range p.DeniedCapabilities {
+		delete(caps, c)
+	}
+}
+
+// readOnly returns true if the user in the context cannot modify the projects.
+func (p *externalPolicy) readOnly(ctx context.Context) bool {
+	if !p.ReadOnly {
+		return false
+	}
+	u, ok := appctx.ContextGetUser(ctx)
+	return ok && p.applies(u)
+}
@@ -0,0 +1,91 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package eoswrapper
+
+import (
+	"context"
+	"path"
+	"slices"
+	"strings"
+
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/cs3org/reva/pkg/appctx"
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+)
+
+// hiddenPaths hides the EOS internals, e.g. the .sys.* folders, from the
+// users not member of the admin group.
+type hiddenPaths struct {
+	// Globs are matched with path.Match against the name of the resources,
+	// or against their full path if they contain a /.
+	Globs      []string `mapstructure:"globs"`
+	AdminGroup string   `mapstructure:"admin_group"`
+}
+
+func parseHiddenPaths(m map[string]interface{}) (*hiddenPaths, error) {
+	var h hiddenPaths
+	v, ok := m["hidden_paths"]
+	if !ok {
+		return &h, nil
+	}
+	if err := mapstructure.Decode(v, &h); err != nil {
+		return nil, errors.Wrap(err, "eos: error decoding hidden_paths")
+	}
+	for _, g := range h.Globs {
+		if _, err := path.Match(g, ""); err != nil {
+			return nil, errors.Wrapf(err, "eos: invalid hidden path %q", g)
+		}
+	}
+	return &h, nil
+}
+
+// hidden returns true if the resource must be hidden to the user.
+func (h *hiddenPaths) hidden(ctx context.Context, p string) bool {
+	if len(h.Globs) == 0 {
+		return false
+	}
+	if h.AdminGroup != "" {
+		if u, ok := appctx.ContextGetUser(ctx); ok && slices.Contains(u.Groups, h.AdminGroup) {
+			return false
+		}
+	}
+
+	name := path.Base(p)
+	for _, g := range h.Globs {
+		target := name
+		if strings.Contains(g, "/") {
+			target = p
+		}
+		if ok, _ := path.Match(g, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// filterHidden removes the hidden resources from a listing.
+func (h *hiddenPaths) filterHidden(ctx context.Context, res []*provider.ResourceInfo) []*provider.ResourceInfo {
+	if len(h.Globs) == 0 {
+		return res
+	}
+	return slices.DeleteFunc(res, func(r *provider.ResourceInfo) bool {
+		return h.hidden(ctx, r.Path)
+	})
+}
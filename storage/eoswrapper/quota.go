@@ -0,0 +1,116 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package eoswrapper
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/cs3org/reva/pkg/appctx"
+	"github.com/cs3org/reva/pkg/eosclient"
+	"github.com/cs3org/reva/pkg/eosclient/eosbinary"
+	"github.com/cs3org/reva/pkg/storage/utils/eosfs"
+	"github.com/cs3org/reva/pkg/utils"
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+)
+
+// parseQuotaNodes returns the depth of the quota nodes in the namespace.
+// The projects have their quota at /$letter/$project. The other namespaces,
+// e.g. the experiments, can be configured in quota_nodes, mapping a prefix
+// of the namespace to the depth of the quota nodes below it:
+//
+//	[grpc.services.storageprovider.drivers.eoswrapper.quota_nodes]
+//	"/eos/experiment" = 1
+//	"/eos/atlas" = 2
+//
+// The longest matching prefix wins. It returns 0 if the quota is not resolved.
+func parseQuotaNodes(m map[string]interface{}, namespace string) (int, error) {
+	nodes := map[string]int{}
+	if v, ok := m["quota_nodes"]; ok {
+		if err := mapstructure.WeakDecode(v, &nodes); err != nil {
+			return 0, errors.Wrap(err, "eos: error decoding quota_nodes")
+		}
+	}
+
+	depth, longest := 0, -1
+	for prefix, d := range nodes {
+		if d < 0 {
+			return 0, fmt.Errorf("eos: invalid depth %d for quota node %q", d, prefix)
+		}
+		if strings.HasPrefix(namespace, prefix) && len(prefix) > longest {
+			depth, longest = d, len(prefix)
+		}
+	}
+	if longest < 0 && strings.HasPrefix(namespace, eosProjectsNamespace) {
+		depth = projectQuotaNodeDepth
+	}
+	return depth, nil
+}
+
+// newQuotaClient returns the client reading the inode quota of the
+// quota nodes, nil if the quota is not resolved by the wrapper.
+func newQuotaClient(c *eosfs.Config, quotaNodeDepth int) (eosclient.EOSClient, error) {
+	if quotaNodeDepth == 0 || c.MasterURL == "" {
+		return nil, nil
+	}
+	client, err := eosbinary.New(&eosbinary.Options{
+		URL:       c.MasterURL,
+		EosBinary: c.EosBinary,
+		UseKeytab: c.UseKeytab,
+		Keytab:    c.Keytab,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "eos: error creating eos client for the quota")
+	}
+	return client, nil
+}
+
+// setInodeQuota attaches to the quota nodes their inode quota, as
+// quota_max_files and quota_used_files, since GetQuota only reports
+// the bytes.
+func (w *wrapper) setInodeQuota(ctx context.Context, r *provider.ResourceInfo) {
+	if w.quotaClient == nil {
+		return
+	}
+	node, ok := pathPrefix(r.Path, w.quotaNodeDepth)
+	if !ok || node != path.Clean(r.Path) {
+		return
+	}
+
+	var qi *eosclient.QuotaInfo
+	if v, err := w.inodeQuotas.Get(node); err == nil {
+		qi = v.(*eosclient.QuotaInfo)
+	} else {
+		u := appctx.ContextMustGetUser(ctx)
+		root := eosclient.Authorization{Role: eosclient.Role{UID: "0", GID: "0"}}
+		qi, err = w.quotaClient.GetQuota(ctx, u.Username, root, path.Join(w.conf.Namespace, node)+"/")
+		if err != nil {
+			appctx.GetLogger(ctx).Error().Err(err).Str("node", node).Msg("eos: error getting the inode quota")
+			return
+		}
+		_ = w.inodeQuotas.Set(node, qi)
+	}
+	r.Opaque = utils.AppendPlainToOpaque(r.Opaque, "quota_max_files", strconv.FormatUint(qi.AvailableInodes, 10))
+	r.Opaque = utils.AppendPlainToOpaque(r.Opaque, "quota_used_files", strconv.FormatUint(qi.UsedInodes, 10))
+}
@@ -0,0 +1,284 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package eoswrapper
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cernbox/reva-plugins/redispool"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/cs3org/reva/pkg/errtypes"
+	"github.com/gomodule/redigo/redis"
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+)
+
+// The projects listed in read_only_projects, e.g. during a migration,
+// reject all the operations modifying them, while the reads keep working.
+// The projects can also be set read-only at runtime with the admin endpoint.
+// Without read_only_redis, the projects set at runtime are only read-only
+// for the replica whose admin endpoint was called, until its restart.
+
+// readOnlyRedisConfig shares the projects set read-only at runtime among
+// the replicas of the storage provider, in a redis set.
+type readOnlyRedisConfig struct {
+	Address  string `mapstructure:"address"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	// Key is the redis set of the read-only projects.
+	// Defaults to "eos:read_only_projects".
+	Key string `mapstructure:"key"`
+	// RefreshInterval is the interval in seconds between the reads of
+	// the set by each replica. Defaults to 10 seconds.
+	RefreshInterval int `mapstructure:"refresh_interval"`
+}
+
+func parseReadOnlyRedisConfig(m map[string]interface{}) (*readOnlyRedisConfig, error) {
+	var c readOnlyRedisConfig
+	if err := mapstructure.Decode(m["read_only_redis"], &c); err != nil {
+		return nil, errors.Wrap(err, "eos: error decoding read_only_redis")
+	}
+	if c.Key == "" {
+		c.Key = "eos:read_only_projects"
+	}
+	if c.RefreshInterval == 0 {
+		c.RefreshInterval = 10
+	}
+	return &c, nil
+}
+
+// readOnlyProjects are the projects in maintenance.
+type readOnlyProjects struct {
+	mu sync.RWMutex
+	// configured are the projects in read_only_projects,
+	// runtime the ones set with the admin endpoint.
+	configured map[string]bool
+	runtime    map[string]bool
+
+	// pool is nil if the runtime projects are not shared.
+	pool *redis.Pool
+	key  string
+}
+
+func newReadOnlyProjects(projects []string, c *readOnlyRedisConfig) *readOnlyProjects {
+	r := &readOnlyProjects{
+		configured: make(map[string]bool, len(projects)),
+		runtime:    map[string]bool{},
+		key:        c.Key,
+	}
+	for _, p := range projects {
+		r.configured[p] = true
+	}
+	if c.Address != "" {
+		r.pool = redispool.New(&redispool.Config{
+			Address:  c.Address,
+			Username: c.Username,
+			Password: c.Password,
+		})
+	}
+	return r
+}
+
+func (r *readOnlyProjects) has(project string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.configured[project] || r.runtime[project]
+}
+
+func (r *readOnlyProjects) empty() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.configured) == 0 && len(r.runtime) == 0
+}
+
+// set sets the project read-only, or writable again. The projects
+// in read_only_projects stay read-only.
+func (r *readOnlyProjects) set(project string, readOnly bool) error {
+	if r.pool != nil {
+		conn := r.pool.Get()
+		defer conn.Close()
+		cmd := "SREM"
+		if readOnly {
+			cmd = "SADD"
+		}
+		if _, err := conn.Do(cmd, r.key, project); err != nil {
+			return errors.Wrap(err, "eos: error updating the read-only projects in redis")
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if readOnly {
+		r.runtime[project] = true
+	} else {
+		delete(r.runtime, project)
+	}
+	return nil
+}
+
+// refresh reads the projects set read-only by all the replicas.
+func (r *readOnlyProjects) refresh(ctx context.Context) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+	projects, err := redis.Strings(conn.Do("SMEMBERS", r.key))
+	if err != nil {
+		return errors.Wrap(err, "eos: error reading the read-only projects from redis")
+	}
+
+	runtime := make(map[string]bool, len(projects))
+	for _, p := range projects {
+		runtime[p] = true
+	}
+	r.mu.Lock()
+	r.runtime = runtime
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *readOnlyProjects) list() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	projects := make([]string, 0, len(r.configured)+len(r.runtime))
+	for p := range r.configured {
+		projects = append(projects, p)
+	}
+	for p := range r.runtime {
+		if !r.configured[p] {
+			projects = append(projects, p)
+		}
+	}
+	sort.Strings(projects)
+	return projects
+}
+
+func (r *readOnlyProjects) close() error {
+	if r.pool == nil {
+		return nil
+	}
+	return r.pool.Close()
+}
+
+// refPath returns the path of the reference, which may not exist yet.
+func (w *wrapper) refPath(ctx context.Context, ref *provider.Reference) (string, error) {
+	if ref.GetResourceId() == nil {
+		return ref.GetPath(), nil
+	}
+	p, err := w.FS.GetPathByID(ctx, ref.GetResourceId())
+	if err != nil {
+		return "", err
+	}
+	return path.Join(p, ref.GetPath()), nil
+}
+
+// checkWritable fails if one of the references is in a read-only project,
+// or if the user is an external user restricted to read-only.
+func (w *wrapper) checkWritable(ctx context.Context, refs ...*provider.Reference) error {
+	if strings.HasPrefix(w.conf.Namespace, eosProjectsNamespace) && w.externalPolicy.readOnly(ctx) {
+		return errtypes.PermissionDenied("eosfs: project spaces are read-only for external users")
+	}
+	if w.readOnlyProjects.empty() {
+		return nil
+	}
+	for _, ref := range refs {
+		p, err := w.refPath(ctx, ref)
+		if err != nil {
+			return err
+		}
+		if project, ok := projectName(p); ok && w.readOnlyProjects.has(project) {
+			return errtypes.PermissionDenied("eosfs: the project " + project + " is read-only for maintenance")
+		}
+	}
+	return nil
+}
+
+func (w *wrapper) CreateDir(ctx context.Context, ref *provider.Reference) error {
+	if err := w.checkWritable(ctx, ref); err != nil {
+		return err
+	}
+	return w.FS.CreateDir(ctx, ref)
+}
+
+func (w *wrapper) TouchFile(ctx context.Context, ref *provider.Reference) error {
+	if err := w.checkWritable(ctx, ref); err != nil {
+		return err
+	}
+	return w.FS.TouchFile(ctx, ref)
+}
+
+func (w *wrapper) Delete(ctx context.Context, ref *provider.Reference) error {
+	if err := w.checkWritable(ctx, ref); err != nil {
+		return err
+	}
+	return w.FS.Delete(ctx, ref)
+}
+
+func (w *wrapper) Move(ctx context.Context, oldRef, newRef *provider.Reference) error {
+	if err := w.checkWritable(ctx, oldRef, newRef); err != nil {
+		return err
+	}
+	return w.FS.Move(ctx, oldRef, newRef)
+}
+
+func (w *wrapper) InitiateUpload(ctx context.Context, ref *provider.Reference, uploadLength int64, metadata map[string]string) (map[string]string, error) {
+	if err := w.checkWritable(ctx, ref); err != nil {
+		return nil, err
+	}
+	return w.FS.InitiateUpload(ctx, ref, uploadLength, metadata)
+}
+
+func (w *wrapper) Upload(ctx context.Context, ref *provider.Reference, r io.ReadCloser, metadata map[string]string) error {
+	if err := w.checkWritable(ctx, ref); err != nil {
+		return err
+	}
+	return w.FS.Upload(ctx, ref, r, metadata)
+}
+
+func (w *wrapper) PurgeRecycleItem(ctx context.Context, basePath, key, relativePath string) error {
+	if err := w.checkWritable(ctx, &provider.Reference{Path: basePath}); err != nil {
+		return err
+	}
+	return w.FS.PurgeRecycleItem(ctx, basePath, key, relativePath)
+}
+
+func (w *wrapper) SetArbitraryMetadata(ctx context.Context, ref *provider.Reference, md *provider.ArbitraryMetadata) error {
+	if err := w.checkWritable(ctx, ref); err != nil {
+		return err
+	}
+	return w.FS.SetArbitraryMetadata(ctx, ref, md)
+}
+
+func (w *wrapper) UnsetArbitraryMetadata(ctx context.Context, ref *provider.Reference, keys []string) error {
+	if err := w.checkWritable(ctx, ref); err != nil {
+		return err
+	}
+	return w.FS.UnsetArbitraryMetadata(ctx, ref, keys)
+}
+
+func (w *wrapper) CreateReference(ctx context.Context, p string, targetURI *url.URL) error {
+	if err := w.checkWritable(ctx, &provider.Reference{Path: p}); err != nil {
+		return err
+	}
+	return w.FS.CreateReference(ctx, p, targetURI)
+}
@@ -0,0 +1,96 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package eoswrapper
+
+import (
+	"fmt"
+	"slices"
+
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+)
+
+// The capabilities that the project roles can grant, on top of the
+// permissions given by the EOS ACLs.
+const (
+	capAddGrant         = "add_grant"
+	capRemoveGrant      = "remove_grant"
+	capUpdateGrant      = "update_grant"
+	capListGrants       = "list_grants"
+	capDenyGrant        = "deny_grant"
+	capGetQuota         = "get_quota"
+	capRevisions        = "revisions"
+	capRestoreElsewhere = "restore_elsewhere"
+)
+
+var capabilities = []string{
+	capAddGrant, capRemoveGrant, capUpdateGrant, capListGrants, capDenyGrant,
+	capGetQuota, capRevisions, capRestoreElsewhere,
+}
+
+// defaultProjectRoles maps the suffix of the project groups to the
+// capabilities of their members.
+var defaultProjectRoles = map[string][]string{
+	projectSpaceAdminGroupsSuffix:   capabilities,
+	projectSpaceWritersGroupsSuffix: {capRestoreElsewhere},
+}
+
+// parseProjectRoles reads the project_roles config, replacing the default
+// roles if set, e.g.
+//
+//	[grpc.services.storageprovider.drivers.eoswrapper.project_roles]
+//	"-admins" = ["add_grant", "remove_grant", ...]
+//	"-writers" = ["get_quota", "restore_elsewhere"]
+func parseProjectRoles(m map[string]interface{}) (map[string][]string, error) {
+	v, ok := m["project_roles"]
+	if !ok {
+		return defaultProjectRoles, nil
+	}
+
+	var roles map[string][]string
+	if err := mapstructure.Decode(v, &roles); err != nil {
+		return nil, errors.Wrap(err, "eos: error decoding project_roles")
+	}
+	for suffix, caps := range roles {
+		for _, c := range caps {
+			if !slices.Contains(capabilities, c) {
+				return nil, fmt.Errorf("eos: unknown capability %q for project role %q", c, suffix)
+			}
+		}
+	}
+	return roles, nil
+}
+
+// projectCapabilities returns the capabilities of the user in the project,
+// granted by the roles of the project groups the user is member of and
+// restricted by the policy of the external users.
+func (w *wrapper) projectCapabilities(u *userpb.User, project string) map[string]bool {
+	caps := map[string]bool{}
+	for suffix, roleCaps := range w.projectRoles {
+		if !slices.Contains(u.Groups, projectSpaceGroupsPrefix+project+suffix) {
+			continue
+		}
+		for _, c := range roleCaps {
+			caps[c] = true
+		}
+	}
+	w.externalPolicy.restrict(u, caps)
+	return caps
+}
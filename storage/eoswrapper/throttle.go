@@ -0,0 +1,381 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package eoswrapper
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/bluele/gcache"
+	"github.com/cernbox/reva-plugins/breaker"
+	"github.com/cernbox/reva-plugins/ratelimit"
+	provider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	types "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
+	"github.com/cs3org/reva/pkg/appctx"
+	"github.com/cs3org/reva/pkg/errtypes"
+	"github.com/cs3org/reva/pkg/storage"
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// throttleConfig configures the throttling of the calls to EOS.
+type throttleConfig struct {
+	// Rate is the number of calls per second allowed to the namespace,
+	// with bursts of Burst calls. 0 disables the limit.
+	Rate  float64 `mapstructure:"rate"`
+	Burst int     `mapstructure:"burst"`
+	// UserRate and UserBurst limit the calls of each user.
+	UserRate  float64 `mapstructure:"user_rate"`
+	UserBurst int     `mapstructure:"user_burst"`
+	// RateLimitStore is the configuration of the store of the rate
+	// limiter, see ratelimit.StoreConfig.
+	RateLimitStore map[string]interface{} `mapstructure:"ratelimit_store"`
+	// CallTimeout is the timeout in milliseconds of the calls to EOS,
+	// except the uploads and downloads. There is no timeout if 0.
+	CallTimeout int `mapstructure:"call_timeout"`
+	// BreakerThreshold is the number of consecutive failed or timed out
+	// calls after which the calls to EOS are suspended. The calls failing
+	// because of the request, e.g. on a missing file, do not count.
+	// 0 disables the circuit breaker.
+	BreakerThreshold int `mapstructure:"breaker_threshold"`
+	// BreakerTimeout is for how long in seconds the calls are suspended.
+	// Defaults to 30.
+	BreakerTimeout int `mapstructure:"breaker_timeout"`
+	// BreakerScope is what a circuit breaker is shared by: "operation",
+	// so that e.g. failing listings do not suspend the downloads, or
+	// "user", so that the failures of a user do not suspend the others.
+	// Defaults to operation.
+	BreakerScope string `mapstructure:"breaker_scope"`
+}
+
+// breakersCacheSize is the number of circuit breakers kept, one per
+// scope, so that the ones of the inactive users are released.
+const breakersCacheSize = 10000
+
+// Unavailable is the error of the calls suspended by the circuit breaker.
+type Unavailable string
+
+func (e Unavailable) Error() string { return string(e) }
+
+// IsUnavailable marks the error as unavailable.
+func (e Unavailable) IsUnavailable() {}
+
+// GRPCStatus returns the unavailable status, used by the status
+// of the responses of the storage provider.
+func (e Unavailable) GRPCStatus() *status.Status {
+	return status.New(codes.Unavailable, string(e))
+}
+
+// throttledFS limits the rate of the calls to the wrapped EOS filesystem,
+// and suspends them when EOS keeps failing, so that a single misbehaving client
+// cannot take down the storage provider for everyone.
+type throttledFS struct {
+	storage.FS
+	c         *throttleConfig
+	namespace string
+	limiter   ratelimit.Limiter
+	// breakers are the circuit breakers by scope
+	breakers gcache.Cache
+}
+
+// newThrottledFS wraps fs if throttle is configured.
+func newThrottledFS(fs storage.FS, m map[string]interface{}, namespace string) (storage.FS, error) {
+	v, ok := m["throttle"]
+	if !ok {
+		return fs, nil
+	}
+	c := &throttleConfig{}
+	if err := mapstructure.Decode(v, c); err != nil {
+		return nil, errors.Wrap(err, "eos: error decoding throttle")
+	}
+	if c.BreakerTimeout == 0 {
+		c.BreakerTimeout = 30
+	}
+	switch c.BreakerScope {
+	case "":
+		c.BreakerScope = "operation"
+	case "operation", "user":
+	default:
+		return nil, errors.Errorf("eos: unknown breaker_scope %q", c.BreakerScope)
+	}
+
+	t := &throttledFS{
+		FS:        fs,
+		c:         c,
+		namespace: namespace,
+		breakers: gcache.New(breakersCacheSize).LRU().LoaderFunc(func(key interface{}) (interface{}, error) {
+			return breaker.New(c.BreakerThreshold, time.Duration(c.BreakerTimeout)*time.Second), nil
+		}).Build(),
+	}
+	if c.Rate > 0 || c.UserRate > 0 {
+		limiter, err := ratelimit.NewLimiterFromMap(c.RateLimitStore)
+		if err != nil {
+			return nil, err
+		}
+		t.limiter = limiter
+	}
+	return t, nil
+}
+
+// breaker returns the circuit breaker of the operation in its scope.
+func (t *throttledFS) breaker(ctx context.Context, op string) *breaker.Breaker {
+	key := op
+	if t.c.BreakerScope == "user" {
+		key = ""
+		if u, ok := appctx.ContextGetUser(ctx); ok {
+			key = u.Username
+		}
+	}
+	b, _ := t.breakers.Get(key)
+	return b.(*breaker.Breaker)
+}
+
+func (t *throttledFS) allow(ctx context.Context, op string) error {
+	if t.c.BreakerThreshold > 0 && !t.breaker(ctx, op).Allow() {
+		return Unavailable("eos: too many failed calls, " + op + " requests to " + t.namespace + " are suspended")
+	}
+	if t.limiter == nil {
+		return nil
+	}
+
+	check := func(key string, rate float64, burst int) error {
+		if rate <= 0 {
+			return nil
+		}
+		ok, wait, err := t.limiter.Allow(ctx, key, rate, burst)
+		if err != nil {
+			// do not block the users if the limiter is not working
+			appctx.GetLogger(ctx).Error().Err(err).Msg("eos: error checking rate limit")
+			return nil
+		}
+		if !ok {
+			return errtypes.TooEarly(fmt.Sprintf("eos: too many requests, retry in %s", wait.Round(time.Second)))
+		}
+		return nil
+	}
+
+	if u, ok := appctx.ContextGetUser(ctx); ok {
+		if err := check("eos:"+t.namespace+":user:"+u.Username, t.c.UserRate, t.c.UserBurst); err != nil {
+			return err
+		}
+	}
+	return check("eos:"+t.namespace, t.c.Rate, t.c.Burst)
+}
+
+// record reports to the circuit breaker the outcome of a call. Only the
+// failures of EOS count, not the ones caused by the request.
+func (t *throttledFS) record(ctx context.Context, op string, err error) {
+	if t.c.BreakerThreshold <= 0 {
+		return
+	}
+	if eosFailure(err) {
+		t.breaker(ctx, op).Failure()
+	} else {
+		t.breaker(ctx, op).Success()
+	}
+}
+
+// eosFailure returns true if the error is a failure or a timeout of EOS.
+func eosFailure(err error) bool {
+	switch errors.Cause(err).(type) {
+	case nil:
+		return false
+	case interface{ IsNotFound() }, interface{ IsAlreadyExists() }, interface{ IsPermissionDenied() },
+		interface{ IsBadRequest() }, interface{ IsNotSupported() }, interface{ IsLocked() },
+		interface{ IsInvalidCredentials() }, interface{ IsInsufficientStorage() }, interface{ IsPreconditionFailed() }:
+		return false
+	}
+	// the requests cancelled by the clients are not failures
+	return !errors.Is(err, context.Canceled)
+}
+
+// withCallTimeout bounds the duration of a call to EOS.
+func (t *throttledFS) withCallTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if t.c.CallTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, time.Duration(t.c.CallTimeout)*time.Millisecond)
+}
+
+func throttle[T any](ctx context.Context, t *throttledFS, op string, f func(ctx context.Context) (T, error)) (T, error) {
+	if err := t.allow(ctx, op); err != nil {
+		var zero T
+		return zero, err
+	}
+	callCtx, cancel := t.withCallTimeout(ctx)
+	defer cancel()
+	res, err := f(callCtx)
+	t.record(ctx, op, err)
+	return res, err
+}
+
+func throttleErr(ctx context.Context, t *throttledFS, op string, f func(ctx context.Context) error) error {
+	_, err := throttle(ctx, t, op, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, f(ctx)
+	})
+	return err
+}
+
+func (t *throttledFS) GetHome(ctx context.Context) (string, error) {
+	return throttle(ctx, t, "GetHome", func(ctx context.Context) (string, error) { return t.FS.GetHome(ctx) })
+}
+
+func (t *throttledFS) CreateHome(ctx context.Context) error {
+	return throttleErr(ctx, t, "CreateHome", func(ctx context.Context) error { return t.FS.CreateHome(ctx) })
+}
+
+func (t *throttledFS) CreateDir(ctx context.Context, ref *provider.Reference) error {
+	return throttleErr(ctx, t, "CreateDir", func(ctx context.Context) error { return t.FS.CreateDir(ctx, ref) })
+}
+
+func (t *throttledFS) TouchFile(ctx context.Context, ref *provider.Reference) error {
+	return throttleErr(ctx, t, "TouchFile", func(ctx context.Context) error { return t.FS.TouchFile(ctx, ref) })
+}
+
+func (t *throttledFS) Delete(ctx context.Context, ref *provider.Reference) error {
+	return throttleErr(ctx, t, "Delete", func(ctx context.Context) error { return t.FS.Delete(ctx, ref) })
+}
+
+func (t *throttledFS) Move(ctx context.Context, oldRef, newRef *provider.Reference) error {
+	return throttleErr(ctx, t, "Move", func(ctx context.Context) error { return t.FS.Move(ctx, oldRef, newRef) })
+}
+
+func (t *throttledFS) GetMD(ctx context.Context, ref *provider.Reference, mdKeys []string) (*provider.ResourceInfo, error) {
+	return throttle(ctx, t, "GetMD", func(ctx context.Context) (*provider.ResourceInfo, error) { return t.FS.GetMD(ctx, ref, mdKeys) })
+}
+
+func (t *throttledFS) ListFolder(ctx context.Context, ref *provider.Reference, mdKeys []string) ([]*provider.ResourceInfo, error) {
+	return throttle(ctx, t, "ListFolder", func(ctx context.Context) ([]*provider.ResourceInfo, error) { return t.FS.ListFolder(ctx, ref, mdKeys) })
+}
+
+func (t *throttledFS) InitiateUpload(ctx context.Context, ref *provider.Reference, uploadLength int64, metadata map[string]string) (map[string]string, error) {
+	return throttle(ctx, t, "InitiateUpload", func(ctx context.Context) (map[string]string, error) {
+		return t.FS.InitiateUpload(ctx, ref, uploadLength, metadata)
+	})
+}
+
+// Upload and Download have no call timeout: their duration depends
+// on the size of the files, not on the latency of EOS.
+
+func (t *throttledFS) Upload(ctx context.Context, ref *provider.Reference, r io.ReadCloser, metadata map[string]string) error {
+	if err := t.allow(ctx, "Upload"); err != nil {
+		return err
+	}
+	err := t.FS.Upload(ctx, ref, r, metadata)
+	t.record(ctx, "Upload", err)
+	return err
+}
+
+func (t *throttledFS) Download(ctx context.Context, ref *provider.Reference) (io.ReadCloser, error) {
+	if err := t.allow(ctx, "Download"); err != nil {
+		return nil, err
+	}
+	rc, err := t.FS.Download(ctx, ref)
+	t.record(ctx, "Download", err)
+	return rc, err
+}
+
+func (t *throttledFS) ListRevisions(ctx context.Context, ref *provider.Reference) ([]*provider.FileVersion, error) {
+	return throttle(ctx, t, "ListRevisions", func(ctx context.Context) ([]*provider.FileVersion, error) { return t.FS.ListRevisions(ctx, ref) })
+}
+
+func (t *throttledFS) DownloadRevision(ctx context.Context, ref *provider.Reference, key string) (io.ReadCloser, error) {
+	if err := t.allow(ctx, "DownloadRevision"); err != nil {
+		return nil, err
+	}
+	rc, err := t.FS.DownloadRevision(ctx, ref, key)
+	t.record(ctx, "DownloadRevision", err)
+	return rc, err
+}
+
+func (t *throttledFS) RestoreRevision(ctx context.Context, ref *provider.Reference, key string) error {
+	return throttleErr(ctx, t, "RestoreRevision", func(ctx context.Context) error { return t.FS.RestoreRevision(ctx, ref, key) })
+}
+
+func (t *throttledFS) ListRecycle(ctx context.Context, basePath, key, relativePath string, from, to *types.Timestamp) ([]*provider.RecycleItem, error) {
+	return throttle(ctx, t, "ListRecycle", func(ctx context.Context) ([]*provider.RecycleItem, error) {
+		return t.FS.ListRecycle(ctx, basePath, key, relativePath, from, to)
+	})
+}
+
+func (t *throttledFS) RestoreRecycleItem(ctx context.Context, basePath, key, relativePath string, restoreRef *provider.Reference) error {
+	return throttleErr(ctx, t, "RestoreRecycleItem", func(ctx context.Context) error {
+		return t.FS.RestoreRecycleItem(ctx, basePath, key, relativePath, restoreRef)
+	})
+}
+
+func (t *throttledFS) PurgeRecycleItem(ctx context.Context, basePath, key, relativePath string) error {
+	return throttleErr(ctx, t, "PurgeRecycleItem", func(ctx context.Context) error { return t.FS.PurgeRecycleItem(ctx, basePath, key, relativePath) })
+}
+
+func (t *throttledFS) EmptyRecycle(ctx context.Context) error {
+	return throttleErr(ctx, t, "EmptyRecycle", func(ctx context.Context) error { return t.FS.EmptyRecycle(ctx) })
+}
+
+func (t *throttledFS) GetPathByID(ctx context.Context, id *provider.ResourceId) (string, error) {
+	return throttle(ctx, t, "GetPathByID", func(ctx context.Context) (string, error) { return t.FS.GetPathByID(ctx, id) })
+}
+
+func (t *throttledFS) AddGrant(ctx context.Context, ref *provider.Reference, g *provider.Grant) error {
+	return throttleErr(ctx, t, "AddGrant", func(ctx context.Context) error { return t.FS.AddGrant(ctx, ref, g) })
+}
+
+func (t *throttledFS) DenyGrant(ctx context.Context, ref *provider.Reference, g *provider.Grantee) error {
+	return throttleErr(ctx, t, "DenyGrant", func(ctx context.Context) error { return t.FS.DenyGrant(ctx, ref, g) })
+}
+
+func (t *throttledFS) RemoveGrant(ctx context.Context, ref *provider.Reference, g *provider.Grant) error {
+	return throttleErr(ctx, t, "RemoveGrant", func(ctx context.Context) error { return t.FS.RemoveGrant(ctx, ref, g) })
+}
+
+func (t *throttledFS) UpdateGrant(ctx context.Context, ref *provider.Reference, g *provider.Grant) error {
+	return throttleErr(ctx, t, "UpdateGrant", func(ctx context.Context) error { return t.FS.UpdateGrant(ctx, ref, g) })
+}
+
+func (t *throttledFS) ListGrants(ctx context.Context, ref *provider.Reference) ([]*provider.Grant, error) {
+	return throttle(ctx, t, "ListGrants", func(ctx context.Context) ([]*provider.Grant, error) { return t.FS.ListGrants(ctx, ref) })
+}
+
+func (t *throttledFS) GetQuota(ctx context.Context, ref *provider.Reference) (uint64, uint64, error) {
+	if err := t.allow(ctx, "GetQuota"); err != nil {
+		return 0, 0, err
+	}
+	callCtx, cancel := t.withCallTimeout(ctx)
+	defer cancel()
+	total, used, err := t.FS.GetQuota(callCtx, ref)
+	t.record(ctx, "GetQuota", err)
+	return total, used, err
+}
+
+func (t *throttledFS) CreateReference(ctx context.Context, p string, targetURI *url.URL) error {
+	return throttleErr(ctx, t, "CreateReference", func(ctx context.Context) error { return t.FS.CreateReference(ctx, p, targetURI) })
+}
+
+func (t *throttledFS) SetArbitraryMetadata(ctx context.Context, ref *provider.Reference, md *provider.ArbitraryMetadata) error {
+	return throttleErr(ctx, t, "SetArbitraryMetadata", func(ctx context.Context) error { return t.FS.SetArbitraryMetadata(ctx, ref, md) })
+}
+
+func (t *throttledFS) UnsetArbitraryMetadata(ctx context.Context, ref *provider.Reference, keys []string) error {
+	return throttleErr(ctx, t, "UnsetArbitraryMetadata", func(ctx context.Context) error { return t.FS.UnsetArbitraryMetadata(ctx, ref, keys) })
+}
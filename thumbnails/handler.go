@@ -31,6 +31,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/cernbox/reva-plugins/ratelimit"
 	"github.com/cernbox/reva-plugins/thumbnails/manager"
 	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
 	rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
@@ -65,13 +66,14 @@ const (
 
 type config struct {
 	GatewaySVC       string                            `mapstructure:"gateway_svc"`
-	Quality          int                               `mapstructure:"quality"`
+	Quality          int                               `mapstructure:"quality"     validate:"min=0,max=100"`
 	FixedResolutions []string                          `mapstructure:"fixed_resolutions"`
 	Cache            string                            `mapstructure:"cache"`
 	CacheDrivers     map[string]map[string]interface{} `mapstructure:"cache_drivers"`
-	OutputType       string                            `mapstructure:"output_type"`
+	OutputType       string                            `mapstructure:"output_type" validate:"oneof=jpg png bmp"`
 	Prefix           string                            `mapstructure:"prefix"`
 	Insecure         bool                              `mapstructure:"insecure"`
+	RateLimit        map[string]interface{}            `mapstructure:"ratelimit"`
 }
 
 // Thumbnails is an HTTP service that creates
@@ -81,6 +83,7 @@ type Thumbnails struct {
 	log       *zerolog.Logger
 	client    gateway.GatewayAPIClient
 	thumbnail *manager.Thumbnail
+	rateLimit func(http.Handler) http.Handler
 }
 
 func (c *config) ApplyDefaults() {
@@ -133,11 +136,17 @@ func New(ctx context.Context, m map[string]interface{}) (global.Service, error)
 		return nil, err
 	}
 
+	rateLimit, err := ratelimit.Middleware(c.RateLimit)
+	if err != nil {
+		return nil, err
+	}
+
 	s := &Thumbnails{
 		c:         &c,
 		log:       log,
 		thumbnail: mgr,
 		client:    gtw,
+		rateLimit: rateLimit,
 	}
 
 	return s, nil
@@ -403,7 +412,7 @@ func (s *Thumbnails) writeHTTPError(w http.ResponseWriter, err error) {
 }
 
 func (s *Thumbnails) Handler() http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	return s.rateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var head string
 
 		head, r.URL.Path = router.ShiftPath(r.URL.Path)
@@ -422,7 +431,7 @@ func (s *Thumbnails) Handler() http.Handler {
 			}
 			s.davPublicContext(s.Thumbnail(w, r)).ServeHTTP(w, r)
 		}
-	})
+	}))
 }
 
 func checkMethods(r *http.Request, methods ...string) bool {
@@ -24,8 +24,8 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
-	"time"
 
+	"github.com/cernbox/reva-plugins/redispool"
 	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
 	"github.com/gomodule/redigo/redis"
 )
@@ -36,37 +36,26 @@ const (
 	namePrefix       = "name:"
 	mailPrefix       = "mail:"
 	uidPrefix        = "uid:"
-	userGroupsPrefix = "groups:"
+	userGroupsPrefix = "groupset:"
 )
 
-func initRedisPool(address, username, password string) *redis.Pool {
-	return &redis.Pool{
+// groupsSetMarker is the member always present in the cached set of
+// the groups of a user, no group having an empty name.
+const groupsSetMarker = ""
 
-		MaxIdle:     50,
-		MaxActive:   1000,
-		IdleTimeout: 240 * time.Second,
-
-		Dial: func() (redis.Conn, error) {
-			var opts []redis.DialOption
-			if username != "" {
-				opts = append(opts, redis.DialUsername(username))
-			}
-			if password != "" {
-				opts = append(opts, redis.DialPassword(password))
-			}
-
-			c, err := redis.Dial("tcp", address, opts...)
-			if err != nil {
-				return nil, err
-			}
-			return c, err
-		},
-
-		TestOnBorrow: func(c redis.Conn, t time.Time) error {
-			_, err := c.Do("PING")
-			return err
-		},
-	}
+func initRedisPool(c *config) *redis.Pool {
+	return redispool.New(&redispool.Config{
+		Address:           c.RedisAddress,
+		Username:          c.RedisUsername,
+		Password:          c.RedisPassword,
+		TLS:               c.RedisTLS,
+		TLSSkipVerify:     c.RedisTLSSkipVerify,
+		SentinelAddresses: c.RedisSentinelAddresses,
+		MasterName:        c.RedisMasterName,
+		MaxIdle:           c.RedisMaxIdle,
+		MaxActive:         c.RedisMaxActive,
+		IdleTimeout:       c.RedisIdleTimeout,
+	})
 }
 
 func (m *manager) setVal(key, val string, expiration int) error {
@@ -137,7 +126,7 @@ func (m *manager) findCachedUsers(query string) ([]*userpb.User, error) {
 }
 
 func (m *manager) fetchCachedUserDetails(uid *userpb.UserId) (*userpb.User, error) {
-	user, err := m.getVal(userPrefix + usernamePrefix + strings.ToLower(uid.OpaqueId))
+	user, err := m.getUserVal(userPrefix + usernamePrefix + strings.ToLower(uid.OpaqueId))
 	if err != nil {
 		return nil, err
 	}
@@ -154,22 +143,22 @@ func (m *manager) cacheUserDetails(u *userpb.User) error {
 	if err != nil {
 		return err
 	}
-	if err = m.setVal(userPrefix+usernamePrefix+strings.ToLower(u.Id.OpaqueId), string(encodedUser), -1); err != nil {
+	if err = m.setUserVal(userPrefix+usernamePrefix+strings.ToLower(u.Id.OpaqueId), string(encodedUser)); err != nil {
 		return err
 	}
 
 	if u.Mail != "" {
-		if err = m.setVal(userPrefix+mailPrefix+strings.ToLower(u.Mail), string(encodedUser), -1); err != nil {
+		if err = m.setUserVal(userPrefix+mailPrefix+strings.ToLower(u.Mail), string(encodedUser)); err != nil {
 			return err
 		}
 	}
 	if u.DisplayName != "" {
-		if err = m.setVal(userPrefix+namePrefix+u.Id.OpaqueId+"_"+strings.ReplaceAll(strings.ToLower(u.DisplayName), " ", "_"), string(encodedUser), -1); err != nil {
+		if err = m.setUserVal(userPrefix+namePrefix+u.Id.OpaqueId+"_"+strings.ReplaceAll(strings.ToLower(u.DisplayName), " ", "_"), string(encodedUser)); err != nil {
 			return err
 		}
 	}
 	if u.UidNumber != 0 {
-		if err = m.setVal(userPrefix+uidPrefix+strconv.FormatInt(u.UidNumber, 10), string(encodedUser), -1); err != nil {
+		if err = m.setUserVal(userPrefix+uidPrefix+strconv.FormatInt(u.UidNumber, 10), string(encodedUser)); err != nil {
 			return err
 		}
 	}
@@ -177,7 +166,7 @@ func (m *manager) cacheUserDetails(u *userpb.User) error {
 }
 
 func (m *manager) fetchCachedUserByParam(field, claim string) (*userpb.User, error) {
-	user, err := m.getVal(userPrefix + field + ":" + strings.ToLower(claim))
+	user, err := m.getUserVal(userPrefix + field + ":" + strings.ToLower(claim))
 	if err != nil {
 		return nil, err
 	}
@@ -190,21 +179,86 @@ func (m *manager) fetchCachedUserByParam(field, claim string) (*userpb.User, err
 }
 
 func (m *manager) fetchCachedUserGroups(uid *userpb.UserId) ([]string, error) {
-	groups, err := m.getVal(userPrefix + userGroupsPrefix + strings.ToLower(uid.OpaqueId))
+	conn := m.redisPool.Get()
+	defer conn.Close()
+
+	members, err := redis.Strings(conn.Do("SMEMBERS", userPrefix+userGroupsPrefix+strings.ToLower(uid.OpaqueId)))
 	if err != nil {
 		return nil, err
 	}
-	g := []string{}
-	if err = json.Unmarshal([]byte(groups), &g); err != nil {
-		return nil, err
+	if len(members) == 0 {
+		return nil, redis.ErrNil
+	}
+	g := make([]string, 0, len(members)-1)
+	for _, member := range members {
+		if member != groupsSetMarker {
+			g = append(g, member)
+		}
 	}
 	return g, nil
 }
 
-func (m *manager) cacheUserGroups(uid *userpb.UserId, groups []string) error {
-	g, err := json.Marshal(&groups)
+// fetchCachedGroupsMembership checks in a single round trip if the user
+// is member of each of the groups. It returns redis.ErrNil if the groups
+// of the user are not cached.
+func (m *manager) fetchCachedGroupsMembership(uid *userpb.UserId, groups []string) ([]bool, error) {
+	conn := m.redisPool.Get()
+	defer conn.Close()
+
+	key := userPrefix + userGroupsPrefix + strings.ToLower(uid.OpaqueId)
+	args := []interface{}{key, groupsSetMarker}
+	for _, g := range groups {
+		args = append(args, g)
+	}
+	res, err := redis.Ints(conn.Do("SMISMEMBER", args...))
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if len(res) != len(groups)+1 {
+		return nil, fmt.Errorf("rest: unexpected reply of length %d to SMISMEMBER", len(res))
+	}
+	if res[0] == 0 {
+		return nil, redis.ErrNil
+	}
+	member := make([]bool, len(groups))
+	for i := range groups {
+		member[i] = res[i+1] == 1
+	}
+	return member, nil
+}
+
+// cacheUserGroups stores the groups of the user as a redis set, together
+// with a marker to tell an empty set of groups from one not cached.
+func (m *manager) cacheUserGroups(uid *userpb.UserId, groups []string) error {
+	conn := m.redisPool.Get()
+	defer conn.Close()
+
+	key := userPrefix + userGroupsPrefix + strings.ToLower(uid.OpaqueId)
+	args := []interface{}{key, groupsSetMarker}
+	for _, g := range groups {
+		args = append(args, g)
+	}
+
+	_ = conn.Send("MULTI")
+	_ = conn.Send("DEL", key)
+	_ = conn.Send("SADD", args...)
+	_ = conn.Send("EXPIRE", key, m.conf.UserGroupsCacheExpiration*60)
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+// invalidateUserGroups removes the cached groups of the given users,
+// returning how many were cached.
+func (m *manager) invalidateUserGroups(upns []string) (int, error) {
+	if len(upns) == 0 {
+		return 0, nil
+	}
+	conn := m.redisPool.Get()
+	defer conn.Close()
+
+	args := make([]interface{}, 0, len(upns))
+	for _, u := range upns {
+		args = append(args, userPrefix+userGroupsPrefix+strings.ToLower(u))
 	}
-	return m.setVal(userPrefix+userGroupsPrefix+strings.ToLower(uid.OpaqueId), string(g), m.conf.UserGroupsCacheExpiration*60)
+	return redis.Int(conn.Do("DEL", args...))
 }
@@ -0,0 +1,128 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cs3org/reva/pkg/appctx"
+)
+
+const (
+	cachedUsersKey = syncPrefix + "users"
+
+	// healthCacheTTL bounds the probes of redis and the API
+	// made by the requests to the health endpoint.
+	healthCacheTTL = 10 * time.Second
+)
+
+// HealthReport describes the state of the cache of the users.
+type HealthReport struct {
+	// Ready is true if redis is reachable and the users were fetched at least once.
+	Ready bool `json:"ready"`
+	// Healthy is true if the cache is ready, the API is reachable
+	// and the last fetch of the users is not older than two intervals.
+	Healthy      bool      `json:"healthy"`
+	LastSync     time.Time `json:"last_sync"`
+	LastFullSync time.Time `json:"last_full_sync"`
+	SyncError    string    `json:"sync_error,omitempty"`
+	// CachedUsers is the number of users fetched by the last full fetch.
+	CachedUsers int    `json:"cached_users"`
+	RedisError  string `json:"redis_error,omitempty"`
+	APIError    string `json:"api_error,omitempty"`
+}
+
+// HealthChecker is implemented by the user managers reporting their health.
+type HealthChecker interface {
+	Health(ctx context.Context) *HealthReport
+}
+
+// Health reports the state of the cache of the users, checking
+// the connectivity to redis and to the API.
+func (m *manager) Health(ctx context.Context) *HealthReport {
+	r := &HealthReport{}
+
+	m.syncMu.Lock()
+	r.LastSync, r.LastFullSync = m.lastSync, m.lastFullSync
+	if m.syncErr != nil {
+		r.SyncError = m.syncErr.Error()
+	}
+	m.syncMu.Unlock()
+
+	conn := m.redisPool.Get()
+	if _, err := conn.Do("PING"); err != nil {
+		r.RedisError = err.Error()
+	} else if v, err := m.getVal(cachedUsersKey); err == nil {
+		r.CachedUsers, _ = strconv.Atoi(v)
+	}
+	conn.Close()
+
+	endpoint := fmt.Sprintf("%s/api/v1.0/Identity?limit=1&field=upn", m.conf.APIBaseURL)
+	var resp IdentitiesResponse
+	if err := m.apiTokenManager.SendAPIGetRequest(ctx, endpoint, false, &resp); err != nil {
+		r.APIError = err.Error()
+	}
+
+	stale := time.Since(r.LastSync) > 2*time.Duration(m.conf.UserFetchInterval)*time.Second
+	r.Ready = r.RedisError == "" && !r.LastSync.IsZero()
+	r.Healthy = r.Ready && r.APIError == "" && !stale
+	return r
+}
+
+// saveCachedUsers persists the number of users fetched by a full fetch.
+func (m *manager) saveCachedUsers(ctx context.Context, n int) {
+	if err := m.setVal(cachedUsersKey, strconv.Itoa(n), -1); err != nil {
+		appctx.GetLogger(ctx).Error().Err(err).Msg("rest: error persisting the number of cached users")
+	}
+}
+
+// cachedHealth returns the health report, probing again redis and the
+// API only if the last report is older than healthCacheTTL.
+func (m *manager) cachedHealth(ctx context.Context) *HealthReport {
+	m.healthMu.Lock()
+	defer m.healthMu.Unlock()
+	if m.health == nil || time.Since(m.healthAt) > healthCacheTTL {
+		m.health, m.healthAt = m.Health(ctx), time.Now()
+	}
+	return m.health
+}
+
+// handleHealth reports the health of the cache. The full report, with the
+// errors, is only returned to the requests with the invalidation token.
+func (m *manager) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	report := m.cachedHealth(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	if !report.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if !m.authorized(r) {
+		_ = json.NewEncoder(w).Encode(&HealthReport{Ready: report.Ready, Healthy: report.Healthy})
+		return
+	}
+	_ = json.NewEncoder(w).Encode(report)
+}
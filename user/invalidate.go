@@ -0,0 +1,93 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package rest
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cs3org/reva/pkg/appctx"
+	"github.com/pkg/errors"
+)
+
+// invalidateRequest is the body of the notifications of group changes.
+type invalidateRequest struct {
+	// Users are the upns of the users whose groups changed.
+	Users []string `json:"users"`
+}
+
+// serveInvalidations runs the http endpoint receiving the notifications
+// of group changes, so that the users get access to e.g. the projects
+// without waiting for the expiration of their cached groups.
+func (m *manager) serveInvalidations(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/invalidate", m.handleInvalidate)
+	mux.HandleFunc("/health", m.handleHealth)
+	srv := &http.Server{
+		Addr:              m.conf.InvalidationAddress,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = srv.Shutdown(context.Background())
+	}()
+
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// authorized returns true if the request has the invalidation token.
+func (m *manager) authorized(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(m.conf.InvalidationToken)) == 1
+}
+
+func (m *manager) handleInvalidate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !m.authorized(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var req invalidateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+
+	n, err := m.invalidateUserGroups(req.Users)
+	if err != nil {
+		appctx.GetLogger(r.Context()).Error().Err(err).Msg("rest: error invalidating user groups")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]int{"invalidated": n})
+}
@@ -0,0 +1,89 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package rest
+
+import (
+	"time"
+
+	"github.com/bluele/gcache"
+	"github.com/cernbox/reva-plugins/metrics"
+)
+
+var localCacheTotal = metrics.NewCounterVec("user_rest_local_cache_total", "result")
+
+// localCache keeps in memory the users most recently read from redis,
+// sparing a round trip on the hot paths resolving many users.
+type localCache struct {
+	cache gcache.Cache
+}
+
+func newLocalCache(size, expiration int) *localCache {
+	if size <= 0 {
+		return nil
+	}
+	return &localCache{
+		cache: gcache.New(size).LRU().Expiration(time.Duration(expiration) * time.Second).Build(),
+	}
+}
+
+func (l *localCache) get(key string) (string, bool) {
+	if l == nil {
+		return "", false
+	}
+	v, err := l.cache.Get(key)
+	if err != nil {
+		localCacheTotal.Inc("miss")
+		return "", false
+	}
+	localCacheTotal.Inc("hit")
+	return v.(string), true
+}
+
+func (l *localCache) set(key, val string) {
+	if l == nil {
+		return
+	}
+	_ = l.cache.Set(key, val)
+}
+
+func (l *localCache) remove(key string) {
+	if l == nil {
+		return
+	}
+	l.cache.Remove(key)
+}
+
+// getUserVal reads the user cached in redis at key, through the local cache.
+func (m *manager) getUserVal(key string) (string, error) {
+	if v, ok := m.local.get(key); ok {
+		return v, nil
+	}
+	v, err := m.getVal(key)
+	if err != nil {
+		return "", err
+	}
+	m.local.set(key, v)
+	return v, nil
+}
+
+// setUserVal caches the user in redis at key, dropping the stale local copy.
+func (m *manager) setUserVal(key, val string) error {
+	m.local.remove(key)
+	return m.setVal(key, val, -1)
+}
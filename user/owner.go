@@ -0,0 +1,80 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package rest
+
+import (
+	"context"
+	"strings"
+
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	"github.com/cs3org/reva/pkg/errtypes"
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	// identityPrefix maps the grappa ids of the identities to their upn.
+	identityPrefix = "identity:"
+	// ownerPrefix maps the upn of the secondary and service accounts
+	// to the grappa id of their owner.
+	ownerPrefix = "owner:"
+)
+
+// PrimaryAccountResolver is implemented by the user managers able to tell
+// on behalf of whom a secondary or service account acts.
+type PrimaryAccountResolver interface {
+	GetPrimaryAccount(ctx context.Context, uid *userpb.UserId) (*userpb.User, error)
+}
+
+func (m *manager) cacheAccountOwner(i *Identity) error {
+	if i.ID == "" {
+		return nil
+	}
+	if err := m.setVal(identityPrefix+strings.ToLower(i.ID), i.Upn, -1); err != nil {
+		return err
+	}
+	if i.OwnerID == "" || i.OwnerID == i.ID {
+		// the account may have been owned before
+		conn := m.redisPool.Get()
+		defer conn.Close()
+		_, err := conn.Do("DEL", ownerPrefix+strings.ToLower(i.Upn))
+		return err
+	}
+	return m.setVal(ownerPrefix+strings.ToLower(i.Upn), strings.ToLower(i.OwnerID), -1)
+}
+
+// GetPrimaryAccount returns the owner of the given secondary or service
+// account, or the user itself if the account has no owner.
+func (m *manager) GetPrimaryAccount(ctx context.Context, uid *userpb.UserId) (*userpb.User, error) {
+	ownerID, err := m.getVal(ownerPrefix + strings.ToLower(uid.OpaqueId))
+	if err == redis.ErrNil {
+		return m.GetUser(ctx, uid, true)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	upn, err := m.getVal(identityPrefix + ownerID)
+	if err == redis.ErrNil {
+		return nil, errtypes.NotFound("rest: owner " + ownerID + " of account " + uid.OpaqueId)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m.GetUser(ctx, &userpb.UserId{OpaqueId: upn, Idp: m.conf.IDProvider}, true)
+}
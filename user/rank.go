@@ -0,0 +1,91 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package rest
+
+import (
+	"sort"
+	"strings"
+
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+)
+
+// Weights of the fields matched by the query, for the ranking of the users.
+const (
+	usernameWeight    = 3
+	displayNameWeight = 2
+	mailWeight        = 1
+)
+
+// Scores of the kinds of matches, multiplied by the weight of the field.
+const (
+	exactScore    = 100
+	prefixScore   = 50
+	wordScore     = 30
+	containsScore = 10
+)
+
+// matchScore scores how well the value of a field matches the query.
+func matchScore(value, query string) int {
+	value = strings.ToLower(value)
+	switch {
+	case value == "" || query == "":
+		return 0
+	case value == query:
+		return exactScore
+	case strings.HasPrefix(value, query):
+		return prefixScore
+	}
+	for _, w := range strings.FieldsFunc(value, func(r rune) bool { return r == ' ' || r == '.' || r == '-' || r == '@' }) {
+		if strings.HasPrefix(w, query) {
+			return wordScore
+		}
+	}
+	if strings.Contains(value, query) {
+		return containsScore
+	}
+	return 0
+}
+
+func userScore(u *userpb.User, query string) int {
+	return usernameWeight*matchScore(u.Username, query) +
+		displayNameWeight*matchScore(u.DisplayName, query) +
+		mailWeight*matchScore(u.Mail, query)
+}
+
+// rankUsers sorts the users by relevance for the query, so that the exact
+// and prefix matches come first, and keeps at most limit users if limit > 0.
+func rankUsers(users []*userpb.User, query string, limit int) []*userpb.User {
+	query = strings.ToLower(strings.TrimSpace(query))
+	scores := make(map[*userpb.User]int, len(users))
+	for _, u := range users {
+		scores[u] = userScore(u, query)
+	}
+
+	sort.SliceStable(users, func(i, j int) bool {
+		if si, sj := scores[users[i]], scores[users[j]]; si != sj {
+			return si > sj
+		}
+		return users[i].Username < users[j].Username
+	})
+
+	if limit > 0 && len(users) > limit {
+		users = users[:limit]
+	}
+	return users
+}
@@ -21,16 +21,17 @@ package rest
 import (
 	"context"
 	"fmt"
-	"os"
-	"os/signal"
+	"net/url"
 	"strings"
-	"syscall"
+	"sync"
 	"time"
 
+	"github.com/cernbox/reva-plugins/runner"
 	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
 	"github.com/cs3org/reva"
 	"github.com/cs3org/reva/pkg/appctx"
 	utils "github.com/cs3org/reva/pkg/cbox/utils"
+	"github.com/cs3org/reva/pkg/errtypes"
 	"github.com/cs3org/reva/pkg/user"
 	"github.com/cs3org/reva/pkg/utils/cfg"
 	"github.com/cs3org/reva/pkg/utils/list"
@@ -39,16 +40,28 @@ import (
 )
 
 func init() {
-	reva.RegisterPlugin(manager{})
+	reva.RegisterPlugin(&manager{})
 }
 
 type manager struct {
 	conf            *config
 	redisPool       *redis.Pool
 	apiTokenManager *utils.APITokenManager
+	runner          *runner.Runner
+	local           *localCache
+
+	syncMu       sync.Mutex
+	lastSync     time.Time
+	lastFullSync time.Time
+	syncErr      error
+
+	// health caches the last health report.
+	healthMu sync.Mutex
+	health   *HealthReport
+	healthAt time.Time
 }
 
-func (manager) RevaPlugin() reva.PluginInfo {
+func (*manager) RevaPlugin() reva.PluginInfo {
 	return reva.PluginInfo{
 		ID:  "grpc.services.userprovider.drivers.rest",
 		New: New,
@@ -62,23 +75,54 @@ type config struct {
 	RedisUsername string `mapstructure:"redis_username" docs:""`
 	// The password for connecting to the redis server
 	RedisPassword string `mapstructure:"redis_password" docs:""`
+	// Use TLS to connect to the redis server and the sentinels
+	RedisTLS           bool `mapstructure:"redis_tls" docs:"false"`
+	RedisTLSSkipVerify bool `mapstructure:"redis_tls_skip_verify" docs:"false"`
+	// The addresses of the redis sentinels. If set, the redis master
+	// is resolved through them instead of using redis_address
+	RedisSentinelAddresses []string `mapstructure:"redis_sentinel_addresses" docs:""`
+	// The name of the master monitored by the sentinels
+	RedisMasterName string `mapstructure:"redis_master_name" docs:"" validate:"required_with=RedisSentinelAddresses"`
+	// The sizing of the pool of connections to redis
+	RedisMaxIdle     int `mapstructure:"redis_max_idle" docs:"50"`
+	RedisMaxActive   int `mapstructure:"redis_max_active" docs:"1000"`
+	RedisIdleTimeout int `mapstructure:"redis_idle_timeout" docs:"240"`
 	// The time in minutes for which the groups to which a user belongs would be cached
 	UserGroupsCacheExpiration int `mapstructure:"user_groups_cache_expiration" docs:"5"`
+	// The number of users kept in memory in front of redis. 0 disables the local cache
+	LocalCacheSize int `mapstructure:"local_cache_size" docs:"0" validate:"min=0"`
+	// The time in seconds for which the users are kept in memory
+	LocalCacheExpiration int `mapstructure:"local_cache_expiration" docs:"60"`
 	// The OIDC Provider
 	IDProvider string `mapstructure:"id_provider" docs:"http://cernbox.cern.ch"`
 	// Base API Endpoint
-	APIBaseURL string `mapstructure:"api_base_url" docs:"https://authorization-service-api-dev.web.cern.ch"`
+	APIBaseURL string `mapstructure:"api_base_url" docs:"https://authorization-service-api-dev.web.cern.ch" validate:"url"`
 	// Client ID needed to authenticate
-	ClientID string `mapstructure:"client_id" docs:"-"`
+	ClientID string `mapstructure:"client_id" docs:"-" validate:"required"`
 	// Client Secret
-	ClientSecret string `mapstructure:"client_secret" docs:"-"`
+	ClientSecret string `mapstructure:"client_secret" docs:"-" validate:"required"`
 
 	// Endpoint to generate token to access the API
-	OIDCTokenEndpoint string `mapstructure:"oidc_token_endpoint" docs:"https://keycloak-dev.cern.ch/auth/realms/cern/api-access/token"`
+	OIDCTokenEndpoint string `mapstructure:"oidc_token_endpoint" docs:"https://keycloak-dev.cern.ch/auth/realms/cern/api-access/token" validate:"url"`
 	// The target application for which token needs to be generated
 	TargetAPI string `mapstructure:"target_api" docs:"authorization-service-api"`
 	// The time in seconds between bulk fetch of user accounts
 	UserFetchInterval int `mapstructure:"user_fetch_interval" docs:"3600"`
+	// The time in seconds between full fetches of the user accounts; in between,
+	// only the accounts modified since the last fetch are fetched. 0 disables
+	// the incremental fetches.
+	FullSyncInterval int `mapstructure:"full_sync_interval" docs:"0" validate:"min=0"`
+	// Reject the lookups of the blocked accounts in GetUser and GetUserByClaim
+	RejectBlockedUsers bool `mapstructure:"reject_blocked_users" docs:"false"`
+	// The maximum number of users returned by FindUsers, the most relevant first.
+	// 0 returns all the matching users.
+	FindUsersLimit int `mapstructure:"find_users_limit" docs:"0" validate:"min=0"`
+	// The address of the http endpoint receiving the notifications of group changes,
+	// to invalidate the cached groups of the users, and serving the health of
+	// the cache at /health. Disabled if empty.
+	InvalidationAddress string `mapstructure:"invalidation_address" docs:""`
+	// The bearer token expected by the invalidation endpoint
+	InvalidationToken string `mapstructure:"invalidation_token" docs:"-" validate:"required_with=InvalidationAddress"`
 }
 
 func (c *config) ApplyDefaults() {
@@ -88,6 +132,18 @@ func (c *config) ApplyDefaults() {
 	if c.RedisAddress == "" {
 		c.RedisAddress = ":6379"
 	}
+	if c.RedisMaxIdle == 0 {
+		c.RedisMaxIdle = 50
+	}
+	if c.RedisMaxActive == 0 {
+		c.RedisMaxActive = 1000
+	}
+	if c.RedisIdleTimeout == 0 {
+		c.RedisIdleTimeout = 240
+	}
+	if c.LocalCacheExpiration == 0 {
+		c.LocalCacheExpiration = 60
+	}
 	if c.APIBaseURL == "" {
 		c.APIBaseURL = "https://authorization-service-api-dev.web.cern.ch"
 	}
@@ -105,6 +161,15 @@ func (c *config) ApplyDefaults() {
 	}
 }
 
+const (
+	identityFields = "upn,primaryAccountEmail,displayName,uid,gid,type,source,activeUser,blocked,expirationDate,id,ownerId"
+	// modifiedSinceFilter is the grappa filter on the last modification of the identities.
+	modifiedSinceFilter = "lastModified:gt:"
+	// syncOverlap is subtracted from the time of the last fetch, to not
+	// miss the identities modified while it was running.
+	syncOverlap = 5 * time.Minute
+)
+
 // New returns a user manager implementation that makes calls to the GRAPPA API.
 func New(ctx context.Context, m map[string]interface{}) (user.Manager, error) {
 	mgr := &manager{}
@@ -120,7 +185,7 @@ func (m *manager) Configure(ml map[string]interface{}) error {
 	if err := cfg.Decode(ml, &c); err != nil {
 		return err
 	}
-	redisPool := initRedisPool(c.RedisAddress, c.RedisUsername, c.RedisPassword)
+	redisPool := initRedisPool(&c)
 	apiTokenManager, err := utils.InitAPITokenManager(ml)
 	if err != nil {
 		return err
@@ -128,28 +193,30 @@ func (m *manager) Configure(ml map[string]interface{}) error {
 	m.conf = &c
 	m.redisPool = redisPool
 	m.apiTokenManager = apiTokenManager
+	m.local = newLocalCache(c.LocalCacheSize, c.LocalCacheExpiration)
+
+	// stop the tasks of a previous configuration
+	if m.runner != nil {
+		_ = m.runner.Close()
+	}
+	m.runner = runner.New(context.Background())
 
 	// Since we're starting a subroutine which would take some time to execute,
 	// we can't wait to see if it works before returning the user.Manager object
-	// TODO: return err if the fetch fails
-	go m.fetchAllUsers(context.Background())
+	m.loadSyncState()
+	m.runner.Every("rest: fetch all users", time.Duration(m.conf.UserFetchInterval)*time.Second, true, m.syncUsers)
+	if m.conf.InvalidationAddress != "" {
+		m.runner.Go("rest: invalidation endpoint", runner.RestartAlways, m.serveInvalidations)
+	}
 	return nil
 }
 
-func (m *manager) fetchAllUsers(ctx context.Context) {
-	_ = m.fetchAllUserAccounts(ctx)
-	ticker := time.NewTicker(time.Duration(m.conf.UserFetchInterval) * time.Second)
-	work := make(chan os.Signal, 1)
-	signal.Notify(work, syscall.SIGHUP, syscall.SIGINT, syscall.SIGQUIT)
-
-	for {
-		select {
-		case <-work:
-			return
-		case <-ticker.C:
-			_ = m.fetchAllUserAccounts(ctx)
-		}
+// Close stops the background fetch of the users.
+func (m *manager) Close() error {
+	if m.runner == nil {
+		return nil
 	}
+	return m.runner.Close()
 }
 
 // Identity contains the information of a single user.
@@ -162,6 +229,11 @@ type Identity struct {
 	ActiveUser          bool   `json:"activeUser,omitempty"`
 	UID                 int    `json:"uid,omitempty"`
 	GID                 int    `json:"gid,omitempty"`
+	Blocked             bool   `json:"blocked,omitempty"`
+	ExpirationDate      string `json:"expirationDate,omitempty"`
+	ID                  string `json:"id,omitempty"`
+	// OwnerID is the id of the identity owning a secondary or service account
+	OwnerID string `json:"ownerId,omitempty"`
 }
 
 // IdentitiesResponse contains the expected response from grappa
@@ -195,28 +267,74 @@ func (i *Identity) UserType() userpb.UserType {
 	}
 }
 
+// fetchAllUserAccounts caches the user accounts. Only the accounts modified
+// since the last fetch are fetched, unless a full fetch is due or the
+// incremental one fails.
 func (m *manager) fetchAllUserAccounts(ctx context.Context) error {
-	url := fmt.Sprintf("%s/api/v1.0/Identity?filter=unconfirmed%3Afalse&field=upn&field=primaryAccountEmail&field=displayName&field=uid&field=gid&field=type&field=source&field=activeUser", m.conf.APIBaseURL)
+	log := appctx.GetLogger(ctx)
+	start := time.Now()
+
+	m.syncMu.Lock()
+	lastSync, lastFullSync := m.lastSync, m.lastFullSync
+	m.syncMu.Unlock()
+
+	full := m.conf.FullSyncInterval == 0 || lastFullSync.IsZero() ||
+		start.Sub(lastFullSync) > time.Duration(m.conf.FullSyncInterval)*time.Second
+	if !full {
+		since := lastSync.Add(-syncOverlap).UTC().Format(time.RFC3339)
+		n, err := m.fetchUserAccounts(ctx, modifiedSinceFilter+since)
+		if err == nil {
+			log.Debug().Int("users", n).Str("since", since).Msg("rest: fetched modified users")
+			m.saveSyncState(ctx, start, lastFullSync)
+			return nil
+		}
+		log.Error().Err(err).Msg("rest: error fetching modified users, falling back to a full fetch")
+	}
+
+	n, err := m.fetchUserAccounts(ctx, "")
+	if err != nil {
+		return err
+	}
+	log.Debug().Int("users", n).Msg("rest: fetched all users")
+	m.saveSyncState(ctx, start, start)
+	m.saveCachedUsers(ctx, n)
+	return nil
+}
+
+// fetchUserAccounts caches the confirmed user accounts matching the
+// given grappa filter, returning how many were fetched.
+func (m *manager) fetchUserAccounts(ctx context.Context, filter string) (int, error) {
+	q := url.Values{}
+	q.Add("filter", "unconfirmed:false")
+	if filter != "" {
+		q.Add("filter", filter)
+	}
+	for _, f := range strings.Split(identityFields, ",") {
+		q.Add("field", f)
+	}
+	endpoint := fmt.Sprintf("%s/api/v1.0/Identity?%s", m.conf.APIBaseURL, q.Encode())
 
+	var n int
 	for {
 		var r IdentitiesResponse
-		if err := m.apiTokenManager.SendAPIGetRequest(ctx, url, false, &r); err != nil {
-			return err
+		if err := m.apiTokenManager.SendAPIGetRequest(ctx, endpoint, false, &r); err != nil {
+			return n, err
 		}
 
 		for _, usr := range r.Data {
 			if _, err := m.parseAndCacheUser(ctx, usr); err != nil {
 				continue
 			}
+			n++
 		}
 
 		if r.Pagination.Next == nil {
 			break
 		}
-		url = fmt.Sprintf("%s%s", m.conf.APIBaseURL, *r.Pagination.Next)
+		endpoint = fmt.Sprintf("%s%s", m.conf.APIBaseURL, *r.Pagination.Next)
 	}
 
-	return nil
+	return n, nil
 }
 
 func (m *manager) parseAndCacheUser(ctx context.Context, i *Identity) (*userpb.User, error) {
@@ -230,12 +348,16 @@ func (m *manager) parseAndCacheUser(ctx context.Context, i *Identity) (*userpb.U
 		DisplayName: i.DisplayName,
 		UidNumber:   int64(i.UID),
 		GidNumber:   int64(i.GID),
+		Opaque:      accountStatusOpaque(i),
 	}
 	u.Username = utils.FormatUserID(u.Id)
 
 	if err := m.cacheUserDetails(u); err != nil {
 		log.Error().Err(err).Msg("rest: error caching user details")
 	}
+	if err := m.cacheAccountOwner(i); err != nil {
+		log.Error().Err(err).Msg("rest: error caching account owner")
+	}
 
 	return u, nil
 }
@@ -245,6 +367,9 @@ func (m *manager) GetUser(ctx context.Context, uid *userpb.UserId, skipFetchingG
 	if err != nil {
 		return nil, err
 	}
+	if m.conf.RejectBlockedUsers && IsBlocked(u) {
+		return nil, errtypes.PermissionDenied("rest: the account " + u.Username + " is blocked")
+	}
 
 	if !skipFetchingGroups {
 		userGroups, err := m.GetUserGroups(ctx, uid)
@@ -262,6 +387,9 @@ func (m *manager) GetUserByClaim(ctx context.Context, claim, value string, skipF
 	if err != nil {
 		return nil, err
 	}
+	if m.conf.RejectBlockedUsers && IsBlocked(u) {
+		return nil, errtypes.PermissionDenied("rest: the account " + u.Username + " is blocked")
+	}
 
 	if !skipFetchingGroups {
 		userGroups, err := m.GetUserGroups(ctx, u.Id)
@@ -311,7 +439,7 @@ func (m *manager) FindUsers(ctx context.Context, query string, skipFetchingGroup
 		}
 	}
 
-	return userSlice, nil
+	return rankUsers(userSlice, query, m.conf.FindUsersLimit), nil
 }
 
 // isUserAnyType returns true if the user's type is one of types list.
@@ -344,16 +472,14 @@ func (m *manager) GetUserGroups(ctx context.Context, uid *userpb.UserId) ([]stri
 		return groups, nil
 	}
 
-	// TODO (gdelmont): support pagination! we may have problems with users having more than 1000 groups
-	url := fmt.Sprintf("%s/api/v1.0/Identity/%s/groups/recursive?field=displayName", m.conf.APIBaseURL, uid.OpaqueId)
-
-	var r GroupsResponse
-	if err := m.apiTokenManager.SendAPIGetRequest(ctx, url, false, &r); err != nil {
+	endpoint := fmt.Sprintf("%s/api/v1.0/Identity/%s/groups/recursive?field=displayName", m.conf.APIBaseURL, uid.OpaqueId)
+	groups, err = fetchGroups(ctx, m.conf.APIBaseURL, endpoint, func(ctx context.Context, url string, r *GroupsResponse) error {
+		return m.apiTokenManager.SendAPIGetRequest(ctx, url, false, r)
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	groups = list.Map(r.Data, func(g Group) string { return strings.ToLower(g.DisplayName) })
-
 	if err = m.cacheUserGroups(uid, groups); err != nil {
 		log := appctx.GetLogger(ctx)
 		log.Error().Err(err).Msg("rest: error caching user groups")
@@ -362,18 +488,50 @@ func (m *manager) GetUserGroups(ctx context.Context, uid *userpb.UserId) ([]stri
 	return groups, nil
 }
 
+// fetchGroups follows the pagination of the groups returned at endpoint,
+// as users can be members of more than the groups in a single page.
+func fetchGroups(ctx context.Context, baseURL, endpoint string, get func(context.Context, string, *GroupsResponse) error) ([]string, error) {
+	groups := []string{}
+	for {
+		var r GroupsResponse
+		if err := get(ctx, endpoint, &r); err != nil {
+			return nil, err
+		}
+		groups = append(groups, list.Map(r.Data, func(g Group) string { return strings.ToLower(g.DisplayName) })...)
+
+		if r.Pagination.Next == nil {
+			return groups, nil
+		}
+		endpoint = fmt.Sprintf("%s%s", baseURL, *r.Pagination.Next)
+	}
+}
+
 func (m *manager) IsInGroup(ctx context.Context, uid *userpb.UserId, group string) (bool, error) {
-	// TODO (gdelmont): this can be improved storing the groups a user belong to as a list in redis
-	// and, instead of returning all the groups, use the redis apis to check if the group is in the list.
-	userGroups, err := m.GetUserGroups(ctx, uid)
+	member, err := m.IsInGroups(ctx, uid, []string{group})
 	if err != nil {
 		return false, err
 	}
+	return member[0], nil
+}
+
+// IsInGroups checks if the user is member of each of the given groups,
+// with a single round trip to redis when the groups of the user are cached.
+func (m *manager) IsInGroups(ctx context.Context, uid *userpb.UserId, groups []string) ([]bool, error) {
+	if member, err := m.fetchCachedGroupsMembership(uid, groups); err == nil {
+		return member, nil
+	}
 
+	userGroups, err := m.GetUserGroups(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[string]struct{}, len(userGroups))
 	for _, g := range userGroups {
-		if group == g {
-			return true, nil
-		}
+		set[g] = struct{}{}
+	}
+	member := make([]bool, len(groups))
+	for i, g := range groups {
+		_, member[i] = set[g]
 	}
-	return false, nil
+	return member, nil
 }
@@ -0,0 +1,101 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package rest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+)
+
+func TestFetchGroups(t *testing.T) {
+	const base = "https://grappa"
+	next := func(s string) *string { return &s }
+
+	pages := map[string]*GroupsResponse{
+		base + "/groups?page=1": {Data: []Group{{DisplayName: "CERNBOX-Admins"}, {DisplayName: "it-dep"}}},
+		base + "/groups?page=2": {Data: []Group{{DisplayName: "cernbox-project-foo-writers"}}},
+		base + "/groups?page=3": {Data: []Group{}},
+	}
+	pages[base+"/groups?page=1"].Pagination.Next = next("/groups?page=2")
+	pages[base+"/groups?page=2"].Pagination.Next = next("/groups?page=3")
+
+	var requested []string
+	get := func(_ context.Context, url string, r *GroupsResponse) error {
+		requested = append(requested, url)
+		p, ok := pages[url]
+		if !ok {
+			return fmt.Errorf("unexpected url %s", url)
+		}
+		*r = *p
+		return nil
+	}
+
+	groups, err := fetchGroups(context.Background(), base, base+"/groups?page=1", get)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"cernbox-admins", "it-dep", "cernbox-project-foo-writers"}; !slices.Equal(groups, expected) {
+		t.Fatalf("expected groups %v, got %v", expected, groups)
+	}
+	if len(requested) != 3 {
+		t.Fatalf("expected 3 pages to be requested, got %v", requested)
+	}
+}
+
+func TestFetchGroupsError(t *testing.T) {
+	const base = "https://grappa"
+	next := "/groups?page=2"
+	get := func(_ context.Context, url string, r *GroupsResponse) error {
+		if url == base+next {
+			return errors.New("unavailable")
+		}
+		r.Data = []Group{{DisplayName: "it-dep"}}
+		r.Pagination.Next = &next
+		return nil
+	}
+
+	// a partial list of groups must not be returned, as it would be cached
+	if groups, err := fetchGroups(context.Background(), base, base+"/groups?page=1", get); err == nil {
+		t.Fatalf("expected an error, got groups %v", groups)
+	}
+}
+
+func TestRankUsers(t *testing.T) {
+	users := []*userpb.User{
+		{Username: "agonzalez", DisplayName: "Ana Gonzalez", Mail: "ana.gonzalez@cern.ch"},
+		{Username: "gonzo", DisplayName: "Gonzo The Great", Mail: "gonzo@cern.ch"},
+		{Username: "bgonzalo", DisplayName: "Bruno Gonzalo", Mail: "bruno.gonzalo@cern.ch"},
+		{Username: "gonz", DisplayName: "Gon Z", Mail: "gon.z@cern.ch"},
+	}
+
+	ranked := rankUsers(users, "Gonz", 3)
+	var usernames []string
+	for _, u := range ranked {
+		usernames = append(usernames, u.Username)
+	}
+	// exact username match first, then prefix matches on the username
+	if expected := []string{"gonz", "gonzo", "agonzalez"}; !slices.Equal(usernames, expected) {
+		t.Fatalf("expected %v, got %v", expected, usernames)
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package rest
+
+import (
+	"strconv"
+
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	types "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
+)
+
+// Keys of the status of the accounts in the opaque of the users.
+const (
+	OpaqueActive     = "active"
+	OpaqueBlocked    = "blocked"
+	OpaqueExpiration = "expiration"
+)
+
+func accountStatusOpaque(i *Identity) *types.Opaque {
+	plain := func(v string) *types.OpaqueEntry {
+		return &types.OpaqueEntry{Decoder: "plain", Value: []byte(v)}
+	}
+	o := &types.Opaque{Map: map[string]*types.OpaqueEntry{
+		OpaqueActive:  plain(strconv.FormatBool(i.ActiveUser)),
+		OpaqueBlocked: plain(strconv.FormatBool(i.Blocked)),
+	}}
+	if i.ExpirationDate != "" {
+		o.Map[OpaqueExpiration] = plain(i.ExpirationDate)
+	}
+	return o
+}
+
+// IsBlocked returns true if the account of the user is blocked.
+func IsBlocked(u *userpb.User) bool {
+	e, ok := u.GetOpaque().GetMap()[OpaqueBlocked]
+	return ok && string(e.Value) == "true"
+}
@@ -0,0 +1,104 @@
+// Copyright 2018-2023 CERN
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// In applying this license, CERN does not waive the privileges and immunities
+// granted to it by virtue of its status as an Intergovernmental Organization
+// or submit itself to any jurisdiction.
+
+package rest
+
+import (
+	"context"
+	"time"
+
+	"github.com/cernbox/reva-plugins/metrics"
+	"github.com/cs3org/reva/pkg/appctx"
+)
+
+const (
+	syncPrefix       = "sync:"
+	lastSyncKey      = syncPrefix + "last"
+	lastFullSyncKey  = syncPrefix + "last_full"
+	minSyncBackoff   = time.Second
+	maxSyncBackoff   = 5 * time.Minute
+	syncStatusOK     = "ok"
+	syncStatusFailed = "failed"
+)
+
+var syncsTotal = metrics.NewCounterVec("user_rest_syncs_total", "status")
+
+// loadSyncState reads the times of the last fetches persisted in redis,
+// so that after a restart the users already cached are not fetched again.
+func (m *manager) loadSyncState() {
+	get := func(key string) time.Time {
+		v, err := m.getVal(key)
+		if err != nil {
+			return time.Time{}
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}
+		}
+		return t
+	}
+
+	m.syncMu.Lock()
+	defer m.syncMu.Unlock()
+	m.lastSync = get(lastSyncKey)
+	m.lastFullSync = get(lastFullSyncKey)
+}
+
+func (m *manager) saveSyncState(ctx context.Context, lastSync, lastFullSync time.Time) {
+	m.syncMu.Lock()
+	m.lastSync, m.lastFullSync = lastSync, lastFullSync
+	m.syncMu.Unlock()
+
+	for key, t := range map[string]time.Time{lastSyncKey: lastSync, lastFullSyncKey: lastFullSync} {
+		if err := m.setVal(key, t.UTC().Format(time.RFC3339), -1); err != nil {
+			appctx.GetLogger(ctx).Error().Err(err).Msg("rest: error persisting the sync state")
+		}
+	}
+}
+
+// syncUsers fetches the users. Until a first fetch succeeds, as the cache
+// may be empty, it is retried with an exponential backoff.
+func (m *manager) syncUsers(ctx context.Context) error {
+	backoff := minSyncBackoff
+	for {
+		err := m.fetchAllUserAccounts(ctx)
+
+		m.syncMu.Lock()
+		m.syncErr = err
+		synced := !m.lastSync.IsZero()
+		m.syncMu.Unlock()
+
+		if err == nil {
+			syncsTotal.Inc(syncStatusOK)
+			return nil
+		}
+		syncsTotal.Inc(syncStatusFailed)
+		if synced {
+			// keep serving the users cached in redis until the next fetch
+			return err
+		}
+
+		appctx.GetLogger(ctx).Error().Err(err).Dur("retry_in", backoff).Msg("rest: error fetching the users, the cache is empty")
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxSyncBackoff)
+	}
+}